@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"slices"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mark3labs/mcp-go/server"
 
@@ -44,6 +50,39 @@ type grafanaConfig struct {
 	debug bool
 }
 
+// Configuration for the InfluxDB tools.
+type influxdbConfig struct {
+	// Suffix appended to the User-Agent of requests made by the InfluxDB tools.
+	userAgentSuffix string
+	// SQL added before and after every statement sent by the InfluxDB tools.
+	sqlPrefix, sqlSuffix string
+	// HTTP proxy for requests made by the InfluxDB tools.
+	proxyURL string
+	// Timeouts for connecting, the TLS handshake and the response headers of
+	// requests made by the InfluxDB tools.
+	dialTimeout, tlsHandshakeTimeout, responseHeaderTimeout time.Duration
+	// Whether to re-issue a query once when its frame data is corrupt.
+	retryCorruptFrames bool
+	// Number of InfluxDB query results decoded at once.
+	decodeConcurrency int
+	// Name of the time column of InfluxDB tables.
+	timeColumn string
+	// Replacement for NaN and infinite floats in InfluxDB results.
+	nonFiniteValue string
+	// Whether InfluxDB queries must be confirmed after a preview.
+	requireConfirmation bool
+	// Default limit on the number of columns of InfluxDB query results.
+	maxColumns int
+	// LIMIT added to InfluxDB SELECT statements which have none.
+	defaultLimit int
+	// Table the ingestion pipeline records rejected writes in.
+	ingestionErrorsTable string
+	// Path of the JSON file named queries are loaded from.
+	namedQueries string
+	// Comma-separated Grafana URLs the InfluxDB tools may be pointed at.
+	allowedGrafanaURLs string
+}
+
 func (dt *disabledTools) addFlags() {
 	flag.StringVar(&dt.enabledTools, "enabled-tools", "search,datasource,incident,prometheus,loki,alerting,dashboard,oncall,asserts,sift,influxdb", "A comma separated list of tools enabled for this server. Can be overwritten entirely or by disabling specific components, e.g. --disable-search.")
 
@@ -64,6 +103,68 @@ func (gc *grafanaConfig) addFlags() {
 	flag.BoolVar(&gc.debug, "debug", false, "Enable debug mode for the Grafana transport")
 }
 
+func (ic *influxdbConfig) addFlags() {
+	flag.StringVar(&ic.userAgentSuffix, "influxdb-user-agent-suffix", "", "Suffix appended to the User-Agent header of InfluxDB queries")
+	flag.StringVar(&ic.sqlPrefix, "influxdb-sql-prefix", "", "SQL added before every InfluxDB query, e.g. a /* agent:<id> */ comment")
+	flag.StringVar(&ic.sqlSuffix, "influxdb-sql-suffix", "", "SQL added after every InfluxDB query, e.g. a LIMIT clause")
+	flag.StringVar(&ic.proxyURL, "influxdb-proxy-url", "", "HTTP proxy for InfluxDB queries, overriding the HTTP_PROXY environment variables")
+	flag.DurationVar(&ic.dialTimeout, "influxdb-dial-timeout", 10*time.Second, "Timeout for connecting to Grafana for InfluxDB queries")
+	flag.DurationVar(&ic.tlsHandshakeTimeout, "influxdb-tls-handshake-timeout", 10*time.Second, "Timeout for the TLS handshake with Grafana for InfluxDB queries")
+	flag.DurationVar(&ic.responseHeaderTimeout, "influxdb-response-header-timeout", 0, "Timeout for Grafana to start responding to an InfluxDB query, which includes the time the query runs for. 0 means no limit")
+	flag.BoolVar(&ic.retryCorruptFrames, "influxdb-retry-corrupt-frames", false, "Re-run an InfluxDB query once if its response frame fails to decompress or unmarshal")
+	flag.IntVar(&ic.decodeConcurrency, "influxdb-decode-concurrency", 0, "Number of record batches of an InfluxDB result frame decoded at once. 0 means GOMAXPROCS")
+	flag.StringVar(&ic.timeColumn, "influxdb-time-column", "time", "Name of the time column of InfluxDB tables, which the table tools filter and order by")
+	flag.StringVar(&ic.nonFiniteValue, "influxdb-non-finite-value", "", "String NaN and infinite floats in InfluxDB results are returned as, e.g. NaN. Empty returns them as null")
+	flag.BoolVar(&ic.requireConfirmation, "influxdb-require-confirmation", false, "Make query_influxdb_sql and run_named_influxdb_query return an EXPLAIN-based cost estimate and a confirmation token instead of running a query, which runs when the call is repeated with the token")
+	flag.IntVar(&ic.maxColumns, "influxdb-max-columns", 0, "Default maximum number of columns returned by InfluxDB queries; further columns are dropped. 0 means no limit")
+	flag.IntVar(&ic.defaultLimit, "influxdb-default-limit", 1000, "LIMIT added to InfluxDB SELECT queries which have none, reported to the agent as appliedLimit when it cuts a result off. 0 disables it")
+	flag.StringVar(&ic.ingestionErrorsTable, "influxdb-ingestion-errors-table", "", "InfluxDB [schema.]table where rejected writes are recorded, read by influxdb_ingestion_errors")
+	flag.StringVar(&ic.namedQueries, "influxdb-named-queries", "", "JSON file of named InfluxDB queries for run_named_influxdb_query. Reloaded on SIGHUP")
+	flag.StringVar(&ic.allowedGrafanaURLs, "influxdb-allowed-grafana-urls", "", "Comma-separated Grafana URLs besides the configured one that query_influxdb_sql's grafanaUrl may name. The request's credentials are sent there; any other URL is refused")
+}
+
+func (ic *influxdbConfig) toolsConfig() (tools.InfluxDBConfig, error) {
+	cfg := tools.InfluxDBConfig{
+		UserAgentSuffix:       ic.userAgentSuffix,
+		DialTimeout:           ic.dialTimeout,
+		TLSHandshakeTimeout:   ic.tlsHandshakeTimeout,
+		ResponseHeaderTimeout: ic.responseHeaderTimeout,
+		SQLHook:               tools.SQLAffixHook(ic.sqlPrefix, ic.sqlSuffix),
+		RetryCorruptFrames:    ic.retryCorruptFrames,
+		DecodeConcurrency:     ic.decodeConcurrency,
+		MaxColumns:            ic.maxColumns,
+		TimeColumn:            ic.timeColumn,
+		NonFiniteValue:        ic.nonFiniteValue,
+		RequireConfirmation:   ic.requireConfirmation,
+		DefaultLimit:          ic.defaultLimit,
+		IngestionErrorsTable:  ic.ingestionErrorsTable,
+	}
+	if ic.proxyURL != "" {
+		u, err := url.Parse(ic.proxyURL)
+		if err != nil {
+			return tools.InfluxDBConfig{}, fmt.Errorf("invalid -influxdb-proxy-url: %w", err)
+		}
+		cfg.ProxyURL = u
+	}
+	for _, raw := range strings.Split(ic.allowedGrafanaURLs, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return tools.InfluxDBConfig{}, fmt.Errorf("invalid -influxdb-allowed-grafana-urls entry %q: must be an absolute http or https URL", raw)
+		}
+		cfg.AllowedGrafanaURLs = append(cfg.AllowedGrafanaURLs, raw)
+	}
+	if ic.namedQueries != "" {
+		r, err := tools.LoadNamedQueries(ic.namedQueries)
+		if err != nil {
+			return tools.InfluxDBConfig{}, err
+		}
+		cfg.NamedQueries = r
+	}
+	return cfg, nil
+}
+
 func (dt *disabledTools) addTools(s *server.MCPServer) {
 	enabledTools := strings.Split(dt.enabledTools, ",")
 	maybeAddTools(s, tools.AddSearchTools, enabledTools, dt.search, "search")
@@ -82,28 +183,87 @@ func (dt *disabledTools) addTools(s *server.MCPServer) {
 func newServer(dt disabledTools) *server.MCPServer {
 	s := server.NewMCPServer(
 		"mcp-grafana",
-		"0.1.0",
+		mcpgrafana.Version,
 	)
 	dt.addTools(s)
 	return s
 }
 
-func run(transport, addr string, logLevel slog.Level, dt disabledTools, gc grafanaConfig) error {
+// shutdownTimeout bounds how long the server waits for in-flight requests
+// when stopping.
+const shutdownTimeout = 5 * time.Second
+
+// reloadOnHangup reloads the named InfluxDB queries whenever the process
+// receives SIGHUP, until ctx is done.
+func reloadOnHangup(ctx context.Context, r *tools.NamedQueryRegistry) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := r.Reload(); err != nil {
+				slog.Error("Failed to reload named InfluxDB queries", "error", err)
+				continue
+			}
+			slog.Info("Reloaded named InfluxDB queries", "count", len(r.Names()))
+		}
+	}
+}
+
+func run(transport, addr string, logLevel slog.Level, dt disabledTools, gc grafanaConfig, ic influxdbConfig) error {
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
 	s := newServer(dt)
+	influxCfg, err := ic.toolsConfig()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if influxCfg.NamedQueries != nil {
+		go reloadOnHangup(ctx, influxCfg.NamedQueries)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := tools.ShutdownInfluxDB(shutdownCtx); err != nil {
+			slog.Warn("Timed out waiting for InfluxDB queries to finish", "error", err)
+		}
+	}()
 
 	switch transport {
 	case "stdio":
 		srv := server.NewStdioServer(s)
-		srv.SetContextFunc(mcpgrafana.ComposedStdioContextFunc(gc.debug))
+		srv.SetContextFunc(mcpgrafana.ComposeStdioContextFuncs(
+			mcpgrafana.ComposedStdioContextFunc(gc.debug),
+			func(ctx context.Context) context.Context {
+				return tools.WithInfluxDBConfig(ctx, influxCfg)
+			},
+		))
 		slog.Info("Starting Grafana MCP server using stdio transport")
-		return srv.Listen(context.Background(), os.Stdin, os.Stdout)
+		if err := srv.Listen(ctx, os.Stdin, os.Stdout); err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
 	case "sse":
 		srv := server.NewSSEServer(s,
-			server.WithSSEContextFunc(mcpgrafana.ComposedSSEContextFunc(gc.debug)),
+			server.WithSSEContextFunc(mcpgrafana.ComposeSSEContextFuncs(
+				mcpgrafana.ComposedSSEContextFunc(gc.debug),
+				func(ctx context.Context, req *http.Request) context.Context {
+					return tools.WithInfluxDBConfig(ctx, influxCfg)
+				},
+			)),
 		)
 		slog.Info("Starting Grafana MCP server using SSE transport", "address", addr)
-		if err := srv.Start(addr); err != nil {
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			_ = srv.Shutdown(shutdownCtx)
+		}()
+		if err := srv.Start(addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return fmt.Errorf("Server error: %v", err)
 		}
 	default:
@@ -130,9 +290,11 @@ func main() {
 	dt.addFlags()
 	var gc grafanaConfig
 	gc.addFlags()
+	var ic influxdbConfig
+	ic.addFlags()
 	flag.Parse()
 
-	if err := run(transport, *addr, parseLevel(*logLevel), dt, gc); err != nil {
+	if err := run(transport, *addr, parseLevel(*logLevel), dt, gc, ic); err != nil {
 		panic(err)
 	}
 }
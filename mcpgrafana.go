@@ -15,6 +15,10 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 )
 
+// Version is the version of the MCP server, reported to clients and sent in
+// the User-Agent of outgoing requests.
+var Version = "0.1.0"
+
 const (
 	defaultGrafanaHost = "localhost:3000"
 	defaultGrafanaURL  = "http://" + defaultGrafanaHost
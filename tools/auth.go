@@ -1,11 +1,39 @@
 package tools
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying a request ID, which is sent as the
+// X-Request-Id header of requests made with it so that they can be found in
+// Grafana's logs.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFromContext returns the request ID set with WithRequestID or, if
+// there is none, the trace ID of the active span.
+func requestIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok && id != "" {
+		return id
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
 
 type authRoundTripper struct {
 	accessToken string
 	userToken   string
 	apiKey      string
+	userAgent   string
 	underlying  http.RoundTripper
 }
 
@@ -16,6 +44,15 @@ func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 	} else if rt.apiKey != "" {
 		req.Header.Set("Authorization", "Bearer "+rt.apiKey)
 	}
+	if rt.userAgent != "" {
+		req.Header.Set("User-Agent", rt.userAgent)
+	}
+	ctx := req.Context()
+	if id := requestIDFromContext(ctx); id != "" {
+		req.Header.Set("X-Request-Id", id)
+	}
+	// Propagate the active span, if any, as a W3C traceparent header.
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	resp, err := rt.underlying.RoundTrip(req)
 	if err != nil {
@@ -24,3 +61,11 @@ func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 
 	return resp, nil
 }
+
+// CloseIdleConnections closes the idle connections of the underlying
+// transport, so that http.Client.CloseIdleConnections reaches it.
+func (rt *authRoundTripper) CloseIdleConnections() {
+	if c, ok := rt.underlying.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
@@ -3,15 +3,24 @@ package tools
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/DataDog/zstd"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/go-openapi/strfmt"
+	"github.com/grafana/grafana-openapi-client-go/client"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	mcpgrafana "github.com/grafana/mcp-grafana"
 	"github.com/mark3labs/mcp-go/server"
@@ -21,6 +30,10 @@ type dsQueryPayload struct {
 	Queries []dsInnerQuery `json:"queries"`
 	From    string         `json:"from"`
 	To      string         `json:"to"`
+	// DashboardUID and PanelID attribute the query to a dashboard panel;
+	// see queryOptions.
+	DashboardUID string `json:"dashboardUID,omitempty"`
+	PanelID      int64  `json:"panelId,omitempty"`
 }
 
 type dsInnerQuery struct {
@@ -29,76 +42,734 @@ type dsInnerQuery struct {
 	Format     string            `json:"format"`
 	RawSQL     string            `json:"rawSql"`
 	RawQuery   bool              `json:"rawQuery"`
+	IntervalMs int64             `json:"intervalMs,omitempty"`
+	QueryType  string            `json:"queryType,omitempty"`
+	// ResultEncoding asks for frames in a particular data encoding where
+	// the datasource supports choosing it; see queryOptions.PreferJSON.
+	ResultEncoding string `json:"resultEncoding,omitempty"`
 }
 
+// resultEncodingJSON requests frames using the JSON values encoding rather
+// than base64 Arrow IPC.
+const resultEncodingJSON = "json"
+
+// defaultQueryType is the query language sent when none is specified.
+const defaultQueryType = "SQL"
+
 type dsQueryResponse struct {
-	Results map[string]struct {
-		Error       string `json:"error,omitempty"`
-		ErrorSource string `json:"errorSource,omitempty"`
-		Status      int    `json:"status,omitempty"`
-		Frames      []struct {
-			Schema any             `json:"schema"`
-			Data   json.RawMessage `json:"data"`
-		} `json:"frames,omitempty"`
-	} `json:"results"`
+	Results map[string]dsRefResult `json:"results"`
+}
+
+type dsRefResult struct {
+	Error       string          `json:"error,omitempty"`
+	ErrorSource string          `json:"errorSource,omitempty"`
+	Status      int             `json:"status,omitempty"`
+	Frames      []frameEnvelope `json:"frames,omitempty"`
+}
+
+// frameEnvelope is a single data frame as returned by ds/query. Data is
+// either a base64 string of zstd-compressed Arrow IPC or a JSON object with
+// column-oriented values.
+type frameEnvelope struct {
+	Schema any             `json:"schema"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// result returns the result for refID. Some proxies echo the result under a
+// differently-cased or rewritten key, so if refID is missing but the response
+// holds exactly one result, that result is used instead.
+func (r dsQueryResponse) result(refID string) (dsRefResult, bool) {
+	if ref, ok := r.Results[refID]; ok {
+		return ref, true
+	}
+	if len(r.Results) == 1 {
+		for _, ref := range r.Results {
+			return ref, true
+		}
+	}
+	return dsRefResult{}, false
 }
 
 type influxdbClient struct {
-	baseURL    string
+	baseURL string
+	// grafana is the Grafana URL as configured, which unlike baseURL tells
+	// apart Grafanas on different Unix sockets; see cacheKey.
+	grafana    string
 	httpClient *http.Client
 	uid        string
+	sqlHook    SQLHook
+	// retryCorruptFrames re-issues a query once if its frame data is corrupt.
+	retryCorruptFrames bool
+	// maxColumns is the default column limit; see decodeOptions.
+	maxColumns int
+	// defaultLimit is the LIMIT added to unbounded SELECTs; see
+	// InfluxDBConfig.DefaultLimit.
+	defaultLimit int
+	// decodeConcurrency bounds how many record batches of a frame are
+	// decoded at once; see decodeOptions.
+	decodeConcurrency int
+	// timeColumn is the time column of tables; see timeCol.
+	timeColumn string
+	// nonFiniteValue is the default replacement of NaN and infinite
+	// floats; see decodeOptions.
+	nonFiniteValue string
+	// requireConfirmation makes query_influxdb_sql return a preview of
+	// each query before running it; see previewQuery.
+	requireConfirmation bool
+	// orgID, if non-zero, is sent as X-Grafana-Org-Id to select the
+	// organization queries run in.
+	orgID int64
+	// queries, if set, tracks in-flight queries so they can be cancelled on
+	// shutdown.
+	queries *queryTracker
+}
+
+// timeCol returns the name of the time column of the tables the client
+// reads, "time" unless configured otherwise.
+func (c *influxdbClient) timeCol() string {
+	if c.timeColumn != "" {
+		return c.timeColumn
+	}
+	return defaultTimeColumn
+}
+
+// dsQueryPath is the path of Grafana's query endpoint, relative to the
+// Grafana URL.
+const dsQueryPath = "/api/ds/query?ds_type=influxdb"
+
+// grafanaURL returns the URL of the Grafana instance the client queries.
+func (c *influxdbClient) grafanaURL() string {
+	return strings.TrimSuffix(c.baseURL, dsQueryPath)
+}
+
+// cacheKey identifies the datasource the client queries across Grafana
+// instances and organizations, for caching what is learnt about it.
+func (c *influxdbClient) cacheKey() string {
+	return c.grafana + "\x00" + strconv.FormatInt(c.orgID, 10) + "\x00" + c.uid
 }
 
+// errGrafanaURLNotConfigured is returned when the context carries no Grafana
+// URL to send queries to.
+var errGrafanaURLNotConfigured = errors.New("Grafana URL not configured")
+
 func newInfluxdbClient(ctx context.Context, uid string) (*influxdbClient, error) {
-	if _, err := getDatasourceByUID(ctx, GetDatasourceByUIDParams{UID: uid}); err != nil {
+	return newInfluxdbClientInOrg(ctx, uid, 0)
+}
+
+// newInfluxdbClientInOrg returns a client for the datasource uid in the given
+// Grafana organization, or in the organization of the credentials if orgID is
+// zero. The datasource lookup is made in the same organization, so a UID from
+// another one is reported as not found.
+func newInfluxdbClientInOrg(ctx context.Context, uid string, orgID int64) (*influxdbClient, error) {
+	// A context which is already done would fail the lookup anyway; fail
+	// before making it.
+	if err := ctx.Err(); err != nil {
+		return nil, transportError(err)
+	}
+	grafanaURL := strings.TrimRight(mcpgrafana.GrafanaURLFromContext(ctx), "/")
+	if grafanaURL == "" {
+		return nil, errGrafanaURLNotConfigured
+	}
+	if orgID < 0 {
+		return nil, fmt.Errorf("invalid orgId %d", orgID)
+	}
+	if skipDatasourceValidation(ctx) {
+		return newInfluxdbClientUnchecked(ctx, grafanaURL, uid, orgID), nil
+	}
+	lookupCtx := ctx
+	if orgID != 0 {
+		if gc := mcpgrafana.GrafanaClientFromContext(ctx); gc != nil {
+			lookupCtx = mcpgrafana.WithGrafanaClient(ctx, gc.Clone().WithOrgID(orgID))
+		}
+	}
+	ds, err := getDatasourceByUID(lookupCtx, GetDatasourceByUIDParams{UID: uid})
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, &InfluxQueryError{Category: QueryErrorDatasourceNotFound, Status: http.StatusNotFound, Err: err}
+		}
 		return nil, err
 	}
+	if orgID != 0 && ds.OrgID != 0 && ds.OrgID != orgID {
+		return nil, &InfluxQueryError{
+			Category: QueryErrorDatasourceNotFound,
+			Status:   http.StatusNotFound,
+			Err:      fmt.Errorf("datasource with UID '%s' belongs to organization %d, not %d", uid, ds.OrgID, orgID),
+		}
+	}
 
-	grafanaURL := strings.TrimRight(mcpgrafana.GrafanaURLFromContext(ctx), "/")
-	base := fmt.Sprintf("%s/api/ds/query?ds_type=influxdb", grafanaURL)
+	return newInfluxdbClientUnchecked(ctx, grafanaURL, uid, orgID), nil
+}
 
-	access, user := mcpgrafana.OnBehalfOfAuthFromContext(ctx)
+// newInfluxdbClientUnchecked returns a client for uid without checking that
+// the datasource exists.
+func newInfluxdbClientUnchecked(ctx context.Context, grafanaURL, uid string, orgID int64) *influxdbClient {
+	cfg := InfluxDBConfigFromContext(ctx)
+	baseURL := grafanaURL
+	if _, ok := unixSocketPath(grafanaURL); ok {
+		baseURL = unixSocketBaseURL
+	}
 	return &influxdbClient{
-		baseURL: base,
-		uid:     uid,
-		httpClient: &http.Client{
-			Transport: &authRoundTripper{
-				accessToken: access,
-				userToken:   user,
-				apiKey:      mcpgrafana.GrafanaAPIKeyFromContext(ctx),
-				underlying:  http.DefaultTransport,
-			},
+		baseURL:             baseURL + dsQueryPath,
+		grafana:             grafanaURL,
+		uid:                 uid,
+		httpClient:          newInfluxdbHTTPClient(ctx),
+		sqlHook:             cfg.SQLHook,
+		retryCorruptFrames:  cfg.RetryCorruptFrames,
+		maxColumns:          cfg.MaxColumns,
+		defaultLimit:        cfg.DefaultLimit,
+		decodeConcurrency:   cfg.DecodeConcurrency,
+		timeColumn:          cfg.TimeColumn,
+		nonFiniteValue:      cfg.NonFiniteValue,
+		requireConfirmation: cfg.RequireConfirmation,
+		orgID:               orgID,
+		queries:             activeQueries,
+	}
+}
+
+type skipDatasourceValidationKey struct{}
+
+// WithSkipDatasourceValidation returns a context with which the InfluxDB
+// tools do not look the datasource up before querying it. That saves a round
+// trip to Grafana per call, at the cost of a less clear error for a wrong
+// UID: it surfaces as the query's own not-found error, and a datasource in
+// another organization than orgId is no longer reported as such. Callers
+// should only set it when they know their UIDs are valid.
+func WithSkipDatasourceValidation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipDatasourceValidationKey{}, true)
+}
+
+func skipDatasourceValidation(ctx context.Context) bool {
+	skip, _ := ctx.Value(skipDatasourceValidationKey{}).(bool)
+	return skip
+}
+
+// errGrafanaURLNotAllowed is returned for a grafanaUrl the operator has not
+// allowed; see InfluxDBConfig.AllowedGrafanaURLs.
+var errGrafanaURLNotAllowed = errors.New("grafanaUrl is not one of the Grafana URLs the server allows")
+
+// normalizeGrafanaURL returns the form of a Grafana URL it is compared in
+// against the allowed URLs: lower-case scheme and host, and no trailing
+// slash.
+func normalizeGrafanaURL(u *url.URL) string {
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + strings.TrimRight(u.Path, "/")
+}
+
+// grafanaURLAllowed reports whether the tools may send the credentials of ctx
+// to u: the Grafana the server is configured with always, and others only if
+// the operator listed them.
+func grafanaURLAllowed(ctx context.Context, u *url.URL) bool {
+	want := normalizeGrafanaURL(u)
+	allowed := InfluxDBConfigFromContext(ctx).AllowedGrafanaURLs
+	if configured := mcpgrafana.GrafanaURLFromContext(ctx); configured != "" {
+		allowed = append([]string{configured}, allowed...)
+	}
+	for _, raw := range allowed {
+		if a, err := url.Parse(strings.TrimSpace(raw)); err == nil && normalizeGrafanaURL(a) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// withGrafanaURL returns a context in which the InfluxDB tools talk to the
+// Grafana at rawURL instead of the one configured, both for the datasource
+// lookup and the query. The credentials in ctx are kept and sent there too,
+// so rawURL must be one of the URLs the operator allowed; it is checked
+// before any request is made.
+func withGrafanaURL(ctx context.Context, rawURL string) (context.Context, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grafanaUrl: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid grafanaUrl %q: must be an absolute http or https URL", rawURL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return nil, fmt.Errorf("invalid grafanaUrl %q: must not have credentials, a query or a fragment", rawURL)
+	}
+	if !grafanaURLAllowed(ctx, u) {
+		return nil, fmt.Errorf("%w: %q", errGrafanaURLNotAllowed, rawURL)
+	}
+	cfg := client.DefaultTransportConfig()
+	cfg.Host = u.Host
+	cfg.BasePath = strings.TrimRight(u.Path, "/") + "/api"
+	if u.Scheme == "http" {
+		cfg.Schemes = []string{"http"}
+	}
+	cfg.APIKey = mcpgrafana.GrafanaAPIKeyFromContext(ctx)
+	cfg.Debug = mcpgrafana.GrafanaDebugFromContext(ctx)
+	ctx = mcpgrafana.WithGrafanaClient(ctx, client.NewHTTPClientWithConfig(strfmt.Default, cfg))
+	return mcpgrafana.WithGrafanaURL(ctx, strings.TrimRight(u.String(), "/")), nil
+}
+
+// influxdbUserAgent returns the User-Agent sent with InfluxDB queries.
+func influxdbUserAgent(cfg InfluxDBConfig) string {
+	ua := "mcp-grafana-influxdb/" + mcpgrafana.Version
+	if cfg.UserAgentSuffix != "" {
+		ua += " " + cfg.UserAgentSuffix
+	}
+	return ua
+}
+
+// unixSocketScheme prefixes the URL of a Grafana listening on a Unix domain
+// socket rather than TCP, as in sidecar deployments: unix:///run/grafana.sock.
+// Only the requests the InfluxDB tools make themselves go to the socket. The
+// datasource lookup uses the Grafana API client, so a unix:// URL usually
+// goes with WithSkipDatasourceValidation.
+const unixSocketScheme = "unix://"
+
+// unixSocketBaseURL is the base URL of requests to a Grafana on a Unix socket.
+// Its host only fills the Host header; the transport always dials the socket.
+const unixSocketBaseURL = "http://unix"
+
+// unixSocketPath returns the socket path of a unix:// Grafana URL, and false
+// for any other URL.
+func unixSocketPath(grafanaURL string) (string, bool) {
+	path, ok := strings.CutPrefix(grafanaURL, unixSocketScheme)
+	if !ok || path == "" {
+		return "", false
+	}
+	return path, true
+}
+
+// newInfluxdbHTTPClient returns an HTTP client which authenticates requests
+// using the credentials in the context. If the context's Grafana URL is a
+// unix:// URL the client connects to that socket.
+func newInfluxdbHTTPClient(ctx context.Context) *http.Client {
+	cfg := InfluxDBConfigFromContext(ctx)
+	access, user := mcpgrafana.OnBehalfOfAuthFromContext(ctx)
+	socket, _ := unixSocketPath(strings.TrimRight(mcpgrafana.GrafanaURLFromContext(ctx), "/"))
+	return &http.Client{
+		Transport: &authRoundTripper{
+			accessToken: access,
+			userToken:   user,
+			apiKey:      mcpgrafana.GrafanaAPIKeyFromContext(ctx),
+			userAgent:   influxdbUserAgent(cfg),
+			underlying:  influxdbTransport(cfg, socket),
 		},
-	}, nil
+	}
+}
+
+// transportKey identifies the settings a transport is built with.
+type transportKey struct {
+	proxyURL, socket                                        string
+	dialTimeout, tlsHandshakeTimeout, responseHeaderTimeout time.Duration
 }
 
-func (c *influxdbClient) query(ctx context.Context, sql string) ([]map[string]any, error) {
-	now := time.Now().UnixMilli()
-	hrAgo := now - 60*60*1000
+// influxdbTransports caches a transport per configuration, so that calls
+// with the same settings share their connection pool.
+var influxdbTransports sync.Map
 
+// influxdbTransport returns the transport requests are sent with: a copy of
+// the default transport with the configured proxy and timeouts. It is a copy
+// even without any, so that closing its idle connections, as do does, leaves
+// the pool other clients share alone. If socket is set every connection is
+// made to that Unix socket, bypassing any proxy.
+func influxdbTransport(cfg InfluxDBConfig, socket string) http.RoundTripper {
+	key := transportKey{
+		socket:                socket,
+		dialTimeout:           cfg.DialTimeout,
+		tlsHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		responseHeaderTimeout: cfg.ResponseHeaderTimeout,
+	}
+	if cfg.ProxyURL != nil {
+		key.proxyURL = cfg.ProxyURL.String()
+	}
+	if t, ok := influxdbTransports.Load(key); ok {
+		return t.(http.RoundTripper)
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.ProxyURL != nil {
+		t.Proxy = http.ProxyURL(cfg.ProxyURL)
+	}
+	if cfg.DialTimeout > 0 {
+		t.DialContext = (&net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	}
+	if socket != "" {
+		dialer := &net.Dialer{Timeout: cfg.DialTimeout}
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+	if cfg.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	}
+	if cfg.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	}
+	actual, _ := influxdbTransports.LoadOrStore(key, t)
+	return actual.(http.RoundTripper)
+}
+
+// queryResult holds the decoded rows of a query along with the column order
+// reported by the frame schema, since the row maps themselves are unordered.
+type queryResult struct {
+	Columns []string
+	Rows    []map[string]any
+	// Labels holds the labels of each labeled column, keyed by column name.
+	// Only Arrow frames carry labels.
+	Labels map[string]map[string]string
+	// TotalSeen is the number of rows before maxRows truncation. It is zero
+	// if the result was not truncated.
+	TotalSeen int
+	// Warnings are notes about the query which did not stop it from running.
+	Warnings []string
+	// Data holds the values of each column when the result was decoded
+	// column-oriented, in which case Rows is nil.
+	Data map[string][]any
+	// NextPageToken resumes a paged query after this result. It is empty on
+	// the last page.
+	NextPageToken string
+	// Sampling describes how the result was sampled, if it was.
+	Sampling *samplingInfo
+	// ColumnsOmitted is the number of columns dropped by the column limit.
+	ColumnsOmitted int
+	// CellsTruncated is the number of values shortened by the cell size
+	// limit.
+	CellsTruncated int
+	// TimeSpan is the range of times in the result, if it was computed.
+	TimeSpan *timeSpan
+	// AppliedLimit is the default LIMIT added to the query, if the result
+	// reached it; see queryLimited.
+	AppliedLimit int
+	// ResultBytes is the estimated size of the rows kept by the
+	// maxResultBytes limit; see limitResultBytes.
+	ResultBytes int
+	// Summary is set if the summarize option is.
+	Summary *resultSummary
+	// ArrowSchema is the schema of the Arrow frame the result was decoded
+	// from when it was decoded with decodeOptions.Nested.
+	ArrowSchema *arrow.Schema
+	// Frame is the frame the result was decoded from, if it was an Arrow
+	// frame decoded into rows. It is not changed by transformResult.
+	Frame *data.Frame
+	// Nulls are the null counts of the decoded result, if
+	// decodeOptions.NullRates is set, and NullRates the fractions of null
+	// values transformResult computes from them.
+	Nulls     *nullCounts
+	NullRates map[string]float64
+	// EffectiveSQL is the SQL sent to Grafana, after every rewrite by the
+	// tool and the server's SQL hook. Grafana expands macros itself.
+	EffectiveSQL string
+	// UnitConversions are the conversions the convertUnits option applied.
+	UnitConversions []unitConversion
+	// Debug is the debug information of the frame, if decodeOptions.Debug
+	// is set and Grafana sent any.
+	Debug *queryDebug
+}
+
+// numRows returns the number of rows of res, however it was decoded.
+func (res *queryResult) numRows() int {
+	if res.Data != nil {
+		if len(res.Columns) == 0 {
+			return 0
+		}
+		return len(res.Data[res.Columns[0]])
+	}
+	return len(res.Rows)
+}
+
+// columnarResponse is returned when the columnar option is set.
+type columnarResponse struct {
+	Columns   []string         `json:"columns"`
+	Data      map[string][]any `json:"data"`
+	Truncated bool             `json:"truncated,omitempty"`
+	TotalSeen int              `json:"totalSeen,omitempty"`
+	Warnings  []string         `json:"warnings,omitempty"`
+	// NextPageToken is set when paging and more rows may follow.
+	NextPageToken  string        `json:"nextPageToken,omitempty"`
+	Sampling       *samplingInfo `json:"sampling,omitempty"`
+	ColumnsOmitted int           `json:"columnsOmitted,omitempty"`
+	CellsTruncated int           `json:"cellsTruncated,omitempty"`
+	TimeSpan       *timeSpan     `json:"timeSpan,omitempty"`
+	// AppliedLimit is the default LIMIT the server added to the SQL, if it
+	// cut the result off.
+	AppliedLimit int `json:"appliedLimit,omitempty"`
+	// Hash is set if the hash option is; see resultHash.
+	Hash        string             `json:"hash,omitempty"`
+	ResultBytes int                `json:"resultBytes,omitempty"`
+	Summary     *resultSummary     `json:"summary,omitempty"`
+	NullRates   map[string]float64 `json:"nullRates,omitempty"`
+	// EffectiveSQL is set if the returnEffectiveSQL option is.
+	EffectiveSQL    string           `json:"effectiveSql,omitempty"`
+	UnitConversions []unitConversion `json:"unitConversions,omitempty"`
+	Debug           *queryDebug      `json:"debug,omitempty"`
+}
+
+// queryResponse is returned instead of bare rows when an option adds
+// information about the result beyond the rows themselves.
+type queryResponse struct {
+	Rows      any                          `json:"rows"`
+	Labels    map[string]map[string]string `json:"labels,omitempty"`
+	Truncated bool                         `json:"truncated"`
+	TotalSeen int                          `json:"totalSeen,omitempty"`
+	Warnings  []string                     `json:"warnings,omitempty"`
+	// NextPageToken is set when paging and more rows may follow.
+	NextPageToken  string        `json:"nextPageToken,omitempty"`
+	Sampling       *samplingInfo `json:"sampling,omitempty"`
+	ColumnsOmitted int           `json:"columnsOmitted,omitempty"`
+	CellsTruncated int           `json:"cellsTruncated,omitempty"`
+	TimeSpan       *timeSpan     `json:"timeSpan,omitempty"`
+	// AppliedLimit is the default LIMIT the server added to the SQL, if it
+	// cut the result off.
+	AppliedLimit int `json:"appliedLimit,omitempty"`
+	// Hash is set if the hash option is; see resultHash.
+	Hash        string         `json:"hash,omitempty"`
+	ResultBytes int            `json:"resultBytes,omitempty"`
+	Summary     *resultSummary `json:"summary,omitempty"`
+	// NullRates is set if the nullRates option is.
+	NullRates map[string]float64 `json:"nullRates,omitempty"`
+	// EffectiveSQL is set if the returnEffectiveSQL option is.
+	EffectiveSQL string `json:"effectiveSql,omitempty"`
+	// UnitConversions is set if the convertUnits option is.
+	UnitConversions []unitConversion `json:"unitConversions,omitempty"`
+	// Debug is set if the debug option is and Grafana returned any.
+	Debug *queryDebug `json:"debug,omitempty"`
+}
+
+// hashResponse is returned instead of the rows when hashOnly is set.
+type hashResponse struct {
+	Hash      string   `json:"hash"`
+	RowCount  int      `json:"rowCount"`
+	Truncated bool     `json:"truncated,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// queryOptions controls how a single ds/query request is built.
+type queryOptions struct {
+	// From and To bound the query time range. When zero, the range defaults
+	// to the last hour.
+	From, To time.Time
+	// IntervalMs, if set, is sent so that Grafana resolves $__interval
+	// predictably instead of deriving it from the time range.
+	IntervalMs int64
+	// QueryType is the query language sent with the query. Defaults to
+	// defaultQueryType.
+	QueryType string
+	// FrameFormat is the shape Grafana is asked to return frames in, e.g.
+	// "trace". Defaults to "table".
+	FrameFormat string
+	// Columnar decodes the result into queryResult.Data instead of rows.
+	Columnar bool
+	// FrameEncoding forces the compression Arrow frames are decoded with.
+	// Defaults to detecting it.
+	FrameEncoding string
+	// MaxColumns limits the number of columns decoded. Defaults to the
+	// client's limit; see decodeOptions.
+	MaxColumns    int
+	StrictColumns bool
+	// MaxCellBytes limits the size of string values; see decodeOptions.
+	MaxCellBytes int
+	// SkipCache asks Grafana to bypass its query cache and run the query
+	// against the datasource.
+	SkipCache bool
+	// Headers are extra request headers, such as Grafana feature toggles.
+	// They must not include reservedQueryHeaders.
+	Headers http.Header
+	// PreferJSON asks for frames in the JSON values encoding, which is
+	// cheaper to decode for small results. Datasources which ignore the
+	// request return Arrow frames, which are decoded as usual.
+	PreferJSON bool
+	// Nested decodes list, struct and map columns; see decodeNestedArrow.
+	Nested bool
+	// DashboardUID and PanelID, if set, attribute the query to a dashboard
+	// panel in Grafana's query history, caching and usage insights. They are
+	// sent in the payload and as the headers the Grafana frontend uses.
+	DashboardUID string
+	PanelID      int64
+	// FrameIndex selects which frame of the result is decoded; see
+	// decodeOptions.
+	FrameIndex int
+	// NullRates counts the null values of each column; see decodeOptions.
+	NullRates bool
+	// Debug collects the debug information of the frame; see
+	// decodeOptions.
+	Debug bool
+	// NonFiniteValue replaces NaN and infinite floats. Defaults to the
+	// client's setting; see decodeOptions.
+	NonFiniteValue string
+}
+
+// reservedQueryHeaders are headers set by the client itself, including the
+// authentication headers, which callers may not override.
+var reservedQueryHeaders = []string{
+	"Accept", "Authorization", "Content-Length", "Content-Type", "Cookie", "Host",
+	"User-Agent", "X-Access-Token", "X-Grafana-Id", client.OrgIDHeader, cacheSkipHeader,
+}
+
+// parseQueryHeaders parses headers given as "Name: value" strings, rejecting
+// reserved headers.
+func parseQueryHeaders(headers []string) (http.Header, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	out := make(http.Header, len(headers))
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q: expected 'Name: value'", h)
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		for _, reserved := range reservedQueryHeaders {
+			if canonical == http.CanonicalHeaderKey(reserved) {
+				return nil, fmt.Errorf("header %q cannot be set per query; it is set by the server", name)
+			}
+		}
+		out.Add(canonical, strings.TrimSpace(value))
+	}
+	return out, nil
+}
+
+// cacheSkipHeader makes Grafana bypass its datasource query cache, a Grafana
+// Enterprise and Grafana Cloud feature. Other editions ignore it.
+const cacheSkipHeader = "X-Cache-Skip"
+
+// dashboardUIDHeader and panelIDHeader are the headers with which the Grafana
+// frontend attributes queries to the panel they were made for.
+const (
+	dashboardUIDHeader = "X-Dashboard-Uid"
+	panelIDHeader      = "X-Panel-Id"
+)
+
+// queryLimited runs sql like query, after adding the client's default LIMIT
+// to it if it is an unbounded SELECT. Only if the result reaches the limit,
+// and so may have been cut off by it, is the limit reported in AppliedLimit
+// and a warning.
+func (c *influxdbClient) queryLimited(ctx context.Context, sql string, opts queryOptions) (*queryResult, error) {
+	sql, limited := withDefaultLimit(sql, c.defaultLimit)
+	res, err := c.query(ctx, sql, opts)
+	if err != nil {
+		return nil, err
+	}
+	if limited && res.numRows() >= c.defaultLimit {
+		res.AppliedLimit = c.defaultLimit
+		res.Warnings = append(res.Warnings, fmt.Sprintf("the result was cut off at the server's default LIMIT %d; add a LIMIT to the SQL to choose how many rows are returned", c.defaultLimit))
+	}
+	return res, nil
+}
+
+// query runs sql and decodes the resulting frame.
+func (c *influxdbClient) query(ctx context.Context, sql string, opts queryOptions) (*queryResult, error) {
+	results, err := c.queryMulti(ctx, []string{sql}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// queryMulti runs several statements in a single ds/query request, with
+// refIds A, B and so on, and returns their results in the same order. If the
+// client is configured to, a request whose frame data fails to decompress or
+// unmarshal is re-issued once, as such corruption has been seen to be
+// transient.
+func (c *influxdbClient) queryMulti(ctx context.Context, sqls []string, opts queryOptions) ([]*queryResult, error) {
+	results, err := c.queryOnce(ctx, sqls, opts)
+	if err != nil && c.retryCorruptFrames && errors.Is(err, errCorruptFrame) {
+		return c.queryOnce(ctx, sqls, opts)
+	}
+	return results, err
+}
+
+// refIDs returns the refIds used for n queries sent in one request: A to Z,
+// then AA, AB and so on, as Grafana names the queries of a panel.
+func refIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		for j := i + 1; j > 0; j = (j - 1) / 26 {
+			ids[i] = string(rune('A'+(j-1)%26)) + ids[i]
+		}
+	}
+	return ids
+}
+
+func (c *influxdbClient) queryOnce(ctx context.Context, sqls []string, opts queryOptions) ([]*queryResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, transportError(err)
+	}
+	if c.queries != nil {
+		var done func()
+		var err error
+		if ctx, done, err = c.queries.track(ctx); err != nil {
+			return nil, err
+		}
+		defer done()
+	}
+
+	to := opts.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	from := opts.From
+	if from.IsZero() {
+		from = to.Add(-time.Hour)
+	}
+
+	queryType := opts.QueryType
+	if queryType == "" {
+		queryType = defaultQueryType
+	}
+	frameFormat := opts.FrameFormat
+	if frameFormat == "" {
+		frameFormat = "table"
+	}
+
+	ids := refIDs(len(sqls))
 	payload := dsQueryPayload{
-		From: fmt.Sprintf("%d", hrAgo),
-		To:   fmt.Sprintf("%d", now),
-		Queries: []dsInnerQuery{{
-			RefID: "A",
+		From:    fmt.Sprintf("%d", from.UnixMilli()),
+		To:      fmt.Sprintf("%d", to.UnixMilli()),
+		Queries: make([]dsInnerQuery, 0, len(sqls)),
+
+		DashboardUID: opts.DashboardUID,
+		PanelID:      opts.PanelID,
+	}
+	var encoding string
+	if opts.PreferJSON {
+		encoding = resultEncodingJSON
+	}
+	for i, sql := range sqls {
+		if c.sqlHook != nil {
+			sql = c.sqlHook(c.uid, sql)
+		}
+		payload.Queries = append(payload.Queries, dsInnerQuery{
+			RefID: ids[i],
 			Datasource: map[string]string{
 				"type": "influxdb",
 				"uid":  c.uid,
 			},
-			Format:   "table",
-			RawSQL:   sql,
-			RawQuery: true,
-		}},
+			Format:         frameFormat,
+			RawSQL:         sql,
+			RawQuery:       true,
+			IntervalMs:     opts.IntervalMs,
+			QueryType:      queryType,
+			ResultEncoding: encoding,
+		})
 	}
 
 	b, _ := json.Marshal(payload)
 	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL, bytes.NewReader(b))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	if opts.SkipCache {
+		req.Header.Set(cacheSkipHeader, "true")
+	}
+	if c.orgID != 0 {
+		req.Header.Set(client.OrgIDHeader, strconv.FormatInt(c.orgID, 10))
+	}
+	for k, values := range opts.Headers {
+		req.Header[k] = append(req.Header[k], values...)
+	}
+	if opts.DashboardUID != "" {
+		req.Header.Set(dashboardUIDHeader, opts.DashboardUID)
+	}
+	if opts.PanelID != 0 {
+		req.Header.Set(panelIDHeader, strconv.FormatInt(opts.PanelID, 10))
+	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request to Grafana /api/ds/query: %w", err)
+		return nil, transportError(fmt.Errorf("request to Grafana /api/ds/query: %w", err))
 	}
 	defer resp.Body.Close()
 
@@ -107,143 +778,667 @@ func (c *influxdbClient) query(ctx context.Context, sql string) ([]map[string]an
 
 		var dj dsQueryResponse
 		if err := json.Unmarshal(raw, &dj); err == nil {
-			if ref, ok := dj.Results["A"]; ok && ref.Error != "" {
-				return []map[string]any{
-					{
-						"error":        ref.Error,
-						"error_source": ref.ErrorSource,
-						"status":       ref.Status,
-					},
-				}, nil
+			for _, id := range ids {
+				if ref, ok := dj.result(id); ok && ref.Error != "" {
+					status := ref.Status
+					if status == 0 {
+						status = resp.StatusCode
+					}
+					return nil, refError(ids, id, statusError(status, ref.ErrorSource, ref.Error))
+				}
 			}
 		}
 
-		return []map[string]any{
-			{
-				"error":  strings.TrimSpace(string(raw)),
-				"status": resp.StatusCode,
-			},
-		}, nil
+		return nil, statusError(resp.StatusCode, "", strings.TrimSpace(string(raw)))
 	}
 
-	var parsed dsQueryResponse
-	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
-		return nil, fmt.Errorf("decode response JSON: %w", err)
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, transportError(fmt.Errorf("reading response body: %w", err))
 	}
+	dopts := decodeOptions{
+		Columnar:       opts.Columnar,
+		FrameEncoding:  opts.FrameEncoding,
+		MaxColumns:     opts.MaxColumns,
+		StrictColumns:  opts.StrictColumns,
+		MaxCellBytes:   opts.MaxCellBytes,
+		Nested:         opts.Nested,
+		FrameIndex:     opts.FrameIndex,
+		NullRates:      opts.NullRates,
+		Debug:          opts.Debug,
+		NonFiniteValue: opts.NonFiniteValue,
+		Concurrency:    c.decodeConcurrency,
+	}
+	if dopts.MaxColumns == 0 {
+		dopts.MaxColumns = c.maxColumns
+	}
+	if dopts.NonFiniteValue == "" {
+		dopts.NonFiniteValue = c.nonFiniteValue
+	}
+	results, err := decodeDSQueryResults(raw, ids, dopts)
+	if err != nil {
+		return nil, err
+	}
+	for i, res := range results {
+		res.EffectiveSQL = payload.Queries[i].RawSQL
+	}
+	return results, nil
+}
 
-	ref, ok := parsed.Results["A"]
-	if !ok {
-		return nil, fmt.Errorf("no result for refId A")
+// do sends req. If it fails because the kept-alive connection it was sent
+// on had been closed by Grafana or a proxy while idle, it is sent once more
+// on a new connection. Go's transport only does that by itself for
+// idempotent methods, and ds/query requests are POSTs.
+func (c *influxdbClient) do(req *http.Request) (*http.Response, error) {
+	var reused bool
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+	resp, err := c.httpClient.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err == nil || !reused || !isStaleConnError(err) || req.Context().Err() != nil || req.GetBody == nil {
+		return resp, err
+	}
+	body, bodyErr := req.GetBody()
+	if bodyErr != nil {
+		return nil, err
 	}
+	retry := req.Clone(req.Context())
+	retry.Body = body
+	// The other idle connections are likely just as stale. The transport is
+	// the InfluxDB client's own, so no other client's pool is affected.
+	c.httpClient.CloseIdleConnections()
+	return c.httpClient.Do(retry)
+}
 
-	if ref.Error != "" {
-		return []map[string]any{
-			{
-				"error":        ref.Error,
-				"error_source": ref.ErrorSource,
-				"status":       ref.Status,
-			},
-		}, nil
+// isStaleConnError reports whether err is what a request gets when the
+// connection it was sent on had been closed by the other end.
+func isStaleConnError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// MaxInfluxQueryChunks bounds the number of sub-queries a chunked query may
+// issue.
+const MaxInfluxQueryChunks = 100
+
+// timeMacros are the Grafana macros that make a query's SQL depend on the
+// request time range. Chunking only works if the SQL uses one of them.
+var timeMacros = []string{"$__timeFilter", "$__timeFrom", "$__timeTo", "$__timeRange"}
+
+// usesTimeMacro reports whether sql uses one of timeMacros.
+func usesTimeMacro(sql string) bool {
+	for _, m := range timeMacros {
+		if strings.Contains(sql, m) {
+			return true
+		}
 	}
+	return false
+}
 
-	if len(ref.Frames) == 0 {
-		return []map[string]any{}, nil
+// queryChunked splits the [opts.From, opts.To) range into consecutive
+// intervals, runs the query once per interval and concatenates the rows in
+// time order. The default limit is not applied to the chunks, which would
+// otherwise each be cut off at it.
+func (c *influxdbClient) queryChunked(ctx context.Context, sql string, opts queryOptions, interval time.Duration) (*queryResult, error) {
+	if !usesTimeMacro(sql) {
+		return nil, fmt.Errorf("chunked queries require the SQL to use a time macro such as $__timeFilter(time)")
 	}
 
-	var dataStr string
-	if err := json.Unmarshal(ref.Frames[0].Data, &dataStr); err == nil {
-		decBase64, err := base64.StdEncoding.DecodeString(dataStr)
+	span := opts.To.Sub(opts.From)
+	chunks := int((span + interval - 1) / interval)
+	if chunks > MaxInfluxQueryChunks {
+		return nil, fmt.Errorf("time range would be split into %d chunks, more than the maximum of %d; use a larger chunkInterval", chunks, MaxInfluxQueryChunks)
+	}
+
+	out := &queryResult{Rows: []map[string]any{}}
+	for start := opts.From; start.Before(opts.To); start = start.Add(interval) {
+		end := start.Add(interval)
+		if end.After(opts.To) {
+			end = opts.To
+		}
+		chunkOpts := opts
+		chunkOpts.From, chunkOpts.To = start, end
+		res, err := c.query(ctx, sql, chunkOpts)
 		if err != nil {
-			return nil, fmt.Errorf("base64 decode frame: %w", err)
+			return nil, fmt.Errorf("chunk %d-%d: %w", start.UnixMilli(), end.UnixMilli(), err)
+		}
+		if out.Columns == nil {
+			out.Columns = res.Columns
+		}
+		out.Rows = append(out.Rows, res.Rows...)
+		for _, w := range res.Warnings {
+			if !slices.Contains(out.Warnings, w) {
+				out.Warnings = append(out.Warnings, w)
+			}
+		}
+		for col, labels := range res.Labels {
+			if _, ok := out.Labels[col]; !ok {
+				if out.Labels == nil {
+					out.Labels = make(map[string]map[string]string)
+				}
+				out.Labels[col] = labels
+			}
+		}
+		out.ColumnsOmitted = max(out.ColumnsOmitted, res.ColumnsOmitted)
+		out.CellsTruncated += res.CellsTruncated
+		out.Nulls = out.Nulls.add(res.Nulls)
+		out.EffectiveSQL = res.EffectiveSQL
+		if out.Debug == nil {
+			out.Debug = res.Debug
+		}
+	}
+	return out, nil
+}
+
+type QueryInfluxSQLParams struct {
+	DatasourceUID      string            `json:"datasourceUid"            jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	OrgID              int64             `json:"orgId,omitempty"          jsonschema:"description=Grafana organization the datasource belongs to. Only needed in multi-org Grafana where the same UID exists in more than one organization; defaults to the organization of the credentials"`
+	GrafanaURL         string            `json:"grafanaUrl,omitempty"     jsonschema:"description=URL of the Grafana instance to query for this call\\, e.g. https://grafana-eu.example.com\\, instead of the one the server is configured with. The credentials of the request are sent to it\\, so it must be one of the Grafana URLs the server allows. Defaults to the configured Grafana"`
+	SkipValidation     bool              `json:"skipValidation,omitempty" jsonschema:"description=Query the datasource without first checking that it exists\\, saving a round trip to Grafana. A wrong datasourceUid is then reported by the query itself\\, with a less specific error. Only set it for UIDs known to be valid"`
+	SQL                string            `json:"sql"                      jsonschema:"required,description=SQL statement to execute. If the server has a default limit configured a SELECT without a LIMIT gets one\\, and if the result reaches it the response reports it in appliedLimit with a warning; write an explicit LIMIT to choose the number of rows"`
+	DashboardUID       string            `json:"dashboardUid,omitempty"   jsonschema:"description=UID of the dashboard the query is made for. Sent to Grafana so that the query is attributed to the dashboard in query history\\, caching and usage insights"`
+	PanelID            int64             `json:"panelId,omitempty"        jsonschema:"description=ID of the panel within dashboardUid the query is made for"`
+	From               string            `json:"from,omitempty"           jsonschema:"description=Start of the time range as epoch milliseconds\\, an ISO 8601 timestamp (e.g. '2024-05-01T12:00:00Z') or a relative expression like 'now-6h' or 'now/d' (start of today in UTC). Defaults to one hour before 'to'"`
+	To                 string            `json:"to,omitempty"             jsonschema:"description=End of the time range in the same forms as 'from'. Defaults to now"`
+	ChunkInterval      string            `json:"chunkInterval,omitempty"  jsonschema:"description=Optional duration (e.g. '1h' or '1d') used to split the time range into sequential sub-queries whose rows are concatenated in order. The SQL must use time macros such as $__timeFilter(time)"`
+	MaxPoints          int               `json:"maxPoints,omitempty"      jsonschema:"description=Downsample the result to at most this many rows using the largest-triangle-three-buckets algorithm over the time and value columns. The first and last points are always kept"`
+	TimeColumn         string            `json:"timeColumn,omitempty"     jsonschema:"description=Time column used when downsampling\\, paging or formatting as a chart. Defaults to the server's configured time column\\, normally 'time'"`
+	ValueColumn        string            `json:"valueColumn,omitempty"    jsonschema:"description=Numeric column used when downsampling. Defaults to the first numeric column"`
+	IntervalMs         int64             `json:"intervalMs,omitempty"     jsonschema:"description=Interval in milliseconds used by Grafana to resolve the $__interval and $__interval_ms macros. Defaults to Grafana's computed interval"`
+	QueryType          string            `json:"queryType,omitempty"      jsonschema:"description=Query language sent to the datasource\\, e.g. 'SQL' (default)\\, 'InfluxQL' or 'Flux'. Only needed for datasource versions that require it"`
+	UseServerCache     *bool             `json:"useServerCache,omitempty" jsonschema:"description=Set to false to bypass Grafana's query cache and fetch fresh results. By default cached results are used if query caching is enabled for the datasource. Query caching is only available in Grafana Enterprise and Grafana Cloud"`
+	SelectColumns      []string          `json:"selectColumns,omitempty"  jsonschema:"description=Return only these columns\\, dropping the others\\, to reduce the size of the result without changing the SQL. Columns keep their order in the result; names the result does not have are ignored"`
+	RedactColumns      []string          `json:"redactColumns,omitempty"  jsonschema:"description=Columns whose values are replaced with '***' in the returned rows"`
+	Pseudonymize       []string          `json:"pseudonymize,omitempty"   jsonschema:"description=Columns whose values are replaced with pseudonyms: tokens derived from the value and pseudonymSalt\\, so that equal values get equal tokens and stay joinable and groupable while the real values are removed. Requires pseudonymSalt"`
+	PseudonymSalt      string            `json:"pseudonymSalt,omitempty"  jsonschema:"description=Secret mixed into the pseudonyms of pseudonymize columns. The same salt gives the same tokens across calls; use a new one to make tokens unlinkable to earlier results"`
+	PageSize           int               `json:"pageSize,omitempty"       jsonschema:"description=Return the result a page of this many rows at a time\\, newest first\\, using keyset pagination on the time column. The response includes a nextPageToken while more rows may follow. The SQL must not apply its own ORDER BY or LIMIT\\, and paging moves strictly backwards in time: rows sharing a timestamp with the last row of a page are skipped\\, so timestamps should be unique within the result (e.g. filter to one series). Cannot be combined with maxRows or chunkInterval"`
+	PageToken          string            `json:"pageToken,omitempty"      jsonschema:"description=nextPageToken from the previous page. Requires pageSize and the same sql"`
+	SampleRate         float64           `json:"sampleRate,omitempty"     jsonschema:"description=Return a random subset of roughly this fraction of the rows (greater than 0 and at most 1)\\, e.g. 0.01 to explore a large table cheaply. Rows are sampled by the datasource using random() where supported and otherwise after fetching every row; the response's sampling field says which was used"`
+	TimeSpan           bool              `json:"timeSpan,omitempty"       jsonschema:"description=Also return timeSpan: the earliest and latest value of the time column (see timeColumn) in the returned rows\\, which may be narrower than the requested range. Useful to judge how fresh and complete the data is"`
+	ErrorOnEmpty       bool              `json:"errorOnEmpty,omitempty"   jsonschema:"description=Fail with a 'query returned no rows' error instead of returning an empty result when the query matches no rows"`
+	MaxRows            int               `json:"maxRows,omitempty"        jsonschema:"description=Maximum number of rows to return. When set the result is returned as {rows\\, truncated\\, totalSeen} so that a cut-off result is never mistaken for a complete one"`
+	MaxResultBytes     int               `json:"maxResultBytes,omitempty" jsonschema:"description=Maximum size of the returned rows in bytes. Rows are added in order until the next one would exceed it\\, and the result is returned as {rows\\, truncated\\, totalSeen\\, resultBytes}. The size is estimated as the JSON encoding of the rows with the chosen typePolicy\\, not counting the fields around them\\, which makes it a better guide than maxRows for staying within a token budget"`
+	Dedupe             bool              `json:"dedupe,omitempty"         jsonschema:"description=Remove rows which exactly duplicate an earlier row across all columns. The first occurrence of each row is kept in order"`
+	IncludeLabels      bool              `json:"includeLabels,omitempty"  jsonschema:"description=Return the result as {rows\\, labels} where labels maps each labeled column to its label set (e.g. {host: web1}). Useful for time series whose columns share a name"`
+	TypePolicy         string            `json:"typePolicy,omitempty"     jsonschema:"description=How values are converted in JSON output: 'jsonSafe' (default) returns plain values with times as RFC 3339 strings in UTC\\, 'string' returns every value as text and 'native' returns values as decoded"`
+	Columnar           bool              `json:"columnar,omitempty"       jsonschema:"description=Return the result column-oriented as {columns\\, data} where data maps each column to its array of values. More compact than row objects for wide or long results"`
+	CheckColumns       bool              `json:"checkColumns,omitempty"   jsonschema:"description=Before running the query check that the columns it references exist in the table it reads from and fail with a list of unknown columns if not. The check is best-effort: names it is not sure are meant as columns and queries it cannot analyse (e.g. joins or subqueries) run anyway with a warning"`
+	MaxColumns         int               `json:"maxColumns,omitempty"     jsonschema:"description=Return at most this many columns\\, in result order. Further columns are dropped and counted in columnsOmitted. Defaults to the server's limit\\, if any"`
+	StrictColumns      bool              `json:"strictColumns,omitempty"  jsonschema:"description=Fail instead of dropping columns when the result is wider than maxColumns"`
+	MaxCellBytes       int               `json:"maxCellBytes,omitempty"   jsonschema:"description=Truncate string values longer than this many bytes\\, marking them with '…(truncated)'\\, and return binary values as a note of their length instead of their bytes. The number of values changed is returned in cellsTruncated"`
+	Headers            []string          `json:"headers,omitempty"        jsonschema:"description=Extra HTTP headers sent to Grafana with the query as 'Name: value' strings\\, e.g. 'X-Grafana-Feature-Toggles: someToggle=true' to enable preview behaviour. Authentication and other headers set by the server cannot be overridden"`
+	Nested             bool              `json:"nested,omitempty"         jsonschema:"description=Decode list\\, struct and map columns into nested JSON arrays and objects. Without it results with such columns fail to decode. Use influxdb_result_schema to see how nested columns are structured"`
+	FrameIndex         int               `json:"frameIndex,omitempty"     jsonschema:"description=Which frame of the result to return\\, counting from 0 (the default). Only needed for queries returning several frames\\, such as one per series; an index past the last frame is an error"`
+	PreferJSON         bool              `json:"preferJSON,omitempty"     jsonschema:"description=Ask for frames as plain JSON instead of compressed Arrow\\, which is faster for small results. Datasources which do not support it return Arrow frames as usual"`
+	FrameEncoding      string            `json:"frameEncoding,omitempty"  jsonschema:"description=Compression of the Arrow frames Grafana returns: 'auto' (default) to detect it\\, or 'zstd'\\, 'gzip'\\, 'lz4' or 'none' to force it. Only needed if detection fails for a particular Grafana build"`
+	OrderedKeys        bool              `json:"orderedKeys,omitempty"    jsonschema:"description=Emit the keys of each row in the query's column order instead of alphabetically"`
+	KeyCase            string            `json:"keyCase,omitempty"        jsonschema:"description=Case of the column names in the result: 'asis' (default) keeps them as the datasource returns them\\, 'lower' or 'upper' converts them. Fails if two columns would get the same name"`
+	Summarize          bool              `json:"summarize,omitempty"      jsonschema:"description=Also return summary: the row count and for each column its type\\, non-null and null counts\\, min/max/mean of numeric columns\\, earliest/latest of time columns and the count of each value of string and boolean columns with at most 20 distinct values. It covers the whole result even if maxRows or maxResultBytes cut the rows short"`
+	NullRates          bool              `json:"nullRates,omitempty"      jsonschema:"description=Also return nullRates: the fraction of the values of each column which are null or empty strings\\, from 0 to 1. It is counted as the result is decoded\\, so it covers every row the query returned even if options such as dedupe or maxRows drop some"`
+	ReturnEffectiveSQL bool              `json:"returnEffectiveSQL,omitempty" jsonschema:"description=Also return effectiveSql: the SQL exactly as it was sent to Grafana\\, after the server's default LIMIT\\, paging\\, sampling and any SQL the server adds to every query. Grafana then expands macros such as $__timeFilter itself. Useful to debug why a query behaved unexpectedly"`
+	ConvertUnits       map[string]string `json:"convertUnits,omitempty"   jsonschema:"description=Map of column to the unit its values are converted to\\, e.g. {\"mem_used\": \"MB\"\\, \"latency\": \"ms\"}. The column's current unit is read from its field config. Data units are B\\, KB\\, MB\\, GB\\, TB and the binary KiB to TiB; time units ns\\, us\\, ms\\, s\\, m\\, h and d; ratios percent and percentunit. The conversions applied are returned as unitConversions"`
+	Debug              bool              `json:"debug,omitempty"          jsonschema:"description=Also return debug: the debug information Grafana attaches to the result\\, such as executedQueryString\\, the query the datasource sent to InfluxDB after expanding macros like $__timeFilter\\, and any notices and query statistics. The InfluxDB SQL datasource of Grafana 10 and later sets executedQueryString; older versions and other query languages may return nothing\\, in which case debug is omitted"`
+	NonFiniteValue     string            `json:"nonFiniteValue,omitempty" jsonschema:"description=What NaN and infinite float values are returned as\\, since JSON cannot represent them: null or a sentinel string such as 'NaN'. Defaults to the server's setting\\, normally null"`
+	ConfirmationToken  string            `json:"confirmationToken,omitempty" jsonschema:"description=Token from a preview returned instead of a result when the server requires confirmation. Repeat the call with the same datasourceUid\\, orgId\\, grafanaUrl\\, headers\\, queryType\\, sql\\, from\\, to\\, pageSize\\, pageToken\\, sampleRate and chunkInterval and this token to run the query. Tokens expire after 5 minutes and can be reused until then"`
+	Transpose          bool              `json:"transpose,omitempty"      jsonschema:"description=Flip the result so that each column becomes a row of {field\\, value}\\, easier to read for a single wide row such as a query describing one entity. Results with several rows get one value column per row\\, row_1\\, row_2 and so on. Only results of at most 10 rows can be transposed; larger ones fail. Not available with format 'arrow' or 'chart'"`
+	Hash               bool              `json:"hash,omitempty"           jsonschema:"description=Also return hash: a SHA-256 of the result which stays the same as long as the returned rows do\\, whatever their order. Compare it between polls to detect changes cheaply"`
+	HashOnly           bool              `json:"hashOnly,omitempty"       jsonschema:"description=Return only {hash\\, rowCount} instead of the rows\\, for change detection without transferring the result"`
+	Format             string            `json:"format,omitempty"         jsonschema:"description=Output format. Either 'json' (default) for an array of row objects\\, 'compact' for {cols\\, rows} where cols lists the column names once and each row is an array of values in the same order (row i is the object mapping cols[j] to rows[i][j])\\, which takes far fewer tokens than row objects for wide or long results\\, 'markdown' for a GitHub-flavored Markdown table\\, 'chart' for a time series shaped for plotting as {x\\, series: [{name\\, labels\\, y}]} where x holds the row times in epoch milliseconds and each numeric column becomes a series of y values aligned with x (null where missing)\\, 'trace' to request trace-formatted frames and return one object per span with nested fields such as tags and logs intact\\, or 'arrow' for {format\\, rows\\, data} and the metadata of 'json' where data is the result as a base64-encoded Arrow IPC stream with its exact column types\\, for Arrow-aware tools: in Python pyarrow.ipc.open_stream(base64.b64decode(data)).read_all() gives a table for pandas or DuckDB. 'arrow' cannot be combined with options which change values or rows\\, such as convertUnits\\, nonFiniteValue\\, dedupe or typePolicy 'string'. 'chart' requires the time column (see timeColumn) and at least one numeric column; 'trace' requires a datasource which returns trace frames"`
+	MaxColumnWidth     int               `json:"maxColumnWidth,omitempty" jsonschema:"description=Maximum characters per cell when format is 'markdown'. Longer values are truncated with an ellipsis. Defaults to 40"`
+}
+
+func queryInfluxSQL(ctx context.Context, args QueryInfluxSQLParams) (any, error) {
+	if args.SkipValidation {
+		ctx = WithSkipDatasourceValidation(ctx)
+	}
+	if args.GrafanaURL != "" {
+		var err error
+		if ctx, err = withGrafanaURL(ctx, args.GrafanaURL); err != nil {
+			return nil, err
 		}
-		arrowBytes, err := zstd.Decompress(nil, decBase64)
+	}
+	cli, err := newInfluxdbClientInOrg(ctx, args.DatasourceUID, args.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn == "" {
+		args.TimeColumn = cli.timeColumn
+	}
+	if args.FrameEncoding != "" && !slices.Contains(frameEncodings, args.FrameEncoding) {
+		return nil, fmt.Errorf("invalid frameEncoding %q: must be one of %s", args.FrameEncoding, strings.Join(frameEncodings, ", "))
+	}
+	if len(args.Pseudonymize) > 0 && args.PseudonymSalt == "" {
+		return nil, fmt.Errorf("pseudonymize requires pseudonymSalt")
+	}
+	if args.Transpose && args.Format == "chart" {
+		return nil, fmt.Errorf("transpose cannot be combined with format %q", args.Format)
+	}
+	if args.Format == "arrow" {
+		if err := checkArrowOptions(args); err != nil {
+			return nil, err
+		}
+	}
+	opts := queryOptions{
+		IntervalMs:     args.IntervalMs,
+		QueryType:      args.QueryType,
+		FrameEncoding:  args.FrameEncoding,
+		MaxColumns:     args.MaxColumns,
+		StrictColumns:  args.StrictColumns,
+		MaxCellBytes:   args.MaxCellBytes,
+		PreferJSON:     args.PreferJSON,
+		Nested:         args.Nested,
+		DashboardUID:   args.DashboardUID,
+		PanelID:        args.PanelID,
+		FrameIndex:     args.FrameIndex,
+		NullRates:      args.NullRates,
+		Debug:          args.Debug,
+		NonFiniteValue: args.NonFiniteValue,
+	}
+	if opts.Headers, err = parseQueryHeaders(args.Headers); err != nil {
+		return nil, err
+	}
+	if args.UseServerCache != nil && !*args.UseServerCache {
+		opts.SkipCache = true
+	}
+	if args.Format == "trace" {
+		opts.FrameFormat = "trace"
+	}
+	// Options which work on rows need the result decoded row by row; it is
+	// pivoted afterwards instead.
+	opts.Columnar = (args.Columnar || args.Format == "compact") && args.ChunkInterval == "" && args.MaxPoints == 0 &&
+		!args.Dedupe && len(args.RedactColumns) == 0 && len(args.Pseudonymize) == 0 && args.SampleRate == 0
+	if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+		return nil, err
+	}
+	sql := args.SQL
+	if args.PageSize > 0 || args.PageToken != "" {
+		if sql, err = pagedSQL(args); err != nil {
+			return nil, err
+		}
+	}
+
+	// Paged queries are already limited, and downsampling needs every row
+	// of the range.
+	if args.PageSize > 0 || args.MaxPoints > 0 {
+		cli.defaultLimit = 0
+	}
+	if cli.requireConfirmation {
+		if args.ConfirmationToken == "" {
+			return cli.previewQuery(ctx, cli.plannedSQL(sql, args), args, opts, time.Now())
+		}
+		if err := checkConfirmationToken(args, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	var warnings []string
+	if args.CheckColumns {
+		if warnings, err = cli.checkColumns(ctx, args.SQL); err != nil {
+			return nil, err
+		}
+	}
+	var res *queryResult
+	switch {
+	case args.SampleRate != 0:
+		if args.ChunkInterval != "" || args.PageSize > 0 {
+			return nil, fmt.Errorf("sampleRate cannot be combined with chunkInterval or pageSize")
+		}
+		if res, err = cli.querySampled(ctx, sql, args.SampleRate, opts); err != nil {
+			return nil, err
+		}
+	case args.ChunkInterval != "":
+		interval, err := parseInfluxDuration(args.ChunkInterval)
 		if err != nil {
-			return nil, fmt.Errorf("zstd decompress: %w", err)
+			return nil, fmt.Errorf("parsing chunkInterval: %w", err)
 		}
-		frames, err := data.UnmarshalArrowFrames([][]byte{arrowBytes})
+		res, err = cli.queryChunked(ctx, sql, opts, interval)
 		if err != nil {
-			return nil, fmt.Errorf("unmarshal arrow frame: %w", err)
-		}
-		if len(frames) == 0 {
-			return []map[string]any{}, nil
-		}
-		frame := frames[0]
-		numRows := frame.Rows()
-		records := make([]map[string]any, 0, numRows)
-		for i := 0; i < numRows; i++ {
-			row := make(map[string]any, len(frame.Fields))
-			for _, f := range frame.Fields {
-				row[f.Name] = f.At(i)
-			}
-			records = append(records, row)
+			return nil, err
+		}
+	default:
+		if res, err = cli.queryLimited(ctx, sql, opts); err != nil {
+			return nil, err
 		}
-		return records, nil
 	}
 
-	var obj struct {
-		Values [][]any `json:"values"`
+	if args.ErrorOnEmpty && res.numRows() == 0 {
+		return nil, fmt.Errorf("%w between %s and %s", ErrInfluxDBEmptyResult,
+			opts.From.UTC().Format(time.RFC3339), opts.To.UTC().Format(time.RFC3339))
 	}
-	if err := json.Unmarshal(ref.Frames[0].Data, &obj); err != nil {
-		return nil, fmt.Errorf("unknown data format: %w", err)
+	if args.PageSize > 0 && res.numRows() == args.PageSize {
+		last, err := lastRowTime(res, args.timeColumn())
+		if err != nil {
+			return nil, err
+		}
+		res.NextPageToken = encodePageToken(args.SQL, last)
+	}
+	res.Warnings = append(warnings, res.Warnings...)
+	if err := transformResult(res, args); err != nil {
+		return nil, err
 	}
-	return valuesMatrixToJSON(obj.Values, ref.Frames[0].Schema), nil
+	return formatResult(res, args)
 }
 
-// Expand the column-oriented values array into row-oriented format
-func valuesMatrixToJSON(vals [][]any, schema any) []map[string]any {
-	if len(vals) == 0 || len(vals[0]) == 0 {
-		return nil
+// timeColumn returns the time column the query's options refer to.
+func (args QueryInfluxSQLParams) timeColumn() string {
+	if args.TimeColumn != "" {
+		return args.TimeColumn
 	}
-	rows := len(vals[0])
-	cols := len(vals)
-	var fieldNames []string
-	if s, ok := schema.(map[string]any); ok {
-		if flds, ok := s["fields"].([]any); ok {
-			for _, f := range flds {
-				if fm, ok := f.(map[string]any); ok {
-					if name, ok := fm["name"].(string); ok {
-						fieldNames = append(fieldNames, name)
-					}
-				}
-			}
+	return defaultTimeColumn
+}
+
+// pagedSQL returns the statement for the page of args.SQL selected by the
+// paging options.
+func pagedSQL(args QueryInfluxSQLParams) (string, error) {
+	if args.PageSize <= 0 {
+		return "", fmt.Errorf("pageToken requires pageSize")
+	}
+	if args.ChunkInterval != "" {
+		return "", fmt.Errorf("pageSize cannot be combined with chunkInterval")
+	}
+	// The next page starts after the last row of this one, which maxRows
+	// would cut off.
+	if args.MaxRows > 0 {
+		return "", fmt.Errorf("pageSize cannot be combined with maxRows; use a smaller pageSize")
+	}
+	var before time.Time
+	if args.PageToken != "" {
+		var err error
+		if before, err = decodePageToken(args.SQL, args.PageToken); err != nil {
+			return "", err
 		}
 	}
-	out := make([]map[string]any, rows)
-	for r := 0; r < rows; r++ {
-		row := make(map[string]any, cols)
-		for c := 0; c < cols; c++ {
-			name := ""
-			if c < len(fieldNames) {
-				name = fieldNames[c]
-			} else {
-				name = fmt.Sprintf("col%d", c)
+	return keysetPageSQL(args.SQL, args.timeColumn(), before, args.PageSize), nil
+}
+
+// formatResult renders a transformed result in the requested output format.
+func formatResult(res *queryResult, args QueryInfluxSQLParams) (any, error) {
+	if args.HashOnly {
+		return hashResponse{Hash: resultHash(res), RowCount: res.numRows(), Truncated: res.TotalSeen > 0, Warnings: res.Warnings}, nil
+	}
+	var hash string
+	if args.Hash {
+		hash = resultHash(res)
+	}
+	var effectiveSQL string
+	if args.ReturnEffectiveSQL {
+		effectiveSQL = res.EffectiveSQL
+	}
+	if args.Transpose {
+		var err error
+		if res, err = transposeResult(res); err != nil {
+			return nil, err
+		}
+	}
+	if args.Columnar {
+		if args.Format != "" && args.Format != "json" {
+			return nil, fmt.Errorf("columnar output cannot be combined with format %q", args.Format)
+		}
+		if res.Data == nil {
+			res.Data = columnsFromRows(res)
+		}
+		for _, values := range res.Data {
+			if err := applyTypePolicyValues(values, args.TypePolicy); err != nil {
+				return nil, err
 			}
-			row[name] = vals[c][r]
 		}
-		out[r] = row
+		return columnarResponse{
+			Columns:         res.Columns,
+			Data:            res.Data,
+			Truncated:       res.TotalSeen > 0,
+			TotalSeen:       res.TotalSeen,
+			Warnings:        res.Warnings,
+			NextPageToken:   res.NextPageToken,
+			Sampling:        res.Sampling,
+			ColumnsOmitted:  res.ColumnsOmitted,
+			CellsTruncated:  res.CellsTruncated,
+			TimeSpan:        res.TimeSpan,
+			AppliedLimit:    res.AppliedLimit,
+			Hash:            hash,
+			ResultBytes:     res.ResultBytes,
+			Summary:         res.Summary,
+			NullRates:       res.NullRates,
+			EffectiveSQL:    effectiveSQL,
+			UnitConversions: res.UnitConversions,
+			Debug:           res.Debug,
+		}, nil
 	}
-	return out
-}
 
-type QueryInfluxSQLParams struct {
-	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
-	SQL           string `json:"sql"           jsonschema:"required,description=SQL statement to execute"`
+	switch args.Format {
+	case "", "json", "trace":
+		if err := applyTypePolicy(res.Rows, args.TypePolicy); err != nil {
+			return nil, err
+		}
+		var rows any = res.Rows
+		if args.OrderedKeys {
+			rows = orderedRows(res)
+		}
+		if args.IncludeLabels || args.MaxRows > 0 || args.MaxResultBytes > 0 || args.PageSize > 0 || res.Sampling != nil || res.ColumnsOmitted > 0 || res.CellsTruncated > 0 || res.AppliedLimit > 0 || res.Summary != nil || res.NullRates != nil || res.UnitConversions != nil || args.Hash || args.ReturnEffectiveSQL || args.Debug || args.TimeSpan || len(res.Warnings) > 0 {
+			resp := queryResponse{
+				Rows:            rows,
+				Truncated:       res.TotalSeen > 0,
+				TotalSeen:       res.TotalSeen,
+				Warnings:        res.Warnings,
+				NextPageToken:   res.NextPageToken,
+				Sampling:        res.Sampling,
+				ColumnsOmitted:  res.ColumnsOmitted,
+				CellsTruncated:  res.CellsTruncated,
+				TimeSpan:        res.TimeSpan,
+				AppliedLimit:    res.AppliedLimit,
+				Hash:            hash,
+				ResultBytes:     res.ResultBytes,
+				Summary:         res.Summary,
+				NullRates:       res.NullRates,
+				EffectiveSQL:    effectiveSQL,
+				UnitConversions: res.UnitConversions,
+				Debug:           res.Debug,
+			}
+			if args.IncludeLabels {
+				resp.Labels = res.Labels
+			}
+			return resp, nil
+		}
+		return rows, nil
+	case "compact":
+		rows := compactRows(res)
+		for _, row := range rows {
+			if err := applyTypePolicyValues(row, args.TypePolicy); err != nil {
+				return nil, err
+			}
+		}
+		return compactResponse{
+			Cols:            res.Columns,
+			Rows:            rows,
+			Truncated:       res.TotalSeen > 0,
+			TotalSeen:       res.TotalSeen,
+			Warnings:        res.Warnings,
+			NextPageToken:   res.NextPageToken,
+			Sampling:        res.Sampling,
+			ColumnsOmitted:  res.ColumnsOmitted,
+			CellsTruncated:  res.CellsTruncated,
+			TimeSpan:        res.TimeSpan,
+			AppliedLimit:    res.AppliedLimit,
+			Hash:            hash,
+			Summary:         res.Summary,
+			NullRates:       res.NullRates,
+			EffectiveSQL:    effectiveSQL,
+			UnitConversions: res.UnitConversions,
+			Debug:           res.Debug,
+		}, nil
+	case "arrow":
+		return arrowIPCResult(res, hash, effectiveSQL)
+	case "chart":
+		chart, err := chartResult(res, args.timeColumn())
+		if err != nil {
+			return nil, err
+		}
+		chart.Truncated, chart.Warnings = res.TotalSeen > 0, res.Warnings
+		return chart, nil
+	case "markdown":
+		table := renderMarkdownTable(res, args.MaxColumnWidth)
+		if res.TotalSeen > 0 {
+			table += fmt.Sprintf("\n\n_Truncated: showing %d of %d rows._", len(res.Rows), res.TotalSeen)
+		}
+		for _, w := range res.Warnings {
+			table += "\n\n_Warning: " + w + "_"
+		}
+		if res.ColumnsOmitted > 0 {
+			table += fmt.Sprintf("\n\n_%d more columns omitted._", res.ColumnsOmitted)
+		}
+		if res.CellsTruncated > 0 {
+			table += fmt.Sprintf("\n\n_%d values truncated to %d bytes._", res.CellsTruncated, args.MaxCellBytes)
+		}
+		if s := res.Sampling; s != nil {
+			table += fmt.Sprintf("\n\n_Sampled: about %g of rows, %s-side._", s.Rate, s.Method)
+		}
+		if res.AppliedLimit > 0 {
+			table += fmt.Sprintf("\n\n_The server's default LIMIT %d was applied._", res.AppliedLimit)
+		}
+		if span := res.TimeSpan; span != nil {
+			table += fmt.Sprintf("\n\n_Rows span %s to %s._", span.Start, span.End)
+		}
+		if hash != "" {
+			table += "\n\n_Hash: " + hash + "_"
+		}
+		if res.Summary != nil {
+			table += "\n\n" + renderSummary(res.Summary)
+		}
+		if res.NullRates != nil {
+			table += "\n\n" + renderNullRates(res.Columns, res.NullRates)
+		}
+		if len(res.UnitConversions) > 0 {
+			table += "\n\n" + renderUnitConversions(res.UnitConversions)
+		}
+		if effectiveSQL != "" {
+			table += "\n\n_Effective SQL:_\n\n```sql\n" + effectiveSQL + "\n```"
+		}
+		if d := res.Debug; d != nil && d.ExecutedQueryString != "" {
+			table += "\n\n_Executed query:_\n\n```sql\n" + d.ExecutedQueryString + "\n```"
+		}
+		if res.NextPageToken != "" {
+			table += "\n\n_More rows may follow: pass pageToken " + res.NextPageToken + " for the next page._"
+		}
+		return table, nil
+	default:
+		return nil, fmt.Errorf("invalid format %q: must be 'json', 'compact', 'markdown', 'chart', 'trace' or 'arrow'", args.Format)
+	}
 }
 
-func queryInfluxSQL(ctx context.Context, args QueryInfluxSQLParams) ([]map[string]any, error) {
-	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
-	if err != nil {
-		return nil, err
+// transformResult applies the client-side post-processing options to the
+// decoded result, in place.
+func transformResult(res *queryResult, args QueryInfluxSQLParams) error {
+	if args.Dedupe {
+		res.Rows = dedupeRows(res.Rows)
+	}
+	if len(args.ConvertUnits) > 0 {
+		var err error
+		if res.UnitConversions, err = convertUnits(res, args.ConvertUnits); err != nil {
+			return err
+		}
+	}
+	if args.MaxPoints > 0 {
+		timeCol := args.timeColumn()
+		valueCol := args.ValueColumn
+		if valueCol == "" {
+			if valueCol = firstNumericColumn(res, timeCol); valueCol == "" {
+				return fmt.Errorf("downsampling requires a numeric value column; set valueColumn")
+			}
+		}
+		var err error
+		if res.Rows, err = downsampleLTTB(res.Rows, timeCol, valueCol, args.MaxPoints); err != nil {
+			return fmt.Errorf("downsampling: %w", err)
+		}
+	}
+	if args.Summarize {
+		var err error
+		if res.Summary, err = summarizeResult(res, args); err != nil {
+			return err
+		}
+	}
+	if res.Nulls != nil {
+		var err error
+		if res.NullRates, err = nullRates(res.Nulls, args); err != nil {
+			return err
+		}
+	}
+	if n := res.numRows(); args.MaxRows > 0 && n > args.MaxRows {
+		res.TotalSeen = n
+		if res.Data != nil {
+			for col, values := range res.Data {
+				res.Data[col] = values[:args.MaxRows]
+			}
+		} else {
+			res.Rows = res.Rows[:args.MaxRows]
+		}
+	}
+	if args.TimeSpan {
+		// Computed before selectColumns, which may drop the time column.
+		if res.TimeSpan = observedTimeSpan(res, args.timeColumn()); res.TimeSpan == nil {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("timeSpan not computed: the result has no values in time column %q", args.timeColumn()))
+		}
+	}
+	if len(args.SelectColumns) > 0 {
+		selectColumns(res, args.SelectColumns)
+	}
+	if len(args.RedactColumns) > 0 {
+		redactColumns(res.Rows, args.RedactColumns)
+	}
+	if len(args.Pseudonymize) > 0 {
+		pseudonymizeColumns(res.Rows, args.Pseudonymize, args.PseudonymSalt)
+	}
+	if err := normalizeKeyCase(res, args.KeyCase); err != nil {
+		return err
 	}
-	return cli.query(ctx, args.SQL)
+	if args.MaxResultBytes > 0 {
+		return limitResultBytes(res, args.MaxResultBytes, args.TypePolicy)
+	}
+	return nil
 }
 
 var QueryInfluxSQL = mcpgrafana.MustTool(
 	"query_influxdb_sql",
-	"InfluxDB v3 datasource: Executes arbitrary SQL and returns the results as an array of JSON objects, one per row.",
+	"InfluxDB v3 datasource: Executes arbitrary SQL and returns the results as an array of JSON objects, one per row. Set format to 'markdown' to receive a Markdown table instead, or to 'compact' for {cols, rows} with the column names once and each row as an array of values in that order, which saves tokens on large results. If the server requires confirmation, a call without confirmationToken returns {confirmationRequired, confirmationToken, expiresAt, estimate, plan} instead of running the query: check the estimated cost, then repeat the call with the token to run it.",
 	queryInfluxSQL,
 )
 
+// influxDBTools are the InfluxDB tools, in the order they are registered.
+var influxDBTools = []mcpgrafana.Tool{
+	QueryInfluxSQL,
+	InfluxDBCapabilities,
+	InfluxDBTableRowCounts,
+	PollInfluxDBUntil,
+	InfluxDBRecentQueries,
+	ResolveInfluxDBTimeRange,
+	ExploreInfluxDBTable,
+	InfluxDBCompareWindows,
+	JoinInfluxDBQueries,
+	InfluxDBColumnStats,
+	RunNamedInfluxDBQuery,
+	InfluxDBDetectNewColumns,
+	AssertInfluxDBQuery,
+	InfluxDBTableDDL,
+	QueryInfluxDBWaitForData,
+	SearchInfluxDBTables,
+	TailInfluxDBTable,
+	CheckInfluxDBWriteAccess,
+	RunInfluxDBQuerySteps,
+	InfluxDBResultSchema,
+	InfluxDBSQLReference,
+	InfluxDBIngestionErrors,
+	InfluxDBFindOutliers,
+	ResolveInfluxDBDatasource,
+	InfluxDBHistogram,
+	InfluxDBClusterSeries,
+	InfluxDBTimeGranularity,
+	DiffInfluxDBResults,
+	InfluxDBColumnCardinality,
+	InfluxDBTimeseries,
+	InfluxDBDatasourceInfo,
+	InfluxDBAggregate,
+}
+
+// AddInfluxDBTools registers the InfluxDB tools. Their datasourceUid
+// parameter may also be given as one of datasourceUIDAliases.
 func AddInfluxDBTools(mcp *server.MCPServer) {
-	QueryInfluxSQL.Register(mcp)
+	for _, t := range influxDBTools {
+		t = withDatasourceUIDAliases(t)
+		t.Register(mcp)
+	}
 }
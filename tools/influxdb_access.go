@@ -0,0 +1,109 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-openapi-client-go/client"
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+type CheckInfluxDBWriteAccessParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Database      string `json:"database" jsonschema:"required,description=Database (bucket) to check write access to"`
+}
+
+type writeAccessResult struct {
+	// CanWrite is only meaningful if Indeterminate is false.
+	CanWrite      bool   `json:"canWrite"`
+	Indeterminate bool   `json:"indeterminate"`
+	Reason        string `json:"reason"`
+	// Status is the HTTP status of the probe, if it got a response.
+	Status int `json:"status,omitempty"`
+}
+
+// maxProbeBodyBytes caps how much of a probe response is quoted in reasons.
+const maxProbeBodyBytes = 200
+
+// writeProbeURL returns the URL of the InfluxDB v2-compatible write endpoint
+// of a datasource, reached through Grafana's datasource proxy. InfluxDB v3
+// serves it on every edition.
+func writeProbeURL(grafanaURL, uid, database string) string {
+	q := url.Values{"bucket": {database}, "precision": {"ns"}}
+	return fmt.Sprintf("%s/api/datasources/proxy/uid/%s/api/v2/write?%s", grafanaURL, url.PathEscape(uid), q.Encode())
+}
+
+// classifyWriteProbe interprets the response to an empty write. InfluxDB
+// checks the token before it looks at the body, so a rejected body means the
+// write would have been authorized.
+func classifyWriteProbe(status int, body string) *writeAccessResult {
+	res := &writeAccessResult{Status: status}
+	quoted := ""
+	if body = strings.TrimSpace(body); body != "" {
+		if len(body) > maxProbeBodyBytes {
+			body = body[:maxProbeBodyBytes] + "..."
+		}
+		quoted = ": " + body
+	}
+	switch {
+	case status == http.StatusNoContent || status == http.StatusOK:
+		res.CanWrite = true
+		res.Reason = "an empty write was accepted"
+	case status == http.StatusBadRequest:
+		res.CanWrite = true
+		res.Reason = "the credentials were accepted and only the empty body was rejected" + quoted
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		res.Reason = fmt.Sprintf("the write was refused with status %d%s", status, quoted)
+	default:
+		res.Indeterminate = true
+		res.Reason = fmt.Sprintf("the write endpoint answered with status %d%s; the datasource or Grafana may not allow writes through the datasource proxy, so write access could not be determined", status, quoted)
+	}
+	return res
+}
+
+// checkWriteAccess probes whether the credentials in use may write to
+// database by sending an empty write, which InfluxDB authorizes but which
+// persists nothing. InfluxDB has no dry-run write, so this is the closest
+// available check.
+func (c *influxdbClient) checkWriteAccess(ctx context.Context, database string) (*writeAccessResult, error) {
+	if database == "" {
+		return nil, fmt.Errorf("database is required")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, writeProbeURL(c.grafanaURL(), c.uid, database), strings.NewReader(""))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.orgID != 0 {
+		req.Header.Set(client.OrgIDHeader, strconv.FormatInt(c.orgID, 10))
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return &writeAccessResult{Indeterminate: true, Reason: fmt.Sprintf("the write probe failed: %v", err)}, nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxProbeBodyBytes+1))
+	return classifyWriteProbe(resp.StatusCode, string(body)), nil
+}
+
+func checkInfluxDBWriteAccess(ctx context.Context, args CheckInfluxDBWriteAccessParams) (*writeAccessResult, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	return cli.checkWriteAccess(ctx, args.Database)
+}
+
+var CheckInfluxDBWriteAccess = mcpgrafana.MustTool(
+	"check_influxdb_write_access",
+	"InfluxDB v3 datasource: Checks whether the datasource's credentials may write to a database, without writing any data, by sending an empty write through Grafana's datasource proxy. Returns {canWrite, indeterminate, reason}. InfluxDB has no dry-run write, so if the proxy or server does not give a clear answer the result is reported as indeterminate rather than guessed.",
+	checkInfluxDBWriteAccess,
+)
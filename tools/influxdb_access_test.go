@@ -0,0 +1,52 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbCheckWriteAccess(t *testing.T) {
+	for _, tc := range []struct {
+		name                    string
+		status                  int
+		body                    string
+		canWrite, indeterminate bool
+	}{
+		{"accepted", http.StatusNoContent, "", true, false},
+		{"empty body rejected", http.StatusBadRequest, `{"code":"invalid","message":"no data"}`, true, false},
+		{"unauthorized", http.StatusUnauthorized, `{"code":"unauthorized"}`, false, false},
+		{"forbidden", http.StatusForbidden, "", false, false},
+		{"proxy refuses", http.StatusMethodNotAllowed, "", false, true},
+		{"not found", http.StatusNotFound, "", false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				path, query string
+				body        []byte
+			)
+			cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+				path, query = r.URL.Path, r.URL.RawQuery
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			})
+			res, err := cli.checkWriteAccess(context.Background(), "my db")
+			require.NoError(t, err)
+			assert.Equal(t, tc.canWrite, res.CanWrite, res.Reason)
+			assert.Equal(t, tc.indeterminate, res.Indeterminate)
+			assert.Equal(t, tc.status, res.Status)
+			assert.NotEmpty(t, res.Reason)
+			assert.Equal(t, "/api/datasources/proxy/uid/influx/api/v2/write", path)
+			assert.Equal(t, "bucket=my+db&precision=ns", query)
+			assert.Empty(t, body, "the probe must not write data")
+		})
+	}
+}
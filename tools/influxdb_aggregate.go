@@ -0,0 +1,215 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+const (
+	// MaxInfluxAggregates is the maximum number of aggregates computed in one
+	// call.
+	MaxInfluxAggregates = 50
+	// MaxInfluxAggregateGroups is the maximum number of groups returned.
+	MaxInfluxAggregateGroups = 1000
+)
+
+// aggregateFunction is an aggregate the aggregate tools can compute: its SQL
+// expression, with %[1]s the column and %[2]s the time column, and whether it
+// only applies to numeric columns.
+type aggregateFunction struct {
+	expr    string
+	numeric bool
+}
+
+// aggregateFunctions are the aggregates influxdb_aggregate and
+// influxdb_timeseries accept, by name.
+var aggregateFunctions = map[string]aggregateFunction{
+	"count":          {"COUNT(%[1]s)", false},
+	"count_distinct": {"COUNT(DISTINCT %[1]s)", false},
+	"min":            {"MIN(%[1]s)", false},
+	"max":            {"MAX(%[1]s)", false},
+	"first":          {"first_value(%[1]s ORDER BY %[2]s)", false},
+	"last":           {"last_value(%[1]s ORDER BY %[2]s)", false},
+	"sum":            {"SUM(%[1]s)", true},
+	"mean":           {"AVG(%[1]s)", true},
+	"median":         {"median(%[1]s)", true},
+	"stddev":         {"STDDEV(%[1]s)", true},
+	"p90":            {"approx_percentile_cont(%[1]s, 0.9)", true},
+	"p95":            {"approx_percentile_cont(%[1]s, 0.95)", true},
+	"p99":            {"approx_percentile_cont(%[1]s, 0.99)", true},
+}
+
+// aggregateNames lists the names of aggregateFunctions, sorted, for error
+// messages.
+func aggregateNames() string {
+	names := make([]string, 0, len(aggregateFunctions))
+	for name := range aggregateFunctions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// lookupAggregate returns the aggregate named name, or an error listing the
+// valid names.
+func lookupAggregate(name string) (aggregateFunction, error) {
+	fn, ok := aggregateFunctions[name]
+	if !ok {
+		return aggregateFunction{}, fmt.Errorf("invalid aggregate %q: must be one of %s", name, aggregateNames())
+	}
+	return fn, nil
+}
+
+type aggregateSpec struct {
+	Function string `json:"function" jsonschema:"required,description=Aggregate function: count\\, count_distinct\\, min\\, max\\, first\\, last\\, sum\\, mean\\, median\\, stddev\\, p90\\, p95 or p99. The last seven need a numeric column"`
+	Column   string `json:"column,omitempty" jsonschema:"description=Column aggregated. May be omitted for count\\, which then counts rows"`
+}
+
+type InfluxDBAggregateParams struct {
+	DatasourceUID string          `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table         string          `json:"table" jsonschema:"required,description=Table (measurement) to aggregate"`
+	Aggregates    []aggregateSpec `json:"aggregates" jsonschema:"required,description=Aggregates to compute\\, e.g. [{function: count}\\, {function: max\\, column: usage}]. Each is returned as a column named function_column\\, e.g. max_usage\\, or count for a row count. At most 50"`
+	GroupBy       []string        `json:"groupBy,omitempty" jsonschema:"description=Columns to group by\\, usually tags such as host. Every aggregate is computed per group\\, and groups are returned sorted by these columns. Defaults to a single row over the whole range"`
+	Database      string          `json:"database,omitempty" jsonschema:"description=Schema the table is in. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+	From          string          `json:"from,omitempty" jsonschema:"description=If set\\, only rows at or after this time are aggregated. Epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Aggregating the whole table can be slow for large tables"`
+	To            string          `json:"to,omitempty" jsonschema:"description=If set\\, only rows before this time are aggregated. Defaults to now when from is set"`
+	TimeColumn    string          `json:"timeColumn,omitempty" jsonschema:"description=Name of the table's time column. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type aggregateResult struct {
+	Table string `json:"table"`
+	SQL   string `json:"sql"`
+	// Columns are the groupBy columns followed by one column per aggregate.
+	Columns []string     `json:"columns"`
+	Rows    []orderedRow `json:"rows"`
+	// Truncated is set if there were more groups than were returned.
+	Truncated bool `json:"truncated"`
+}
+
+// aggregateAlias names the result column of an aggregate.
+func aggregateAlias(spec aggregateSpec) string {
+	if spec.Column == "" {
+		return spec.Function
+	}
+	return spec.Function + "_" + spec.Column
+}
+
+// aggregateSQL computes every aggregate in a single statement, grouped by the
+// groupBy columns. The specs must have been validated.
+func aggregateSQL(schema, table, timeColumn string, specs []aggregateSpec, groupBy []string, timeFilter bool) string {
+	exprs := make([]string, 0, len(groupBy)+len(specs))
+	positions := make([]string, len(groupBy))
+	for i, c := range groupBy {
+		exprs = append(exprs, quoteIdent(c))
+		positions[i] = fmt.Sprint(i + 1)
+	}
+	for _, spec := range specs {
+		arg := "*"
+		if spec.Column != "" {
+			arg = quoteIdent(spec.Column)
+		}
+		expr := fmt.Sprintf(aggregateFunctions[spec.Function].expr, arg, quoteIdent(timeColumn))
+		exprs = append(exprs, expr+" AS "+quoteIdent(aggregateAlias(spec)))
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), qualifiedTable(schema, table))
+	if timeFilter {
+		sql += fmt.Sprintf(" WHERE $__timeFilter(%s)", quoteIdent(timeColumn))
+	}
+	if len(groupBy) > 0 {
+		by := strings.Join(positions, ", ")
+		sql += fmt.Sprintf(" GROUP BY %s ORDER BY %s LIMIT %d", by, by, MaxInfluxAggregateGroups)
+	}
+	return sql
+}
+
+// aggregate computes several aggregates of a table in one query. The
+// functions and columns are checked first, so that mistakes are reported by
+// name rather than as SQL errors.
+func (c *influxdbClient) aggregate(ctx context.Context, schema, table string, specs []aggregateSpec, groupBy []string, opts queryOptions) (*aggregateResult, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("at least one aggregate is required")
+	}
+	if len(specs) > MaxInfluxAggregates {
+		return nil, fmt.Errorf("%d aggregates requested, more than the maximum of %d per call", len(specs), MaxInfluxAggregates)
+	}
+	described, err := c.describeTable(ctx, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(described))
+	for _, col := range described {
+		types[col.Name] = col.DataType
+	}
+	for _, col := range groupBy {
+		if _, ok := types[col]; !ok {
+			return nil, fmt.Errorf("groupBy column %q not found in table %q", col, table)
+		}
+	}
+	columns := slices.Clone(groupBy)
+	for i, spec := range specs {
+		fn, err := lookupAggregate(spec.Function)
+		if err != nil {
+			return nil, fmt.Errorf("aggregates[%d]: %w", i, err)
+		}
+		switch t, ok := types[spec.Column]; {
+		case spec.Column == "" && spec.Function != "count":
+			return nil, fmt.Errorf("aggregates[%d]: %s needs a column", i, spec.Function)
+		case spec.Column != "" && !ok:
+			return nil, fmt.Errorf("aggregates[%d]: column %q not found in table %q", i, spec.Column, table)
+		case fn.numeric && !isNumericType(t):
+			return nil, fmt.Errorf("aggregates[%d]: %s needs a numeric column, and %q is %s", i, spec.Function, spec.Column, t)
+		}
+		alias := aggregateAlias(spec)
+		if slices.Contains(columns, alias) {
+			return nil, fmt.Errorf("aggregates[%d]: %s is requested twice or collides with a groupBy column", i, alias)
+		}
+		columns = append(columns, alias)
+	}
+
+	sql := aggregateSQL(schema, table, c.timeCol(), specs, groupBy, !opts.From.IsZero())
+	res, err := c.query(ctx, sql, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyTypePolicy(res.Rows, typePolicyJSONSafe); err != nil {
+		return nil, err
+	}
+	if schema == "" {
+		schema = defaultInfluxSchema
+	}
+	return &aggregateResult{
+		Table:     schema + "." + table,
+		SQL:       sql,
+		Columns:   columns,
+		Rows:      orderedRows(res),
+		Truncated: len(groupBy) > 0 && len(res.Rows) == MaxInfluxAggregateGroups,
+	}, nil
+}
+
+func influxDBAggregate(ctx context.Context, args InfluxDBAggregateParams) (*aggregateResult, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	var opts queryOptions
+	if args.From != "" {
+		if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return cli.aggregate(ctx, args.Database, args.Table, args.Aggregates, args.GroupBy, opts)
+}
+
+var InfluxDBAggregate = mcpgrafana.MustTool(
+	"influxdb_aggregate",
+	"InfluxDB v3 datasource: Computes several aggregates of a table in a single query instead of one round trip each, e.g. the row count, max and p95 of a column per host. Each aggregate is a {function, column} pair; functions are count, count_distinct, min, max, first, last, sum, mean, median, stddev, p90, p95 and p99. Returns {table, sql, columns, rows, truncated}, one row per combination of the groupBy columns sorted by them, with each aggregate in a column named function_column, e.g. max_usage. Functions and columns are checked against the table before the query runs.",
+	influxDBAggregate,
+)
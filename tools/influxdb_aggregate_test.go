@@ -0,0 +1,83 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbAggregate(t *testing.T) {
+	columns := arrowResponse(t, data.NewFrame("",
+		data.NewField("column_name", nil, []string{"time", "host", "region", "usage"}),
+		data.NewField("data_type", nil, []string{"Timestamp(Nanosecond, None)", "Dictionary(Int32, Utf8)", "Dictionary(Int32, Utf8)", "Float64"}),
+		data.NewField("is_nullable", nil, []string{"NO", "YES", "YES", "YES"}),
+	))
+	var sent []string
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sql := decodePayload(t, r).Queries[0].RawSQL
+		if strings.Contains(sql, "information_schema") {
+			_, _ = w.Write(columns)
+			return
+		}
+		sent = append(sent, sql)
+		_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+			data.NewField("host", nil, []string{"web1", "web2"}),
+			data.NewField("count", nil, []int64{10, 12}),
+			data.NewField("max_usage", nil, []float64{0.9, 0.7}),
+			data.NewField("p95_usage", nil, []float64{0.85, 0.65}),
+			data.NewField("count_distinct_region", nil, []int64{1, 2}),
+		)))
+	})
+	specs := []aggregateSpec{{Function: "count"}, {Function: "max", Column: "usage"}, {Function: "p95", Column: "usage"}, {Function: "count_distinct", Column: "region"}}
+
+	t.Run("grouped", func(t *testing.T) {
+		sent = nil
+		t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		res, err := cli.aggregate(context.Background(), "", "cpu", specs, []string{"host"}, queryOptions{From: t0, To: t0.Add(time.Hour)})
+		require.NoError(t, err)
+		require.Len(t, sent, 1, "every aggregate is computed in one query")
+		assert.Equal(t, `SELECT "host", COUNT(*) AS "count", MAX("usage") AS "max_usage", approx_percentile_cont("usage", 0.95) AS "p95_usage", COUNT(DISTINCT "region") AS "count_distinct_region" FROM "cpu" WHERE $__timeFilter("time") GROUP BY 1 ORDER BY 1 LIMIT 1000`, sent[0])
+		assert.Equal(t, "iox.cpu", res.Table)
+		assert.Equal(t, []string{"host", "count", "max_usage", "p95_usage", "count_distinct_region"}, res.Columns)
+		require.Len(t, res.Rows, 2)
+		assert.Equal(t, map[string]any{"host": "web2", "count": int64(12), "max_usage": 0.7, "p95_usage": 0.65, "count_distinct_region": int64(2)}, res.Rows[1].values)
+		assert.False(t, res.Truncated)
+	})
+
+	t.Run("ungrouped", func(t *testing.T) {
+		sent = nil
+		_, err := cli.aggregate(context.Background(), "", "cpu", specs[:2], nil, queryOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, `SELECT COUNT(*) AS "count", MAX("usage") AS "max_usage" FROM "cpu"`, sent[0])
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		sent = nil
+		for _, tc := range []struct {
+			specs   []aggregateSpec
+			groupBy []string
+			want    string
+		}{
+			{nil, nil, "at least one aggregate"},
+			{[]aggregateSpec{{Function: "DROP TABLE cpu", Column: "usage"}}, nil, `invalid aggregate "DROP TABLE cpu"`},
+			{[]aggregateSpec{{Function: "max"}}, nil, "max needs a column"},
+			{[]aggregateSpec{{Function: "max", Column: "idle"}}, nil, `column "idle" not found`},
+			{[]aggregateSpec{{Function: "mean", Column: "host"}}, nil, "needs a numeric column"},
+			{[]aggregateSpec{{Function: "max", Column: "usage"}, {Function: "max", Column: "usage"}}, nil, "requested twice"},
+			{specs, []string{"zone"}, `groupBy column "zone" not found`},
+		} {
+			_, err := cli.aggregate(context.Background(), "", "cpu", tc.specs, tc.groupBy, queryOptions{})
+			assert.ErrorContains(t, err, tc.want)
+		}
+		assert.Empty(t, sent, "invalid aggregates are rejected before querying")
+	})
+}
@@ -0,0 +1,81 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+	"github.com/invopop/jsonschema"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// datasourceUIDParam is the canonical name of the datasource UID parameter of
+// the InfluxDB tools.
+const datasourceUIDParam = "datasourceUid"
+
+// datasourceUIDAliases are the other names accepted for datasourceUid, the
+// variations agents most often send instead. Only datasourceUid is in the
+// tools' schemas, whose description lists the aliases; an alias is used only
+// if datasourceUid is not given.
+var datasourceUIDAliases = []string{"datasource_uid", "datasourceUID", "dsUid", "dsUID", "uid"}
+
+// normalizeDatasourceUID renames an alias of datasourceUid in the arguments
+// of a tool call to datasourceUid, in place. It fails if several aliases are
+// given with different values, as which one was meant is unclear.
+func normalizeDatasourceUID(args map[string]any) error {
+	if _, ok := args[datasourceUIDParam]; ok {
+		return nil
+	}
+	var (
+		found string
+		value any
+	)
+	for _, alias := range datasourceUIDAliases {
+		v, ok := args[alias]
+		if !ok {
+			continue
+		}
+		if found != "" && !reflect.DeepEqual(v, value) {
+			return fmt.Errorf("both %s and %s are given with different values; pass the datasource UID once as %s", found, alias, datasourceUIDParam)
+		}
+		found, value = alias, v
+	}
+	if found != "" {
+		args[datasourceUIDParam] = value
+		for _, alias := range datasourceUIDAliases {
+			delete(args, alias)
+		}
+	}
+	return nil
+}
+
+// withDatasourceUIDAliases returns t with its handler accepting
+// datasourceUIDAliases for datasourceUid, and the aliases named in the
+// parameter's description. Tools without a datasourceUid parameter are
+// returned unchanged.
+func withDatasourceUIDAliases(t mcpgrafana.Tool) mcpgrafana.Tool {
+	prop, ok := t.Tool.InputSchema.Properties[datasourceUIDParam]
+	if !ok {
+		return t
+	}
+	if schema, ok := prop.(*jsonschema.Schema); ok {
+		// The schema and its properties are shared with the unwrapped tool.
+		described := *schema
+		last := len(datasourceUIDAliases) - 1
+		described.Description = fmt.Sprintf("%s. Also accepted as %s or %s", strings.TrimSuffix(schema.Description, "."),
+			strings.Join(datasourceUIDAliases[:last], ", "), datasourceUIDAliases[last])
+		t.Tool.InputSchema.Properties = maps.Clone(t.Tool.InputSchema.Properties)
+		t.Tool.InputSchema.Properties[datasourceUIDParam] = &described
+	}
+	handler := t.Handler
+	t.Handler = func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if err := normalizeDatasourceUID(request.Params.Arguments); err != nil {
+			return nil, err
+		}
+		return handler(ctx, request)
+	}
+	return t
+}
@@ -0,0 +1,65 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+	"github.com/invopop/jsonschema"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeDatasourceUID(t *testing.T) {
+	for _, alias := range datasourceUIDAliases {
+		args := map[string]any{alias: "influx", "sql": "SELECT 1"}
+		require.NoError(t, normalizeDatasourceUID(args))
+		assert.Equal(t, map[string]any{"datasourceUid": "influx", "sql": "SELECT 1"}, args, alias)
+	}
+
+	args := map[string]any{"datasourceUid": "influx", "uid": "other"}
+	require.NoError(t, normalizeDatasourceUID(args))
+	assert.Equal(t, "influx", args["datasourceUid"], "the canonical name wins")
+
+	args = map[string]any{"dsUid": "influx", "uid": "influx"}
+	require.NoError(t, normalizeDatasourceUID(args))
+	assert.Equal(t, map[string]any{"datasourceUid": "influx"}, args)
+
+	err := normalizeDatasourceUID(map[string]any{"dsUid": "influx", "uid": "other"})
+	assert.ErrorContains(t, err, "different values")
+
+	require.NoError(t, normalizeDatasourceUID(nil))
+}
+
+func TestWithDatasourceUIDAliases(t *testing.T) {
+	type params struct {
+		DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=Datasource UID"`
+	}
+	tool := withDatasourceUIDAliases(mcpgrafana.MustTool("echo", "Echoes the UID", func(ctx context.Context, args params) (string, error) {
+		return args.DatasourceUID, nil
+	}))
+	var req mcp.CallToolRequest
+	req.Params.Arguments = map[string]any{"datasource_uid": "influx"}
+	res, err := tool.Handler(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.Content, 1)
+	assert.Equal(t, "influx", res.Content[0].(mcp.TextContent).Text)
+
+	described := tool.Tool.InputSchema.Properties[datasourceUIDParam].(*jsonschema.Schema).Description
+	assert.Equal(t, "Datasource UID. Also accepted as datasource_uid, datasourceUID, dsUid, dsUID or uid", described)
+	wrapped := withDatasourceUIDAliases(QueryInfluxSQL)
+	assert.Contains(t, wrapped.Tool.InputSchema.Properties[datasourceUIDParam].(*jsonschema.Schema).Description, "Also accepted as datasource_uid")
+	assert.Equal(t, "InfluxDB v3 datasource UID", QueryInfluxSQL.Tool.InputSchema.Properties[datasourceUIDParam].(*jsonschema.Schema).Description,
+		"the unwrapped tool is left alone")
+
+	_, ok := ResolveInfluxDBDatasource.Tool.InputSchema.Properties[datasourceUIDParam]
+	require.False(t, ok)
+	unchanged := withDatasourceUIDAliases(ResolveInfluxDBDatasource)
+	assert.Equal(t, reflect.ValueOf(ResolveInfluxDBDatasource.Handler).Pointer(), reflect.ValueOf(unchanged.Handler).Pointer(),
+		"tools without datasourceUid are not wrapped")
+}
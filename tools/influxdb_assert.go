@@ -0,0 +1,198 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+type AssertInfluxDBQueryParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	SQL           string `json:"sql" jsonschema:"required,description=SQL statement whose result is checked"`
+	Expect        string `json:"expect" jsonschema:"required,description=Expectation on the result: one or more comparisons joined with 'and'. The left side is rowCount or min/max/avg/sum/count of a result column\\, the right side a number\\, e.g. 'rowCount == 0' or 'max(usage) < 100 and count(host) >= 3'. Operators are ==\\, !=\\, <\\, <=\\, > and >="`
+	From          string `json:"from,omitempty" jsonschema:"description=Start of the time range as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Defaults to one hour before 'to'"`
+	To            string `json:"to,omitempty" jsonschema:"description=End of the time range. Defaults to now"`
+}
+
+// assertion is one parsed comparison of an expectation.
+type assertion struct {
+	expr string
+	// fn is "rowCount" or an aggregate over column.
+	fn, column string
+	op         string
+	expected   float64
+}
+
+type assertionCheck struct {
+	Expression string   `json:"expression"`
+	Passed     bool     `json:"passed"`
+	Actual     *float64 `json:"actual"`
+	Expected   float64  `json:"expected"`
+	// Note explains a check which failed without an actual value.
+	Note string `json:"note,omitempty"`
+}
+
+type assertionResult struct {
+	Passed   bool             `json:"passed"`
+	RowCount int              `json:"rowCount"`
+	Checks   []assertionCheck `json:"checks"`
+}
+
+var (
+	assertionClause    = regexp.MustCompile(`^\s*(?:(?i:(rowcount))|(?i:(min|max|avg|sum|count))\(\s*("(?:[^"]|"")+"|[^()\s]+)\s*\))\s*(==|!=|<=|>=|<|>)\s*(\S+)\s*$`)
+	assertionSeparator = regexp.MustCompile(`(?i)\s+and\s+`)
+)
+
+// parseAssertions parses an expectation such as 'max(usage) < 100 and
+// rowCount > 0'. Quoted column names keep their case and may contain
+// spaces, but not the word 'and'.
+func parseAssertions(expect string) ([]assertion, error) {
+	if strings.TrimSpace(expect) == "" {
+		return nil, fmt.Errorf("expectation is empty")
+	}
+	var out []assertion
+	for _, clause := range assertionSeparator.Split(strings.TrimSpace(expect), -1) {
+		m := assertionClause.FindStringSubmatch(clause)
+		if m == nil {
+			return nil, fmt.Errorf("invalid expectation %q: expected e.g. 'rowCount == 0' or 'max(column) < 100'", clause)
+		}
+		expected, err := strconv.ParseFloat(m[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expectation %q: %q is not a number", clause, m[5])
+		}
+		a := assertion{expr: strings.TrimSpace(clause), fn: "rowCount", op: m[4], expected: expected}
+		if m[1] == "" {
+			a.fn = strings.ToLower(m[2])
+			a.column = m[3]
+			if strings.HasPrefix(a.column, `"`) {
+				a.column = strings.ReplaceAll(a.column[1:len(a.column)-1], `""`, `"`)
+			}
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// aggregate computes the assertion's left-hand side over res. It returns
+// nil if the aggregate has no value, e.g. the maximum of no rows.
+func (a assertion) aggregate(res *queryResult) (*float64, error) {
+	if a.fn == "rowCount" {
+		n := float64(len(res.Rows))
+		return &n, nil
+	}
+	if len(res.Rows) > 0 && !slices.Contains(res.Columns, a.column) {
+		return nil, fmt.Errorf("column %q in expectation %q is not in the result (columns: %s)", a.column, a.expr, strings.Join(res.Columns, ", "))
+	}
+	var (
+		n, sum float64
+		lo, hi = math.Inf(1), math.Inf(-1)
+	)
+	for _, row := range res.Rows {
+		v := row[a.column]
+		if isNil(v) {
+			continue
+		}
+		if a.fn == "count" {
+			n++
+			continue
+		}
+		f, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("column %q in expectation %q is not numeric", a.column, a.expr)
+		}
+		n++
+		sum += f
+		lo, hi = math.Min(lo, f), math.Max(hi, f)
+	}
+	var v float64
+	switch a.fn {
+	case "count":
+		v = n
+	case "sum":
+		v = sum
+	default:
+		if n == 0 {
+			return nil, nil
+		}
+		v = map[string]float64{"min": lo, "max": hi, "avg": sum / n}[a.fn]
+	}
+	return &v, nil
+}
+
+func (a assertion) holds(v float64) bool {
+	switch a.op {
+	case "==":
+		return v == a.expected
+	case "!=":
+		return v != a.expected
+	case "<":
+		return v < a.expected
+	case "<=":
+		return v <= a.expected
+	case ">":
+		return v > a.expected
+	default:
+		return v >= a.expected
+	}
+}
+
+// evaluateAssertions checks every assertion against res. An error means an
+// assertion could not be evaluated, not that it failed.
+func evaluateAssertions(res *queryResult, assertions []assertion) (*assertionResult, error) {
+	out := &assertionResult{Passed: true, RowCount: len(res.Rows), Checks: make([]assertionCheck, 0, len(assertions))}
+	for _, a := range assertions {
+		actual, err := a.aggregate(res)
+		if err != nil {
+			return nil, err
+		}
+		check := assertionCheck{Expression: a.expr, Actual: actual, Expected: a.expected}
+		if actual == nil {
+			check.Note = fmt.Sprintf("%s(%s) has no value because the column has no non-null values", a.fn, a.column)
+		} else {
+			check.Passed = a.holds(*actual)
+		}
+		out.Passed = out.Passed && check.Passed
+		out.Checks = append(out.Checks, check)
+	}
+	return out, nil
+}
+
+// assertQuery runs sql and checks the expectation against its result. Query
+// failures are returned as errors; failed assertions are reported in the
+// result.
+func (c *influxdbClient) assertQuery(ctx context.Context, sql, expect string, opts queryOptions) (*assertionResult, error) {
+	assertions, err := parseAssertions(expect)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.query(ctx, sql, opts)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateAssertions(res, assertions)
+}
+
+func assertInfluxDBQuery(ctx context.Context, args AssertInfluxDBQueryParams) (*assertionResult, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	var opts queryOptions
+	if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+		return nil, err
+	}
+	return cli.assertQuery(ctx, args.SQL, args.Expect, opts)
+}
+
+var AssertInfluxDBQuery = mcpgrafana.MustTool(
+	"assert_influxdb_query",
+	"InfluxDB v3 datasource: Runs an SQL query and checks an expectation against its result, such as 'rowCount == 0' or 'max(usage) < 100 and count(host) >= 3'. Returns whether it passed along with the actual and expected value of each comparison. A failed expectation is a normal result with passed=false; an error means the query or the expectation itself could not be evaluated.",
+	assertInfluxDBQuery,
+)
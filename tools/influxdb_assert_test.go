@@ -0,0 +1,66 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbAssertQuery(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	status := http.StatusOK
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"results":{"A":{"error":"table 'cpu' not found","status":400}}}`))
+			return
+		}
+		_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+			data.NewField("host", nil, []string{"web1", "web2", "web3"}),
+			data.NewField("Usage", nil, []*float64{f(0.5), nil, f(0.75)}),
+		)))
+	})
+
+	res, err := cli.assertQuery(context.Background(), "SELECT * FROM cpu", `rowCount == 3 AND max("Usage") < 0.7 and count(host) >= 3`, queryOptions{})
+	require.NoError(t, err)
+	assert.False(t, res.Passed)
+	assert.Equal(t, 3, res.RowCount)
+	assert.Equal(t, []assertionCheck{
+		{Expression: "rowCount == 3", Passed: true, Actual: f(3), Expected: 3},
+		{Expression: `max("Usage") < 0.7`, Passed: false, Actual: f(0.75), Expected: 0.7},
+		{Expression: "count(host) >= 3", Passed: true, Actual: f(3), Expected: 3},
+	}, res.Checks)
+
+	res, err = cli.assertQuery(context.Background(), "SELECT * FROM cpu", `avg("Usage") <= 0.625`, queryOptions{})
+	require.NoError(t, err)
+	assert.True(t, res.Passed)
+
+	t.Run("invalid expectations", func(t *testing.T) {
+		for expect, msg := range map[string]string{
+			"":                    "expectation is empty",
+			"rows > 1":            `invalid expectation "rows > 1"`,
+			"max(usage) < lots":   `"lots" is not a number`,
+			"max(usage) < 1":      `column "usage" in expectation "max(usage) < 1" is not in the result`,
+			"sum(host) > 0":       `column "host" in expectation "sum(host) > 0" is not numeric`,
+			"median(Usage) > 0.1": `invalid expectation "median(Usage) > 0.1"`,
+		} {
+			_, err := cli.assertQuery(context.Background(), "SELECT * FROM cpu", expect, queryOptions{})
+			assert.ErrorContains(t, err, msg, expect)
+		}
+	})
+
+	t.Run("query errors are not assertion failures", func(t *testing.T) {
+		status = http.StatusBadRequest
+		_, err := cli.assertQuery(context.Background(), "SELECT * FROM cpu", "rowCount == 0", queryOptions{})
+		var qe *InfluxQueryError
+		require.ErrorAs(t, err, &qe)
+		assert.Equal(t, QueryErrorBadRequest, qe.Category)
+	})
+}
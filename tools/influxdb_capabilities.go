@@ -0,0 +1,161 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// capabilityProbeTimeout bounds how long each feature-detection query may run.
+const capabilityProbeTimeout = 5 * time.Second
+
+// capabilityProbes maps each capability name to a tiny query which only
+// succeeds if the datasource supports the feature.
+var capabilityProbes = map[string]string{
+	"windowFunctions":   "SELECT ROW_NUMBER() OVER (ORDER BY x) AS n FROM (VALUES (1)) AS v(x)",
+	"dateBin":           "SELECT date_bin(INTERVAL '1 minute', now()) AS t",
+	"approxPercentile":  "SELECT approx_percentile_cont(x, 0.5) AS p FROM (VALUES (1.0)) AS v(x)",
+	"commonTableExprs":  "WITH t AS (SELECT 1 AS x) SELECT x FROM t",
+	"informationSchema": "SELECT table_name FROM information_schema.tables LIMIT 1",
+	"random":            "SELECT random() AS r",
+}
+
+// capabilityCache holds the definitive probe results of each datasource by
+// cacheKey, since a datasource's SQL dialect does not change between calls.
+var capabilityCache = struct {
+	sync.Mutex
+	byKey map[string]map[string]bool
+}{byKey: map[string]map[string]bool{}}
+
+// dialectErrors are fragments of the errors DataFusion returns for SQL it
+// cannot plan, which some Grafana versions report with a 5xx status.
+var dialectErrors = []string{"error during planning", "invalid function", "not implemented", "not supported", "parsererror", "sql error"}
+
+// probeRejected reports whether err says the datasource rejected a probe's
+// query, which unlike a timeout or an auth failure shows that it lacks the
+// feature.
+func probeRejected(err error) bool {
+	var qe *InfluxQueryError
+	if !errors.As(err, &qe) {
+		return false
+	}
+	switch qe.Category {
+	case QueryErrorBadRequest:
+		return true
+	case QueryErrorUpstream5xx:
+		msg := strings.ToLower(err.Error())
+		return slices.ContainsFunc(dialectErrors, func(s string) bool { return strings.Contains(msg, s) })
+	}
+	return false
+}
+
+// probeCapabilities runs the named capability probes concurrently against the
+// datasource and returns which of them succeeded. Probes which failed for
+// another reason than the datasource rejecting them are left out of caps and
+// their errors are returned in errs, since their outcome says nothing about
+// the feature. The probes are sent without the server's SQL hook, which could
+// otherwise break them.
+func (c *influxdbClient) probeCapabilities(ctx context.Context, names []string) (caps map[string]bool, errs map[string]error) {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	probe := *c
+	probe.sqlHook = nil
+	caps = make(map[string]bool, len(names))
+	errs = map[string]error{}
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, capabilityProbeTimeout)
+			defer cancel()
+			_, err := probe.query(probeCtx, capabilityProbes[name], queryOptions{})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && !probeRejected(err) {
+				errs[name] = err
+				return
+			}
+			caps[name] = err == nil
+		}(name)
+	}
+	wg.Wait()
+	return caps, errs
+}
+
+// cachedCapabilities returns the cached definitive probe results of the
+// client's datasource.
+func (c *influxdbClient) cachedCapabilities() map[string]bool {
+	capabilityCache.Lock()
+	defer capabilityCache.Unlock()
+	return maps.Clone(capabilityCache.byKey[c.cacheKey()])
+}
+
+// capabilities returns the client's datasource's capabilities, running the
+// probes whose definitive outcome is not cached, or all of them if refresh is
+// set. Only definitive outcomes are cached and returned; capabilities whose
+// probe was inconclusive are left out. If every probe which ran failed
+// inconclusively and nothing was cached, the first of their errors is
+// returned, as the datasource could not be probed at all.
+func (c *influxdbClient) capabilities(ctx context.Context, refresh bool) (map[string]bool, error) {
+	caps := map[string]bool{}
+	if !refresh {
+		caps = c.cachedCapabilities()
+		if caps == nil {
+			caps = map[string]bool{}
+		}
+	}
+	var missing []string
+	for name := range capabilityProbes {
+		if _, ok := caps[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return caps, nil
+	}
+	slices.Sort(missing)
+	probed, errs := c.probeCapabilities(ctx, missing)
+	if len(errs) == len(missing) && len(caps) == 0 {
+		return nil, errs[missing[0]]
+	}
+
+	capabilityCache.Lock()
+	cached := capabilityCache.byKey[c.cacheKey()]
+	if cached == nil || refresh {
+		cached = map[string]bool{}
+		capabilityCache.byKey[c.cacheKey()] = cached
+	}
+	for name, ok := range probed {
+		caps[name] = ok
+		cached[name] = ok
+	}
+	capabilityCache.Unlock()
+	return caps, nil
+}
+
+type InfluxDBCapabilitiesParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Refresh       bool   `json:"refresh,omitempty" jsonschema:"description=Re-run the probes instead of returning cached results"`
+}
+
+func influxDBCapabilities(ctx context.Context, args InfluxDBCapabilitiesParams) (map[string]bool, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	return cli.capabilities(ctx, args.Refresh)
+}
+
+var InfluxDBCapabilities = mcpgrafana.MustTool(
+	"influxdb_capabilities",
+	"InfluxDB v3 datasource: Probes which SQL features the datasource supports (window functions, date_bin, approx_percentile_cont, CTEs, information_schema, random) using tiny feature-detection queries. Returns a map of capability name to boolean, false meaning the datasource rejected the probe's query. Results are cached per datasource. A capability whose probe failed for another reason, e.g. a timeout, is unknown: it is left out of the map and probed again on the next call.",
+	influxDBCapabilities,
+)
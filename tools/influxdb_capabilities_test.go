@@ -0,0 +1,113 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbProbeCapabilities(t *testing.T) {
+	var randomFails bool
+	probed := map[string]int{}
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sql := decodePayload(t, r).Queries[0].RawSQL
+		probed[sql]++
+		switch {
+		case strings.Contains(sql, "date_bin"):
+			_, _ = w.Write([]byte(`{"results":{"A":{"error":"Invalid function 'date_bin'","status":400}}}`))
+		case strings.Contains(sql, "approx_percentile_cont"):
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"results":{"A":{"error":"Error during planning: Invalid function 'approx_percentile_cont'","status":500}}}`))
+		case strings.Contains(sql, "random") && randomFails:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			_, _ = w.Write(arrowResponse(t, cpuFrame()))
+		}
+	})
+	cli.sqlHook = func(_, sql string) string { return sql + " LIMIT 1" }
+
+	randomFails = true
+	caps, err := cli.capabilities(context.Background(), false)
+	require.NoError(t, err)
+	assert.Len(t, caps, len(capabilityProbes)-1)
+	assert.False(t, caps["dateBin"])
+	assert.False(t, caps["approxPercentile"], "a dialect error is a rejection whatever its status")
+	assert.True(t, caps["windowFunctions"])
+	assert.True(t, caps["informationSchema"])
+	assert.NotContains(t, caps, "random", "an inconclusive probe is not reported")
+	assert.True(t, cli.supportsRandom(), "a failed probe is not a rejection")
+	assert.Equal(t, 1, probed[capabilityProbes["informationSchema"]], "the probes are sent without the SQL hook")
+
+	// Only the inconclusive probe runs again.
+	randomFails = false
+	caps, err = cli.capabilities(context.Background(), false)
+	require.NoError(t, err)
+	assert.True(t, caps["random"])
+	assert.False(t, caps["dateBin"])
+	assert.Equal(t, 1, probed[capabilityProbes["dateBin"]])
+	assert.Equal(t, 2, probed[capabilityProbes["random"]])
+
+	t.Run("failures are not cached", func(t *testing.T) {
+		var requests int
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+		_, err := cli.capabilities(context.Background(), false)
+		var qe *InfluxQueryError
+		require.ErrorAs(t, err, &qe)
+		assert.Equal(t, QueryErrorAuth, qe.Category)
+		assert.Nil(t, cli.cachedCapabilities())
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = cli.capabilities(cancelled, false)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, cli.cachedCapabilities())
+	})
+
+	t.Run("cached per organization", func(t *testing.T) {
+		other := *cli
+		other.orgID = 2
+		assert.Nil(t, other.cachedCapabilities())
+		assert.NotNil(t, cli.cachedCapabilities())
+	})
+}
+
+func TestInfluxDBCapabilitiesChecksDatasource(t *testing.T) {
+	var lookups int
+	var deny bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/datasources/uid/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		w.Header().Set("Content-Type", "application/json")
+		if deny {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"Access denied"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"uid": r.PathValue("uid"), "type": "influxdb"})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(arrowResponse(t, cpuFrame()))
+	})
+	ctx := newTestGrafanaMuxContext(t, mux)
+
+	_, err := influxDBCapabilities(ctx, InfluxDBCapabilitiesParams{DatasourceUID: "influx"})
+	require.NoError(t, err)
+
+	// A cached result is not returned to a caller who may not see the
+	// datasource.
+	deny = true
+	_, err = influxDBCapabilities(ctx, InfluxDBCapabilitiesParams{DatasourceUID: "influx"})
+	assert.Error(t, err)
+	assert.Equal(t, 2, lookups)
+}
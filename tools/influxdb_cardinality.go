@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// MaxInfluxCardinalityColumns is the maximum number of columns whose
+// cardinality is counted in one call. Each adds a distinct count to the
+// query, which the datasource computes by hashing every value.
+const MaxInfluxCardinalityColumns = 50
+
+type InfluxDBColumnCardinalityParams struct {
+	DatasourceUID string   `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table         string   `json:"table" jsonschema:"required,description=Table (measurement) to inspect"`
+	Columns       []string `json:"columns" jsonschema:"required,description=Columns to count the distinct values of\\, usually tags. At most 50"`
+	Database      string   `json:"database,omitempty" jsonschema:"description=Schema the table is in. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+	From          string   `json:"from,omitempty" jsonschema:"description=If set\\, only rows at or after this time are counted. Epoch milliseconds or a relative expression like 'now-6h'. Counting the whole table can be slow for large tables"`
+	To            string   `json:"to,omitempty" jsonschema:"description=If set\\, only rows before this time are counted. Defaults to now when from is set"`
+	Approximate   bool     `json:"approximate,omitempty" jsonschema:"description=Estimate the counts with approx_distinct (HyperLogLog) instead of counting exactly. Much cheaper for high-cardinality columns\\, within a few percent"`
+	TimeColumn    string   `json:"timeColumn,omitempty" jsonschema:"description=Name of the table's time column. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type columnCardinality struct {
+	// Cardinality is the number of distinct non-null values of each column.
+	Cardinality map[string]int64 `json:"cardinality"`
+	Approximate bool             `json:"approximate,omitempty"`
+	// Errors holds the reason each requested column could not be counted.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// cardinalitySQL counts the distinct values of every column in one
+// statement. Results are aliased by position so that column names never need
+// to appear in aliases.
+func cardinalitySQL(schema, table, timeColumn string, columns []string, approximate, timeFilter bool) string {
+	fn := "COUNT(DISTINCT %s)"
+	if approximate {
+		fn = "approx_distinct(%s)"
+	}
+	exprs := make([]string, len(columns))
+	for i, col := range columns {
+		exprs[i] = fmt.Sprintf(fn+" AS distinct_%d", quoteIdent(col), i)
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), qualifiedTable(schema, table))
+	if timeFilter {
+		sql += fmt.Sprintf(" WHERE $__timeFilter(%s)", quoteIdent(timeColumn))
+	}
+	return sql
+}
+
+// columnCardinality counts the distinct values of columns of a table, all in
+// one query. If that query fails, for example because one column's type
+// cannot be counted, each column is counted on its own so that the others
+// are still returned and the failing ones reported. Columns which do not
+// exist are reported without being queried.
+func (c *influxdbClient) columnCardinality(ctx context.Context, schema, table string, columns []string, approximate bool, opts queryOptions) (*columnCardinality, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("at least one column is required")
+	}
+	if len(columns) > MaxInfluxCardinalityColumns {
+		return nil, fmt.Errorf("%d columns requested, more than the maximum of %d per call", len(columns), MaxInfluxCardinalityColumns)
+	}
+	described, err := c.describeTable(ctx, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(described))
+	for _, col := range described {
+		exists[col.Name] = true
+	}
+
+	out := &columnCardinality{Cardinality: make(map[string]int64), Approximate: approximate}
+	fail := func(col, reason string) {
+		if out.Errors == nil {
+			out.Errors = make(map[string]string)
+		}
+		out.Errors[col] = reason
+	}
+	var counted []string
+	for _, col := range columns {
+		switch {
+		case !exists[col]:
+			fail(col, "column not found")
+		case !slices.Contains(counted, col):
+			counted = append(counted, col)
+		}
+	}
+	if len(counted) == 0 {
+		return out, nil
+	}
+
+	count := func(cols []string) error {
+		res, err := c.query(ctx, cardinalitySQL(schema, table, c.timeCol(), cols, approximate, !opts.From.IsZero()), opts)
+		if err != nil {
+			return err
+		}
+		if len(res.Rows) == 0 {
+			return fmt.Errorf("cardinality query returned no rows")
+		}
+		for i, col := range cols {
+			if n, ok := toFloat(res.Rows[0][fmt.Sprintf("distinct_%d", i)]); ok {
+				out.Cardinality[col] = int64(n)
+			}
+		}
+		return nil
+	}
+	err = count(counted)
+	if err == nil || len(counted) == 1 {
+		if err != nil {
+			fail(counted[0], err.Error())
+		}
+		return out, nil
+	}
+	for _, col := range counted {
+		if err := count([]string{col}); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			fail(col, err.Error())
+		}
+	}
+	return out, nil
+}
+
+func influxDBColumnCardinality(ctx context.Context, args InfluxDBColumnCardinalityParams) (*columnCardinality, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	var opts queryOptions
+	if args.From != "" {
+		if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return cli.columnCardinality(ctx, args.Database, args.Table, args.Columns, args.Approximate, opts)
+}
+
+var InfluxDBColumnCardinality = mcpgrafana.MustTool(
+	"influxdb_column_cardinality",
+	"InfluxDB v3 datasource: Counts the distinct values of columns of a table, usually tags, in a single query, to judge which make good GROUP BY keys: a column with a handful of values groups usefully, one with a value per row does not. Returns {cardinality: {column: count}, errors}; requested columns which do not exist or cannot be counted are listed under 'errors' with the reason. Set approximate for a cheaper estimate on large tables.",
+	influxDBColumnCardinality,
+)
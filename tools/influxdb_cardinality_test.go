@@ -0,0 +1,68 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbColumnCardinality(t *testing.T) {
+	var sqls []string
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sql := decodePayload(t, r).Queries[0].RawSQL
+		switch {
+		case strings.Contains(sql, "information_schema.columns"):
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+				data.NewField("column_name", nil, []string{"time", "host", "region", `we"ird`}),
+				data.NewField("data_type", nil, []string{"Timestamp(Nanosecond, None)", "Dictionary(Int32, Utf8)", "Dictionary(Int32, Utf8)", "Struct"}),
+				data.NewField("is_nullable", nil, []string{"NO", "YES", "YES", "YES"}),
+			)))
+			return
+		case strings.Contains(sql, `"we""ird"`):
+			// Counting the struct column fails, alone or batched.
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"results":{"A":{"error":"unsupported type","status":400}}}`))
+		case strings.Contains(sql, "distinct_1"):
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+				data.NewField("distinct_0", nil, []int64{12}),
+				data.NewField("distinct_1", nil, []int64{3}),
+			)))
+		default:
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("distinct_0", nil, []int64{12}))))
+		}
+		sqls = append(sqls, sql)
+	})
+
+	out, err := cli.columnCardinality(context.Background(), "", "cpu", []string{"host", "region", "host", "nope"}, false, queryOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"host": 12, "region": 3}, out.Cardinality)
+	assert.Equal(t, map[string]string{"nope": "column not found"}, out.Errors)
+	assert.Equal(t, []string{`SELECT COUNT(DISTINCT "host") AS distinct_0, COUNT(DISTINCT "region") AS distinct_1 FROM "cpu"`}, sqls, "columns are counted in one query")
+
+	t.Run("failing column", func(t *testing.T) {
+		sqls = nil
+		out, err := cli.columnCardinality(context.Background(), "", "cpu", []string{"host", `we"ird`}, true, queryOptions{From: time.UnixMilli(0), To: time.UnixMilli(1)})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int64{"host": 12}, out.Cardinality)
+		assert.Contains(t, out.Errors[`we"ird`], "unsupported type")
+		assert.Equal(t, []string{
+			`SELECT approx_distinct("host") AS distinct_0, approx_distinct("we""ird") AS distinct_1 FROM "cpu" WHERE $__timeFilter("time")`,
+			`SELECT approx_distinct("host") AS distinct_0 FROM "cpu" WHERE $__timeFilter("time")`,
+			`SELECT approx_distinct("we""ird") AS distinct_0 FROM "cpu" WHERE $__timeFilter("time")`,
+		}, sqls, "after the batch fails each column is counted alone")
+	})
+
+	t.Run("too many columns", func(t *testing.T) {
+		_, err := cli.columnCardinality(context.Background(), "", "cpu", make([]string, MaxInfluxCardinalityColumns+1), false, queryOptions{})
+		assert.ErrorContains(t, err, "more than the maximum")
+	})
+}
@@ -0,0 +1,216 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+const (
+	// MaxInfluxClusterSeries is the maximum number of series clustered.
+	// Clustering compares every pair of series several times, so its cost
+	// grows with the cube of their number.
+	MaxInfluxClusterSeries = 200
+
+	// defaultClusterThreshold groups series whose values move closely
+	// together.
+	defaultClusterThreshold = 0.9
+
+	// minCorrelationPoints is the number of times two series must both have
+	// values at for their correlation to be computed.
+	minCorrelationPoints = 3
+)
+
+type InfluxDBClusterSeriesParams struct {
+	DatasourceUID string  `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	SQL           string  `json:"sql" jsonschema:"required,description=SQL returning time series: a time column and numeric value columns\\, with tag columns such as host splitting the values into one series per tag combination. Should use $__timeFilter(time) and usually aggregates with date_bin so that the series share timestamps"`
+	From          string  `json:"from,omitempty" jsonschema:"description=Start of the time range as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Defaults to one hour before 'to'"`
+	To            string  `json:"to,omitempty" jsonschema:"description=End of the time range. Defaults to now"`
+	Threshold     float64 `json:"threshold,omitempty" jsonschema:"description=Lowest Pearson correlation two series in the same cluster may have\\, greater than -1 and at most 1. Defaults to 0.9; lower values give fewer\\, looser clusters"`
+	TimeColumn    string  `json:"timeColumn,omitempty" jsonschema:"description=Time column of the result. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type clusterMember struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type seriesCluster struct {
+	Series []clusterMember `json:"series"`
+	// MinCorrelation is the lowest correlation between two of the series,
+	// unset for a cluster of one.
+	MinCorrelation *float64 `json:"minCorrelation,omitempty"`
+}
+
+type seriesClusters struct {
+	// Metric is always "pearson".
+	Metric    string  `json:"metric"`
+	Threshold float64 `json:"threshold"`
+	// Clusters are ordered by size, largest first.
+	Clusters []seriesCluster `json:"clusters"`
+	// Assignments maps each series name to the index of its cluster.
+	Assignments map[string]int `json:"assignments"`
+}
+
+// pearson returns the Pearson correlation of x and y over the indices where
+// both have a value, and false if there are fewer than minCorrelationPoints
+// of them or either is constant over them.
+func pearson(x, y []*float64) (float64, bool) {
+	var n, sx, sy float64
+	for i := range x {
+		if x[i] != nil && y[i] != nil {
+			n++
+			sx += *x[i]
+			sy += *y[i]
+		}
+	}
+	if n < minCorrelationPoints {
+		return 0, false
+	}
+	mx, my := sx/n, sy/n
+	var cov, vx, vy float64
+	for i := range x {
+		if x[i] != nil && y[i] != nil {
+			dx, dy := *x[i]-mx, *y[i]-my
+			cov += dx * dy
+			vx += dx * dx
+			vy += dy * dy
+		}
+	}
+	if vx == 0 || vy == 0 {
+		return 0, false
+	}
+	return cov / math.Sqrt(vx*vy), true
+}
+
+// clusterSeries groups series by complete-linkage agglomerative clustering on
+// their Pearson correlation: starting from one cluster per series, the two
+// clusters whose least correlated pair of series is the most correlated are
+// merged, as long as that correlation is at least threshold. Every pair of
+// series in a cluster is therefore correlated at least that strongly. Series
+// whose correlation cannot be computed are never clustered together. It
+// returns the clusters as indices into series, in order of their first
+// series, with the minimum correlation within each.
+func clusterSeries(series []chartSeries, threshold float64) ([][]int, []float64) {
+	n := len(series)
+	corr := make([][]float64, n)
+	for i := range corr {
+		corr[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			r, ok := pearson(series[i].Y, series[j].Y)
+			if !ok {
+				r = math.Inf(-1)
+			}
+			corr[i][j], corr[j][i] = r, r
+		}
+	}
+
+	clusters := make([][]int, n)
+	minCorr := make([]float64, n)
+	for i := range clusters {
+		clusters[i] = []int{i}
+		minCorr[i] = math.Inf(1)
+	}
+	// linkage is the correlation of the least correlated pair across a and b.
+	linkage := func(a, b []int) float64 {
+		m := math.Inf(1)
+		for _, i := range a {
+			for _, j := range b {
+				m = math.Min(m, corr[i][j])
+			}
+		}
+		return m
+	}
+	for {
+		bestA, bestB, best := -1, -1, math.Inf(-1)
+		for a := range clusters {
+			for b := a + 1; b < len(clusters); b++ {
+				if l := linkage(clusters[a], clusters[b]); l > best {
+					bestA, bestB, best = a, b, l
+				}
+			}
+		}
+		if bestA < 0 || best < threshold {
+			break
+		}
+		clusters[bestA] = append(clusters[bestA], clusters[bestB]...)
+		minCorr[bestA] = math.Min(best, math.Min(minCorr[bestA], minCorr[bestB]))
+		clusters = append(clusters[:bestB], clusters[bestB+1:]...)
+		minCorr = append(minCorr[:bestB], minCorr[bestB+1:]...)
+	}
+	return clusters, minCorr
+}
+
+// clusterResult runs clusterSeries over the series of a time series result.
+func clusterResult(res *queryResult, timeColumn string, threshold float64) (*seriesClusters, error) {
+	chart, err := chartResult(res, timeColumn)
+	if err != nil {
+		return nil, err
+	}
+	if len(chart.Series) > MaxInfluxClusterSeries {
+		return nil, fmt.Errorf("the query returned %d series, more than the maximum of %d which can be clustered; filter or group the query to return fewer", len(chart.Series), MaxInfluxClusterSeries)
+	}
+	clusters, minCorr := clusterSeries(chart.Series, threshold)
+	// The sort is stable, so clusters of the same size stay in the order of
+	// their first series.
+	order := make([]int, len(clusters))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return len(clusters[order[a]]) > len(clusters[order[b]]) })
+
+	out := &seriesClusters{Metric: "pearson", Threshold: threshold, Clusters: []seriesCluster{}, Assignments: map[string]int{}}
+	for _, c := range order {
+		var sc seriesCluster
+		for _, i := range clusters[c] {
+			s := chart.Series[i]
+			sc.Series = append(sc.Series, clusterMember{Name: s.Name, Labels: s.Labels})
+			out.Assignments[s.Name] = len(out.Clusters)
+		}
+		if len(clusters[c]) > 1 {
+			m := minCorr[c]
+			sc.MinCorrelation = &m
+		}
+		out.Clusters = append(out.Clusters, sc)
+	}
+	return out, nil
+}
+
+func influxDBClusterSeries(ctx context.Context, args InfluxDBClusterSeriesParams) (*seriesClusters, error) {
+	threshold := args.Threshold
+	if threshold == 0 {
+		threshold = defaultClusterThreshold
+	}
+	if threshold <= -1 || threshold > 1 || math.IsNaN(threshold) {
+		return nil, fmt.Errorf("threshold must be greater than -1 and at most 1, got %g", threshold)
+	}
+	opts := queryOptions{FrameFormat: "time_series"}
+	var err error
+	if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+		return nil, err
+	}
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	res, err := cli.query(ctx, args.SQL, opts)
+	if err != nil {
+		return nil, err
+	}
+	return clusterResult(res, cli.timeCol(), threshold)
+}
+
+var InfluxDBClusterSeries = mcpgrafana.MustTool(
+	"influxdb_cluster_series",
+	"InfluxDB v3 datasource: Runs a time series SQL query and groups the returned series by how similarly they move, to summarize many series, e.g. one per host, as a few groups. Series are compared by Pearson correlation over the times at which both have values, so series with the same shape cluster together whatever their scale or offset. Clusters are built by complete linkage: every pair of series in a cluster has a correlation of at least threshold (default 0.9). Returns {metric, threshold, clusters: [{series: [{name, labels}], minCorrelation}], assignments} with clusters largest first and assignments mapping each series to its cluster's index. At most 200 series can be clustered.",
+	influxDBClusterSeries,
+)
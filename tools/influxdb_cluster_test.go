@@ -0,0 +1,86 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxDBClusterSeries(t *testing.T) {
+	times := []time.Time{
+		time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 1, 0, 1, 0, 0, time.UTC),
+		time.Date(2024, 5, 1, 0, 2, 0, 0, time.UTC),
+		time.Date(2024, 5, 1, 0, 3, 0, 0, time.UTC),
+	}
+	usage := func(host string, values ...float64) *data.Field {
+		return data.NewField("usage", data.Labels{"host": host}, values)
+	}
+	var format string
+	ctx := newTestGrafanaContext(t, func(w http.ResponseWriter, r *http.Request) {
+		format = decodePayload(t, r).Queries[0].Format
+		_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+			data.NewField("time", nil, times),
+			usage("web1", 1, 2, 3, 4),
+			usage("web2", 10, 20, 30, 45),
+			usage("db1", 4, 3, 2, 1),
+			usage("web3", 0.1, 0.2, 0.3, 0.4),
+			usage("idle", 5, 5, 5, 5),
+		)))
+	})
+
+	out, err := influxDBClusterSeries(ctx, InfluxDBClusterSeriesParams{DatasourceUID: "influx", SQL: "SELECT * FROM cpu"})
+	require.NoError(t, err)
+	assert.Equal(t, "time_series", format)
+	assert.Equal(t, "pearson", out.Metric)
+	assert.Equal(t, defaultClusterThreshold, out.Threshold)
+	require.Len(t, out.Clusters, 3)
+	names := func(c seriesCluster) []string {
+		var names []string
+		for _, s := range c.Series {
+			names = append(names, s.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"usage {host=web1}", "usage {host=web3}", "usage {host=web2}"}, names(out.Clusters[0]))
+	assert.Equal(t, map[string]string{"host": "web1"}, out.Clusters[0].Series[0].Labels)
+	require.NotNil(t, out.Clusters[0].MinCorrelation)
+	assert.Greater(t, *out.Clusters[0].MinCorrelation, 0.99)
+	assert.Equal(t, []string{"usage {host=db1}"}, names(out.Clusters[1]))
+	assert.Nil(t, out.Clusters[1].MinCorrelation)
+	assert.Equal(t, []string{"usage {host=idle}"}, names(out.Clusters[2]), "a constant series is not clustered")
+	assert.Equal(t, 0, out.Assignments["usage {host=web2}"])
+	assert.Equal(t, 1, out.Assignments["usage {host=db1}"])
+
+	t.Run("a higher threshold gives tighter clusters", func(t *testing.T) {
+		out, err := influxDBClusterSeries(ctx, InfluxDBClusterSeriesParams{DatasourceUID: "influx", SQL: "SELECT * FROM cpu", Threshold: 0.999})
+		require.NoError(t, err)
+		require.Len(t, out.Clusters, 4)
+		assert.Equal(t, []string{"usage {host=web1}", "usage {host=web3}"}, names(out.Clusters[0]))
+		assert.Equal(t, 1, out.Assignments["usage {host=web2}"])
+	})
+
+	t.Run("invalid threshold", func(t *testing.T) {
+		_, err := influxDBClusterSeries(ctx, InfluxDBClusterSeriesParams{DatasourceUID: "influx", SQL: "SELECT 1", Threshold: 1.5})
+		assert.ErrorContains(t, err, "threshold must be")
+	})
+}
+
+func TestClusterResultTooManySeries(t *testing.T) {
+	res := &queryResult{Columns: []string{"time"}, Rows: []map[string]any{{"time": time.Unix(0, 0)}}}
+	for i := 0; i <= MaxInfluxClusterSeries; i++ {
+		col := fmt.Sprintf("s%d", i)
+		res.Columns = append(res.Columns, col)
+		res.Rows[0][col] = float64(i)
+	}
+	_, err := clusterResult(res, "time", defaultClusterThreshold)
+	assert.ErrorContains(t, err, "more than the maximum of 200")
+}
@@ -0,0 +1,292 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// sqlKeywords are the words sqlColumnRefs does not treat as column
+// references when they appear unquoted.
+var sqlKeywords = map[string]bool{
+	"select": true, "from": true, "where": true, "and": true, "or": true, "not": true,
+	"as": true, "group": true, "by": true, "order": true, "asc": true, "desc": true,
+	"limit": true, "offset": true, "in": true, "is": true, "null": true, "like": true,
+	"ilike": true, "between": true, "case": true, "when": true, "then": true, "else": true,
+	"end": true, "distinct": true, "true": true, "false": true, "having": true, "interval": true,
+	"nulls": true, "first": true, "last": true, "all": true, "any": true, "exists": true,
+	"cast": true, "over": true, "partition": true, "rows": true, "range": true,
+	"preceding": true, "following": true, "unbounded": true, "current": true, "row": true,
+	"filter": true, "within": true, "timestamp": true, "date": true, "at": true, "zone": true,
+}
+
+// sqlColumnBefore and sqlColumnAfter are the tokens between which only a
+// column can appear, such as the commas of a SELECT list or a comparison,
+// which makes sqlColumnRefs certain that a name there is meant as one.
+var (
+	sqlColumnBefore = map[string]bool{
+		"select": true, "distinct": true, ",": true, "(": true, "by": true, "where": true, "and": true,
+		"or": true, "not": true, "on": true, "having": true,
+		"=": true, "<": true, ">": true, "+": true, "-": true, "*": true, "/": true, "%": true,
+	}
+	sqlColumnAfter = map[string]bool{
+		",": true, ")": true, "": true, "from": true, "as": true, "asc": true, "desc": true, "nulls": true,
+		"is": true, "in": true, "between": true, "like": true, "ilike": true, "not": true, "and": true,
+		"or": true, "where": true, "group": true, "order": true, "limit": true, "having": true,
+		"=": true, "<": true, ">": true, "!": true, "+": true, "-": true, "*": true, "/": true, "%": true, "::": true,
+	}
+)
+
+// sqlUnsupported are words which make a statement too complex for
+// sqlColumnRefs to attribute its columns to a single table.
+var sqlUnsupported = map[string]bool{
+	"join": true, "with": true, "union": true, "intersect": true, "except": true,
+}
+
+type sqlToken struct {
+	text   string
+	ident  bool
+	quoted bool
+	// literal is set for a string literal, whose text is not kept.
+	literal bool
+}
+
+// tokenizeSQL splits sql into identifiers, string literals and punctuation,
+// dropping comments, numbers and Grafana macros.
+func tokenizeSQL(sql string) []sqlToken {
+	var tokens []sqlToken
+	rs := []rune(sql)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i += 2
+			for i+1 < len(rs) && !(rs[i] == '*' && rs[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case r == '\'' || r == '"':
+			j := i + 1
+			var sb strings.Builder
+			for j < len(rs) {
+				if rs[j] == r {
+					if j+1 < len(rs) && rs[j+1] == r {
+						sb.WriteRune(r)
+						j += 2
+						continue
+					}
+					break
+				}
+				sb.WriteRune(rs[j])
+				j++
+			}
+			if r == '"' {
+				tokens = append(tokens, sqlToken{text: sb.String(), ident: true, quoted: true})
+			} else {
+				tokens = append(tokens, sqlToken{text: "'", literal: true})
+			}
+			i = j + 1
+		case r == '$' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			for j < len(rs) && (rs[j] == '$' || rs[j] == '_' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			word := string(rs[i:j])
+			if r != '$' && !unicode.IsDigit(r) {
+				tokens = append(tokens, sqlToken{text: word, ident: true})
+			}
+			i = j
+		case r == ':' && i+1 < len(rs) && rs[i+1] == ':':
+			tokens = append(tokens, sqlToken{text: "::"})
+			i += 2
+		default:
+			tokens = append(tokens, sqlToken{text: string(r)})
+			i++
+		}
+	}
+	return tokens
+}
+
+// sqlColumnRef is a name sqlColumnRefs takes for a column. Certain is set if
+// it appears somewhere only a column can, so that if the table has no such
+// column the query is surely wrong rather than beyond the analysis.
+type sqlColumnRef struct {
+	Name    string
+	Certain bool
+}
+
+// sqlColumnRefs makes a best-effort attempt to find the table a single-table
+// SELECT reads from and the columns it references. Unquoted identifiers are
+// lowercased, as InfluxDB v3 does. Aliases, whether written with AS or not,
+// and the type names of typed literals such as TIMESTAMP '2024-01-01' are
+// not columns. It reports false for statements it cannot analyse, such as
+// joins, subqueries and CTEs.
+func sqlColumnRefs(sql string) (schema, table string, columns []sqlColumnRef, ok bool) {
+	tokens := tokenizeSQL(sql)
+	name := func(t sqlToken) string {
+		if t.quoted {
+			return t.text
+		}
+		return strings.ToLower(t.text)
+	}
+	word := func(i int) string {
+		if i < 0 || i >= len(tokens) || !tokens[i].ident || tokens[i].quoted {
+			return ""
+		}
+		return strings.ToLower(tokens[i].text)
+	}
+	text := func(i int) string {
+		if i < 0 || i >= len(tokens) {
+			return ""
+		}
+		switch t := tokens[i]; {
+		case t.quoted:
+			// Never mistaken for a keyword or punctuation.
+			return `"` + t.text
+		case t.ident:
+			return strings.ToLower(t.text)
+		default:
+			return t.text
+		}
+	}
+
+	selectAt, fromAt, selects := -1, -1, 0
+	for i := range tokens {
+		switch w := word(i); {
+		case sqlUnsupported[w]:
+			return "", "", nil, false
+		case w == "select":
+			selects++
+			selectAt = i
+		case w == "from":
+			if fromAt >= 0 {
+				return "", "", nil, false
+			}
+			fromAt = i
+		}
+	}
+	if selects != 1 || fromAt < selectAt || fromAt+1 >= len(tokens) || !tokens[fromAt+1].ident {
+		return "", "", nil, false
+	}
+	tableEnd := fromAt + 1
+	table = name(tokens[tableEnd])
+	if text(fromAt+2) == "." && fromAt+3 < len(tokens) && tokens[fromAt+3].ident {
+		schema, table = table, name(tokens[fromAt+3])
+		tableEnd = fromAt + 3
+	}
+
+	aliases := make(map[string]bool)
+	for i := range tokens {
+		if word(i-1) == "as" && tokens[i].ident {
+			aliases[name(tokens[i])] = true
+		}
+	}
+	// An alias without AS follows an expression at the top level of the
+	// SELECT list and ends the item, as in SELECT avg(usage) avg_usage.
+	depth := 0
+	for i := selectAt + 1; i < fromAt; i++ {
+		switch tokens[i].text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth != 0 || !tokens[i].ident || (!tokens[i].quoted && sqlKeywords[name(tokens[i])]) {
+			continue
+		}
+		if next := text(i + 1); next != "," && i+1 != fromAt {
+			continue
+		}
+		prev := tokens[i-1]
+		if prev.text == ")" || prev.literal || (prev.ident && (prev.quoted || !sqlKeywords[name(prev)] || word(i-1) == "end")) {
+			aliases[name(tokens[i])] = true
+		}
+	}
+	// A table alias, as in FROM cpu c, may qualify columns.
+	if a := word(tableEnd + 1); a != "" && !sqlKeywords[a] {
+		aliases[a] = true
+	}
+
+	index := make(map[string]int)
+	for i, t := range tokens {
+		if !t.ident || (i > fromAt && i <= tableEnd) {
+			continue
+		}
+		n := name(t)
+		switch {
+		case !t.quoted && sqlKeywords[n]:
+		case text(i+1) == "(" || text(i+1) == ".":
+			// A function call or a table qualifier.
+		case !t.quoted && i+1 < len(tokens) && tokens[i+1].literal:
+			// The type of a typed literal, as in TIME '12:00'.
+		case text(i-1) == "::" || word(i-1) == "as":
+		case aliases[n]:
+		default:
+			certain := sqlColumnBefore[text(i-1)] && sqlColumnAfter[text(i+1)]
+			if j, seen := index[n]; seen {
+				columns[j].Certain = columns[j].Certain || certain
+				continue
+			}
+			index[n] = len(columns)
+			columns = append(columns, sqlColumnRef{Name: n, Certain: certain})
+		}
+	}
+	return schema, table, columns, true
+}
+
+// errTableNotFound is returned by describeTable for a table with no columns.
+var errTableNotFound = errors.New("table not found")
+
+// checkColumns verifies that the columns sql references exist in the table it
+// reads from. It returns an error listing unknown columns where the analysis
+// is certain they are meant as columns; other unknown names, and statements
+// it cannot analyse, are not blocked but produce a warning instead.
+func (c *influxdbClient) checkColumns(ctx context.Context, sql string) ([]string, error) {
+	schema, table, refs, ok := sqlColumnRefs(sql)
+	if !ok {
+		return []string{"column check skipped: could not determine the table and columns the query references"}, nil
+	}
+	columns, err := c.describeTable(ctx, schema, table)
+	if errors.Is(err, errTableNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return []string{fmt.Sprintf("column check skipped: %v", err)}, nil
+	}
+
+	known := make(map[string]bool, len(columns))
+	names := make([]string, 0, len(columns))
+	for _, col := range columns {
+		known[col.Name] = true
+		names = append(names, col.Name)
+	}
+	sort.Strings(names)
+	var unknown, doubtful []string
+	for _, ref := range refs {
+		switch {
+		case known[ref.Name]:
+		case ref.Certain:
+			unknown = append(unknown, ref.Name)
+		default:
+			doubtful = append(doubtful, ref.Name)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown columns in table %q: %s (available: %s); disable checkColumns if this is wrong",
+			table, strings.Join(unknown, ", "), strings.Join(names, ", "))
+	}
+	if len(doubtful) > 0 {
+		return []string{fmt.Sprintf("column check: %s may be unknown columns of table %q (available: %s); the query was run anyway",
+			strings.Join(doubtful, ", "), table, strings.Join(names, ", "))}, nil
+	}
+	return nil, nil
+}
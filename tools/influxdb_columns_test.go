@@ -0,0 +1,104 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLColumnRefs(t *testing.T) {
+	for _, tc := range []struct {
+		sql           string
+		schema, table string
+		columns       []string
+	}{
+		{`SELECT host, usage FROM cpu`, "", "cpu", []string{"host", "usage"}},
+		{`SELECT "Host", AVG(usage) AS avg_usage FROM iox.cpu WHERE $__timeFilter(time) AND region = 'us-east' GROUP BY "Host" ORDER BY avg_usage DESC LIMIT 10`,
+			"iox", "cpu", []string{"Host", "usage", "time", "region"}},
+		{`SELECT c.host FROM cpu c -- trailing comment with words`, "", "cpu", []string{"host"}},
+		{`SELECT date_bin(INTERVAL '1 hour', time) AS bucket, COUNT(*) FROM "my table" WHERE usage::double > 0.5`, "", "my table", []string{"time", "usage"}},
+		{`SELECT host FROM cpu WHERE time > TIMESTAMP '2024-01-01T00:00:00Z' AND time < DATE '2024-02-01'`, "", "cpu", []string{"host", "time"}},
+		{`SELECT avg(usage) avg_usage, host h, "Region" r FROM cpu GROUP BY h, r ORDER BY avg_usage`, "", "cpu", []string{"usage", "host", "Region"}},
+		{`SELECT CASE WHEN usage > 1 THEN 'hot' ELSE 'ok' END state FROM cpu ORDER BY state`, "", "cpu", []string{"usage"}},
+	} {
+		t.Run(tc.sql, func(t *testing.T) {
+			schema, table, columns, ok := sqlColumnRefs(tc.sql)
+			require.True(t, ok)
+			assert.Equal(t, tc.schema, schema)
+			assert.Equal(t, tc.table, table)
+			names := make([]string, len(columns))
+			for i, col := range columns {
+				names[i] = col.Name
+			}
+			assert.Equal(t, tc.columns, names)
+		})
+	}
+
+	t.Run("certainty", func(t *testing.T) {
+		_, _, columns, ok := sqlColumnRefs(`SELECT host, usage FROM cpu WHERE time AT TIME ZONE 'UTC' > now()`)
+		require.True(t, ok)
+		assert.Equal(t, []sqlColumnRef{{"host", true}, {"usage", true}, {"time", false}}, columns)
+
+		_, _, columns, ok = sqlColumnRefs(`SELECT usage FROM cpu WHERE host SIMILAR TO 'web%'`)
+		require.True(t, ok)
+		assert.Equal(t, []sqlColumnRef{{"usage", true}, {"host", false}, {"similar", false}}, columns, "to is the type of no literal, but is not certain either")
+	})
+
+	for _, sql := range []string{
+		`SELECT * FROM cpu JOIN mem ON cpu.host = mem.host`,
+		`SELECT * FROM (SELECT host FROM cpu)`,
+		`WITH x AS (SELECT 1) SELECT * FROM x`,
+		`SHOW TABLES`,
+	} {
+		_, _, _, ok := sqlColumnRefs(sql)
+		assert.False(t, ok, sql)
+	}
+}
+
+func TestInfluxdbCheckColumns(t *testing.T) {
+	cli := newTestInfluxdbClient(t, sqlRouter(t, map[string][]byte{
+		"table_name = 'cpu'": arrowResponse(t, data.NewFrame("",
+			data.NewField("column_name", nil, []string{"time", "host", "usage"}),
+			data.NewField("data_type", nil, []string{"Timestamp(Nanosecond, None)", "Utf8", "Float64"}),
+			data.NewField("is_nullable", nil, []string{"NO", "YES", "YES"}),
+		)),
+		"table_name = 'missing'": arrowResponse(t, data.NewFrame("", data.NewField("column_name", nil, []string{}))),
+	}))
+	ctx := context.Background()
+
+	warnings, err := cli.checkColumns(ctx, "SELECT host, usage FROM cpu WHERE $__timeFilter(time)")
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	_, err = cli.checkColumns(ctx, "SELECT hots, usage, mem FROM cpu")
+	assert.EqualError(t, err, `unknown columns in table "cpu": hots, mem (available: host, time, usage); disable checkColumns if this is wrong`)
+
+	// Valid SQL the analysis used to misread.
+	for _, sql := range []string{
+		"SELECT host FROM cpu WHERE time > TIMESTAMP '2024-01-01T00:00:00Z'",
+		"SELECT avg(usage) avg_usage FROM cpu GROUP BY host ORDER BY avg_usage",
+	} {
+		warnings, err = cli.checkColumns(ctx, sql)
+		require.NoError(t, err, sql)
+		assert.Empty(t, warnings, sql)
+	}
+
+	// Names the analysis is unsure are columns only warn.
+	warnings, err = cli.checkColumns(ctx, "SELECT usage FROM cpu WHERE host SIMILAR TO 'web%'")
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "similar may be unknown columns")
+
+	_, err = cli.checkColumns(ctx, "SELECT host FROM missing")
+	assert.ErrorIs(t, err, errTableNotFound)
+
+	warnings, err = cli.checkColumns(ctx, "SELECT * FROM cpu JOIN mem USING (host)")
+	require.NoError(t, err)
+	assert.Len(t, warnings, 1)
+}
@@ -0,0 +1,174 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+type InfluxDBCompareWindowsParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	SQL           string `json:"sql" jsonschema:"required,description=Aggregate SQL run once per window. Must use a time macro such as $__timeFilter(time) so that it is restricted to each window. Every numeric column other than groupBy is compared"`
+	GroupBy       string `json:"groupBy,omitempty" jsonschema:"description=Column identifying the group each row belongs to (e.g. host). Rows are matched across windows by its value. If empty the query must return a single row"`
+	BaselineFrom  string `json:"baselineFrom" jsonschema:"required,description=Start of the baseline window as epoch milliseconds or a relative expression like 'now-1d-1h'"`
+	BaselineTo    string `json:"baselineTo" jsonschema:"required,description=End of the baseline window"`
+	CurrentFrom   string `json:"currentFrom,omitempty" jsonschema:"description=Start of the current window. Defaults to one hour before currentTo"`
+	CurrentTo     string `json:"currentTo,omitempty" jsonschema:"description=End of the current window. Defaults to now"`
+}
+
+type windowDelta struct {
+	Group    string   `json:"group,omitempty"`
+	Metric   string   `json:"metric"`
+	Baseline *float64 `json:"baseline"`
+	Current  *float64 `json:"current"`
+	Delta    *float64 `json:"delta"`
+	// PercentChange is relative to the baseline, and null if the baseline is
+	// zero or missing.
+	PercentChange *float64 `json:"percentChange"`
+	// Presence is "both", "baseline_only" or "current_only".
+	Presence string `json:"presence"`
+}
+
+// windowMetrics indexes the numeric values of a result by group and metric.
+func windowMetrics(res *queryResult, groupBy string) (map[string]map[string]float64, error) {
+	if groupBy == "" && len(res.Rows) > 1 {
+		return nil, fmt.Errorf("query returned %d rows; set groupBy to compare more than one row", len(res.Rows))
+	}
+	out := make(map[string]map[string]float64, len(res.Rows))
+	for _, row := range res.Rows {
+		group := ""
+		if groupBy != "" {
+			v, ok := row[groupBy]
+			if !ok {
+				return nil, fmt.Errorf("groupBy column %q not found in result", groupBy)
+			}
+			group = formatCell(v)
+		}
+		metrics := out[group]
+		if metrics == nil {
+			metrics = make(map[string]float64)
+			out[group] = metrics
+		}
+		for _, col := range rowColumns(res.Columns, row) {
+			if col == groupBy {
+				continue
+			}
+			if f, ok := toFloat(row[col]); ok {
+				metrics[col] = f
+			}
+		}
+	}
+	return out, nil
+}
+
+// diffWindows compares the metrics of two windows. Results are sorted by
+// group and metric; metrics seen in only one window are included with the
+// other side null.
+func diffWindows(baseline, current map[string]map[string]float64) []windowDelta {
+	keys := make(map[[2]string]struct{})
+	for _, side := range []map[string]map[string]float64{baseline, current} {
+		for group, metrics := range side {
+			for metric := range metrics {
+				keys[[2]string{group, metric}] = struct{}{}
+			}
+		}
+	}
+	sorted := make([][2]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i][0] != sorted[j][0] {
+			return sorted[i][0] < sorted[j][0]
+		}
+		return sorted[i][1] < sorted[j][1]
+	})
+
+	deltas := make([]windowDelta, 0, len(sorted))
+	for _, k := range sorted {
+		d := windowDelta{Group: k[0], Metric: k[1]}
+		if v, ok := baseline[k[0]][k[1]]; ok {
+			d.Baseline = &v
+		}
+		if v, ok := current[k[0]][k[1]]; ok {
+			d.Current = &v
+		}
+		switch {
+		case d.Baseline != nil && d.Current != nil:
+			d.Presence = "both"
+			delta := *d.Current - *d.Baseline
+			d.Delta = &delta
+			if *d.Baseline != 0 {
+				pct := delta / math.Abs(*d.Baseline) * 100
+				d.PercentChange = &pct
+			}
+		case d.Baseline != nil:
+			d.Presence = "baseline_only"
+		default:
+			d.Presence = "current_only"
+		}
+		deltas = append(deltas, d)
+	}
+	return deltas
+}
+
+// compareWindows runs sql over the baseline and current windows concurrently
+// and diffs the results.
+func (c *influxdbClient) compareWindows(ctx context.Context, sql, groupBy string, baseline, current queryOptions) ([]windowDelta, error) {
+	if !usesTimeMacro(sql) {
+		return nil, fmt.Errorf("comparing windows requires the SQL to use a time macro such as $__timeFilter(time)")
+	}
+
+	var (
+		wg      sync.WaitGroup
+		results [2]*queryResult
+		errs    [2]error
+	)
+	for i, opts := range []queryOptions{baseline, current} {
+		wg.Add(1)
+		go func(i int, opts queryOptions) {
+			defer wg.Done()
+			results[i], errs[i] = c.query(ctx, sql, opts)
+		}(i, opts)
+	}
+	wg.Wait()
+
+	windows := [2]map[string]map[string]float64{}
+	for i, name := range []string{"baseline", "current"} {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("%s window: %w", name, errs[i])
+		}
+		var err error
+		if windows[i], err = windowMetrics(results[i], groupBy); err != nil {
+			return nil, fmt.Errorf("%s window: %w", name, err)
+		}
+	}
+	return diffWindows(windows[0], windows[1]), nil
+}
+
+func influxDBCompareWindows(ctx context.Context, args InfluxDBCompareWindowsParams) ([]windowDelta, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var baseline, current queryOptions
+	if baseline.From, baseline.To, err = resolveInfluxTimeRange(args.BaselineFrom, args.BaselineTo, now); err != nil {
+		return nil, fmt.Errorf("baseline window: %w", err)
+	}
+	if current.From, current.To, err = resolveInfluxTimeRange(args.CurrentFrom, args.CurrentTo, now); err != nil {
+		return nil, fmt.Errorf("current window: %w", err)
+	}
+	return cli.compareWindows(ctx, args.SQL, args.GroupBy, baseline, current)
+}
+
+var InfluxDBCompareWindows = mcpgrafana.MustTool(
+	"influxdb_compare_windows",
+	"InfluxDB v3 datasource: Runs an aggregate SQL query over a baseline and a current time window and returns, for each group and numeric column, the value in each window, the delta and the percent change. Metrics present in only one window are reported with the other side null. Useful for spotting anomalies against a previous period.",
+	influxDBCompareWindows,
+)
@@ -0,0 +1,61 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbCompareWindows(t *testing.T) {
+	baseline := queryOptions{From: time.UnixMilli(0), To: time.UnixMilli(1000)}
+	current := queryOptions{From: time.UnixMilli(1000), To: time.UnixMilli(2000)}
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var frame *data.Frame
+		if decodePayload(t, r).From == "0" {
+			frame = data.NewFrame("",
+				data.NewField("host", nil, []string{"web1", "web2"}),
+				data.NewField("avg_usage", nil, []float64{0.5, 0}),
+			)
+		} else {
+			frame = data.NewFrame("",
+				data.NewField("host", nil, []string{"web1", "web2", "web3"}),
+				data.NewField("avg_usage", nil, []float64{0.75, 0.2, 0.1}),
+			)
+		}
+		_, _ = w.Write(arrowResponse(t, frame))
+	})
+
+	f := func(v float64) *float64 { return &v }
+	deltas, err := cli.compareWindows(context.Background(), "SELECT host, AVG(usage) AS avg_usage FROM cpu WHERE $__timeFilter(time) GROUP BY host", "host", baseline, current)
+	require.NoError(t, err)
+	assert.Equal(t, []windowDelta{
+		{Group: "web1", Metric: "avg_usage", Baseline: f(0.5), Current: f(0.75), Delta: f(0.25), PercentChange: f(50), Presence: "both"},
+		{Group: "web2", Metric: "avg_usage", Baseline: f(0), Current: f(0.2), Delta: f(0.2), Presence: "both"},
+		{Group: "web3", Metric: "avg_usage", Current: f(0.1), Presence: "current_only"},
+	}, deltas)
+
+	_, err = cli.compareWindows(context.Background(), "SELECT 1", "", baseline, current)
+	assert.ErrorContains(t, err, "time macro")
+
+	_, err = cli.compareWindows(context.Background(), "SELECT * WHERE $__timeFilter(time)", "", baseline, current)
+	assert.ErrorContains(t, err, "set groupBy")
+}
+
+func TestDiffWindowsBaselineOnly(t *testing.T) {
+	deltas := diffWindows(
+		map[string]map[string]float64{"": {"errors": 3, "requests": 10}},
+		map[string]map[string]float64{"": {"requests": 5}},
+	)
+	require.Len(t, deltas, 2)
+	assert.Equal(t, "baseline_only", deltas[0].Presence)
+	assert.Nil(t, deltas[0].Current)
+	assert.Equal(t, -50.0, *deltas[1].PercentChange)
+}
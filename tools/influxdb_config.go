@@ -0,0 +1,112 @@
+package tools
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// InfluxDBConfig holds operator configuration for the InfluxDB tools. It is
+// set on the context by the server so that it applies to every tool call.
+type InfluxDBConfig struct {
+	// UserAgentSuffix is appended to the User-Agent header sent to Grafana,
+	// e.g. to identify a particular deployment in access logs.
+	UserAgentSuffix string
+	// SQLHook, if set, rewrites every SQL statement before it is sent, e.g.
+	// to tag queries with a comment or enforce a policy clause.
+	SQLHook SQLHook
+	// ProxyURL, if set, is the HTTP proxy requests to Grafana are sent
+	// through. It takes precedence over the HTTP_PROXY environment variables.
+	ProxyURL *url.URL
+	// DialTimeout, TLSHandshakeTimeout and ResponseHeaderTimeout bound the
+	// phases of a request to Grafana separately: connecting, the TLS
+	// handshake, and waiting for the response to start, which for a query is
+	// until the datasource has run it. The server's flags set them to 10s,
+	// 10s and no limit by default. Zero keeps the defaults of Go's
+	// http.DefaultTransport, which are 30s, 10s and no limit.
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	// RetryCorruptFrames re-issues a query once when its frame data fails to
+	// decompress or unmarshal, e.g. because a proxy mangled the response.
+	// Off by default since it runs the query a second time.
+	RetryCorruptFrames bool
+	// DecodeConcurrency is the number of record batches of a frame decoded
+	// at once, each of which is decompressed and parsed separately. Zero
+	// means GOMAXPROCS; 1 decodes them one after another.
+	DecodeConcurrency int
+	// MaxColumns, if positive, is the default limit on the number of columns
+	// query results are decoded with. Wider results keep their first
+	// MaxColumns columns.
+	MaxColumns int
+	// TimeColumn is the name of the time column of the tables the table
+	// tools read, such as tail_influxdb_table and explore_influxdb_table, which
+	// filter and order by it. Empty means "time", the column InfluxDB v3
+	// writes timestamps to; tables loaded from elsewhere may use another.
+	TimeColumn string
+	// NonFiniteValue is the string NaN and infinite float values in query
+	// results are replaced with, since JSON cannot represent them. Empty
+	// means null.
+	NonFiniteValue string
+	// RequireConfirmation makes query_influxdb_sql and
+	// run_named_influxdb_query return the plan of a query with a cost
+	// estimate and a confirmation token instead of running it; the query
+	// runs when the call is repeated with the token. Off by default.
+	RequireConfirmation bool
+	// DefaultLimit, if positive, is appended as a LIMIT to SELECT statements
+	// run by query_influxdb_sql which do not have one, so that an
+	// unbounded query cannot return an enormous result. Unlike maxRows the
+	// limit is applied by the datasource.
+	DefaultLimit int
+	// IngestionErrorsTable is the [schema.]table influxdb_ingestion_errors
+	// reads rejected writes from. If empty, a few common names are tried.
+	IngestionErrorsTable string
+	// AllowedGrafanaURLs are the Grafana URLs besides the configured one
+	// which query_influxdb_sql's grafanaUrl may name. The credentials of the
+	// request are sent to that Grafana, so any other URL is refused. Empty
+	// allows only the configured Grafana.
+	AllowedGrafanaURLs []string
+	// NamedQueries holds the queries run_named_influxdb_query can run.
+	NamedQueries *NamedQueryRegistry
+}
+
+// SQLHook rewrites the SQL sent to the datasource with the given UID. It runs
+// after the tool has assembled the statement and before the request is made,
+// so it applies whether or not the agent cooperates.
+type SQLHook func(datasourceUID, sql string) string
+
+// SQLAffixHook returns a hook which adds prefix before and suffix after every
+// statement, on their own lines so that neither is swallowed by a trailing
+// line comment. Trailing semicolons are removed before the suffix is added.
+// It returns nil if both are empty.
+func SQLAffixHook(prefix, suffix string) SQLHook {
+	if prefix == "" && suffix == "" {
+		return nil
+	}
+	return func(_, sql string) string {
+		if suffix != "" {
+			sql = strings.TrimRight(strings.TrimSpace(sql), ";") + "\n" + suffix
+		}
+		if prefix != "" {
+			sql = prefix + "\n" + sql
+		}
+		return sql
+	}
+}
+
+type influxDBConfigKey struct{}
+
+// WithInfluxDBConfig adds the InfluxDB tool configuration to the context.
+func WithInfluxDBConfig(ctx context.Context, cfg InfluxDBConfig) context.Context {
+	return context.WithValue(ctx, influxDBConfigKey{}, cfg)
+}
+
+// InfluxDBConfigFromContext extracts the InfluxDB tool configuration from the
+// context. If none is set, the zero configuration is returned.
+func InfluxDBConfigFromContext(ctx context.Context) InfluxDBConfig {
+	if cfg, ok := ctx.Value(influxDBConfigKey{}).(InfluxDBConfig); ok {
+		return cfg
+	}
+	return InfluxDBConfig{}
+}
@@ -0,0 +1,129 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbUserAgent(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cfg      InfluxDBConfig
+		expected string
+	}{
+		{"default", InfluxDBConfig{}, "mcp-grafana-influxdb/" + mcpgrafana.Version},
+		{"with suffix", InfluxDBConfig{UserAgentSuffix: "team-a"}, "mcp-grafana-influxdb/" + mcpgrafana.Version + " team-a"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("User-Agent")
+				_, _ = w.Write(arrowResponse(t, cpuFrame()))
+			}))
+			defer srv.Close()
+
+			ctx := WithInfluxDBConfig(context.Background(), tc.cfg)
+			cli := &influxdbClient{baseURL: srv.URL, uid: "influx", httpClient: newInfluxdbHTTPClient(ctx)}
+			_, err := cli.query(ctx, "SELECT 1", queryOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestInfluxdbProxyURL(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		_, _ = w.Write(arrowResponse(t, cpuFrame()))
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	ctx := WithInfluxDBConfig(context.Background(), InfluxDBConfig{ProxyURL: proxyURL})
+	cli := &influxdbClient{
+		baseURL:    "http://grafana.invalid/api/ds/query?ds_type=influxdb",
+		uid:        "influx",
+		httpClient: newInfluxdbHTTPClient(ctx),
+	}
+	res, err := cli.query(ctx, "SELECT 1", queryOptions{})
+	require.NoError(t, err)
+	assert.Len(t, res.Rows, 2)
+	assert.Equal(t, "http://grafana.invalid/api/ds/query?ds_type=influxdb", proxied)
+}
+
+func TestInfluxdbTransportTimeouts(t *testing.T) {
+	cfg := InfluxDBConfig{DialTimeout: time.Second, TLSHandshakeTimeout: 2 * time.Second, ResponseHeaderTimeout: 50 * time.Millisecond}
+	tr, ok := influxdbTransport(cfg, "").(*http.Transport)
+	require.True(t, ok)
+	assert.Equal(t, 2*time.Second, tr.TLSHandshakeTimeout)
+	assert.Equal(t, 50*time.Millisecond, tr.ResponseHeaderTimeout)
+	assert.Same(t, tr, influxdbTransport(cfg, ""), "the same settings share a transport")
+	def := influxdbTransport(InfluxDBConfig{}, "")
+	assert.NotSame(t, http.DefaultTransport, def, "closing idle connections must not reach the shared default transport")
+	assert.Same(t, def, influxdbTransport(InfluxDBConfig{}, ""))
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	ctx := WithInfluxDBConfig(context.Background(), cfg)
+	cli := &influxdbClient{baseURL: srv.URL + dsQueryPath, uid: "influx", httpClient: newInfluxdbHTTPClient(ctx)}
+	_, err := cli.query(ctx, "SELECT 1", queryOptions{})
+	var qe *InfluxQueryError
+	require.ErrorAs(t, err, &qe)
+	assert.Equal(t, QueryErrorTimeout, qe.Category)
+}
+
+func TestInfluxdbSQLHook(t *testing.T) {
+	t.Run("affix hook", func(t *testing.T) {
+		hook := SQLAffixHook("/* agent:test */", "LIMIT 10000")
+		assert.Equal(t, "/* agent:test */\nSELECT 1\nLIMIT 10000", hook("influx", "SELECT 1;\n"))
+		assert.Equal(t, "SELECT 1 -- note\nLIMIT 10", SQLAffixHook("", "LIMIT 10")("influx", "SELECT 1 -- note"))
+		assert.Nil(t, SQLAffixHook("", ""))
+	})
+
+	t.Run("applied before sending", func(t *testing.T) {
+		var sent string
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sent = decodePayload(t, r).Queries[0].RawSQL
+			_, _ = w.Write(arrowResponse(t, cpuFrame()))
+		})
+		var gotUID string
+		cli.sqlHook = func(uid, sql string) string {
+			gotUID = uid
+			return "/* hooked */ " + sql
+		}
+		_, err := cli.query(context.Background(), "SELECT 1", queryOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "/* hooked */ SELECT 1", sent)
+		assert.Equal(t, "influx", gotUID)
+	})
+}
+
+func TestInfluxdbClientTimeColumn(t *testing.T) {
+	assert.Equal(t, "time", (&influxdbClient{}).timeCol())
+	assert.Equal(t, "ts", (&influxdbClient{timeColumn: "ts"}).timeCol())
+
+	ctx := WithInfluxDBConfig(context.Background(), InfluxDBConfig{TimeColumn: "ts"})
+	cli := newInfluxdbClientUnchecked(ctx, "http://grafana", "uid", 0)
+	assert.Equal(t, "ts", cli.timeCol())
+
+	assert.Equal(t, `SELECT DISTINCT "ts" FROM "cpu" WHERE $__timeFilter("ts") ORDER BY "ts" DESC LIMIT 10`, granularitySQL("iox", "cpu", "ts", nil, 10))
+	assert.Equal(t, `SELECT COUNT("usage") AS n, MIN("usage") AS lo, MAX("usage") AS hi FROM "cpu" WHERE $__timeFilter("ts")`, histogramRangeSQL("iox", "cpu", "ts", "usage"))
+	assert.Equal(t, `SELECT COUNT("usage") AS n, AVG("usage") AS mean, STDDEV("usage") AS stddev FROM "cpu" WHERE $__timeFilter("ts")`, outlierStatsSQL("iox", "cpu", "ts", "usage", "zscore"))
+}
@@ -0,0 +1,190 @@
+package tools
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Confirmation tokens gate query_influxdb_sql when the server is started with
+// InfluxDBConfig.RequireConfirmation. Their lifecycle is:
+//
+//  1. A call without confirmationToken does not run the query. It runs
+//     EXPLAIN on it instead and returns a queryPreview with the plan, a cost
+//     estimate read from it and a token.
+//  2. The token is bound to the query and where it is sent: the datasource,
+//     orgId, grafanaUrl, headers and queryType, the SQL, the from and to
+//     arguments exactly as given and the options which rewrite the statement
+//     or split it into several: pageSize, pageToken, sampleRate and
+//     chunkInterval. It expires confirmationTokenTTL after it was issued.
+//  3. Repeating the call with the same arguments and the token runs the
+//     query. The token may be reused until it expires, e.g. to retry.
+//
+// Tokens are signed with a key generated when the process starts, so unlike
+// page tokens they cannot be made up by the caller, and a restart invalidates
+// them all.
+
+// confirmationTokenTTL is how long a confirmation token is valid for.
+const confirmationTokenTTL = 5 * time.Minute
+
+var (
+	// errInvalidConfirmationToken is returned for a confirmation token which
+	// was not issued for the query it is used with.
+	errInvalidConfirmationToken = errors.New("invalid confirmationToken; call again without one for a new preview")
+	// errExpiredConfirmationToken is returned for a confirmation token used
+	// after it expired.
+	errExpiredConfirmationToken = errors.New("confirmationToken has expired; call again without one for a new preview")
+)
+
+// confirmationKey signs confirmation tokens.
+var confirmationKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("generating confirmation key: %v", err))
+	}
+	return key
+}()
+
+// confirmationToken is the decoded form of a confirmation token. Expires is
+// in Unix milliseconds, and Sum binds it to the query it was issued for.
+type confirmationToken struct {
+	Expires int64  `json:"e"`
+	Sum     string `json:"s"`
+}
+
+// confirmationSum signs an expiry together with the query and the options
+// which decide where it is sent and what runs. Headers are sorted, as their
+// order does not matter.
+func confirmationSum(expires int64, args QueryInfluxSQLParams) string {
+	headers := slices.Clone(args.Headers)
+	for i, h := range headers {
+		headers[i] = strings.TrimSpace(h)
+	}
+	slices.Sort(headers)
+	mac := hmac.New(sha256.New, confirmationKey)
+	for _, s := range []string{
+		strconv.FormatInt(expires, 10), args.DatasourceUID, strconv.FormatInt(args.OrgID, 10),
+		strings.TrimRight(args.GrafanaURL, "/"), strings.Join(headers, "\n"), args.QueryType,
+		args.SQL, args.From, args.To, strconv.Itoa(args.PageSize), args.PageToken,
+		floatLiteral(args.SampleRate), args.ChunkInterval,
+	} {
+		mac.Write([]byte(s))
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// encodeConfirmationToken returns a token confirming args' query until
+// expires.
+func encodeConfirmationToken(args QueryInfluxSQLParams, expires time.Time) string {
+	ms := expires.UnixMilli()
+	b, _ := json.Marshal(confirmationToken{Expires: ms, Sum: confirmationSum(ms, args)})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// checkConfirmationToken returns nil if args.ConfirmationToken was issued for
+// args' query and has not expired at now.
+func checkConfirmationToken(args QueryInfluxSQLParams, now time.Time) error {
+	b, err := base64.RawURLEncoding.DecodeString(args.ConfirmationToken)
+	if err != nil {
+		return errInvalidConfirmationToken
+	}
+	var ct confirmationToken
+	if err := json.Unmarshal(b, &ct); err != nil {
+		return errInvalidConfirmationToken
+	}
+	if !hmac.Equal([]byte(ct.Sum), []byte(confirmationSum(ct.Expires, args))) {
+		return errInvalidConfirmationToken
+	}
+	if now.UnixMilli() >= ct.Expires {
+		return errExpiredConfirmationToken
+	}
+	return nil
+}
+
+// queryEstimate is the cost of a query as far as its physical plan shows it.
+type queryEstimate struct {
+	// ParquetFiles is the number of Parquet files the plan reads and Chunks
+	// the number of chunks of recently written data not yet persisted.
+	ParquetFiles int `json:"parquetFiles"`
+	Chunks       int `json:"chunks"`
+}
+
+// queryPreview is returned instead of the result of a query awaiting
+// confirmation.
+type queryPreview struct {
+	ConfirmationRequired bool          `json:"confirmationRequired"`
+	ConfirmationToken    string        `json:"confirmationToken"`
+	ExpiresAt            string        `json:"expiresAt"`
+	Estimate             queryEstimate `json:"estimate"`
+	// Plan is the physical plan, or the logical plan if the datasource
+	// returned no physical plan.
+	Plan string `json:"plan"`
+}
+
+var (
+	parquetFileRe = regexp.MustCompile(`\.parquet\b`)
+	chunksRe      = regexp.MustCompile(`RecordBatchesExec: chunks=(\d+)`)
+)
+
+// estimateCost reads the cost of a query from its physical plan.
+func estimateCost(plan string) queryEstimate {
+	est := queryEstimate{ParquetFiles: len(parquetFileRe.FindAllStringIndex(plan, -1))}
+	for _, m := range chunksRe.FindAllStringSubmatch(plan, -1) {
+		n, _ := strconv.Atoi(m[1])
+		est.Chunks += n
+	}
+	return est
+}
+
+// plannedSQL returns the statement queryInfluxSQL runs for sql, the possibly
+// paged SQL of args, once sampling and the client's default limit are
+// applied. Chunked queries run it once per chunk without the limit.
+func (c *influxdbClient) plannedSQL(sql string, args QueryInfluxSQLParams) string {
+	if args.ChunkInterval != "" {
+		return sql
+	}
+	if args.SampleRate != 0 {
+		sql = sampledSQL(sql, args.SampleRate)
+	}
+	sql, _ = withDefaultLimit(sql, c.defaultLimit)
+	return sql
+}
+
+// previewQuery explains sql, the statement args' query runs (see plannedSQL),
+// over opts' time range and returns its plan with a token confirming args.
+// EXPLAIN returns one row per plan, with columns plan_type and plan.
+func (c *influxdbClient) previewQuery(ctx context.Context, sql string, args QueryInfluxSQLParams, opts queryOptions, now time.Time) (*queryPreview, error) {
+	explain := queryOptions{From: opts.From, To: opts.To, Headers: opts.Headers}
+	res, err := c.query(ctx, "EXPLAIN "+strings.TrimSpace(sql), explain)
+	if err != nil {
+		return nil, fmt.Errorf("explaining the query for confirmation: %w", err)
+	}
+	plans := map[string]string{}
+	for _, row := range res.Rows {
+		plans[formatCell(row["plan_type"])] = formatCell(row["plan"])
+	}
+	plan, ok := plans["physical_plan"]
+	if !ok {
+		plan = plans["logical_plan"]
+	}
+	expires := now.Add(confirmationTokenTTL)
+	return &queryPreview{
+		ConfirmationRequired: true,
+		ConfirmationToken:    encodeConfirmationToken(args, expires),
+		ExpiresAt:            expires.UTC().Format(time.RFC3339),
+		Estimate:             estimateCost(plan),
+		Plan:                 plan,
+	}, nil
+}
@@ -0,0 +1,138 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryInfluxSQLConfirmation(t *testing.T) {
+	plan := "ProjectionExec: expr=[host@0 as host, usage@1 as usage]\n" +
+		"  UnionExec\n" +
+		"    RecordBatchesExec: chunks=2\n" +
+		"    ParquetExec: file_groups={2 groups: [[1/1/a.parquet, 1/1/b.parquet], [1/1/c.parquet]]}"
+	ctx, sent := newSQLRouterContext(t, map[string][]byte{
+		"EXPLAIN ": arrowResponse(t, data.NewFrame("",
+			data.NewField("plan_type", nil, []string{"logical_plan", "physical_plan"}),
+			data.NewField("plan", nil, []string{"Projection: host, usage", plan}),
+		)),
+		"": arrowResponse(t, cpuFrame()),
+	})
+	args := QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT host, usage FROM cpu LIMIT 10", From: "now-1h"}
+	confirmCtx := WithInfluxDBConfig(ctx, InfluxDBConfig{RequireConfirmation: true})
+
+	sent.reset()
+	out, err := queryInfluxSQL(confirmCtx, args)
+	require.NoError(t, err)
+	preview, ok := out.(*queryPreview)
+	require.True(t, ok, "a call without a token is previewed: %T", out)
+	assert.Equal(t, []string{"EXPLAIN " + args.SQL}, sent.all(), "the query itself is not run")
+	assert.True(t, preview.ConfirmationRequired)
+	assert.Equal(t, plan, preview.Plan)
+	assert.Equal(t, queryEstimate{ParquetFiles: 3, Chunks: 2}, preview.Estimate)
+	require.NotEmpty(t, preview.ConfirmationToken)
+
+	sent.reset()
+	confirmed := args
+	confirmed.ConfirmationToken = preview.ConfirmationToken
+	out, err = queryInfluxSQL(confirmCtx, confirmed)
+	require.NoError(t, err)
+	assert.Equal(t, []string{args.SQL}, sent.all())
+	assert.Len(t, out, 2)
+
+	t.Run("token bound to the query", func(t *testing.T) {
+		for _, edit := range []func(*QueryInfluxSQLParams){
+			func(a *QueryInfluxSQLParams) { a.SQL = "SELECT * FROM cpu LIMIT 10" },
+			func(a *QueryInfluxSQLParams) { a.From = "now-30d" },
+			func(a *QueryInfluxSQLParams) { a.DatasourceUID = "other" },
+			func(a *QueryInfluxSQLParams) { a.OrgID = 2 },
+			func(a *QueryInfluxSQLParams) { a.GrafanaURL = "https://grafana-eu.example.com" },
+			func(a *QueryInfluxSQLParams) { a.Headers = []string{"X-Grafana-Feature-Toggles: some=true"} },
+			func(a *QueryInfluxSQLParams) { a.QueryType = "InfluxQL" },
+			func(a *QueryInfluxSQLParams) { a.PageSize = 5 },
+			func(a *QueryInfluxSQLParams) { a.PageToken = "next" },
+			func(a *QueryInfluxSQLParams) { a.SampleRate = 0.5 },
+			func(a *QueryInfluxSQLParams) { a.ChunkInterval = "1h" },
+			func(a *QueryInfluxSQLParams) { a.ConfirmationToken = "bogus" },
+		} {
+			a := confirmed
+			edit(&a)
+			assert.ErrorIs(t, checkConfirmationToken(a, time.Now()), errInvalidConfirmationToken)
+		}
+		_, err := queryInfluxSQL(confirmCtx, QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT 1", ConfirmationToken: preview.ConfirmationToken})
+		assert.ErrorIs(t, err, errInvalidConfirmationToken)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		assert.NoError(t, checkConfirmationToken(confirmed, time.Now().Add(confirmationTokenTTL-time.Minute)))
+		assert.ErrorIs(t, checkConfirmationToken(confirmed, time.Now().Add(confirmationTokenTTL+time.Second)), errExpiredConfirmationToken)
+	})
+
+	t.Run("explains the statement which runs", func(t *testing.T) {
+		limitCtx := WithInfluxDBConfig(ctx, InfluxDBConfig{RequireConfirmation: true, DefaultLimit: 5})
+		unlimited := QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT host, usage FROM cpu WHERE $__timeFilter(time)", From: "now-1h"}
+		for _, tc := range []struct {
+			name string
+			edit func(*QueryInfluxSQLParams)
+			want string
+		}{
+			{"default limit", func(*QueryInfluxSQLParams) {}, unlimited.SQL + "\nLIMIT 5"},
+			{"sampled", func(a *QueryInfluxSQLParams) { a.SampleRate = 0.5 }, sampledSQL(unlimited.SQL, 0.5) + "\nLIMIT 5"},
+			{"paged", func(a *QueryInfluxSQLParams) { a.PageSize = 2 }, keysetPageSQL(unlimited.SQL, "time", time.Time{}, 2)},
+			{"chunked", func(a *QueryInfluxSQLParams) { a.ChunkInterval = "10m" }, unlimited.SQL},
+		} {
+			a := unlimited
+			tc.edit(&a)
+			sent.reset()
+			out, err := queryInfluxSQL(limitCtx, a)
+			require.NoError(t, err, tc.name)
+			require.IsType(t, &queryPreview{}, out, tc.name)
+			assert.Equal(t, []string{"EXPLAIN " + tc.want}, sent.all(), tc.name)
+		}
+	})
+
+	t.Run("not required", func(t *testing.T) {
+		sent.reset()
+		out, err := queryInfluxSQL(ctx, args)
+		require.NoError(t, err)
+		assert.Equal(t, []string{args.SQL}, sent.all())
+		assert.Len(t, out, 2)
+	})
+
+	t.Run("named query", func(t *testing.T) {
+		registry := NewNamedQueryRegistry()
+		require.NoError(t, registry.Register(NamedQuery{
+			Name: "usage", DatasourceUID: "influx",
+			SQL:    "SELECT host, usage FROM cpu WHERE host = {{host}} LIMIT 10",
+			Params: []NamedQueryParam{{Name: "host", Required: true}},
+		}))
+		namedCtx := WithInfluxDBConfig(ctx, InfluxDBConfig{RequireConfirmation: true, NamedQueries: registry})
+		named := RunNamedInfluxDBQueryParams{Name: "usage", Params: map[string]any{"host": "web1"}}
+		rendered := "SELECT host, usage FROM cpu WHERE host = 'web1' LIMIT 10"
+
+		sent.reset()
+		out, err := runNamedInfluxDBQuery(namedCtx, named)
+		require.NoError(t, err)
+		preview, ok := out.(*queryPreview)
+		require.True(t, ok, "%T", out)
+		assert.Equal(t, []string{"EXPLAIN " + rendered}, sent.all())
+
+		sent.reset()
+		named.ConfirmationToken = preview.ConfirmationToken
+		out, err = runNamedInfluxDBQuery(namedCtx, named)
+		require.NoError(t, err)
+		assert.Equal(t, []string{rendered}, sent.all())
+		assert.Len(t, out, 2)
+
+		// The token does not confirm the query with other parameters.
+		named.Params = map[string]any{"host": "web2"}
+		_, err = runNamedInfluxDBQuery(namedCtx, named)
+		assert.ErrorIs(t, err, errInvalidConfirmationToken)
+	})
+}
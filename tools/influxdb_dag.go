@@ -0,0 +1,204 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// MaxInfluxQuerySteps bounds the number of steps of a single
+// run_influxdb_query_steps call.
+const MaxInfluxQuerySteps = 20
+
+// QueryStep is one named query of a multi-step run. Its SQL may reference the
+// scalar result of other steps as {{name}}.
+type QueryStep struct {
+	Name string `json:"name" jsonschema:"required,description=Name other steps use to reference this step's result"`
+	SQL  string `json:"sql" jsonschema:"required,description=SQL statement. {{name}} is replaced with the scalar result of step 'name': the first column of its first row"`
+}
+
+type RunInfluxDBQueryStepsParams struct {
+	DatasourceUID string      `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Steps         []QueryStep `json:"steps" jsonschema:"required,description=Queries to run. They run in dependency order regardless of the order given; at most 20"`
+	From          string      `json:"from,omitempty" jsonschema:"description=Start of the time range for every step as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Defaults to one hour before 'to'"`
+	To            string      `json:"to,omitempty" jsonschema:"description=End of the time range. Defaults to now"`
+}
+
+type stepResult struct {
+	Name string `json:"name"`
+	// SQL is the statement as run, with references substituted.
+	SQL  string       `json:"sql"`
+	Rows []orderedRow `json:"rows"`
+	// Scalar is the value other steps see when they reference this one.
+	Scalar any `json:"scalar"`
+}
+
+type querySteps struct {
+	Order []string     `json:"order"`
+	Steps []stepResult `json:"steps"`
+}
+
+// stepName matches the names a {{name}} reference can refer to.
+var stepName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// planQuerySteps validates steps and returns the order to run them in: every
+// step after the steps it references, otherwise in the order given.
+func planQuerySteps(steps []QueryStep) ([]string, map[string][]string, error) {
+	if len(steps) == 0 {
+		return nil, nil, fmt.Errorf("at least one step is required")
+	}
+	if len(steps) > MaxInfluxQuerySteps {
+		return nil, nil, fmt.Errorf("too many steps: %d, the maximum is %d", len(steps), MaxInfluxQuerySteps)
+	}
+	index := make(map[string]bool, len(steps))
+	for _, s := range steps {
+		if !stepName.MatchString(s.Name) {
+			return nil, nil, fmt.Errorf("invalid step name %q: use letters, digits and underscores", s.Name)
+		}
+		if index[s.Name] {
+			return nil, nil, fmt.Errorf("duplicate step name %q", s.Name)
+		}
+		index[s.Name] = true
+	}
+	deps := make(map[string][]string, len(steps))
+	for _, s := range steps {
+		for _, m := range namedQueryPlaceholder.FindAllStringSubmatch(s.SQL, -1) {
+			if !index[m[1]] {
+				return nil, nil, fmt.Errorf("step %q references unknown step {{%s}}", s.Name, m[1])
+			}
+			deps[s.Name] = append(deps[s.Name], m[1])
+		}
+	}
+
+	// Depth-first search in request order, so that independent steps keep
+	// their relative order.
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(steps))
+	order := make([]string, 0, len(steps))
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			start := 0
+			for path[start] != name {
+				start++
+			}
+			cycle := append(append([]string{}, path[start:]...), name)
+			return fmt.Errorf("steps reference each other in a cycle: %s", strings.Join(cycle, " -> "))
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range deps[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+	for _, s := range steps {
+		if err := visit(s.Name); err != nil {
+			return nil, nil, err
+		}
+	}
+	return order, deps, nil
+}
+
+// scalarLiteral renders a step's scalar result as an SQL literal.
+func scalarLiteral(v any) string {
+	if isNil(v) {
+		return "NULL"
+	}
+	if t, ok := v.(time.Time); ok {
+		return quoteLiteral(t.UTC().Format(time.RFC3339Nano))
+	}
+	if t, ok := v.(*time.Time); ok {
+		return quoteLiteral(t.UTC().Format(time.RFC3339Nano))
+	}
+	if f, ok := toFloat(v); ok {
+		return floatLiteral(f)
+	}
+	if b, ok := v.(bool); ok {
+		return strconv.FormatBool(b)
+	}
+	return quoteLiteral(formatCell(v))
+}
+
+// runQuerySteps runs steps in dependency order, substituting each step's
+// scalar result into the steps referencing it.
+func (c *influxdbClient) runQuerySteps(ctx context.Context, steps []QueryStep, opts queryOptions) ([]string, []stepResult, error) {
+	order, deps, err := planQuerySteps(steps)
+	if err != nil {
+		return nil, nil, err
+	}
+	byName := make(map[string]QueryStep, len(steps))
+	for _, s := range steps {
+		byName[s.Name] = s
+	}
+
+	scalars := make(map[string]string, len(steps))
+	results := make([]stepResult, 0, len(steps))
+	for _, name := range order {
+		for _, dep := range deps[name] {
+			if _, ok := scalars[dep]; !ok {
+				return nil, nil, fmt.Errorf("step %q: step %q returned no rows, so {{%s}} has no value", name, dep, dep)
+			}
+		}
+		sql := namedQueryPlaceholder.ReplaceAllStringFunc(byName[name].SQL, func(m string) string {
+			return scalars[namedQueryPlaceholder.FindStringSubmatch(m)[1]]
+		})
+		res, err := c.query(ctx, sql, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("step %q: %w", name, err)
+		}
+		out := stepResult{Name: name, SQL: sql}
+		if len(res.Rows) > 0 && len(res.Columns) > 0 {
+			v := res.Rows[0][res.Columns[0]]
+			scalars[name] = scalarLiteral(v)
+			out.Scalar = jsonSafeValue(v)
+		}
+		if err := applyTypePolicy(res.Rows, typePolicyJSONSafe); err != nil {
+			return nil, nil, err
+		}
+		out.Rows = orderedRows(res)
+		results = append(results, out)
+	}
+	return order, results, nil
+}
+
+func runInfluxDBQuerySteps(ctx context.Context, args RunInfluxDBQueryStepsParams) (*querySteps, error) {
+	var opts queryOptions
+	var err error
+	if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+		return nil, err
+	}
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	order, results, err := cli.runQuerySteps(ctx, args.Steps, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &querySteps{Order: order, Steps: results}, nil
+}
+
+var RunInfluxDBQuerySteps = mcpgrafana.MustTool(
+	"run_influxdb_query_steps",
+	"InfluxDB v3 datasource: Runs several named SQL queries in one call, where a query can use the result of another by writing {{name}}, which is replaced with the first column of the first row of step 'name' as an SQL literal. For example a step 'threshold' computing AVG(usage) * 2 and a step 'spikes' with WHERE usage > {{threshold}}. Steps run in dependency order; cycles are reported as errors. Returns the execution order and each step's SQL as run, rows and scalar value.",
+	runInfluxDBQuerySteps,
+)
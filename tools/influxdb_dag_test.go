@@ -0,0 +1,76 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbRunQuerySteps(t *testing.T) {
+	var sqls []string
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sql := decodePayload(t, r).Queries[0].RawSQL
+		sqls = append(sqls, sql)
+		switch {
+		case strings.Contains(sql, "AVG"):
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("threshold", nil, []float64{1.5}))))
+		case strings.Contains(sql, "MAX(time)"):
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("latest", nil, []time.Time{time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}))))
+		case strings.Contains(sql, "empty"):
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("x", nil, []string{}))))
+		default:
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("host", nil, []string{"web1", "web2"}))))
+		}
+	})
+
+	// Given out of order: spikes needs threshold and latest.
+	order, steps, err := cli.runQuerySteps(context.Background(), []QueryStep{
+		{Name: "spikes", SQL: "SELECT host FROM cpu WHERE usage > {{threshold}} AND time <= {{ latest }}"},
+		{Name: "threshold", SQL: "SELECT AVG(usage) * 2 FROM cpu"},
+		{Name: "latest", SQL: "SELECT MAX(time) FROM cpu"},
+	}, queryOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"threshold", "latest", "spikes"}, order)
+	assert.Equal(t, "SELECT host FROM cpu WHERE usage > 1.5 AND time <= '2024-05-01T12:00:00Z'", sqls[2])
+	require.Len(t, steps, 3)
+	assert.Equal(t, 1.5, steps[0].Scalar)
+	assert.Equal(t, "2024-05-01T12:00:00Z", steps[1].Scalar)
+	assert.Len(t, steps[2].Rows, 2)
+	assert.Equal(t, "web1", steps[2].Scalar)
+
+	for _, tc := range []struct {
+		name  string
+		steps []QueryStep
+		err   string
+	}{
+		{"cycle", []QueryStep{
+			{Name: "a", SQL: "SELECT {{b}}"},
+			{Name: "b", SQL: "SELECT {{c}}"},
+			{Name: "c", SQL: "SELECT {{a}}"},
+		}, "cycle: a -> b -> c -> a"},
+		{"self reference", []QueryStep{{Name: "a", SQL: "SELECT {{a}}"}}, "cycle: a -> a"},
+		{"unknown reference", []QueryStep{{Name: "a", SQL: "SELECT {{nope}}"}}, "unknown step {{nope}}"},
+		{"duplicate", []QueryStep{{Name: "a", SQL: "SELECT 1"}, {Name: "a", SQL: "SELECT 2"}}, "duplicate step name"},
+		{"invalid name", []QueryStep{{Name: "a b", SQL: "SELECT 1"}}, "invalid step name"},
+		{"none", nil, "at least one step"},
+		{"too many", make([]QueryStep, MaxInfluxQuerySteps+1), "too many steps"},
+		{"empty dependency", []QueryStep{
+			{Name: "e", SQL: "SELECT x FROM empty"},
+			{Name: "f", SQL: "SELECT {{e}}"},
+		}, `step "e" returned no rows`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := cli.runQuerySteps(context.Background(), tc.steps, queryOptions{})
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+type InfluxDBTableDDLParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table         string `json:"table" jsonschema:"required,description=Table (measurement) to describe"`
+	Database      string `json:"database,omitempty" jsonschema:"description=Schema the table is in. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+}
+
+// arrowSQLTypes maps the Arrow type names information_schema reports to SQL
+// type names.
+var arrowSQLTypes = map[string]string{
+	"Boolean": "BOOLEAN",
+	"Int8":    "TINYINT", "Int16": "SMALLINT", "Int32": "INT", "Int64": "BIGINT",
+	"UInt8": "TINYINT UNSIGNED", "UInt16": "SMALLINT UNSIGNED", "UInt32": "INT UNSIGNED", "UInt64": "BIGINT UNSIGNED",
+	"Float16": "REAL", "Float32": "REAL", "Float64": "DOUBLE",
+	"Utf8": "VARCHAR", "LargeUtf8": "VARCHAR", "Utf8View": "VARCHAR",
+	"Binary": "BYTEA", "LargeBinary": "BYTEA", "Date32": "DATE", "Date64": "DATE",
+}
+
+// sqlColumnType returns the SQL type of a column reported with the given
+// Arrow type, and whether it is an InfluxDB tag. Tags are dictionary-encoded
+// strings. Types without an SQL equivalent are returned unchanged.
+func sqlColumnType(arrowType string) (sqlType string, tag bool) {
+	switch {
+	case strings.HasPrefix(arrowType, "Dictionary("):
+		return "VARCHAR", true
+	case strings.HasPrefix(arrowType, "Timestamp("):
+		return "TIMESTAMP", false
+	}
+	if t, ok := arrowSQLTypes[arrowType]; ok {
+		return t, false
+	}
+	return arrowType, false
+}
+
+// reconstructDDL builds a CREATE TABLE statement from a table's columns.
+// InfluxDB v3 creates tables implicitly on write, so the statement documents
+// the schema rather than being something the database would have run.
+func reconstructDDL(schema, table string, columns []tableColumn) string {
+	if schema == "" {
+		schema = defaultInfluxSchema
+	}
+	var b strings.Builder
+	b.WriteString("-- Reconstructed from information_schema.columns. InfluxDB v3 creates tables on\n")
+	b.WriteString("-- write; tags are dictionary-encoded strings and time is the series timestamp.\n")
+	fmt.Fprintf(&b, "CREATE TABLE %s.%s (\n", quoteIdent(schema), quoteIdent(table))
+	for i, col := range columns {
+		sqlType, tag := sqlColumnType(col.DataType)
+		fmt.Fprintf(&b, "  %s %s", quoteIdent(col.Name), sqlType)
+		if !col.Nullable {
+			b.WriteString(" NOT NULL")
+		}
+		if i < len(columns)-1 {
+			b.WriteString(",")
+		}
+		switch {
+		case tag:
+			fmt.Fprintf(&b, " -- tag, %s", col.DataType)
+		case sqlType != col.DataType:
+			fmt.Fprintf(&b, " -- %s", col.DataType)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(");")
+	return b.String()
+}
+
+// nativeDDL returns the definition reported by SHOW CREATE TABLE, or "" if
+// the datasource does not support it or reports no definition, as
+// DataFusion does for tables other than views.
+func (c *influxdbClient) nativeDDL(ctx context.Context, schema, table string) string {
+	res, err := c.query(ctx, "SHOW CREATE TABLE "+qualifiedTable(schema, table), queryOptions{})
+	if err != nil || len(res.Rows) == 0 {
+		return ""
+	}
+	if def := res.Rows[0]["definition"]; !isNil(def) {
+		return strings.TrimSpace(formatCell(def))
+	}
+	return ""
+}
+
+// tableDDL returns the DDL of a table, preferring the datasource's own
+// SHOW CREATE TABLE and otherwise reconstructing it from information_schema.
+func (c *influxdbClient) tableDDL(ctx context.Context, schema, table string) (string, error) {
+	if ddl := c.nativeDDL(ctx, schema, table); ddl != "" {
+		return ddl, nil
+	}
+	columns, err := c.describeTable(ctx, schema, table)
+	if errors.Is(err, errTableNotFound) {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading table schema (the datasource may not expose information_schema): %w", err)
+	}
+	return reconstructDDL(schema, table, columns), nil
+}
+
+func influxDBTableDDL(ctx context.Context, args InfluxDBTableDDLParams) (string, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return "", err
+	}
+	return cli.tableDDL(ctx, args.Database, args.Table)
+}
+
+var InfluxDBTableDDL = mcpgrafana.MustTool(
+	"influxdb_table_ddl",
+	"InfluxDB v3 datasource: Returns a CREATE TABLE statement for a table. Uses SHOW CREATE TABLE where the datasource provides a definition, and otherwise reconstructs one from information_schema.columns with the column names, SQL types and nullability, marking tag columns and the original Arrow types in comments.",
+	influxDBTableDDL,
+)
@@ -0,0 +1,71 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbTableDDL(t *testing.T) {
+	columns := func(w http.ResponseWriter) {
+		_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+			data.NewField("column_name", nil, []string{"time", "host", "usage", "ok", "blob"}),
+			data.NewField("data_type", nil, []string{"Timestamp(Nanosecond, None)", "Dictionary(Int32, Utf8)", "Float64", "Boolean", "Struct(a Int64)"}),
+			data.NewField("is_nullable", nil, []string{"NO", "YES", "YES", "YES", "YES"}),
+		)))
+	}
+
+	t.Run("reconstructed", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sql := decodePayload(t, r).Queries[0].RawSQL
+			if strings.HasPrefix(sql, "SHOW CREATE TABLE") {
+				// DataFusion only has definitions for views.
+				_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+					data.NewField("table_name", nil, []string{"cpu"}),
+					data.NewField("definition", nil, []*string{nil}),
+				)))
+				return
+			}
+			columns(w)
+		})
+		ddl, err := cli.tableDDL(context.Background(), "", "cpu")
+		require.NoError(t, err)
+		assert.Equal(t, `-- Reconstructed from information_schema.columns. InfluxDB v3 creates tables on
+-- write; tags are dictionary-encoded strings and time is the series timestamp.
+CREATE TABLE "iox"."cpu" (
+  "time" TIMESTAMP NOT NULL, -- Timestamp(Nanosecond, None)
+  "host" VARCHAR, -- tag, Dictionary(Int32, Utf8)
+  "usage" DOUBLE, -- Float64
+  "ok" BOOLEAN, -- Boolean
+  "blob" Struct(a Int64)
+);`, ddl)
+	})
+
+	t.Run("native", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+				data.NewField("definition", nil, []string{"CREATE VIEW cpu AS SELECT 1"}),
+			)))
+		})
+		ddl, err := cli.tableDDL(context.Background(), "", "cpu")
+		require.NoError(t, err)
+		assert.Equal(t, "CREATE VIEW cpu AS SELECT 1", ddl)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"results":{"A":{"error":"unsupported statement","status":400}}}`))
+		})
+		_, err := cli.tableDDL(context.Background(), "", "cpu")
+		assert.ErrorContains(t, err, "the datasource may not expose information_schema")
+	})
+}
@@ -0,0 +1,739 @@
+package tools
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/DataDog/zstd"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pierrec/lz4/v4"
+)
+
+// DecodeDSQueryResponse decodes a captured Grafana /api/ds/query response
+// body for refId A into rows, using the same decoding as the InfluxDB query
+// tools: base64+zstd Arrow frames as well as the JSON values encoding are
+// supported. It is intended for debugging decode issues and writing tests
+// without a live Grafana.
+func DecodeDSQueryResponse(raw []byte) ([]map[string]any, error) {
+	res, err := decodeDSQueryResponse(raw)
+	if err != nil {
+		return nil, err
+	}
+	return res.Rows, nil
+}
+
+// decodeDSQueryResponse decodes the result for refId A of a successful
+// ds/query response.
+func decodeDSQueryResponse(raw []byte) (*queryResult, error) {
+	results, err := decodeDSQueryResults(raw, []string{"A"}, decodeOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// decodeOptions controls how frames are decoded.
+type decodeOptions struct {
+	// Columnar decodes results into queryResult.Data instead of rows.
+	Columnar bool
+	// FrameEncoding is the compression of Arrow frames, one of
+	// frameEncodings. Empty means "auto".
+	FrameEncoding string
+	// MaxColumns, if positive, limits results to their first MaxColumns
+	// columns. Further columns are dropped before any row is built and
+	// counted in queryResult.ColumnsOmitted, or rejected with
+	// errTooManyColumns if StrictColumns is set.
+	MaxColumns    int
+	StrictColumns bool
+	// MaxCellBytes, if positive, truncates longer string values and
+	// replaces binary values with a note of their length; see limitCells.
+	MaxCellBytes int
+	// Nested decodes Arrow frames with decodeNestedArrow, which supports
+	// list, struct and map columns.
+	Nested bool
+	// FrameIndex selects the frame of a result which is decoded.
+	FrameIndex int
+	// NullRates counts the null and empty values of each column into
+	// queryResult.Nulls as the result is decoded.
+	NullRates bool
+	// Debug collects the debug information Grafana attaches to the frame
+	// into queryResult.Debug.
+	Debug bool
+	// NonFiniteValue is the string NaN and infinite float values are
+	// replaced with, as JSON cannot represent them. Empty or "null" replaces
+	// them with null.
+	NonFiniteValue string
+	// Concurrency is the number of record batches of a frame decoded at
+	// once. Zero means GOMAXPROCS.
+	Concurrency int
+}
+
+// errTooManyColumns is returned for results wider than the column limit when
+// strict column checking is enabled.
+var errTooManyColumns = errors.New("too many columns")
+
+// limitColumns returns how many of n columns to keep and how many to omit.
+func (dopts decodeOptions) limitColumns(n int) (keep, omitted int, err error) {
+	if dopts.MaxColumns <= 0 || n <= dopts.MaxColumns {
+		return n, 0, nil
+	}
+	if dopts.StrictColumns {
+		return 0, 0, fmt.Errorf("%w: the result has %d columns, more than the limit of %d; select fewer columns", errTooManyColumns, n, dopts.MaxColumns)
+	}
+	return dopts.MaxColumns, n - dopts.MaxColumns, nil
+}
+
+// frameEncodings are the accepted values of decodeOptions.FrameEncoding.
+var frameEncodings = []string{"auto", "zstd", "gzip", "lz4", "none"}
+
+// decodeDSQueryResults decodes the result of each of refIDs from a ds/query
+// response, in order.
+func decodeDSQueryResults(raw []byte, refIDs []string, dopts decodeOptions) ([]*queryResult, error) {
+	var parsed dsQueryResponse
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return nil, decodeError(fmt.Errorf("decode response JSON: %w", err))
+	}
+
+	refs := make([]dsRefResult, 0, len(refIDs))
+	for _, id := range refIDs {
+		var ref dsRefResult
+		var ok bool
+		if len(refIDs) == 1 {
+			ref, ok = parsed.result(id)
+		} else {
+			// Falling back to the only result is ambiguous with several queries.
+			ref, ok = parsed.Results[id]
+		}
+		if !ok {
+			return nil, decodeError(fmt.Errorf("no result for refId %s", id))
+		}
+		if ref.Error != "" {
+			return nil, refError(refIDs, id, statusError(ref.Status, ref.ErrorSource, ref.Error))
+		}
+		refs = append(refs, ref)
+	}
+
+	results := make([]*queryResult, len(refs))
+	for i, ref := range refs {
+		res, err := decodeFrames(ref.Frames, dopts)
+		if err != nil {
+			return nil, refError(refIDs, refIDs[i], err)
+		}
+		results[i] = res
+	}
+	return results, nil
+}
+
+// concurrency returns how many of n frames to decode at once.
+func (dopts decodeOptions) concurrency(n int) int {
+	workers := dopts.Concurrency
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return max(1, min(workers, n))
+}
+
+// decodeEach calls decode for each of 0..n-1, up to workers at a time, and
+// returns once all calls have.
+func decodeEach(n, workers int, decode func(i int)) {
+	if workers == 1 {
+		for i := range n {
+			decode(i)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i := range n {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			decode(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// refError identifies which query of a multi-query request err belongs to.
+// Errors of single queries are returned unchanged.
+func refError(refIDs []string, id string, err error) error {
+	if len(refIDs) == 1 {
+		return err
+	}
+	return fmt.Errorf("refId %s: %w", id, err)
+}
+
+// decodeFrames decodes the frames of a query result into rows. Only the frame
+// at dopts.FrameIndex is decoded, the first by default, as InfluxDB SQL
+// queries in table format return a single frame. If dopts.Columnar is set the values are decoded column by column
+// into queryResult.Data, without building a map per row.
+func decodeFrames(frames []frameEnvelope, dopts decodeOptions) (*queryResult, error) {
+	if dopts.FrameIndex < 0 || (dopts.FrameIndex > 0 && dopts.FrameIndex >= len(frames)) {
+		return nil, fmt.Errorf("frameIndex %d is out of range: the result has %d frames", dopts.FrameIndex, len(frames))
+	}
+	if len(frames) == 0 {
+		return emptyResult(dopts), nil
+	}
+
+	frame := frames[dopts.FrameIndex]
+	res, err := decodeFrame(frame, dopts)
+	if err != nil && os.Getenv(influxdbDecodeDebugEnvVar) != "" {
+		err = fmt.Errorf("%w (frame data, base64: %s)", err, debugDump(frame.Data))
+	}
+	if err == nil {
+		replaceNonFinite(res, dopts.NonFiniteValue)
+	}
+	if err == nil && dopts.NullRates {
+		res.Nulls = countNulls(res)
+	}
+	if err == nil && dopts.Debug {
+		res.Debug = frameDebug(frame, res.Frame)
+	}
+	return res, err
+}
+
+// emptyResult is the result of a frame without rows.
+func emptyResult(dopts decodeOptions) *queryResult {
+	if dopts.Columnar {
+		return &queryResult{Data: map[string][]any{}}
+	}
+	return &queryResult{Rows: []map[string]any{}}
+}
+
+// decodeFrame decodes a single frame. A frame whose data is a JSON array of
+// base64 Arrow frames, as some Grafana versions send, is one frame split into
+// several record batches: they are decoded concurrently, up to
+// dopts.Concurrency at a time, and the rows are concatenated in order. A frame
+// with null or no data has no rows.
+func decodeFrame(env frameEnvelope, dopts decodeOptions) (*queryResult, error) {
+	var (
+		dataStr string
+		parts   []string
+	)
+	switch raw := bytes.TrimSpace(env.Data); {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		return emptyResult(dopts), nil
+	case json.Unmarshal(raw, &dataStr) == nil:
+		return decodeArrowFrame(dataStr, dopts)
+	case json.Unmarshal(raw, &parts) == nil:
+		if len(parts) == 0 {
+			return emptyResult(dopts), nil
+		}
+		batches := make([]*queryResult, len(parts))
+		errs := make([]error, len(parts))
+		decodeEach(len(parts), dopts.concurrency(len(parts)), func(i int) {
+			batches[i], errs[i] = decodeArrowFrame(parts[i], dopts)
+		})
+		res := batches[0]
+		for i, next := range batches {
+			err := errs[i]
+			if err == nil && i > 0 {
+				err = appendResult(res, next)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("frame array element %d: %w", i, err)
+			}
+		}
+		return res, nil
+	}
+	return decodeValuesFrame(env, dopts)
+}
+
+// appendResult appends the rows of next, decoded from another record batch of
+// the same frame, to res. The batches must have the same columns.
+func appendResult(res, next *queryResult) error {
+	if !slices.Equal(res.Columns, next.Columns) {
+		return decodeError(fmt.Errorf("columns %v differ from the columns %v of the first element", next.Columns, res.Columns))
+	}
+	res.Rows = append(res.Rows, next.Rows...)
+	for col, values := range next.Data {
+		res.Data[col] = append(res.Data[col], values...)
+	}
+	for col, labels := range next.Labels {
+		if _, ok := res.Labels[col]; !ok {
+			if res.Labels == nil {
+				res.Labels = make(map[string]map[string]string)
+			}
+			res.Labels[col] = labels
+		}
+	}
+	res.ColumnsOmitted = max(res.ColumnsOmitted, next.ColumnsOmitted)
+	res.CellsTruncated += next.CellsTruncated
+	if res.Frame == nil || next.Frame == nil {
+		res.Frame = nil
+		return nil
+	}
+	for j, f := range res.Frame.Fields {
+		nf := next.Frame.Fields[j]
+		if f.Type() != nf.Type() {
+			return decodeError(fmt.Errorf("column %s is %s, but %s in the first element", res.Columns[j], nf.Type(), f.Type()))
+		}
+		for k := 0; k < nf.Len(); k++ {
+			f.Append(nf.At(k))
+		}
+	}
+	return nil
+}
+
+// queryDebug is the debug information Grafana attaches to the meta of a
+// result frame: the query the datasource sent upstream after expanding
+// macros, notices and query statistics. Which of these are set depends on the
+// datasource and Grafana version; the InfluxDB SQL datasource of Grafana 10
+// and later sets executedQueryString.
+type queryDebug struct {
+	ExecutedQueryString string           `json:"executedQueryString,omitempty"`
+	Notices             []data.Notice    `json:"notices,omitempty"`
+	Stats               []data.QueryStat `json:"stats,omitempty"`
+	Custom              any              `json:"custom,omitempty"`
+}
+
+// frameDebug returns the debug information in the meta of a frame, taken
+// from the decoded Arrow frame if there is one and otherwise from the
+// envelope's schema. It returns nil if the frame has none.
+func frameDebug(env frameEnvelope, frame *data.Frame) *queryDebug {
+	meta := &data.FrameMeta{}
+	if frame != nil && frame.Meta != nil {
+		meta = frame.Meta
+	} else if env.Schema != nil {
+		var schema struct {
+			Meta *data.FrameMeta `json:"meta"`
+		}
+		if b, err := json.Marshal(env.Schema); err == nil && json.Unmarshal(b, &schema) == nil && schema.Meta != nil {
+			meta = schema.Meta
+		}
+	}
+	d := &queryDebug{ExecutedQueryString: meta.ExecutedQueryString, Notices: meta.Notices, Stats: meta.Stats, Custom: meta.Custom}
+	if d.ExecutedQueryString == "" && len(d.Notices) == 0 && len(d.Stats) == 0 && d.Custom == nil {
+		return nil
+	}
+	return d
+}
+
+const (
+	// influxdbDecodeDebugEnvVar enables including a dump of undecodable
+	// frame data in decode errors. It is off by default because the dump may
+	// contain query results.
+	influxdbDecodeDebugEnvVar = "INFLUXDB_DEBUG_DECODE"
+
+	// maxDebugDumpBytes caps how much frame data is included in the dump.
+	maxDebugDumpBytes = 256
+)
+
+// debugDump base64-encodes up to maxDebugDumpBytes of raw frame data.
+func debugDump(raw []byte) string {
+	if len(raw) <= maxDebugDumpBytes {
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+	return fmt.Sprintf("%s... (%d bytes total)", base64.StdEncoding.EncodeToString(raw[:maxDebugDumpBytes]), len(raw))
+}
+
+// errCorruptFrame marks decode failures caused by corrupt frame bytes, as
+// opposed to a response with an unexpected structure. Only the former may
+// succeed if the query is retried.
+var errCorruptFrame = errors.New("corrupt frame data")
+
+// decodeArrowFrame decodes a base64-encoded Arrow IPC frame, compressed as
+// dopts.FrameEncoding says.
+func decodeArrowFrame(dataStr string, dopts decodeOptions) (*queryResult, error) {
+	decBase64, err := base64.StdEncoding.DecodeString(dataStr)
+	if err != nil {
+		return nil, decodeError(fmt.Errorf("base64 decode frame: %w", err))
+	}
+	arrowBytes, err := decompressFrame(decBase64, dopts.FrameEncoding)
+	if err != nil {
+		return nil, decodeError(err)
+	}
+	if dopts.Nested {
+		return decodeNestedArrow(arrowBytes, dopts)
+	}
+	frames, err := data.UnmarshalArrowFrames([][]byte{arrowBytes})
+	if err != nil && isUnsupportedArrowType(err) {
+		return nil, decodeError(fmt.Errorf("unmarshal arrow frame: %w; set nested to decode list, struct and map columns", err))
+	}
+	if err != nil {
+		return nil, decodeError(fmt.Errorf("unmarshal arrow frame: %w: %w", errCorruptFrame, err))
+	}
+	if len(frames) == 0 {
+		return emptyResult(dopts), nil
+	}
+	frame := frames[0]
+	keep, omitted, err := dopts.limitColumns(len(frame.Fields))
+	if err != nil {
+		return nil, err
+	}
+	if omitted > 0 {
+		frame = data.NewFrame(frame.Name, frame.Fields[:keep]...).SetMeta(frame.Meta)
+	}
+	var res *queryResult
+	if dopts.Columnar {
+		res = frameToColumnar(frame)
+	} else {
+		res = frameToResult(frame)
+	}
+	res.ColumnsOmitted = omitted
+	res.CellsTruncated = limitCells(res, dopts.MaxCellBytes)
+	res.Frame = frame
+	return res, nil
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	// lz4Magic starts an LZ4 frame, the format Arrow uses for LZ4.
+	lz4Magic = []byte{0x04, 0x22, 0x4d, 0x18}
+	// arrowStreamMagic is the continuation marker starting each message of
+	// an Arrow IPC stream; arrowFileMagic starts an Arrow IPC file.
+	arrowStreamMagic = []byte{0xff, 0xff, 0xff, 0xff}
+	arrowFileMagic   = []byte("ARROW1")
+)
+
+// decompressFrame returns the Arrow bytes of a frame compressed with
+// encoding. With "auto" the compression is detected from the leading magic
+// bytes, falling back to zstd, which Grafana uses for InfluxDB v3.
+func decompressFrame(b []byte, encoding string) ([]byte, error) {
+	if encoding == "" || encoding == "auto" {
+		switch {
+		case bytes.HasPrefix(b, gzipMagic):
+			encoding = "gzip"
+		case bytes.HasPrefix(b, lz4Magic):
+			encoding = "lz4"
+		case bytes.HasPrefix(b, arrowStreamMagic), bytes.HasPrefix(b, arrowFileMagic):
+			encoding = "none"
+		default:
+			encoding = "zstd"
+		}
+	}
+	switch encoding {
+	case "none":
+		return b, nil
+	case "zstd":
+		out, err := zstd.Decompress(nil, b)
+		if err != nil {
+			return nil, fmt.Errorf("zstd decompress: %w: %w", errCorruptFrame, err)
+		}
+		return out, nil
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("gzip decompress: %w: %w", errCorruptFrame, err)
+		}
+		out, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("gzip decompress: %w: %w", errCorruptFrame, err)
+		}
+		return out, nil
+	case "lz4":
+		out, err := io.ReadAll(lz4.NewReader(bytes.NewReader(b)))
+		if err != nil {
+			return nil, fmt.Errorf("lz4 decompress: %w: %w", errCorruptFrame, err)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown frame encoding %q", encoding)
+}
+
+// frameToResult builds row-oriented records from a decoded frame.
+func frameToResult(frame *data.Frame) *queryResult {
+	columns := fieldColumns(frame)
+	labels := fieldLabels(frame, columns)
+	numRows := frame.Rows()
+	records := make([]map[string]any, 0, numRows)
+	for i := 0; i < numRows; i++ {
+		row := make(map[string]any, len(frame.Fields))
+		for j, f := range frame.Fields {
+			row[columns[j]] = fieldValue(f, i)
+		}
+		records = append(records, row)
+	}
+	return &queryResult{Columns: columns, Rows: records, Labels: labels}
+}
+
+// frameToColumnar builds a column-oriented result directly from the fields of
+// a decoded frame.
+func frameToColumnar(frame *data.Frame) *queryResult {
+	columns := fieldColumns(frame)
+	values := make(map[string][]any, len(frame.Fields))
+	for j, f := range frame.Fields {
+		col := make([]any, f.Len())
+		for i := range col {
+			col[i] = fieldValue(f, i)
+		}
+		values[columns[j]] = col
+	}
+	return &queryResult{Columns: columns, Data: values, Labels: fieldLabels(frame, columns)}
+}
+
+// fieldLabels returns the labels of each labeled field, keyed by its column.
+func fieldLabels(frame *data.Frame, columns []string) map[string]map[string]string {
+	var labels map[string]map[string]string
+	for i, f := range frame.Fields {
+		if len(f.Labels) > 0 {
+			if labels == nil {
+				labels = make(map[string]map[string]string)
+			}
+			labels[columns[i]] = f.Labels.Copy()
+		}
+	}
+	return labels
+}
+
+// binaryValue is the value of a binary field which does not hold JSON. It
+// marshals as a string like the raw bytes did before, but can be told apart
+// from text by limitCells.
+type binaryValue string
+
+// fieldValue returns the value of field f at row i. JSON fields, which trace
+// frames use for span tags, logs and references, are decoded so that their
+// nested structure is returned rather than raw bytes. Arrow binary columns
+// are decoded as JSON fields too; those not holding JSON are binaryValues.
+func fieldValue(f *data.Field, i int) any {
+	v := f.At(i)
+	var raw json.RawMessage
+	switch j := v.(type) {
+	case json.RawMessage:
+		raw = j
+	case *json.RawMessage:
+		if j == nil {
+			return nil
+		}
+		raw = *j
+	default:
+		return v
+	}
+	var decoded any
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return binaryValue(raw)
+	}
+	return decoded
+}
+
+// truncatedSuffix marks string values cut off by the cell size limit.
+const truncatedSuffix = "…(truncated)"
+
+// limitCells truncates string values of res longer than maxBytes, keeping
+// whole UTF-8 characters, and replaces binary values with a note of their
+// length. It returns the number of values changed.
+func limitCells(res *queryResult, maxBytes int) int {
+	if maxBytes <= 0 {
+		return 0
+	}
+	n := 0
+	limit := func(v any) any {
+		switch s := v.(type) {
+		case binaryValue:
+			n++
+			return fmt.Sprintf("<binary, %d bytes>", len(s))
+		case []byte:
+			n++
+			return fmt.Sprintf("<binary, %d bytes>", len(s))
+		case *string:
+			if s != nil && len(*s) > maxBytes {
+				n++
+				return truncateUTF8(*s, maxBytes) + truncatedSuffix
+			}
+		case string:
+			if len(s) > maxBytes {
+				n++
+				return truncateUTF8(s, maxBytes) + truncatedSuffix
+			}
+		}
+		return v
+	}
+	for _, row := range res.Rows {
+		for col, v := range row {
+			row[col] = limit(v)
+		}
+	}
+	for _, values := range res.Data {
+		for i, v := range values {
+			values[i] = limit(v)
+		}
+	}
+	return n
+}
+
+// replaceNonFinite replaces the NaN and infinite float values of res, which
+// make JSON marshaling fail, with sentinel, or with null if sentinel is empty
+// or "null". Values nested in lists and structs are replaced too.
+func replaceNonFinite(res *queryResult, sentinel string) {
+	var repl any
+	if sentinel != "" && sentinel != "null" {
+		repl = sentinel
+	}
+	var replace func(v any) any
+	replace = func(v any) any {
+		var f float64
+		switch x := v.(type) {
+		case float64:
+			f = x
+		case float32:
+			f = float64(x)
+		case *float64:
+			if x == nil {
+				return v
+			}
+			f = *x
+		case *float32:
+			if x == nil {
+				return v
+			}
+			f = float64(*x)
+		case []any:
+			for i, e := range x {
+				x[i] = replace(e)
+			}
+			return v
+		case map[string]any:
+			for k, e := range x {
+				x[k] = replace(e)
+			}
+			return v
+		default:
+			return v
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return repl
+		}
+		return v
+	}
+	for _, row := range res.Rows {
+		for col, v := range row {
+			row[col] = replace(v)
+		}
+	}
+	for _, values := range res.Data {
+		for i, v := range values {
+			values[i] = replace(v)
+		}
+	}
+}
+
+// truncateUTF8 returns the longest prefix of s of at most n bytes which does
+// not split a UTF-8 character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// fieldColumns returns the column key for each field of frame. Time series
+// frames may contain several fields with the same name that differ only by
+// labels; those are keyed as `name {k=v, ...}` so that their values do not
+// overwrite each other.
+func fieldColumns(frame *data.Frame) []string {
+	names := make([]string, len(frame.Fields))
+	labels := make([]data.Labels, len(frame.Fields))
+	for i, f := range frame.Fields {
+		names[i], labels[i] = f.Name, f.Labels
+	}
+	return columnKeys(names, labels)
+}
+
+// columnKeys returns the column key for each field given its name and
+// labels; see fieldColumns.
+func columnKeys(names []string, labels []data.Labels) []string {
+	counts := make(map[string]int, len(names))
+	for _, name := range names {
+		counts[name]++
+	}
+	columns := make([]string, len(names))
+	for i, name := range names {
+		columns[i] = name
+		if counts[name] > 1 && len(labels[i]) > 0 {
+			columns[i] = fmt.Sprintf("%s {%s}", name, labels[i])
+		}
+	}
+	return columns
+}
+
+// decodeValuesFrame decodes a frame whose data uses the JSON values encoding.
+func decodeValuesFrame(env frameEnvelope, dopts decodeOptions) (*queryResult, error) {
+	var obj struct {
+		Values [][]any `json:"values"`
+	}
+	if err := json.Unmarshal(env.Data, &obj); err != nil {
+		return nil, decodeError(fmt.Errorf("unknown data format: %w", err))
+	}
+	keep, omitted, err := dopts.limitColumns(len(obj.Values))
+	if err != nil {
+		return nil, err
+	}
+	obj.Values = obj.Values[:keep]
+	if dopts.Columnar {
+		// The values encoding is already column-oriented.
+		columns := valuesColumnNames(len(obj.Values), env.Schema)
+		values := make(map[string][]any, len(columns))
+		for c, name := range columns {
+			values[name] = obj.Values[c]
+		}
+		res := &queryResult{Columns: columns, Data: values, ColumnsOmitted: omitted}
+		res.CellsTruncated = limitCells(res, dopts.MaxCellBytes)
+		return res, nil
+	}
+	columns, rows := valuesMatrixToJSON(obj.Values, env.Schema)
+	res := &queryResult{Columns: columns, Rows: rows, ColumnsOmitted: omitted}
+	res.CellsTruncated = limitCells(res, dopts.MaxCellBytes)
+	return res, nil
+}
+
+// Expand the column-oriented values array into row-oriented format, returning
+// the column names in schema order alongside the rows.
+func valuesMatrixToJSON(vals [][]any, schema any) ([]string, []map[string]any) {
+	if len(vals) == 0 || len(vals[0]) == 0 {
+		return nil, nil
+	}
+	rows := len(vals[0])
+	cols := len(vals)
+	columns := valuesColumnNames(cols, schema)
+	out := make([]map[string]any, rows)
+	for r := 0; r < rows; r++ {
+		row := make(map[string]any, cols)
+		for c := 0; c < cols; c++ {
+			row[columns[c]] = vals[c][r]
+		}
+		out[r] = row
+	}
+	return columns, out
+}
+
+// valuesColumnNames names the columns of a values matrix from the frame schema,
+// falling back to colN for columns the schema does not name.
+func valuesColumnNames(cols int, schema any) []string {
+	var fieldNames []string
+	if s, ok := schema.(map[string]any); ok {
+		if flds, ok := s["fields"].([]any); ok {
+			for _, f := range flds {
+				if fm, ok := f.(map[string]any); ok {
+					if name, ok := fm["name"].(string); ok {
+						fieldNames = append(fieldNames, name)
+					}
+				}
+			}
+		}
+	}
+	columns := make([]string, cols)
+	for c := 0; c < cols; c++ {
+		if c < len(fieldNames) {
+			columns[c] = fieldNames[c]
+		} else {
+			columns[c] = fmt.Sprintf("col%d", c)
+		}
+	}
+	return columns
+}
@@ -0,0 +1,695 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/zstd"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestColumnarResults(t *testing.T) {
+	t.Run("decoded from arrow fields", func(t *testing.T) {
+		res, err := decodeFrames([]frameEnvelope{{Data: json.RawMessage(`"` + arrowFrameData(t, cpuFrame()) + `"`)}}, decodeOptions{Columnar: true})
+		require.NoError(t, err)
+		assert.Nil(t, res.Rows)
+		assert.Equal(t, []string{"host", "usage"}, res.Columns)
+		assert.Equal(t, map[string][]any{"host": {"web1", "web2"}, "usage": {0.5, 0.75}}, res.Data)
+		assert.Equal(t, 2, res.numRows())
+	})
+
+	t.Run("decoded from values", func(t *testing.T) {
+		results, err := decodeDSQueryResults(readFixture(t, "values.json"), []string{"A"}, decodeOptions{Columnar: true})
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]any{
+			"time":  {1714564800000.0, 1714564860000.0},
+			"host":  {"web1", "web2"},
+			"usage": {0.5, nil},
+		}, results[0].Data)
+	})
+
+	t.Run("truncated with maxRows", func(t *testing.T) {
+		res := &queryResult{Columns: []string{"n"}, Data: map[string][]any{"n": {1, 2, 3}}}
+		args := QueryInfluxSQLParams{Columnar: true, MaxRows: 2}
+		require.NoError(t, transformResult(res, args))
+		out, err := formatResult(res, args)
+		require.NoError(t, err)
+		assert.Equal(t, columnarResponse{Columns: []string{"n"}, Data: map[string][]any{"n": {1, 2}}, Truncated: true, TotalSeen: 3}, out)
+	})
+
+	t.Run("pivoted from rows", func(t *testing.T) {
+		ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		res := &queryResult{
+			Columns: []string{"time", "host"},
+			Rows:    []map[string]any{{"time": ts, "host": "web1"}, {"time": ts, "host": "web1"}},
+		}
+		args := QueryInfluxSQLParams{Columnar: true, Dedupe: true}
+		require.NoError(t, transformResult(res, args))
+		out, err := formatResult(res, args)
+		require.NoError(t, err)
+		assert.Equal(t, columnarResponse{
+			Columns: []string{"time", "host"},
+			Data:    map[string][]any{"time": {"2024-05-01T12:00:00Z"}, "host": {"web1"}},
+		}, out)
+	})
+
+	t.Run("not with markdown", func(t *testing.T) {
+		_, err := formatResult(&queryResult{}, QueryInfluxSQLParams{Columnar: true, Format: "markdown"})
+		assert.Error(t, err)
+	})
+}
+
+func TestQueryInfluxSQLDebug(t *testing.T) {
+	executed := "SELECT * FROM cpu WHERE time >= '2024-05-01T12:00:00Z' LIMIT 10"
+	withMeta := cpuFrame().SetMeta(&data.FrameMeta{
+		ExecutedQueryString: executed,
+		Notices:             []data.Notice{{Severity: data.NoticeSeverityWarning, Text: "slow query"}},
+	})
+	query := func(t *testing.T, frame *data.Frame, args QueryInfluxSQLParams) (any, error) {
+		ctx := newTestGrafanaContext(t, respondWith(arrowResponse(t, frame)))
+		args.DatasourceUID, args.SQL = "influx", "SELECT * FROM cpu WHERE $__timeFilter(time) LIMIT 10"
+		return queryInfluxSQL(ctx, args)
+	}
+
+	t.Run("frame meta", func(t *testing.T) {
+		out, err := query(t, withMeta, QueryInfluxSQLParams{Debug: true})
+		require.NoError(t, err)
+		resp, ok := out.(queryResponse)
+		require.True(t, ok, "debug forces the envelope: %T", out)
+		require.NotNil(t, resp.Debug)
+		assert.Equal(t, executed, resp.Debug.ExecutedQueryString)
+		assert.Equal(t, "slow query", resp.Debug.Notices[0].Text)
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		out, err := query(t, withMeta, QueryInfluxSQLParams{Debug: true, Format: "markdown"})
+		require.NoError(t, err)
+		assert.Contains(t, out, "_Executed query:_\n\n```sql\n"+executed+"\n```")
+	})
+
+	t.Run("not requested", func(t *testing.T) {
+		out, err := query(t, withMeta, QueryInfluxSQLParams{MaxRows: 10})
+		require.NoError(t, err)
+		assert.Nil(t, out.(queryResponse).Debug)
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		out, err := query(t, cpuFrame(), QueryInfluxSQLParams{Debug: true})
+		require.NoError(t, err)
+		assert.Nil(t, out.(queryResponse).Debug, "a frame without meta degrades to no debug")
+	})
+
+	t.Run("values frame", func(t *testing.T) {
+		env := frameEnvelope{Schema: map[string]any{"meta": map[string]any{"executedQueryString": executed}}}
+		assert.Equal(t, &queryDebug{ExecutedQueryString: executed}, frameDebug(env, nil))
+		assert.Nil(t, frameDebug(frameEnvelope{Schema: map[string]any{"fields": []any{}}}, nil))
+	})
+}
+
+func TestQueryInfluxSQLNonFiniteFloats(t *testing.T) {
+	inf := math.Inf(1)
+	frame := data.NewFrame("",
+		data.NewField("host", nil, []string{"web1", "web2", "web3", "web4"}),
+		data.NewField("usage", nil, []float64{math.NaN(), math.Inf(1), math.Inf(-1), 0.5}),
+		data.NewField("load", nil, []*float64{&inf, nil, nil, nil}),
+	)
+	query := func(t *testing.T, cfg InfluxDBConfig, args QueryInfluxSQLParams) string {
+		ctx := newTestGrafanaContext(t, respondWith(arrowResponse(t, frame)))
+		args.DatasourceUID, args.SQL = "influx", "SELECT * FROM cpu LIMIT 10"
+		out, err := queryInfluxSQL(WithInfluxDBConfig(ctx, cfg), args)
+		require.NoError(t, err)
+		b, err := json.Marshal(out)
+		require.NoError(t, err, "the result must always marshal")
+		return string(b)
+	}
+
+	t.Run("null by default", func(t *testing.T) {
+		for _, policy := range []string{"", typePolicyNative} {
+			got := query(t, InfluxDBConfig{}, QueryInfluxSQLParams{TypePolicy: policy})
+			assert.JSONEq(t, `[
+				{"host": "web1", "usage": null, "load": null},
+				{"host": "web2", "usage": null, "load": null},
+				{"host": "web3", "usage": null, "load": null},
+				{"host": "web4", "usage": 0.5, "load": null}
+			]`, got, "typePolicy %q", policy)
+		}
+	})
+
+	t.Run("sentinel", func(t *testing.T) {
+		got := query(t, InfluxDBConfig{}, QueryInfluxSQLParams{NonFiniteValue: "NaN", Format: "compact"})
+		assert.JSONEq(t, `{"cols": ["host", "usage", "load"], "rows": [
+			["web1", "NaN", "NaN"], ["web2", "NaN", null], ["web3", "NaN", null], ["web4", 0.5, null]
+		]}`, got)
+	})
+
+	t.Run("server setting", func(t *testing.T) {
+		got := query(t, InfluxDBConfig{NonFiniteValue: "n/a"}, QueryInfluxSQLParams{})
+		assert.Contains(t, got, `"usage":"n/a"`)
+		got = query(t, InfluxDBConfig{NonFiniteValue: "n/a"}, QueryInfluxSQLParams{NonFiniteValue: "null"})
+		assert.NotContains(t, got, "n/a", "null overrides the server's sentinel")
+	})
+}
+
+func TestDecodeDSQueryResponse(t *testing.T) {
+	t.Run("arrow zstd frame", func(t *testing.T) {
+		rows, err := DecodeDSQueryResponse(readFixture(t, "arrow_zstd.json"))
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+		assert.Equal(t, "web1", rows[0]["host"])
+		ts, ok := rows[0]["time"].(time.Time)
+		require.True(t, ok)
+		assert.True(t, time.UnixMilli(1714564800000).Equal(ts))
+		usage, ok := rows[0]["usage"].(*float64)
+		require.True(t, ok)
+		assert.Equal(t, 0.5, *usage)
+		assert.Nil(t, rows[1]["usage"])
+	})
+
+	t.Run("arrow lz4 frame", func(t *testing.T) {
+		lz4Rows, err := DecodeDSQueryResponse(readFixture(t, "lz4.json"))
+		require.NoError(t, err)
+		zstdRows, err := DecodeDSQueryResponse(readFixture(t, "arrow_zstd.json"))
+		require.NoError(t, err)
+		assert.Equal(t, zstdRows, lz4Rows)
+	})
+
+	t.Run("array of arrow frames", func(t *testing.T) {
+		rows, err := DecodeDSQueryResponse(readFixture(t, "arrow_array.json"))
+		require.NoError(t, err)
+		zstdRows, err := DecodeDSQueryResponse(readFixture(t, "arrow_zstd.json"))
+		require.NoError(t, err)
+		assert.Equal(t, append(zstdRows, zstdRows...), rows, "both elements of the array are decoded")
+	})
+
+	t.Run("values frame", func(t *testing.T) {
+		rows, err := DecodeDSQueryResponse(readFixture(t, "values.json"))
+		require.NoError(t, err)
+		assert.Equal(t, []map[string]any{
+			{"time": 1714564800000.0, "host": "web1", "usage": 0.5},
+			{"time": 1714564860000.0, "host": "web2", "usage": nil},
+		}, rows)
+	})
+
+	t.Run("no frames", func(t *testing.T) {
+		rows, err := DecodeDSQueryResponse(readFixture(t, "empty.json"))
+		require.NoError(t, err)
+		assert.Empty(t, rows)
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		_, err := DecodeDSQueryResponse(readFixture(t, "query_error.json"))
+		var qe *InfluxQueryError
+		require.ErrorAs(t, err, &qe)
+		assert.Equal(t, QueryErrorBadRequest, qe.Category)
+		assert.ErrorContains(t, err, "table 'iox.nope' not found")
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		_, err := DecodeDSQueryResponse([]byte("<html>"))
+		assert.ErrorContains(t, err, "decode response JSON")
+	})
+}
+
+func TestDecodeFrames(t *testing.T) {
+	t.Run("arrow frame", func(t *testing.T) {
+		res, err := decodeFrames([]frameEnvelope{{Data: json.RawMessage(`"` + arrowFrameData(t, cpuFrame()) + `"`)}}, decodeOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"host", "usage"}, res.Columns)
+		assert.Equal(t, []map[string]any{{"host": "web1", "usage": 0.5}, {"host": "web2", "usage": 0.75}}, res.Rows)
+	})
+
+	t.Run("array of arrow frames", func(t *testing.T) {
+		batch := data.NewFrame("", data.NewField("host", nil, []string{"web3"}), data.NewField("usage", nil, []float64{1}))
+		batch.Fields[0].Labels = data.Labels{"region": "eu"}
+		parts, err := json.Marshal([]string{arrowFrameData(t, cpuFrame()), arrowFrameData(t, batch)})
+		require.NoError(t, err)
+		frames := []frameEnvelope{
+			{Data: parts},
+			{Data: json.RawMessage(`null`)},
+			{Data: json.RawMessage(`"` + arrowFrameData(t, data.NewFrame("", data.NewField("n", nil, []int64{7}))) + `"`)},
+		}
+		res, err := decodeFrames(frames, decodeOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []map[string]any{{"host": "web1", "usage": 0.5}, {"host": "web2", "usage": 0.75}, {"host": "web3", "usage": 1.0}}, res.Rows,
+			"the elements of an array are one frame")
+		assert.Equal(t, map[string]map[string]string{"host": {"region": "eu"}}, res.Labels)
+		assert.Equal(t, 3, res.Frame.Rows())
+		res, err = decodeFrames(frames, decodeOptions{Columnar: true})
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]any{"host": {"web1", "web2", "web3"}, "usage": {0.5, 0.75, 1.0}}, res.Data)
+
+		res, err = decodeFrames(frames, decodeOptions{FrameIndex: 1})
+		require.NoError(t, err)
+		assert.Empty(t, res.Rows, "a null frame has no rows")
+		res, err = decodeFrames(frames, decodeOptions{FrameIndex: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []map[string]any{{"n": int64(7)}}, res.Rows, "the null frame does not shift later frames")
+		_, err = decodeFrames(frames, decodeOptions{FrameIndex: 3})
+		assert.ErrorContains(t, err, "the result has 3 frames")
+
+		parts, err = json.Marshal([]string{arrowFrameData(t, cpuFrame()), arrowFrameData(t, data.NewFrame("", data.NewField("n", nil, []int64{7})))})
+		require.NoError(t, err)
+		_, err = decodeFrames([]frameEnvelope{{Data: parts}}, decodeOptions{})
+		assert.ErrorContains(t, err, "frame array element 1")
+		assert.ErrorContains(t, err, "columns [n] differ from the columns [host usage] of the first element")
+		res, err = decodeFrames([]frameEnvelope{{Data: json.RawMessage(`[]`)}}, decodeOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, res.Rows)
+	})
+
+	t.Run("values frame without schema names", func(t *testing.T) {
+		res, err := decodeFrames([]frameEnvelope{{Data: json.RawMessage(`{"values":[[1],["a"]]}`)}}, decodeOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"col0", "col1"}, res.Columns)
+		assert.Equal(t, []map[string]any{{"col0": 1.0, "col1": "a"}}, res.Rows)
+	})
+
+	t.Run("no frames", func(t *testing.T) {
+		res, err := decodeFrames(nil, decodeOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, res.Rows)
+	})
+
+	for name, payload := range map[string]string{
+		"invalid base64":    `"not base64!"`,
+		"invalid zstd":      `"` + base64.StdEncoding.EncodeToString([]byte("plain")) + `"`,
+		"unknown structure": `[1, 2, 3]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeFrames([]frameEnvelope{{Data: json.RawMessage(payload)}}, decodeOptions{})
+			var qe *InfluxQueryError
+			require.ErrorAs(t, err, &qe)
+			assert.Equal(t, QueryErrorDecode, qe.Category)
+		})
+	}
+}
+
+func TestDecodeFramesDebugDump(t *testing.T) {
+	frame := []frameEnvelope{{Data: json.RawMessage(`[1, 2, 3]`)}}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Setenv(influxdbDecodeDebugEnvVar, "")
+		_, err := decodeFrames(frame, decodeOptions{})
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "frame data")
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		t.Setenv(influxdbDecodeDebugEnvVar, "1")
+		_, err := decodeFrames(frame, decodeOptions{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), base64.StdEncoding.EncodeToString([]byte(`[1, 2, 3]`)))
+
+		var qe *InfluxQueryError
+		require.ErrorAs(t, err, &qe)
+		assert.Equal(t, QueryErrorDecode, qe.Category)
+	})
+
+	t.Run("dump is capped", func(t *testing.T) {
+		dump := debugDump([]byte(strings.Repeat("x", 10*maxDebugDumpBytes)))
+		assert.Contains(t, dump, "(2560 bytes total)")
+		assert.Less(t, len(dump), 2*maxDebugDumpBytes)
+	})
+}
+
+func TestDecodeTraceFrame(t *testing.T) {
+	rows, err := DecodeDSQueryResponse(readFixture(t, "trace.json"))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "s1", rows[1]["parentSpanID"])
+	assert.Equal(t, []any{map[string]any{"key": "http.status_code", "value": 200.0}}, rows[0]["tags"])
+	assert.Nil(t, rows[0]["logs"])
+	assert.Equal(t, []any{map[string]any{
+		"timestamp": 1714564800006.0,
+		"fields":    []any{map[string]any{"key": "event", "value": "query"}},
+	}}, rows[1]["logs"])
+}
+
+func TestInfluxdbQueryFrameFormat(t *testing.T) {
+	var format string
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		format = decodePayload(t, r).Queries[0].Format
+		_, _ = w.Write(readFixture(t, "trace.json"))
+	})
+	_, err := cli.query(context.Background(), "SELECT 1", queryOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "table", format)
+	_, err = cli.query(context.Background(), "SELECT 1", queryOptions{FrameFormat: "trace"})
+	require.NoError(t, err)
+	assert.Equal(t, "trace", format)
+}
+
+func TestFrameToResultLabels(t *testing.T) {
+	res, err := decodeDSQueryResponse(readFixture(t, "labeled.json"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"time", "usage {host=web1}", "usage {host=web2}"}, res.Columns)
+	assert.Equal(t, 0.7, res.Rows[0]["usage {host=web2}"])
+	assert.Equal(t, map[string]map[string]string{
+		"usage {host=web1}": {"host": "web1"},
+		"usage {host=web2}": {"host": "web2"},
+	}, res.Labels)
+
+	t.Run("unique names keep their name", func(t *testing.T) {
+		frame := data.NewFrame("cpu",
+			data.NewField("usage", data.Labels{"host": "web1"}, []float64{1}),
+			data.NewField("load", nil, []float64{2}),
+		)
+		res := frameToResult(frame)
+		assert.Equal(t, []string{"usage", "load"}, res.Columns)
+		assert.Equal(t, map[string]map[string]string{"usage": {"host": "web1"}}, res.Labels)
+	})
+}
+
+func TestDecodeFrameEncoding(t *testing.T) {
+	arrowBytes, err := cpuFrame().MarshalArrow()
+	require.NoError(t, err)
+	zstdBytes, err := zstd.Compress(nil, arrowBytes)
+	require.NoError(t, err)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err = zw.Write(arrowBytes)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	var lz bytes.Buffer
+	lw := lz4.NewWriter(&lz)
+	_, err = lw.Write(arrowBytes)
+	require.NoError(t, err)
+	require.NoError(t, lw.Close())
+
+	frame := func(b []byte) []frameEnvelope {
+		return []frameEnvelope{{Data: json.RawMessage(`"` + base64.StdEncoding.EncodeToString(b) + `"`)}}
+	}
+	for _, tc := range []struct {
+		name, encoding string
+		payload        []byte
+	}{
+		{"auto zstd", "auto", zstdBytes},
+		{"auto gzip", "", gz.Bytes()},
+		{"auto lz4", "auto", lz.Bytes()},
+		{"auto none", "auto", arrowBytes},
+		{"forced zstd", "zstd", zstdBytes},
+		{"forced gzip", "gzip", gz.Bytes()},
+		{"forced lz4", "lz4", lz.Bytes()},
+		{"forced none", "none", arrowBytes},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := decodeFrames(frame(tc.payload), decodeOptions{FrameEncoding: tc.encoding})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"host", "usage"}, res.Columns)
+			assert.Len(t, res.Rows, 2)
+		})
+	}
+
+	t.Run("forced encoding mismatch", func(t *testing.T) {
+		_, err := decodeFrames(frame(arrowBytes), decodeOptions{FrameEncoding: "zstd"})
+		assert.ErrorIs(t, err, errCorruptFrame)
+		_, err = decodeFrames(frame(zstdBytes), decodeOptions{FrameEncoding: "gzip"})
+		assert.ErrorIs(t, err, errCorruptFrame)
+		_, err = decodeFrames(frame(gz.Bytes()), decodeOptions{FrameEncoding: "lz4"})
+		assert.ErrorIs(t, err, errCorruptFrame)
+	})
+}
+
+func TestDecodeMaxColumns(t *testing.T) {
+	fields := make([]*data.Field, 500)
+	for i := range fields {
+		fields[i] = data.NewField(fmt.Sprintf("field_%03d", i), nil, []int64{int64(i), int64(i) * 2})
+	}
+	wide := data.NewFrame("", fields...)
+
+	t.Run("rows", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(arrowResponse(t, wide))
+		})
+		cli.maxColumns = 100
+		res, err := cli.query(context.Background(), "SELECT * FROM wide", queryOptions{})
+		require.NoError(t, err)
+		assert.Len(t, res.Columns, 100)
+		assert.Equal(t, "field_099", res.Columns[99])
+		assert.Equal(t, 400, res.ColumnsOmitted)
+		require.Len(t, res.Rows, 2)
+		assert.Len(t, res.Rows[1], 100)
+		assert.Equal(t, int64(198), res.Rows[1]["field_099"])
+
+		// The per-query limit overrides the client's default.
+		res, err = cli.query(context.Background(), "SELECT * FROM wide", queryOptions{MaxColumns: 600})
+		require.NoError(t, err)
+		assert.Len(t, res.Columns, 500)
+		assert.Zero(t, res.ColumnsOmitted)
+
+		_, err = cli.query(context.Background(), "SELECT * FROM wide", queryOptions{StrictColumns: true})
+		require.ErrorIs(t, err, errTooManyColumns)
+		assert.ErrorContains(t, err, "the result has 500 columns, more than the limit of 100")
+	})
+
+	t.Run("columnar", func(t *testing.T) {
+		res, err := decodeFrames([]frameEnvelope{{Data: json.RawMessage(`"` + arrowFrameData(t, wide) + `"`)}}, decodeOptions{Columnar: true, MaxColumns: 10})
+		require.NoError(t, err)
+		assert.Len(t, res.Data, 10)
+		assert.Equal(t, 490, res.ColumnsOmitted)
+	})
+
+	t.Run("values encoding", func(t *testing.T) {
+		env := frameEnvelope{
+			Schema: map[string]any{"fields": []any{
+				map[string]any{"name": "a"}, map[string]any{"name": "b"}, map[string]any{"name": "c"},
+			}},
+			Data: json.RawMessage(`{"values":[[1],[2],[3]]}`),
+		}
+		res, err := decodeFrames([]frameEnvelope{env}, decodeOptions{MaxColumns: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, res.Columns)
+		assert.Equal(t, []map[string]any{{"a": float64(1), "b": float64(2)}}, res.Rows)
+		assert.Equal(t, 1, res.ColumnsOmitted)
+	})
+}
+
+func TestInfluxdbQueryPreferJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		preferJSON bool
+		fixture    string
+		encoding   string
+	}{
+		{"json frames", true, "values.json", "json"},
+		{"falls back to arrow", true, "arrow_zstd.json", "json"},
+		{"default", false, "arrow_zstd.json", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var encoding string
+			cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+				encoding = decodePayload(t, r).Queries[0].ResultEncoding
+				_, _ = w.Write(readFixture(t, tc.fixture))
+			})
+			res, err := cli.query(context.Background(), "SELECT * FROM cpu", queryOptions{PreferJSON: tc.preferJSON})
+			require.NoError(t, err)
+			assert.Equal(t, tc.encoding, encoding)
+			assert.NotEmpty(t, res.Rows)
+		})
+	}
+}
+
+func TestDecodeMaxCellBytes(t *testing.T) {
+	long := strings.Repeat("a", 20)
+	accented := "héllo wörld"
+	blob := json.RawMessage([]byte{0x00, 0xff, 0x10, 0x80, 0x01})
+	doc := json.RawMessage(`{"k":"v"}`)
+	frame := data.NewFrame("",
+		data.NewField("short", nil, []string{"ok", "fine"}),
+		data.NewField("long", nil, []*string{&long, nil}),
+		data.NewField("accented", nil, []string{accented, "x"}),
+		data.NewField("blob", nil, []json.RawMessage{blob, doc}),
+	)
+	body := arrowResponse(t, frame)
+
+	res, err := decodeDSQueryResults(body, []string{"A"}, decodeOptions{MaxCellBytes: 8})
+	require.NoError(t, err)
+	row := res[0].Rows[0]
+	assert.Equal(t, "ok", row["short"])
+	assert.Equal(t, "aaaaaaaa…(truncated)", row["long"])
+	assert.Equal(t, "héllo w…(truncated)", row["accented"], "multi-byte characters are not split")
+	assert.Equal(t, "<binary, 5 bytes>", row["blob"])
+	assert.Equal(t, map[string]any{"k": "v"}, res[0].Rows[1]["blob"], "JSON values are kept")
+	assert.Nil(t, res[0].Rows[1]["long"])
+	assert.Equal(t, 3, res[0].CellsTruncated)
+
+	t.Run("columnar", func(t *testing.T) {
+		res, err := decodeDSQueryResults(body, []string{"A"}, decodeOptions{MaxCellBytes: 8, Columnar: true})
+		require.NoError(t, err)
+		assert.Equal(t, []any{"aaaaaaaa…(truncated)", (*string)(nil)}, res[0].Data["long"])
+		assert.Equal(t, 3, res[0].CellsTruncated)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		res, err := decodeDSQueryResults(body, []string{"A"}, decodeOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 0, res[0].CellsTruncated)
+		assert.Equal(t, &long, res[0].Rows[0]["long"])
+	})
+
+	t.Run("envelope", func(t *testing.T) {
+		res, err := decodeDSQueryResults(body, []string{"A"}, decodeOptions{MaxCellBytes: 8})
+		require.NoError(t, err)
+		out, err := formatResult(res[0], QueryInfluxSQLParams{MaxCellBytes: 8})
+		require.NoError(t, err)
+		assert.Equal(t, 3, out.(queryResponse).CellsTruncated)
+	})
+}
+
+func TestDecodeNestedArrow(t *testing.T) {
+	t.Run("list", func(t *testing.T) {
+		body := readFixture(t, "nested_list.json")
+		_, err := decodeDSQueryResults(body, []string{"A"}, decodeOptions{})
+		require.ErrorContains(t, err, "set nested")
+		assert.NotErrorIs(t, err, errCorruptFrame)
+
+		res, err := decodeDSQueryResults(body, []string{"A"}, decodeOptions{Nested: true})
+		require.NoError(t, err)
+		rows := res[0].Rows
+		require.Len(t, rows, 3)
+		assert.Equal(t, []string{"time", "host", "samples"}, res[0].Columns)
+		assert.Equal(t, "web1", rows[0]["host"])
+		assert.True(t, time.UnixMilli(1714564800000).Equal(rows[0]["time"].(time.Time)))
+		assert.Equal(t, []any{1.5, 2.5}, rows[0]["samples"])
+		assert.Equal(t, []any{nil, 3.0}, rows[1]["samples"])
+		assert.Nil(t, rows[2]["samples"])
+	})
+
+	t.Run("struct", func(t *testing.T) {
+		res, err := decodeDSQueryResults(readFixture(t, "nested_struct.json"), []string{"A"}, decodeOptions{Nested: true})
+		require.NoError(t, err)
+		rows := res[0].Rows
+		require.Len(t, rows, 2)
+		assert.Equal(t, map[string]any{
+			"host":  "web1",
+			"cores": int64(8),
+			"disk":  map[string]any{"used": 0.75},
+			"tags":  []any{"prod", "eu"},
+		}, rows[0]["meta"])
+		assert.Nil(t, rows[1]["meta"])
+		assert.Equal(t, map[string]any{"errors": int64(2), "requests": int64(40)}, rows[0]["counts"])
+		assert.Equal(t, map[string]any{}, rows[1]["counts"])
+
+		require.NoError(t, applyTypePolicy(rows, typePolicyJSONSafe))
+		b, err := json.Marshal(rows[0])
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"time":"2024-05-01T12:00:00Z","meta":{"host":"web1","cores":8,"disk":{"used":0.75},"tags":["prod","eu"]},"counts":{"errors":2,"requests":40}}`, string(b))
+	})
+
+	t.Run("columnar", func(t *testing.T) {
+		res, err := decodeDSQueryResults(readFixture(t, "nested_list.json"), []string{"A"}, decodeOptions{Nested: true, Columnar: true, MaxColumns: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []any{"web1", "web2", "web3"}, res[0].Data["host"])
+		assert.Equal(t, 1, res[0].ColumnsOmitted)
+	})
+
+	t.Run("flat frames", func(t *testing.T) {
+		flat, err := decodeDSQueryResults(readFixture(t, "arrow_zstd.json"), []string{"A"}, decodeOptions{})
+		require.NoError(t, err)
+		nested, err := decodeDSQueryResults(readFixture(t, "arrow_zstd.json"), []string{"A"}, decodeOptions{Nested: true})
+		require.NoError(t, err)
+		assert.Equal(t, flat[0].Columns, nested[0].Columns)
+		assert.Len(t, nested[0].Rows, len(flat[0].Rows))
+	})
+}
+
+// batchedResponse builds a ds/query response whose single result has one
+// frame, sent as an array of one record batch per frame given.
+func batchedResponse(t testing.TB, batches ...*data.Frame) []byte {
+	t.Helper()
+	parts := make([]string, len(batches))
+	for i, batch := range batches {
+		parts[i] = arrowFrameData(t, batch)
+	}
+	b, err := json.Marshal(map[string]any{"results": map[string]any{
+		"A": map[string]any{"status": 200, "frames": []map[string]any{{"schema": map[string]any{}, "data": parts}}},
+	}})
+	require.NoError(t, err)
+	return b
+}
+
+func TestDecodeFrameBatchesConcurrency(t *testing.T) {
+	batches := make([]*data.Frame, 12)
+	for i := range batches {
+		batches[i] = data.NewFrame("", data.NewField("n", nil, []int64{int64(2 * i), int64(2*i + 1)}))
+	}
+	raw := batchedResponse(t, batches...)
+
+	for _, concurrency := range []int{0, 1, 4, 100} {
+		results, err := decodeDSQueryResults(raw, []string{"A"}, decodeOptions{Concurrency: concurrency})
+		require.NoError(t, err)
+		require.Len(t, results[0].Rows, 2*len(batches))
+		for i, row := range results[0].Rows {
+			assert.Equal(t, int64(i), row["n"], "rows keep the order of the batches with concurrency %d", concurrency)
+		}
+	}
+
+	parts := make([]string, len(batches))
+	for i, batch := range batches {
+		parts[i] = arrowFrameData(t, batch)
+	}
+	parts[5] = base64.StdEncoding.EncodeToString([]byte("not zstd"))
+	corrupt, err := json.Marshal(parts)
+	require.NoError(t, err)
+	_, err = decodeFrames([]frameEnvelope{{Data: corrupt}}, decodeOptions{Concurrency: 4})
+	require.ErrorIs(t, err, errCorruptFrame)
+	assert.ErrorContains(t, err, "frame array element 5")
+
+	assert.Equal(t, 1, decodeOptions{Concurrency: 8}.concurrency(1))
+	assert.Equal(t, 3, decodeOptions{Concurrency: 3}.concurrency(10))
+	assert.Equal(t, min(runtime.GOMAXPROCS(0), 10), decodeOptions{}.concurrency(10))
+}
+
+func TestRefIDs(t *testing.T) {
+	ids := refIDs(30)
+	assert.Equal(t, []string{"A", "B"}, ids[:2])
+	assert.Equal(t, []string{"Z", "AA", "AB", "AC", "AD"}, ids[25:])
+	assert.Equal(t, "BA", refIDs(53)[52])
+	assert.Equal(t, "AAA", refIDs(703)[702])
+}
+
+// BenchmarkDecodeFrameBatches decodes a result of one frame split into 32
+// record batches of 5000 rows each one batch at a time and with the default
+// concurrency.
+func BenchmarkDecodeFrameBatches(b *testing.B) {
+	hosts := make([]string, 5000)
+	usage := make([]float64, len(hosts))
+	for i := range hosts {
+		hosts[i], usage[i] = fmt.Sprintf("web%d", i%50), float64(i)/10
+	}
+	batches := make([]*data.Frame, 32)
+	for i := range batches {
+		batches[i] = data.NewFrame("", data.NewField("host", nil, hosts), data.NewField("usage", nil, usage))
+	}
+	raw := batchedResponse(b, batches...)
+
+	for _, bc := range []struct {
+		name        string
+		concurrency int
+	}{{"serial", 1}, {"gomaxprocs", 0}} {
+		b.Run(bc.name, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := decodeDSQueryResults(raw, []string{"A"}, decodeOptions{Concurrency: bc.concurrency}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+const (
+	// DefaultInfluxDiffLimit is the default number of rows returned in each
+	// of onlyA, onlyB and changed.
+	DefaultInfluxDiffLimit = 100
+	// MaxInfluxDiffLimit is the maximum number of rows returned in each.
+	MaxInfluxDiffLimit = 1000
+)
+
+type DiffInfluxDBResultsParams struct {
+	DatasourceUID string   `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	SQLA          string   `json:"sqlA" jsonschema:"required,description=SQL giving result A"`
+	SQLB          string   `json:"sqlB,omitempty" jsonschema:"description=SQL giving result B. Defaults to sqlA\\, to compare one query over two time ranges"`
+	KeyColumns    []string `json:"keyColumns" jsonschema:"required,description=Columns identifying a row\\, e.g. [host] or [time\\, host]. Rows of A and B with the same key values are compared; both results must have these columns"`
+	FromA         string   `json:"fromA,omitempty" jsonschema:"description=Start of the time range of A as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Defaults to one hour before 'toA'"`
+	ToA           string   `json:"toA,omitempty" jsonschema:"description=End of the time range of A. Defaults to now"`
+	FromB         string   `json:"fromB,omitempty" jsonschema:"description=Start of the time range of B. Defaults to fromA"`
+	ToB           string   `json:"toB,omitempty" jsonschema:"description=End of the time range of B. Defaults to toA"`
+	Limit         int      `json:"limit,omitempty" jsonschema:"description=Maximum number of rows returned in each of onlyA\\, onlyB and changed. Defaults to 100\\, maximum 1000; counts covers every row"`
+}
+
+type valueChange struct {
+	Column string `json:"column"`
+	A      any    `json:"a"`
+	B      any    `json:"b"`
+}
+
+type changedRow struct {
+	Key     orderedRow    `json:"key"`
+	Changes []valueChange `json:"changes"`
+}
+
+type duplicateKey struct {
+	// Side is "a" or "b".
+	Side  string     `json:"side"`
+	Key   orderedRow `json:"key"`
+	Count int        `json:"count"`
+}
+
+type diffCounts struct {
+	RowsA     int `json:"rowsA"`
+	RowsB     int `json:"rowsB"`
+	OnlyA     int `json:"onlyA"`
+	OnlyB     int `json:"onlyB"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+}
+
+type resultDiff struct {
+	KeyColumns []string     `json:"keyColumns"`
+	OnlyA      []orderedRow `json:"onlyA"`
+	OnlyB      []orderedRow `json:"onlyB"`
+	Changed    []changedRow `json:"changed"`
+	Counts     diffCounts   `json:"counts"`
+	// DuplicateKeys are the keys held by more than one row of a result. Only
+	// the first such row is compared.
+	DuplicateKeys []duplicateKey `json:"duplicateKeys,omitempty"`
+	// Truncated is set if limit cut off onlyA, onlyB or changed.
+	Truncated bool `json:"truncated"`
+}
+
+// keyedRows indexes the rows of a result by the values of the key columns,
+// keeping the first row of each key in order, and reports the keys held by
+// more than one row.
+type keyedRows struct {
+	keys  []string
+	rows  map[string]map[string]any
+	dupes map[string]int
+}
+
+// diffKey joins the formatted values of the key columns of row.
+func diffKey(row map[string]any, keyColumns []string) string {
+	parts := make([]string, len(keyColumns))
+	for i, c := range keyColumns {
+		parts[i] = formatCell(row[c])
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func indexRows(res *queryResult, keyColumns []string) (*keyedRows, error) {
+	for _, c := range keyColumns {
+		if !slices.Contains(res.Columns, c) {
+			return nil, fmt.Errorf("key column %q not found in result; it has %s", c, strings.Join(res.Columns, ", "))
+		}
+	}
+	out := &keyedRows{rows: make(map[string]map[string]any, len(res.Rows)), dupes: map[string]int{}}
+	for _, row := range res.Rows {
+		k := diffKey(row, keyColumns)
+		if _, ok := out.rows[k]; ok {
+			out.dupes[k]++
+			continue
+		}
+		out.keys = append(out.keys, k)
+		out.rows[k] = row
+	}
+	return out, nil
+}
+
+// diffResults compares the rows of a and b by their key columns. Values are
+// compared as they are formatted, so that e.g. an integer and a float of the
+// same value are equal; a column missing from one side counts as null.
+// onlyA and changed are in the order of a, onlyB in the order of b.
+func diffResults(a, b *queryResult, keyColumns []string, limit int) (*resultDiff, error) {
+	ia, err := indexRows(a, keyColumns)
+	if err != nil {
+		return nil, fmt.Errorf("result A: %w", err)
+	}
+	ib, err := indexRows(b, keyColumns)
+	if err != nil {
+		return nil, fmt.Errorf("result B: %w", err)
+	}
+	var valueColumns []string
+	for _, c := range append(slices.Clone(a.Columns), b.Columns...) {
+		if !slices.Contains(keyColumns, c) && !slices.Contains(valueColumns, c) {
+			valueColumns = append(valueColumns, c)
+		}
+	}
+	keyRow := func(row map[string]any) orderedRow {
+		values := make(map[string]any, len(keyColumns))
+		for _, c := range keyColumns {
+			values[c] = row[c]
+		}
+		return orderedRow{columns: keyColumns, values: values}
+	}
+
+	out := &resultDiff{
+		KeyColumns: keyColumns,
+		OnlyA:      []orderedRow{},
+		OnlyB:      []orderedRow{},
+		Changed:    []changedRow{},
+		Counts:     diffCounts{RowsA: len(a.Rows), RowsB: len(b.Rows)},
+	}
+	for _, k := range ia.keys {
+		rowA := ia.rows[k]
+		rowB, ok := ib.rows[k]
+		if !ok {
+			out.Counts.OnlyA++
+			if len(out.OnlyA) < limit {
+				out.OnlyA = append(out.OnlyA, orderedRow{columns: rowColumns(a.Columns, rowA), values: rowA})
+			}
+			continue
+		}
+		var changes []valueChange
+		for _, c := range valueColumns {
+			va, vb := rowA[c], rowB[c]
+			if (va == nil) != (vb == nil) || formatCell(va) != formatCell(vb) {
+				changes = append(changes, valueChange{Column: c, A: va, B: vb})
+			}
+		}
+		if len(changes) == 0 {
+			out.Counts.Unchanged++
+			continue
+		}
+		out.Counts.Changed++
+		if len(out.Changed) < limit {
+			out.Changed = append(out.Changed, changedRow{Key: keyRow(rowA), Changes: changes})
+		}
+	}
+	for _, k := range ib.keys {
+		if _, ok := ia.rows[k]; !ok {
+			out.Counts.OnlyB++
+			if len(out.OnlyB) < limit {
+				out.OnlyB = append(out.OnlyB, orderedRow{columns: rowColumns(b.Columns, ib.rows[k]), values: ib.rows[k]})
+			}
+		}
+	}
+	for _, side := range []struct {
+		name string
+		idx  *keyedRows
+	}{{"a", ia}, {"b", ib}} {
+		for _, k := range side.idx.keys {
+			if n := side.idx.dupes[k]; n > 0 {
+				out.DuplicateKeys = append(out.DuplicateKeys, duplicateKey{Side: side.name, Key: keyRow(side.idx.rows[k]), Count: n + 1})
+			}
+		}
+	}
+	out.Truncated = out.Counts.OnlyA > len(out.OnlyA) || out.Counts.OnlyB > len(out.OnlyB) || out.Counts.Changed > len(out.Changed)
+	return out, nil
+}
+
+// diffQueries runs the two queries concurrently and diffs their results.
+func (c *influxdbClient) diffQueries(ctx context.Context, sqlA, sqlB string, optsA, optsB queryOptions, keyColumns []string, limit int) (*resultDiff, error) {
+	if len(keyColumns) == 0 {
+		return nil, fmt.Errorf("keyColumns must name at least one column")
+	}
+	if limit <= 0 {
+		limit = DefaultInfluxDiffLimit
+	}
+	limit = min(limit, MaxInfluxDiffLimit)
+
+	var (
+		wg      sync.WaitGroup
+		results [2]*queryResult
+		errs    [2]error
+	)
+	for i, q := range []struct {
+		sql  string
+		opts queryOptions
+	}{{sqlA, optsA}, {sqlB, optsB}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = c.query(ctx, q.sql, q.opts)
+		}()
+	}
+	wg.Wait()
+	for i, name := range []string{"A", "B"} {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("query %s: %w", name, errs[i])
+		}
+		if err := applyTypePolicy(results[i].Rows, typePolicyJSONSafe); err != nil {
+			return nil, err
+		}
+	}
+	return diffResults(results[0], results[1], keyColumns, limit)
+}
+
+func diffInfluxDBResults(ctx context.Context, args DiffInfluxDBResultsParams) (*resultDiff, error) {
+	sqlB := args.SQLB
+	if sqlB == "" {
+		sqlB = args.SQLA
+	}
+	fromB, toB := args.FromB, args.ToB
+	if fromB == "" && toB == "" {
+		fromB, toB = args.FromA, args.ToA
+	}
+	now := time.Now()
+	var optsA, optsB queryOptions
+	var err error
+	if optsA.From, optsA.To, err = resolveInfluxTimeRange(args.FromA, args.ToA, now); err != nil {
+		return nil, fmt.Errorf("range A: %w", err)
+	}
+	if optsB.From, optsB.To, err = resolveInfluxTimeRange(fromB, toB, now); err != nil {
+		return nil, fmt.Errorf("range B: %w", err)
+	}
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	return cli.diffQueries(ctx, args.SQLA, sqlB, optsA, optsB, args.KeyColumns, args.Limit)
+}
+
+var DiffInfluxDBResults = mcpgrafana.MustTool(
+	"diff_influxdb_results",
+	"InfluxDB v3 datasource: Runs two SQL queries, or one query over two time ranges, and diffs the results row by row on key columns, for regression checks such as verifying a migration or detecting drift. Returns {keyColumns, onlyA, onlyB, changed: [{key, changes: [{column, a, b}]}], counts, duplicateKeys, truncated}: the rows whose key is only in A or only in B, and for rows in both the columns whose values differ. Keys held by several rows of a result are listed in duplicateKeys and only their first row is compared.",
+	diffInfluxDBResults,
+)
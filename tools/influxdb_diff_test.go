@@ -0,0 +1,68 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbDiffQueries(t *testing.T) {
+	frames := map[string]*data.Frame{
+		"SELECT * FROM old": data.NewFrame("",
+			data.NewField("host", nil, []string{"web1", "web2", "web3", "web3"}),
+			data.NewField("usage", nil, []float64{0.5, 0.25, 1, 2}),
+			data.NewField("region", nil, []string{"eu", "eu", "us", "us"}),
+		),
+		"SELECT * FROM new": data.NewFrame("",
+			data.NewField("host", nil, []string{"web1", "web2", "web4"}),
+			data.NewField("usage", nil, []float64{0.5, 0.75, 3}),
+			data.NewField("region", nil, []string{"eu", "eu", "us"}),
+		),
+		"SELECT * FROM empty": data.NewFrame("",
+			data.NewField("host", nil, []string{}),
+		),
+	}
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(arrowResponse(t, frames[decodePayload(t, r).Queries[0].RawSQL]))
+	})
+
+	out, err := cli.diffQueries(context.Background(), "SELECT * FROM old", "SELECT * FROM new", queryOptions{}, queryOptions{}, []string{"host"}, 0)
+	require.NoError(t, err)
+	b, err := json.Marshal(out)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"keyColumns": ["host"],
+		"onlyA": [{"host": "web3", "usage": 1, "region": "us"}],
+		"onlyB": [{"host": "web4", "usage": 3, "region": "us"}],
+		"changed": [{"key": {"host": "web2"}, "changes": [{"column": "usage", "a": 0.25, "b": 0.75}]}],
+		"counts": {"rowsA": 4, "rowsB": 3, "onlyA": 1, "onlyB": 1, "changed": 1, "unchanged": 1},
+		"duplicateKeys": [{"side": "a", "key": {"host": "web3"}, "count": 2}],
+		"truncated": false
+	}`, string(b))
+
+	t.Run("limit", func(t *testing.T) {
+		out, err := cli.diffQueries(context.Background(), "SELECT * FROM old", "SELECT * FROM empty", queryOptions{}, queryOptions{}, []string{"host"}, 2)
+		require.NoError(t, err)
+		assert.Len(t, out.OnlyA, 2)
+		assert.Equal(t, 3, out.Counts.OnlyA)
+		assert.True(t, out.Truncated)
+	})
+
+	t.Run("missing key column", func(t *testing.T) {
+		_, err := cli.diffQueries(context.Background(), "SELECT * FROM old", "SELECT * FROM new", queryOptions{}, queryOptions{}, []string{"dc"}, 0)
+		assert.ErrorContains(t, err, `result A: key column "dc" not found`)
+	})
+
+	t.Run("no key columns", func(t *testing.T) {
+		_, err := cli.diffQueries(context.Background(), "SELECT * FROM old", "", queryOptions{}, queryOptions{}, nil, 0)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,179 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// defaultDriftSlices is the number of slices the window is divided into when
+// no slice duration is given; the first and last are compared.
+const defaultDriftSlices = 10
+
+type InfluxDBDetectNewColumnsParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table         string `json:"table" jsonschema:"required,description=Table (measurement) to check"`
+	Database      string `json:"database,omitempty" jsonschema:"description=Schema the table is in. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+	From          string `json:"from,omitempty" jsonschema:"description=Start of the window as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-7d'. Defaults to one hour before 'to'"`
+	To            string `json:"to,omitempty" jsonschema:"description=End of the window. Defaults to now"`
+	Slice         string `json:"slice,omitempty" jsonschema:"description=Duration (e.g. '1h') of the early and recent slices at either end of the window which are compared. Defaults to a tenth of the window"`
+	TimeColumn    string `json:"timeColumn,omitempty" jsonschema:"description=Name of the table's time column. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type sliceColumns struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Rows int64  `json:"rows"`
+	// Populated lists the columns with at least one non-null value.
+	Populated []string `json:"populated"`
+}
+
+type columnDrift struct {
+	// Added lists columns populated in the recent slice but not the early one.
+	Added []string `json:"added"`
+	// Removed lists columns populated in the early slice but not the recent one.
+	Removed  []string     `json:"removed"`
+	Early    sliceColumns `json:"early"`
+	Recent   sliceColumns `json:"recent"`
+	Warnings []string     `json:"warnings,omitempty"`
+}
+
+// populatedColumnsSQL counts the rows and the non-null values of every column
+// within the time filter. Results are aliased by position.
+func populatedColumnsSQL(schema, table, timeColumn string, columns []string) string {
+	exprs := make([]string, 0, len(columns)+1)
+	exprs = append(exprs, "COUNT(*) AS row_count")
+	for i, col := range columns {
+		exprs = append(exprs, fmt.Sprintf("COUNT(%s) AS count_%d", quoteIdent(col), i))
+	}
+	return fmt.Sprintf("SELECT %s FROM %s WHERE $__timeFilter(%s)",
+		strings.Join(exprs, ", "), qualifiedTable(schema, table), quoteIdent(timeColumn))
+}
+
+// populatedColumns returns the row count within opts' time range and the
+// columns with at least one non-null value in it, in table order.
+func (c *influxdbClient) populatedColumns(ctx context.Context, schema, table string, columns []string, opts queryOptions) (int64, []string, error) {
+	res, err := c.query(ctx, populatedColumnsSQL(schema, table, c.timeCol(), columns), opts)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(res.Rows) == 0 {
+		return 0, nil, fmt.Errorf("column count query returned no rows")
+	}
+	row := res.Rows[0]
+	count := func(alias string) int64 {
+		f, _ := toFloat(row[alias])
+		return int64(f)
+	}
+	populated := []string{}
+	for i, col := range columns {
+		if count(fmt.Sprintf("count_%d", i)) > 0 {
+			populated = append(populated, col)
+		}
+	}
+	return count("row_count"), populated, nil
+}
+
+// detectNewColumns compares the columns populated in the first and last
+// slice of the window [from, to).
+func (c *influxdbClient) detectNewColumns(ctx context.Context, schema, table string, from, to time.Time, slice time.Duration) (*columnDrift, error) {
+	window := to.Sub(from)
+	if slice <= 0 {
+		slice = window / defaultDriftSlices
+	}
+	if slice <= 0 {
+		return nil, fmt.Errorf("window is too short to slice")
+	}
+	if 2*slice > window {
+		return nil, fmt.Errorf("slice %s is longer than half the window %s; the slices would overlap", slice, window)
+	}
+
+	described, err := c.describeTable(ctx, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, 0, len(described))
+	for _, col := range described {
+		columns = append(columns, col.Name)
+	}
+
+	bounds := [2]queryOptions{{From: from, To: from.Add(slice)}, {From: to.Add(-slice), To: to}}
+	var (
+		wg   sync.WaitGroup
+		out  [2]sliceColumns
+		errs [2]error
+	)
+	for i, opts := range bounds {
+		wg.Add(1)
+		go func(i int, opts queryOptions) {
+			defer wg.Done()
+			out[i] = sliceColumns{From: opts.From.UTC().Format(time.RFC3339), To: opts.To.UTC().Format(time.RFC3339)}
+			out[i].Rows, out[i].Populated, errs[i] = c.populatedColumns(ctx, schema, table, columns, opts)
+		}(i, opts)
+	}
+	wg.Wait()
+	for i, name := range []string{"early", "recent"} {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("%s slice: %w", name, errs[i])
+		}
+	}
+
+	drift := &columnDrift{
+		Added:   columnsMissingFrom(out[1].Populated, out[0].Populated),
+		Removed: columnsMissingFrom(out[0].Populated, out[1].Populated),
+		Early:   out[0],
+		Recent:  out[1],
+	}
+	for i, name := range []string{"early", "recent"} {
+		if out[i].Rows == 0 {
+			drift.Warnings = append(drift.Warnings, fmt.Sprintf("the %s slice has no rows, so every column populated in the other slice is reported", name))
+		}
+	}
+	return drift, nil
+}
+
+// columnsMissingFrom returns the columns of a which are not in b, in order.
+func columnsMissingFrom(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, col := range b {
+		in[col] = true
+	}
+	out := []string{}
+	for _, col := range a {
+		if !in[col] {
+			out = append(out, col)
+		}
+	}
+	return out
+}
+
+func influxDBDetectNewColumns(ctx context.Context, args InfluxDBDetectNewColumnsParams) (*columnDrift, error) {
+	from, to, err := resolveInfluxTimeRange(args.From, args.To, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var slice time.Duration
+	if args.Slice != "" {
+		if slice, err = parseInfluxDuration(args.Slice); err != nil {
+			return nil, fmt.Errorf("parsing slice: %w", err)
+		}
+	}
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	return cli.detectNewColumns(ctx, args.Database, args.Table, from, to, slice)
+}
+
+var InfluxDBDetectNewColumns = mcpgrafana.MustTool(
+	"influxdb_detect_new_columns",
+	"InfluxDB v3 datasource: Detects schema drift in a table by comparing which columns have non-null values in an early and a recent slice of a time window. Returns the columns that started ('added') or stopped ('removed') being populated, along with the populated columns and row count of each slice. Useful for catching new or abandoned fields in schemaless ingestion.",
+	influxDBDetectNewColumns,
+)
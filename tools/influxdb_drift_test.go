@@ -0,0 +1,61 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbDetectNewColumns(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Hour)
+	var mu sync.Mutex
+	var countSQL string
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		p := decodePayload(t, r)
+		sql := p.Queries[0].RawSQL
+		if strings.Contains(sql, "information_schema.columns") {
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+				data.NewField("column_name", nil, []string{"time", "host", "legacy", "region"}),
+				data.NewField("data_type", nil, []string{"Timestamp(Nanosecond, None)", "Utf8", "Float64", "Utf8"}),
+				data.NewField("is_nullable", nil, []string{"NO", "YES", "YES", "YES"}),
+			)))
+			return
+		}
+		mu.Lock()
+		countSQL = sql
+		mu.Unlock()
+		// The early slice has legacy populated, the recent one region.
+		early := p.From == strconv.FormatInt(from.UnixMilli(), 10)
+		counts := map[bool][]int64{true: {5, 5, 5, 3, 0}, false: {7, 7, 7, 0, 2}}[early]
+		fields := []*data.Field{data.NewField("row_count", nil, []int64{counts[0]})}
+		for i, n := range counts[1:] {
+			fields = append(fields, data.NewField(fmt.Sprintf("count_%d", i), nil, []int64{n}))
+		}
+		_, _ = w.Write(arrowResponse(t, data.NewFrame("", fields...)))
+	})
+
+	drift, err := cli.detectNewColumns(context.Background(), "", "cpu", from, to, 0)
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT COUNT(*) AS row_count, COUNT("time") AS count_0, COUNT("host") AS count_1, COUNT("legacy") AS count_2, COUNT("region") AS count_3 FROM "cpu" WHERE $__timeFilter("time")`, countSQL)
+	assert.Equal(t, []string{"region"}, drift.Added)
+	assert.Equal(t, []string{"legacy"}, drift.Removed)
+	assert.Equal(t, sliceColumns{From: "2024-05-01T00:00:00Z", To: "2024-05-01T01:00:00Z", Rows: 5, Populated: []string{"time", "host", "legacy"}}, drift.Early)
+	assert.Equal(t, "2024-05-01T09:00:00Z", drift.Recent.From)
+	assert.Empty(t, drift.Warnings)
+
+	_, err = cli.detectNewColumns(context.Background(), "", "cpu", from, to, 6*time.Hour)
+	assert.ErrorContains(t, err, "slices would overlap")
+}
@@ -0,0 +1,108 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// QueryErrorCategory classifies why an InfluxDB query failed, so that callers
+// can decide whether retrying makes sense.
+type QueryErrorCategory string
+
+const (
+	QueryErrorAuth               QueryErrorCategory = "Auth"
+	QueryErrorTimeout            QueryErrorCategory = "Timeout"
+	QueryErrorDatasourceNotFound QueryErrorCategory = "DatasourceNotFound"
+	QueryErrorBadRequest         QueryErrorCategory = "BadRequest"
+	QueryErrorDecode             QueryErrorCategory = "Decode"
+	QueryErrorUpstream5xx        QueryErrorCategory = "Upstream5xx"
+	QueryErrorRateLimited        QueryErrorCategory = "RateLimited"
+	QueryErrorUnknown            QueryErrorCategory = "Unknown"
+)
+
+// InfluxQueryError is returned by InfluxDB queries. Use errors.As to inspect
+// the category of a failure.
+type InfluxQueryError struct {
+	Category QueryErrorCategory
+	// Status is the HTTP status reported by Grafana, if any.
+	Status int
+	// Source is the error source reported by Grafana ("plugin" or
+	// "downstream"), if any.
+	Source string
+	Err    error
+}
+
+func (e *InfluxQueryError) Error() string {
+	msg := fmt.Sprintf("influxdb query failed [%s]", e.Category)
+	if e.Status != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.Status)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *InfluxQueryError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the failure is transient, so the same query may
+// succeed if retried.
+func (e *InfluxQueryError) Retryable() bool {
+	switch e.Category {
+	case QueryErrorTimeout, QueryErrorUpstream5xx, QueryErrorRateLimited:
+		return true
+	}
+	return false
+}
+
+// categoryForStatus maps an HTTP status code to an error category.
+func categoryForStatus(status int) QueryErrorCategory {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return QueryErrorAuth
+	case status == http.StatusNotFound:
+		return QueryErrorDatasourceNotFound
+	case status == http.StatusTooManyRequests:
+		return QueryErrorRateLimited
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return QueryErrorTimeout
+	case status >= 500:
+		return QueryErrorUpstream5xx
+	case status >= 400:
+		return QueryErrorBadRequest
+	}
+	return QueryErrorUnknown
+}
+
+// statusError returns the error for a failure reported by Grafana, either as
+// a non-200 response or as an error on the query result.
+func statusError(status int, source, msg string) error {
+	category := categoryForStatus(status)
+	if category == QueryErrorUnknown {
+		// Grafana reported an error on an otherwise successful response,
+		// which happens when the SQL itself is rejected.
+		category = QueryErrorBadRequest
+	}
+	return &InfluxQueryError{Category: category, Status: status, Source: source, Err: errors.New(msg)}
+}
+
+// ErrInfluxDBEmptyResult is returned by query_influxdb_sql when errorOnEmpty
+// is set and the query returns no rows.
+var ErrInfluxDBEmptyResult = errors.New("query returned no rows")
+
+// transportError classifies an error returned while sending the request.
+func transportError(err error) error {
+	category := QueryErrorUnknown
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		category = QueryErrorTimeout
+	}
+	return &InfluxQueryError{Category: category, Err: err}
+}
+
+// decodeError wraps a failure to decode the response.
+func decodeError(err error) error {
+	return &InfluxQueryError{Category: QueryErrorDecode, Err: err}
+}
@@ -0,0 +1,142 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbQueryErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		status    int
+		body      string
+		category  QueryErrorCategory
+		retryable bool
+	}{
+		{"unauthorized", http.StatusUnauthorized, `{"message":"invalid API key"}`, QueryErrorAuth, false},
+		{"forbidden", http.StatusForbidden, `{"message":"forbidden"}`, QueryErrorAuth, false},
+		{"not found", http.StatusNotFound, `{"message":"datasource not found"}`, QueryErrorDatasourceNotFound, false},
+		{"rate limited", http.StatusTooManyRequests, `slow down`, QueryErrorRateLimited, true},
+		{"gateway timeout", http.StatusGatewayTimeout, `timeout`, QueryErrorTimeout, true},
+		{"bad gateway", http.StatusBadGateway, `bad gateway`, QueryErrorUpstream5xx, true},
+		{"sql error", http.StatusBadRequest, `{"results":{"A":{"error":"table 'nope' not found","errorSource":"downstream","status":400}}}`, QueryErrorBadRequest, false},
+		{"sql error on 200", http.StatusOK, `{"results":{"A":{"error":"syntax error"}}}`, QueryErrorBadRequest, false},
+		{"undecodable body", http.StatusOK, `not json`, QueryErrorDecode, false},
+		{"undecodable frame", http.StatusOK, `{"results":{"A":{"frames":[{"schema":{},"data":"!!!"}]}}}`, QueryErrorDecode, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			})
+			_, err := cli.query(context.Background(), "SELECT 1", queryOptions{})
+			require.Error(t, err)
+
+			var qe *InfluxQueryError
+			require.ErrorAs(t, err, &qe)
+			assert.Equal(t, tc.category, qe.Category)
+			assert.Equal(t, tc.retryable, qe.Retryable())
+			assert.Contains(t, err.Error(), "["+string(tc.category)+"]")
+		})
+	}
+
+	t.Run("grafana error source is kept", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"results":{"A":{"error":"bad sql","errorSource":"downstream","status":400}}}`))
+		})
+		_, err := cli.query(context.Background(), "SELECT 1", queryOptions{})
+		var qe *InfluxQueryError
+		require.ErrorAs(t, err, &qe)
+		assert.Equal(t, "downstream", qe.Source)
+		assert.Equal(t, http.StatusBadRequest, qe.Status)
+		assert.ErrorContains(t, err, "bad sql")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := cli.query(ctx, "SELECT 1", queryOptions{})
+		var qe *InfluxQueryError
+		require.ErrorAs(t, err, &qe)
+		assert.Equal(t, QueryErrorTimeout, qe.Category)
+	})
+}
+
+func TestInfluxdbQueryRetryCorruptFrames(t *testing.T) {
+	corrupt := []byte(`{"results":{"A":{"frames":[{"data":"` + base64.StdEncoding.EncodeToString([]byte("not zstd")) + `"}]}}}`)
+	structural := []byte(`{"results":{"A":{"frames":[{"data":{"values":"nope"}}]}}}`)
+
+	newClient := func(t *testing.T, first []byte, retry bool) (*influxdbClient, *int) {
+		var calls int
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				_, _ = w.Write(first)
+				return
+			}
+			_, _ = w.Write(arrowResponse(t, cpuFrame()))
+		})
+		cli.retryCorruptFrames = retry
+		return cli, &calls
+	}
+
+	t.Run("retries corrupt frame once", func(t *testing.T) {
+		cli, calls := newClient(t, corrupt, true)
+		res, err := cli.query(context.Background(), "SELECT 1", queryOptions{})
+		require.NoError(t, err)
+		assert.Len(t, res.Rows, 2)
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		cli, calls := newClient(t, corrupt, false)
+		_, err := cli.query(context.Background(), "SELECT 1", queryOptions{})
+		require.ErrorIs(t, err, errCorruptFrame)
+		assert.Equal(t, 1, *calls)
+	})
+
+	t.Run("structural errors are not retried", func(t *testing.T) {
+		cli, calls := newClient(t, structural, true)
+		_, err := cli.query(context.Background(), "SELECT 1", queryOptions{})
+		var qerr *InfluxQueryError
+		require.ErrorAs(t, err, &qerr)
+		assert.Equal(t, QueryErrorDecode, qerr.Category)
+		assert.NotErrorIs(t, err, errCorruptFrame)
+		assert.Equal(t, 1, *calls)
+	})
+}
+
+func TestQueryInfluxSQLErrorOnEmpty(t *testing.T) {
+	ctx := newTestGrafanaContext(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+			data.NewField("host", nil, []string{}),
+		)))
+	})
+	args := QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT host FROM cpu", From: "2024-05-01T00:00:00Z", To: "2024-05-01T01:00:00Z"}
+
+	out, err := queryInfluxSQL(ctx, args)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	args.ErrorOnEmpty = true
+	_, err = queryInfluxSQL(ctx, args)
+	require.ErrorIs(t, err, ErrInfluxDBEmptyResult)
+	assert.EqualError(t, err, "query returned no rows between 2024-05-01T00:00:00Z and 2024-05-01T01:00:00Z")
+}
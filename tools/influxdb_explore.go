@@ -0,0 +1,178 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+const (
+	// DefaultInfluxExploreSampleRows is the default number of sample rows
+	// returned when exploring a table.
+	DefaultInfluxExploreSampleRows = 5
+	// MaxInfluxExploreSampleRows is the maximum number of sample rows returned
+	// when exploring a table.
+	MaxInfluxExploreSampleRows = 100
+)
+
+type ExploreInfluxDBTableParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table         string `json:"table" jsonschema:"required,description=Table (measurement) to explore"`
+	Database      string `json:"database,omitempty" jsonschema:"description=Schema the table is in. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+	SampleRows    int    `json:"sampleRows,omitempty" jsonschema:"description=Number of sample rows to return. Defaults to 5\\, maximum 100"`
+	TimeColumn    string `json:"timeColumn,omitempty" jsonschema:"description=Name of the table's time column. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type tableColumn struct {
+	Name     string `json:"name"`
+	DataType string `json:"dataType"`
+	Nullable bool   `json:"nullable"`
+}
+
+type timeBounds struct {
+	Min string `json:"min"`
+	Max string `json:"max"`
+}
+
+type tableExploration struct {
+	Table            string           `json:"table"`
+	Columns          []tableColumn    `json:"columns,omitempty"`
+	Sample           []map[string]any `json:"sample,omitempty"`
+	RowCountEstimate *int64           `json:"rowCountEstimate,omitempty"`
+	TimeBounds       *timeBounds      `json:"timeBounds,omitempty"`
+	// Errors holds the error of each section which could not be retrieved,
+	// keyed by section name.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// describeTable returns the columns of a table from information_schema.
+func (c *influxdbClient) describeTable(ctx context.Context, schema, table string) ([]tableColumn, error) {
+	if schema == "" {
+		schema = defaultInfluxSchema
+	}
+	sql := fmt.Sprintf(
+		"SELECT column_name, data_type, is_nullable FROM information_schema.columns WHERE table_schema = %s AND table_name = %s",
+		quoteLiteral(schema), quoteLiteral(table),
+	)
+	res, err := c.query(ctx, sql, queryOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Rows) == 0 {
+		return nil, fmt.Errorf("%w: %q in schema %q", errTableNotFound, table, schema)
+	}
+	columns := make([]tableColumn, 0, len(res.Rows))
+	for _, row := range res.Rows {
+		columns = append(columns, tableColumn{
+			Name:     formatCell(row["column_name"]),
+			DataType: formatCell(row["data_type"]),
+			Nullable: strings.EqualFold(formatCell(row["is_nullable"]), "YES"),
+		})
+	}
+	return columns, nil
+}
+
+// tableTimeBounds returns the earliest and latest values of a table's time
+// column.
+func (c *influxdbClient) tableTimeBounds(ctx context.Context, schema, table string) (*timeBounds, error) {
+	sql := fmt.Sprintf(
+		"SELECT MIN(%[1]s) AS min_time, MAX(%[1]s) AS max_time FROM %[2]s",
+		quoteIdent(c.timeCol()), qualifiedTable(schema, table),
+	)
+	res, err := c.query(ctx, sql, queryOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Rows) == 0 {
+		return nil, fmt.Errorf("time bounds query returned no rows")
+	}
+	return &timeBounds{
+		Min: formatCell(res.Rows[0]["min_time"]),
+		Max: formatCell(res.Rows[0]["max_time"]),
+	}, nil
+}
+
+// exploreTable runs the describe, sample, count and time bounds queries for a
+// table concurrently. A section which fails is reported in Errors rather than
+// failing the whole call; an error is only returned if every section fails.
+func (c *influxdbClient) exploreTable(ctx context.Context, schema, table string, sampleRows int) (*tableExploration, error) {
+	if sampleRows <= 0 {
+		sampleRows = DefaultInfluxExploreSampleRows
+	}
+	if sampleRows > MaxInfluxExploreSampleRows {
+		sampleRows = MaxInfluxExploreSampleRows
+	}
+
+	out := &tableExploration{Table: table}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(map[string]string)
+	)
+	section := func(name string, fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(); err != nil {
+				mu.Lock()
+				errs[name] = err.Error()
+				mu.Unlock()
+			}
+		}()
+	}
+
+	section("columns", func() (err error) {
+		out.Columns, err = c.describeTable(ctx, schema, table)
+		return err
+	})
+	section("sample", func() error {
+		sql := fmt.Sprintf("SELECT * FROM %s LIMIT %d", qualifiedTable(schema, table), sampleRows)
+		res, err := c.query(ctx, sql, queryOptions{})
+		if err != nil {
+			return err
+		}
+		out.Sample = res.Rows
+		return nil
+	})
+	section("rowCountEstimate", func() error {
+		n, err := c.countRows(ctx, schema, table)
+		if err != nil {
+			return err
+		}
+		out.RowCountEstimate = &n
+		return nil
+	})
+	section("timeBounds", func() (err error) {
+		out.TimeBounds, err = c.tableTimeBounds(ctx, schema, table)
+		return err
+	})
+	wg.Wait()
+
+	if len(errs) == 4 {
+		return nil, fmt.Errorf("exploring table %q: %s", table, errs["columns"])
+	}
+	if len(errs) > 0 {
+		out.Errors = errs
+	}
+	return out, nil
+}
+
+func exploreInfluxDBTable(ctx context.Context, args ExploreInfluxDBTableParams) (*tableExploration, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	return cli.exploreTable(ctx, args.Database, args.Table, args.SampleRows)
+}
+
+var ExploreInfluxDBTable = mcpgrafana.MustTool(
+	"explore_influxdb_table",
+	"InfluxDB v3 datasource: Summarizes a table in one call, returning its columns and types, a few sample rows, its row count and the range of its time column. The queries run concurrently; sections which fail are reported under 'errors' while the rest are still returned. Note: counting and time bounds scan the table and can be slow for large tables.",
+	exploreInfluxDBTable,
+)
@@ -0,0 +1,77 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbExploreTable(t *testing.T) {
+	ts := time.UnixMilli(1714564800000).UTC()
+	columns := arrowResponse(t, data.NewFrame("",
+		data.NewField("column_name", nil, []string{"time", "host", "usage"}),
+		data.NewField("data_type", nil, []string{"Timestamp(Nanosecond, None)", "Dictionary(Int32, Utf8)", "Float64"}),
+		data.NewField("is_nullable", nil, []string{"NO", "YES", "YES"}),
+	))
+
+	t.Run("all sections", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, sqlRouter(t, map[string][]byte{
+			"information_schema.columns": columns,
+			"LIMIT 2":                    arrowResponse(t, cpuFrame()),
+			"COUNT(*)":                   arrowResponse(t, data.NewFrame("", data.NewField("row_count", nil, []int64{42}))),
+			"MIN(":                       arrowResponse(t, data.NewFrame("", data.NewField("min_time", nil, []time.Time{ts}), data.NewField("max_time", nil, []time.Time{ts.Add(time.Hour)}))),
+		}))
+		out, err := cli.exploreTable(context.Background(), "", "cpu", 2)
+		require.NoError(t, err)
+		assert.Equal(t, []tableColumn{
+			{Name: "time", DataType: "Timestamp(Nanosecond, None)"},
+			{Name: "host", DataType: "Dictionary(Int32, Utf8)", Nullable: true},
+			{Name: "usage", DataType: "Float64", Nullable: true},
+		}, out.Columns)
+		assert.Len(t, out.Sample, 2)
+		require.NotNil(t, out.RowCountEstimate)
+		assert.Equal(t, int64(42), *out.RowCountEstimate)
+		assert.Equal(t, &timeBounds{Min: "2024-05-01T12:00:00Z", Max: "2024-05-01T13:00:00Z"}, out.TimeBounds)
+		assert.Empty(t, out.Errors)
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, sqlRouter(t, map[string][]byte{
+			"information_schema.columns": columns,
+			"LIMIT 5":                    arrowResponse(t, cpuFrame()),
+		}))
+		out, err := cli.exploreTable(context.Background(), "", "cpu", 0)
+		require.NoError(t, err)
+		assert.Len(t, out.Columns, 3)
+		assert.Len(t, out.Sample, 2)
+		assert.Nil(t, out.RowCountEstimate)
+		assert.Nil(t, out.TimeBounds)
+		assert.Contains(t, out.Errors, "rowCountEstimate")
+		assert.Contains(t, out.Errors, "timeBounds")
+	})
+
+	t.Run("all sections fail", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, sqlRouter(t, map[string][]byte{}))
+		_, err := cli.exploreTable(context.Background(), "", "cpu", 0)
+		assert.Error(t, err)
+	})
+
+	t.Run("custom time column", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, sqlRouter(t, map[string][]byte{
+			"information_schema.columns": columns,
+			"LIMIT 5":                    arrowResponse(t, cpuFrame()),
+			`MIN("ts")`:                  arrowResponse(t, data.NewFrame("", data.NewField("min_time", nil, []time.Time{ts}), data.NewField("max_time", nil, []time.Time{ts}))),
+		}))
+		cli.timeColumn = "ts"
+		out, err := cli.exploreTable(context.Background(), "", "cpu", 0)
+		require.NoError(t, err)
+		assert.Equal(t, &timeBounds{Min: "2024-05-01T12:00:00Z", Max: "2024-05-01T12:00:00Z"}, out.TimeBounds)
+	})
+}
@@ -0,0 +1,516 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// DefaultMarkdownColumnWidth is the default maximum number of characters
+	// rendered per Markdown table cell.
+	DefaultMarkdownColumnWidth = 40
+)
+
+// renderMarkdownTable renders the result as a GitHub-flavored Markdown table,
+// truncating cells longer than maxWidth characters with an ellipsis.
+func renderMarkdownTable(res *queryResult, maxWidth int) string {
+	if maxWidth <= 0 {
+		maxWidth = DefaultMarkdownColumnWidth
+	}
+	if len(res.Rows) == 0 {
+		return "_Query returned no rows._"
+	}
+
+	columns := res.Columns
+	if len(columns) == 0 {
+		columns = sortedKeys(res.Rows[0])
+	}
+
+	var sb strings.Builder
+	sb.WriteString("|")
+	for _, col := range columns {
+		sb.WriteString(" " + markdownCell(col, maxWidth) + " |")
+	}
+	sb.WriteString("\n|")
+	for range columns {
+		sb.WriteString(" --- |")
+	}
+	for _, row := range res.Rows {
+		sb.WriteString("\n|")
+		for _, col := range columns {
+			sb.WriteString(" " + markdownCell(formatCell(row[col]), maxWidth) + " |")
+		}
+	}
+	return sb.String()
+}
+
+// markdownCell escapes characters that would break the table layout and
+// truncates the value to maxWidth characters.
+func markdownCell(s string, maxWidth int) string {
+	s = strings.NewReplacer("|", "\\|", "\r\n", " ", "\n", " ").Replace(s)
+	if r := []rune(s); len(r) > maxWidth {
+		s = string(r[:maxWidth-1]) + "…"
+	}
+	return s
+}
+
+// formatCell converts a decoded value to display text. Nullable Arrow fields
+// are decoded as pointers, so these are dereferenced and nil renders empty.
+// Nested values such as span tags render as JSON.
+func formatCell(v any) string {
+	if v == nil {
+		return ""
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return ""
+		}
+		rv = rv.Elem()
+	}
+	switch val := rv.Interface().(type) {
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano)
+	case []byte:
+		return string(val)
+	case map[string]any, []any:
+		if b, err := json.Marshal(val); err == nil {
+			return string(b)
+		}
+		return fmt.Sprint(val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// sortedKeys returns the keys of a row in lexical order, used when no column
+// order is known for a result.
+func sortedKeys(row map[string]any) []string {
+	keys := make([]string, 0, len(row))
+	for k := range row {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// orderedRow is a result row which marshals its keys in column order rather
+// than the alphabetical order encoding/json uses for maps.
+type orderedRow struct {
+	columns []string
+	values  map[string]any
+}
+
+func (r orderedRow) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, col := range r.columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(col)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(r.values[col])
+		if err != nil {
+			return nil, fmt.Errorf("marshal column %q: %w", col, err)
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// orderedRows wraps each row so its keys marshal in the result's column
+// order. Keys missing from the column list are appended alphabetically.
+func orderedRows(res *queryResult) []orderedRow {
+	out := make([]orderedRow, len(res.Rows))
+	for i, row := range res.Rows {
+		out[i] = orderedRow{columns: rowColumns(res.Columns, row), values: row}
+	}
+	return out
+}
+
+// rowColumns returns the columns of row, in the order given by columns with
+// any remaining keys appended alphabetically.
+func rowColumns(columns []string, row map[string]any) []string {
+	cols := make([]string, 0, len(row))
+	seen := make(map[string]bool, len(row))
+	for _, c := range columns {
+		if _, ok := row[c]; ok && !seen[c] {
+			cols = append(cols, c)
+			seen[c] = true
+		}
+	}
+	for _, k := range sortedKeys(row) {
+		if !seen[k] {
+			cols = append(cols, k)
+		}
+	}
+	return cols
+}
+
+// columnsFromRows pivots the rows of res into an array of values per column.
+// Columns missing from a row are null.
+func columnsFromRows(res *queryResult) map[string][]any {
+	out := make(map[string][]any, len(res.Columns))
+	for _, col := range res.Columns {
+		values := make([]any, len(res.Rows))
+		for i, row := range res.Rows {
+			values[i] = row[col]
+		}
+		out[col] = values
+	}
+	return out
+}
+
+// compactResponse is the result of a query in the 'compact' format: the
+// column names once, then each row as an array of values in the same order,
+// so that keys are not repeated per row. Row i as an object is
+// {cols[0]: rows[i][0], cols[1]: rows[i][1], ...}.
+type compactResponse struct {
+	Cols      []string `json:"cols"`
+	Rows      [][]any  `json:"rows"`
+	Truncated bool     `json:"truncated,omitempty"`
+	TotalSeen int      `json:"totalSeen,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+	// NextPageToken is set when paging and more rows may follow.
+	NextPageToken  string        `json:"nextPageToken,omitempty"`
+	Sampling       *samplingInfo `json:"sampling,omitempty"`
+	ColumnsOmitted int           `json:"columnsOmitted,omitempty"`
+	CellsTruncated int           `json:"cellsTruncated,omitempty"`
+	TimeSpan       *timeSpan     `json:"timeSpan,omitempty"`
+	// AppliedLimit is the default LIMIT the server added to the SQL, if it
+	// cut the result off.
+	AppliedLimit    int                `json:"appliedLimit,omitempty"`
+	Hash            string             `json:"hash,omitempty"`
+	Summary         *resultSummary     `json:"summary,omitempty"`
+	NullRates       map[string]float64 `json:"nullRates,omitempty"`
+	EffectiveSQL    string             `json:"effectiveSql,omitempty"`
+	UnitConversions []unitConversion   `json:"unitConversions,omitempty"`
+	Debug           *queryDebug        `json:"debug,omitempty"`
+}
+
+// compactRows returns the rows of res as arrays of values in column order,
+// taken from the columns directly when res was decoded column-oriented.
+// Columns missing from a row are null.
+func compactRows(res *queryResult) [][]any {
+	n := res.numRows()
+	rows := make([][]any, n)
+	for i := range rows {
+		rows[i] = make([]any, len(res.Columns))
+	}
+	for j, col := range res.Columns {
+		if res.Data != nil {
+			for i, v := range res.Data[col] {
+				rows[i][j] = v
+			}
+			continue
+		}
+		for i, row := range res.Rows {
+			rows[i][j] = row[col]
+		}
+	}
+	return rows
+}
+
+// MaxInfluxTransposeRows is the largest number of rows a result may have to
+// be transposed, since each row becomes a column.
+const MaxInfluxTransposeRows = 10
+
+// transposeResult flips res so that each of its columns becomes a row of
+// {field, value} pairs, easier to read than one wide row. The values of a
+// result with several rows are in columns row_1, row_2 and so on, and a
+// result with no rows gives only the field names.
+func transposeResult(res *queryResult) (*queryResult, error) {
+	n := res.numRows()
+	if n > MaxInfluxTransposeRows {
+		return nil, fmt.Errorf("transpose needs a result of at most %d rows, and this one has %d; filter the query or add a LIMIT", MaxInfluxTransposeRows, n)
+	}
+	columns := []string{"field"}
+	switch {
+	case n == 1:
+		columns = append(columns, "value")
+	case n > 1:
+		for i := range n {
+			columns = append(columns, fmt.Sprintf("row_%d", i+1))
+		}
+	}
+	values := compactRows(res)
+	rows := make([]map[string]any, len(res.Columns))
+	for j, col := range res.Columns {
+		row := make(map[string]any, len(columns))
+		row["field"] = col
+		for i, v := range values {
+			row[columns[i+1]] = v[j]
+		}
+		rows[j] = row
+	}
+	out := *res
+	out.Columns, out.Rows, out.Data, out.Labels = columns, rows, nil, nil
+	return &out, nil
+}
+
+// chartResponse is the result of a query in the 'chart' format: the times of
+// the rows in epoch milliseconds, and for each numeric column a series of
+// values aligned with them, null where the column has no value.
+type chartResponse struct {
+	X      []int64       `json:"x"`
+	Series []chartSeries `json:"series"`
+	// Truncated is set if maxRows cut off the result.
+	Truncated bool     `json:"truncated,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+type chartSeries struct {
+	// Name is the column name, which for labeled fields sharing a name
+	// includes the labels, e.g. 'usage {host=web1}'.
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Y      []*float64        `json:"y"`
+}
+
+// chartResult reshapes a time series result for plotting. Every numeric
+// column other than timeColumn becomes a series; other columns, such as
+// tags, are dropped. Rows without a time are skipped.
+func chartResult(res *queryResult, timeColumn string) (*chartResponse, error) {
+	if !slices.Contains(res.Columns, timeColumn) {
+		return nil, fmt.Errorf("chart format requires a time column %q in the result; set timeColumn", timeColumn)
+	}
+	var series []string
+	for _, col := range res.Columns {
+		if col != timeColumn && numericColumn(res.Rows, col) {
+			series = append(series, col)
+		}
+	}
+	if len(series) == 0 {
+		return nil, fmt.Errorf("chart format requires at least one numeric column in the result")
+	}
+
+	out := &chartResponse{X: make([]int64, 0, len(res.Rows)), Series: make([]chartSeries, len(series))}
+	for i, col := range series {
+		out.Series[i] = chartSeries{Name: col, Labels: res.Labels[col], Y: make([]*float64, 0, len(res.Rows))}
+	}
+	for _, row := range res.Rows {
+		t, ok := toTime(row[timeColumn])
+		if !ok {
+			continue
+		}
+		out.X = append(out.X, t.UnixMilli())
+		for i, col := range series {
+			var y *float64
+			if f, ok := toFloat(row[col]); ok {
+				y = &f
+			}
+			out.Series[i].Y = append(out.Series[i].Y, y)
+		}
+	}
+	return out, nil
+}
+
+// numericColumn reports whether column has at least one value in rows and
+// every value is numeric.
+func numericColumn(rows []map[string]any, column string) bool {
+	seen := false
+	for _, row := range rows {
+		v := row[column]
+		if isNil(v) {
+			continue
+		}
+		if _, ok := toFloat(v); !ok {
+			return false
+		}
+		seen = true
+	}
+	return seen
+}
+
+// renderSummary renders a result summary as a Markdown list.
+func renderSummary(s *resultSummary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "_Summary of %d rows:_\n", s.RowCount)
+	for _, c := range s.Columns {
+		fmt.Fprintf(&b, "\n- %s (%s): %d values, %d nulls", c.Name, c.Type, c.Count, c.Nulls)
+		switch {
+		case c.Mean != nil:
+			fmt.Fprintf(&b, "; min %s, max %s, mean %s", formatCell(*c.Min), formatCell(*c.Max), formatCell(*c.Mean))
+		case c.Earliest != "":
+			fmt.Fprintf(&b, "; %s to %s", c.Earliest, c.Latest)
+		case c.HighCardinality:
+			fmt.Fprintf(&b, "; more than %d distinct values", maxSummaryDistinct)
+		case c.Distinct > 0:
+			values := make([]string, 0, len(c.Values))
+			for _, v := range slices.Sorted(maps.Keys(c.Values)) {
+				values = append(values, fmt.Sprintf("%s (%d)", v, c.Values[v]))
+			}
+			fmt.Fprintf(&b, "; %d distinct: %s", c.Distinct, strings.Join(values, ", "))
+		}
+	}
+	return b.String()
+}
+
+// renderNullRates renders the null rates of columns as a Markdown line, in
+// column order.
+func renderNullRates(columns []string, rates map[string]float64) string {
+	parts := make([]string, 0, len(rates))
+	for _, col := range columns {
+		if rate, ok := rates[col]; ok {
+			parts = append(parts, fmt.Sprintf("%s %.1f%%", col, rate*100))
+		}
+	}
+	return "_Null rates: " + strings.Join(parts, ", ") + "._"
+}
+
+// renderUnitConversions renders the unit conversions applied as a Markdown
+// line.
+func renderUnitConversions(conversions []unitConversion) string {
+	parts := make([]string, len(conversions))
+	for i, c := range conversions {
+		parts[i] = fmt.Sprintf("%s from %s to %s", c.Column, c.From, c.To)
+	}
+	return "_Units converted: " + strings.Join(parts, ", ") + "._"
+}
+
+// arrowIPCResponse is returned for format=arrow. Besides the stream it
+// carries the same metadata as queryResponse.
+type arrowIPCResponse struct {
+	// Format is always "arrow-ipc-stream": Data is an Arrow IPC stream, not
+	// an IPC file.
+	Format         string        `json:"format"`
+	Rows           int           `json:"rows"`
+	Truncated      bool          `json:"truncated,omitempty"`
+	TotalSeen      int           `json:"totalSeen,omitempty"`
+	Warnings       []string      `json:"warnings,omitempty"`
+	NextPageToken  string        `json:"nextPageToken,omitempty"`
+	Sampling       *samplingInfo `json:"sampling,omitempty"`
+	ColumnsOmitted int           `json:"columnsOmitted,omitempty"`
+	TimeSpan       *timeSpan     `json:"timeSpan,omitempty"`
+	AppliedLimit   int           `json:"appliedLimit,omitempty"`
+	// Hash is set if the hash option is; see resultHash.
+	Hash         string             `json:"hash,omitempty"`
+	ResultBytes  int                `json:"resultBytes,omitempty"`
+	Summary      *resultSummary     `json:"summary,omitempty"`
+	NullRates    map[string]float64 `json:"nullRates,omitempty"`
+	EffectiveSQL string             `json:"effectiveSql,omitempty"`
+	Debug        *queryDebug        `json:"debug,omitempty"`
+	// Data is the base64-encoded stream.
+	Data string `json:"data"`
+}
+
+// checkArrowOptions rejects the options format=arrow cannot honour: those
+// which change values, reshape the result or remove rows client-side, since
+// the stream is encoded from the frame as the datasource sent it. It is
+// checked before the query runs.
+func checkArrowOptions(args QueryInfluxSQLParams) error {
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"redactColumns", len(args.RedactColumns) > 0},
+		{"pseudonymize", len(args.Pseudonymize) > 0},
+		{"maxCellBytes", args.MaxCellBytes > 0},
+		{"keyCase", args.KeyCase != "" && args.KeyCase != "asis"},
+		{"convertUnits", len(args.ConvertUnits) > 0},
+		{"nonFiniteValue", args.NonFiniteValue != ""},
+		{"typePolicy 'string'", args.TypePolicy == typePolicyString},
+		{"dedupe", args.Dedupe},
+		{"maxPoints", args.MaxPoints > 0},
+		{"sampleRate", args.SampleRate != 0},
+		{"transpose", args.Transpose},
+	} {
+		if opt.set {
+			return fmt.Errorf("format 'arrow' returns values as the datasource sent them and cannot be combined with %s", opt.name)
+		}
+	}
+	return nil
+}
+
+// arrowIPCResult encodes the frame res was decoded from as an Arrow IPC
+// stream, keeping its column types exactly. Only the rows and columns res
+// kept are encoded, so maxRows and selectColumns apply; options which change
+// values are rejected by checkArrowOptions.
+func arrowIPCResult(res *queryResult, hash, effectiveSQL string) (any, error) {
+	frame := res.Frame
+	if frame == nil {
+		return nil, fmt.Errorf("format 'arrow' requires the result as a single Arrow frame; it is not available with preferJSON, nested or chunkInterval")
+	}
+	total := len(res.Rows)
+	if res.TotalSeen > 0 {
+		total = res.TotalSeen
+	}
+	if total != frame.Rows() {
+		return nil, fmt.Errorf("format 'arrow' cannot be combined with options which add or remove rows, such as dedupe, maxPoints or client-side sampling")
+	}
+
+	// Rebuild the frame with the columns res kept and its first rows.
+	n := len(res.Rows)
+	byColumn := make(map[string]*data.Field, len(frame.Fields))
+	for i, col := range fieldColumns(frame) {
+		byColumn[col] = frame.Fields[i]
+	}
+	fields := make([]*data.Field, 0, len(res.Columns))
+	for _, col := range res.Columns {
+		f, ok := byColumn[col]
+		if !ok {
+			continue
+		}
+		out := data.NewFieldFromFieldType(f.Type(), n)
+		out.Name, out.Labels, out.Config = f.Name, f.Labels, f.Config
+		for i := 0; i < n; i++ {
+			out.Set(i, f.At(i))
+		}
+		fields = append(fields, out)
+	}
+	table, err := data.FrameToArrowTable(data.NewFrame(frame.Name, fields...).SetMeta(frame.Meta))
+	if err != nil {
+		return nil, fmt.Errorf("encode arrow: %w", err)
+	}
+	defer table.Release()
+
+	var buf bytes.Buffer
+	w := ipc.NewWriter(&buf, ipc.WithSchema(table.Schema()))
+	tr := array.NewTableReader(table, -1)
+	defer tr.Release()
+	for tr.Next() {
+		if err := w.Write(tr.Record()); err != nil {
+			return nil, fmt.Errorf("encode arrow: %w", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("encode arrow: %w", err)
+	}
+	return arrowIPCResponse{
+		Format:         "arrow-ipc-stream",
+		Rows:           n,
+		Truncated:      res.TotalSeen > 0,
+		TotalSeen:      res.TotalSeen,
+		Warnings:       res.Warnings,
+		NextPageToken:  res.NextPageToken,
+		Sampling:       res.Sampling,
+		ColumnsOmitted: res.ColumnsOmitted,
+		TimeSpan:       res.TimeSpan,
+		AppliedLimit:   res.AppliedLimit,
+		Hash:           hash,
+		ResultBytes:    res.ResultBytes,
+		Summary:        res.Summary,
+		NullRates:      res.NullRates,
+		EffectiveSQL:   effectiveSQL,
+		Debug:          res.Debug,
+		Data:           base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
@@ -0,0 +1,272 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderMarkdownTable(t *testing.T) {
+	t.Run("renders header and rows in column order", func(t *testing.T) {
+		res := &queryResult{
+			Columns: []string{"host", "usage"},
+			Rows: []map[string]any{
+				{"host": "web1", "usage": 0.5},
+				{"host": "a|b", "usage": nil},
+			},
+		}
+		expected := "| host | usage |\n| --- | --- |\n| web1 | 0.5 |\n| a\\|b |  |"
+		assert.Equal(t, expected, renderMarkdownTable(res, 0))
+	})
+
+	t.Run("truncates wide cells", func(t *testing.T) {
+		res := &queryResult{
+			Columns: []string{"msg"},
+			Rows:    []map[string]any{{"msg": "abcdefghij"}},
+		}
+		assert.Contains(t, renderMarkdownTable(res, 5), "| abcd… |")
+	})
+
+	t.Run("dereferences nullable values", func(t *testing.T) {
+		v := 1.5
+		res := &queryResult{
+			Columns: []string{"v"},
+			Rows:    []map[string]any{{"v": &v}},
+		}
+		assert.Contains(t, renderMarkdownTable(res, 0), "| 1.5 |")
+	})
+
+	t.Run("renders nested values as JSON", func(t *testing.T) {
+		res := &queryResult{
+			Columns: []string{"tags"},
+			Rows:    []map[string]any{{"tags": []any{map[string]any{"key": "k", "value": 1.0}}}},
+		}
+		assert.Contains(t, renderMarkdownTable(res, 0), `| [{"key":"k","value":1}] |`)
+	})
+
+	t.Run("empty result", func(t *testing.T) {
+		assert.Equal(t, "_Query returned no rows._", renderMarkdownTable(&queryResult{}, 0))
+	})
+}
+
+func TestOrderedRows(t *testing.T) {
+	res := &queryResult{
+		Columns: []string{"time", "host", "b", "a"},
+		Rows: []map[string]any{
+			{"time": 1, "host": "web1", "b": 2, "a": 3},
+			{"time": 2, "host": "web2", "b": 4, "a": 5, "extra": true},
+		},
+	}
+	b, err := json.Marshal(orderedRows(res))
+	require.NoError(t, err)
+	assert.Equal(t, `[{"time":1,"host":"web1","b":2,"a":3},{"time":2,"host":"web2","b":4,"a":5,"extra":true}]`, string(b))
+
+	// Marshaling the same rows repeatedly yields identical output.
+	again, err := json.Marshal(orderedRows(res))
+	require.NoError(t, err)
+	assert.Equal(t, b, again)
+}
+
+func TestCompactResults(t *testing.T) {
+	t.Run("query", func(t *testing.T) {
+		ctx := newTestGrafanaContext(t, respondWith(arrowResponse(t, cpuFrame())))
+		out, err := queryInfluxSQL(ctx, QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT host, usage FROM cpu LIMIT 10", Format: "compact"})
+		require.NoError(t, err)
+		assert.Equal(t, compactResponse{
+			Cols: []string{"host", "usage"},
+			Rows: [][]any{{"web1", 0.5}, {"web2", 0.75}},
+		}, out)
+		b, err := json.Marshal(out)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"cols":["host","usage"],"rows":[["web1",0.5],["web2",0.75]]}`, string(b))
+	})
+
+	t.Run("from rows", func(t *testing.T) {
+		ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		res := &queryResult{
+			Columns: []string{"time", "host", "usage"},
+			Rows:    []map[string]any{{"time": ts, "host": "web1"}, {"time": ts, "host": "web2", "usage": 1.5}, {"time": ts, "host": "web3"}},
+		}
+		args := QueryInfluxSQLParams{Format: "compact", MaxRows: 2}
+		require.NoError(t, transformResult(res, args))
+		out, err := formatResult(res, args)
+		require.NoError(t, err)
+		assert.Equal(t, compactResponse{
+			Cols:      []string{"time", "host", "usage"},
+			Rows:      [][]any{{"2024-05-01T12:00:00Z", "web1", nil}, {"2024-05-01T12:00:00Z", "web2", 1.5}},
+			Truncated: true,
+			TotalSeen: 3,
+		}, out)
+	})
+
+	t.Run("not with columnar", func(t *testing.T) {
+		_, err := formatResult(&queryResult{}, QueryInfluxSQLParams{Columnar: true, Format: "compact"})
+		assert.Error(t, err)
+	})
+}
+
+func TestQueryInfluxSQLTranspose(t *testing.T) {
+	query := func(t *testing.T, frame *data.Frame, args QueryInfluxSQLParams) (any, error) {
+		ctx := newTestGrafanaContext(t, respondWith(arrowResponse(t, frame)))
+		args.DatasourceUID, args.SQL, args.Transpose = "influx", "SELECT * FROM hosts LIMIT 10", true
+		return queryInfluxSQL(ctx, args)
+	}
+	host := data.NewFrame("",
+		data.NewField("host", nil, []string{"web1"}),
+		data.NewField("region", nil, []string{"eu"}),
+		data.NewField("cores", nil, []int64{8}),
+		data.NewField("booted", nil, []time.Time{time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}),
+	)
+
+	t.Run("single row", func(t *testing.T) {
+		out, err := query(t, host, QueryInfluxSQLParams{OrderedKeys: true})
+		require.NoError(t, err)
+		b, err := json.Marshal(out)
+		require.NoError(t, err)
+		assert.Equal(t, `[{"field":"host","value":"web1"},{"field":"region","value":"eu"},{"field":"cores","value":8},{"field":"booted","value":"2024-05-01T12:00:00Z"}]`, string(b))
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		out, err := query(t, host, QueryInfluxSQLParams{Format: "markdown"})
+		require.NoError(t, err)
+		assert.Contains(t, out, "| field | value |")
+		assert.Contains(t, out, "| cores | 8 |")
+	})
+
+	t.Run("several rows", func(t *testing.T) {
+		out, err := query(t, cpuFrame(), QueryInfluxSQLParams{Format: "compact"})
+		require.NoError(t, err)
+		b, err := json.Marshal(out)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"cols": ["field", "row_1", "row_2"], "rows": [["host", "web1", "web2"], ["usage", 0.5, 0.75]]}`, string(b))
+	})
+
+	t.Run("too many rows", func(t *testing.T) {
+		hosts := make([]string, MaxInfluxTransposeRows+1)
+		_, err := query(t, data.NewFrame("", data.NewField("host", nil, hosts)), QueryInfluxSQLParams{})
+		assert.ErrorContains(t, err, "at most 10 rows")
+	})
+
+	t.Run("arrow", func(t *testing.T) {
+		_, err := query(t, host, QueryInfluxSQLParams{Format: "arrow"})
+		assert.ErrorContains(t, err, "cannot be combined")
+	})
+}
+
+func TestFormatResultChart(t *testing.T) {
+	res, err := decodeDSQueryResponse(readFixture(t, "labeled.json"))
+	require.NoError(t, err)
+	times := make([]int64, len(res.Rows))
+	for i, row := range res.Rows {
+		ts, ok := toTime(row["time"])
+		require.True(t, ok)
+		times[i] = ts.UnixMilli()
+	}
+
+	out, err := formatResult(res, QueryInfluxSQLParams{Format: "chart"})
+	require.NoError(t, err)
+	chart := out.(*chartResponse)
+	assert.Equal(t, times, chart.X)
+	require.Len(t, chart.Series, 2)
+	assert.Equal(t, "usage {host=web1}", chart.Series[0].Name)
+	assert.Equal(t, map[string]string{"host": "web2"}, chart.Series[1].Labels)
+	for _, s := range chart.Series {
+		assert.Len(t, s.Y, len(chart.X))
+	}
+	assert.Equal(t, 0.7, *chart.Series[1].Y[0])
+
+	t.Run("tags and nulls", func(t *testing.T) {
+		t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		onePointFive := 1.5
+		res := frameToResult(data.NewFrame("",
+			data.NewField("time", nil, []time.Time{t0, t0.Add(time.Minute)}),
+			data.NewField("host", nil, []string{"a", "b"}),
+			data.NewField("usage", nil, []*float64{nil, &onePointFive}),
+		))
+		out, err := formatResult(res, QueryInfluxSQLParams{Format: "chart"})
+		require.NoError(t, err)
+		b, err := json.Marshal(out)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"x":[1714564800000,1714564860000],"series":[{"name":"usage","y":[null,1.5]}]}`, string(b))
+	})
+
+	t.Run("requirements", func(t *testing.T) {
+		_, err := formatResult(&queryResult{Columns: []string{"usage"}, Rows: []map[string]any{{"usage": 1.0}}}, QueryInfluxSQLParams{Format: "chart"})
+		assert.ErrorContains(t, err, "time column")
+		_, err = formatResult(&queryResult{Columns: []string{"time", "host"}, Rows: []map[string]any{{"time": time.Now(), "host": "a"}}}, QueryInfluxSQLParams{Format: "chart"})
+		assert.ErrorContains(t, err, "numeric column")
+	})
+}
+
+func TestQueryInfluxSQLArrowFormat(t *testing.T) {
+	ctx := newTestGrafanaContext(t, respondWith(arrowResponse(t, cpuFrame())))
+
+	out, err := queryInfluxSQL(ctx, QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT 1", Format: "arrow", MaxRows: 1, SelectColumns: []string{"usage"}})
+	require.NoError(t, err)
+	resp, ok := out.(arrowIPCResponse)
+	require.True(t, ok)
+	assert.Equal(t, "arrow-ipc-stream", resp.Format)
+	assert.Equal(t, 1, resp.Rows)
+	assert.True(t, resp.Truncated)
+
+	b, err := base64.StdEncoding.DecodeString(resp.Data)
+	require.NoError(t, err)
+	r, err := ipc.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	defer r.Release()
+	require.Equal(t, 1, r.Schema().NumFields())
+	assert.Equal(t, "usage", r.Schema().Field(0).Name)
+	assert.Equal(t, arrow.PrimitiveTypes.Float64, r.Schema().Field(0).Type)
+	require.True(t, r.Next())
+	assert.Equal(t, 0.5, r.Record().Column(0).(*array.Float64).Value(0))
+	assert.Equal(t, int64(1), r.Record().NumRows())
+
+	_, err = queryInfluxSQL(ctx, QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT 1", Format: "arrow", RedactColumns: []string{"host"}})
+	assert.ErrorContains(t, err, "cannot be combined with redactColumns")
+	dupes := newTestGrafanaContext(t, respondWith(arrowResponse(t, data.NewFrame("", data.NewField("host", nil, []string{"web1", "web1"})))))
+	_, err = queryInfluxSQL(dupes, QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT 1", Format: "arrow", Dedupe: true})
+	assert.ErrorContains(t, err, "cannot be combined with dedupe")
+	// These are rejected before the query is sent.
+	unqueried := newTestGrafanaContext(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("query sent: %s", decodePayload(t, r).Queries[0].RawSQL)
+	})
+	for name, args := range map[string]QueryInfluxSQLParams{
+		"convertUnits":        {ConvertUnits: map[string]string{"usage": "percent"}},
+		"nonFiniteValue":      {NonFiniteValue: "NaN"},
+		"typePolicy 'string'": {TypePolicy: "string"},
+		"maxPoints":           {MaxPoints: 10},
+		"sampleRate":          {SampleRate: 0.5},
+		"transpose":           {Transpose: true},
+	} {
+		args.DatasourceUID, args.SQL, args.Format = "influx", "SELECT 1", "arrow"
+		_, err = queryInfluxSQL(unqueried, args)
+		assert.ErrorContains(t, err, "cannot be combined with "+name)
+	}
+
+	t.Run("metadata", func(t *testing.T) {
+		args := QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT 1", Summarize: true, Hash: true, ReturnEffectiveSQL: true}
+		out, err := queryInfluxSQL(ctx, args)
+		require.NoError(t, err)
+		want := out.(queryResponse)
+		args.Format = "arrow"
+		out, err = queryInfluxSQL(ctx, args)
+		require.NoError(t, err)
+		resp := out.(arrowIPCResponse)
+		assert.Equal(t, want.Hash, resp.Hash)
+		assert.Equal(t, want.Summary, resp.Summary)
+		assert.Equal(t, "SELECT 1", resp.EffectiveSQL)
+	})
+}
@@ -0,0 +1,235 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+const (
+	// DefaultInfluxGranularitySample is the default number of timestamps
+	// sampled to estimate a table's granularity.
+	DefaultInfluxGranularitySample = 1000
+	// MaxInfluxGranularitySample is the maximum number of timestamps sampled.
+	MaxInfluxGranularitySample = 10000
+
+	// granularityTolerance is how far, as a fraction of the granularity, an
+	// interval may be from it and still count as matching it.
+	granularityTolerance = 0.1
+	// regularConfidence is the confidence from which data is reported as
+	// arriving at a regular interval.
+	regularConfidence = 0.8
+)
+
+// niceIntervals are the intervals data is usually written at. An estimated
+// granularity within granularityTolerance/2 of one is rounded to it, so that
+// jitter in the timestamps does not give e.g. 9.98s.
+var niceIntervals = []time.Duration{
+	time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second, 20 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+type InfluxDBTimeGranularityParams struct {
+	DatasourceUID string   `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table         string   `json:"table" jsonschema:"required,description=Table (measurement) to sample"`
+	Database      string   `json:"database,omitempty" jsonschema:"description=Schema the table is in. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+	GroupBy       []string `json:"groupBy,omitempty" jsonschema:"description=Tag columns identifying a series\\, e.g. host. Intervals are then measured between the points of each series rather than between distinct timestamps of the whole table\\, which matters when series are written at different times"`
+	From          string   `json:"from,omitempty" jsonschema:"description=Start of the time range sampled as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Defaults to one hour before 'to'"`
+	To            string   `json:"to,omitempty" jsonschema:"description=End of the time range. Defaults to now"`
+	Limit         int      `json:"limit,omitempty" jsonschema:"description=Number of most recent timestamps sampled. Defaults to 1000\\, maximum 10000"`
+	TimeColumn    string   `json:"timeColumn,omitempty" jsonschema:"description=Name of the table's time column. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type timeGranularity struct {
+	Table string `json:"table"`
+	// Points is the number of timestamps sampled and Intervals the number
+	// of positive intervals between consecutive ones.
+	Points    int `json:"points"`
+	Intervals int `json:"intervals"`
+	// Granularity is the most common interval, e.g. "10s", and DateBin the
+	// matching date_bin interval. Both are unset if there are too few
+	// points.
+	Granularity   string  `json:"granularity,omitempty"`
+	GranularityMs float64 `json:"granularityMs,omitempty"`
+	DateBin       string  `json:"dateBin,omitempty"`
+	// Confidence is the fraction of intervals within 10% of Granularity.
+	// Regular is set if it is at least 0.8; irregular data is better
+	// described by the range of intervals.
+	Confidence float64  `json:"confidence"`
+	Regular    bool     `json:"regular"`
+	MinMs      float64  `json:"minMs,omitempty"`
+	MedianMs   float64  `json:"medianMs,omitempty"`
+	MaxMs      float64  `json:"maxMs,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// granularitySQL returns the most recent limit timestamps of the table, with
+// the groupBy columns if any.
+func granularitySQL(schema, table, timeColumn string, groupBy []string, limit int) string {
+	timeCol := quoteIdent(timeColumn)
+	if len(groupBy) == 0 {
+		return fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE $__timeFilter(%s) ORDER BY %s DESC LIMIT %d",
+			timeCol, qualifiedTable(schema, table), timeCol, timeCol, limit)
+	}
+	cols := make([]string, len(groupBy))
+	for i, c := range groupBy {
+		cols[i] = quoteIdent(c)
+	}
+	return fmt.Sprintf("SELECT %s, %s FROM %s WHERE $__timeFilter(%s) ORDER BY %s DESC LIMIT %d",
+		timeCol, strings.Join(cols, ", "), qualifiedTable(schema, table), timeCol, timeCol, limit)
+}
+
+// seriesIntervals returns the positive intervals between consecutive values
+// of timeColumn in each series of rows, identified by the groupBy columns,
+// and the number of rows with a time.
+func seriesIntervals(rows []map[string]any, timeColumn string, groupBy []string) ([]time.Duration, int) {
+	series := map[string][]time.Time{}
+	points := 0
+	for _, row := range rows {
+		t, ok := toTime(row[timeColumn])
+		if !ok {
+			continue
+		}
+		points++
+		key := make([]string, len(groupBy))
+		for i, c := range groupBy {
+			key[i] = formatCell(row[c])
+		}
+		k := strings.Join(key, "\x00")
+		series[k] = append(series[k], t)
+	}
+	var intervals []time.Duration
+	for _, times := range series {
+		slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+		for i := 1; i < len(times); i++ {
+			if d := times[i].Sub(times[i-1]); d > 0 {
+				intervals = append(intervals, d)
+			}
+		}
+	}
+	return intervals, points
+}
+
+// modalInterval returns the most common of intervals, which must be sorted.
+// Intervals are first rounded to a tenth of the order of magnitude of their
+// median, so that jitter does not split them, and the result is rounded to a
+// niceInterval if it is close to one.
+func modalInterval(sorted []time.Duration) time.Duration {
+	median := sorted[len(sorted)/2]
+	q := time.Duration(math.Pow(10, math.Floor(math.Log10(float64(median))))) / 10
+	if q < 1 {
+		q = 1
+	}
+	counts := map[time.Duration]int{}
+	var mode time.Duration
+	for _, d := range sorted {
+		r := d.Round(q)
+		counts[r]++
+		if counts[r] > counts[mode] || (counts[r] == counts[mode] && r < mode) {
+			mode = r
+		}
+	}
+	for _, nice := range niceIntervals {
+		if math.Abs(float64(mode-nice)) <= float64(nice)*granularityTolerance/2 {
+			return nice
+		}
+	}
+	return mode
+}
+
+// durationUnits are the units granularities are written in, largest first,
+// as a Go duration suffix and a SQL interval unit.
+var durationUnits = []struct {
+	d          time.Duration
+	short, sql string
+}{
+	{24 * time.Hour, "d", "days"}, {time.Hour, "h", "hours"}, {time.Minute, "m", "minutes"},
+	{time.Second, "s", "seconds"}, {time.Millisecond, "ms", "milliseconds"},
+	{time.Microsecond, "us", "microseconds"}, {time.Nanosecond, "ns", "nanoseconds"},
+}
+
+// formatGranularity writes d in the largest unit it is a whole number of,
+// e.g. "10s", along with the equivalent SQL interval.
+func formatGranularity(d time.Duration) (string, string) {
+	for _, u := range durationUnits {
+		if d%u.d == 0 {
+			n := int64(d / u.d)
+			return fmt.Sprintf("%d%s", n, u.short), fmt.Sprintf("INTERVAL '%d %s'", n, u.sql)
+		}
+	}
+	return d.String(), ""
+}
+
+// timeGranularity estimates the interval at which points are written to a
+// table from its most recent timestamps in opts' time range.
+func (c *influxdbClient) timeGranularity(ctx context.Context, schema, table string, groupBy []string, limit int, opts queryOptions) (*timeGranularity, error) {
+	if limit <= 0 {
+		limit = DefaultInfluxGranularitySample
+	}
+	limit = min(limit, MaxInfluxGranularitySample)
+	if schema == "" {
+		schema = defaultInfluxSchema
+	}
+	res, err := c.query(ctx, granularitySQL(schema, table, c.timeCol(), groupBy, limit), opts)
+	if err != nil {
+		return nil, err
+	}
+	intervals, points := seriesIntervals(res.Rows, c.timeCol(), groupBy)
+	out := &timeGranularity{Table: schema + "." + table, Points: points, Intervals: len(intervals)}
+	if len(intervals) < 2 {
+		out.Warnings = append(out.Warnings, "too few points in the time range to estimate a granularity; widen the range")
+		return out, nil
+	}
+	slices.Sort(intervals)
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	out.MinMs, out.MedianMs, out.MaxMs = ms(intervals[0]), ms(intervals[len(intervals)/2]), ms(intervals[len(intervals)-1])
+
+	mode := modalInterval(intervals)
+	out.Granularity, out.DateBin = formatGranularity(mode)
+	out.GranularityMs = ms(mode)
+	matching := 0
+	for _, d := range intervals {
+		if math.Abs(float64(d-mode)) <= float64(mode)*granularityTolerance {
+			matching++
+		}
+	}
+	out.Confidence = float64(matching) / float64(len(intervals))
+	out.Regular = out.Confidence >= regularConfidence
+	if !out.Regular {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("the data is irregular: only %.0f%% of intervals are close to %s, and they range from %sms to %sms",
+			out.Confidence*100, out.Granularity, formatCell(out.MinMs), formatCell(out.MaxMs)))
+	}
+	if len(res.Rows) == limit {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("only the most recent %d points were sampled", limit))
+	}
+	return out, nil
+}
+
+func influxDBTimeGranularity(ctx context.Context, args InfluxDBTimeGranularityParams) (*timeGranularity, error) {
+	var opts queryOptions
+	var err error
+	if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+		return nil, err
+	}
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	return cli.timeGranularity(ctx, args.Database, args.Table, args.GroupBy, args.Limit, opts)
+}
+
+var InfluxDBTimeGranularity = mcpgrafana.MustTool(
+	"influxdb_time_granularity",
+	"InfluxDB v3 datasource: Estimates how often points are written to a table, e.g. every 10s, from the intervals between its most recent timestamps, to choose a date_bin window no finer than the data. Returns {table, points, intervals, granularity, granularityMs, dateBin, confidence, regular, minMs, medianMs, maxMs}, where granularity is the most common interval, dateBin the matching SQL interval and confidence the fraction of intervals within 10% of it. For irregular data (regular false) use the range of intervals instead. Set groupBy to the tag columns of a series if series are written at different times.",
+	influxDBTimeGranularity,
+)
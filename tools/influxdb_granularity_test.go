@@ -0,0 +1,103 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbTimeGranularity(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	timesEvery := func(offsets ...time.Duration) []time.Time {
+		times := make([]time.Time, len(offsets))
+		for i, o := range offsets {
+			times[i] = start.Add(o)
+		}
+		return times
+	}
+
+	t.Run("regular with jitter", func(t *testing.T) {
+		var sql string
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sql = decodePayload(t, r).Queries[0].RawSQL
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("time", nil, timesEvery(
+				0, 10*time.Second, 20*time.Second+40*time.Millisecond, 30*time.Second, 39*time.Second+980*time.Millisecond, 50*time.Second,
+			)))))
+		})
+		out, err := cli.timeGranularity(context.Background(), "", "cpu", nil, 0, queryOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, `SELECT DISTINCT "time" FROM "cpu" WHERE $__timeFilter("time") ORDER BY "time" DESC LIMIT 1000`, sql)
+		assert.Equal(t, "iox.cpu", out.Table)
+		assert.Equal(t, 6, out.Points)
+		assert.Equal(t, 5, out.Intervals)
+		assert.Equal(t, "10s", out.Granularity)
+		assert.Equal(t, float64(10000), out.GranularityMs)
+		assert.Equal(t, "INTERVAL '10 seconds'", out.DateBin)
+		assert.Equal(t, float64(1), out.Confidence)
+		assert.True(t, out.Regular)
+		assert.Equal(t, float64(9960), out.MinMs)
+		assert.Equal(t, float64(10040), out.MaxMs)
+		assert.Empty(t, out.Warnings)
+	})
+
+	t.Run("irregular", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, respondWith(arrowResponse(t, data.NewFrame("", data.NewField("time", nil, timesEvery(
+			0, time.Minute, 2*time.Minute, 3*time.Minute, 10*time.Minute, 13*time.Minute,
+		))))))
+		out, err := cli.timeGranularity(context.Background(), "", "cpu", nil, 0, queryOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "1m", out.Granularity)
+		assert.Equal(t, 0.6, out.Confidence)
+		assert.False(t, out.Regular)
+		assert.Equal(t, float64(60000), out.MinMs)
+		assert.Equal(t, float64(420000), out.MaxMs)
+		require.Len(t, out.Warnings, 1)
+		assert.Contains(t, out.Warnings[0], "irregular")
+	})
+
+	t.Run("per series", func(t *testing.T) {
+		var sql string
+		// Two hosts each writing every 30s, 5s apart from each other.
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sql = decodePayload(t, r).Queries[0].RawSQL
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+				data.NewField("time", nil, timesEvery(0, 5*time.Second, 30*time.Second, 35*time.Second, time.Minute, 65*time.Second)),
+				data.NewField("host", nil, []string{"web1", "web2", "web1", "web2", "web1", "web2"}),
+			)))
+		})
+		out, err := cli.timeGranularity(context.Background(), "", "cpu", []string{"host"}, 5000, queryOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, `SELECT "time", "host" FROM "cpu" WHERE $__timeFilter("time") ORDER BY "time" DESC LIMIT 5000`, sql)
+		assert.Equal(t, "30s", out.Granularity)
+		assert.Equal(t, 4, out.Intervals)
+	})
+
+	t.Run("too few points", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, respondWith(arrowResponse(t, data.NewFrame("", data.NewField("time", nil, timesEvery(0))))))
+		out, err := cli.timeGranularity(context.Background(), "", "cpu", nil, 0, queryOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, out.Granularity)
+		assert.Contains(t, out.Warnings[0], "too few points")
+	})
+}
+
+func TestFormatGranularity(t *testing.T) {
+	for d, want := range map[time.Duration][2]string{
+		90 * time.Second:        {"90s", "INTERVAL '90 seconds'"},
+		2 * time.Hour:           {"2h", "INTERVAL '2 hours'"},
+		48 * time.Hour:          {"2d", "INTERVAL '2 days'"},
+		250 * time.Millisecond:  {"250ms", "INTERVAL '250 milliseconds'"},
+		1500 * time.Microsecond: {"1500us", "INTERVAL '1500 microseconds'"},
+	} {
+		short, sql := formatGranularity(d)
+		assert.Equal(t, want, [2]string{short, sql}, d.String())
+	}
+}
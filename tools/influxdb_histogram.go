@@ -0,0 +1,162 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+const (
+	// DefaultInfluxHistogramBuckets is the default number of histogram
+	// buckets.
+	DefaultInfluxHistogramBuckets = 10
+	// MaxInfluxHistogramBuckets is the maximum number of histogram buckets,
+	// whether given directly or implied by a bucket width.
+	MaxInfluxHistogramBuckets = 1000
+)
+
+type InfluxDBHistogramParams struct {
+	DatasourceUID string  `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table         string  `json:"table" jsonschema:"required,description=Table (measurement) to read"`
+	Column        string  `json:"column" jsonschema:"required,description=Numeric column whose distribution is counted"`
+	Database      string  `json:"database,omitempty" jsonschema:"description=Schema the table is in. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+	Buckets       int     `json:"buckets,omitempty" jsonschema:"description=Number of equal-width buckets spanning the column's minimum to maximum. Defaults to 10\\, maximum 1000. Ignored if width is set"`
+	Width         float64 `json:"width,omitempty" jsonschema:"description=Width of each bucket. Buckets then start at multiples of width\\, e.g. width 10 gives buckets 0-10\\, 10-20 and so on"`
+	From          string  `json:"from,omitempty" jsonschema:"description=Start of the time range as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Defaults to one hour before 'to'"`
+	To            string  `json:"to,omitempty" jsonschema:"description=End of the time range. Defaults to now"`
+	TimeColumn    string  `json:"timeColumn,omitempty" jsonschema:"description=Name of the table's time column. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type histogramBucket struct {
+	// BucketStart is inclusive and BucketEnd exclusive, except for the last
+	// bucket of a histogram by count, which includes the maximum.
+	BucketStart float64 `json:"bucketStart"`
+	BucketEnd   float64 `json:"bucketEnd"`
+	Count       int64   `json:"count"`
+}
+
+type histogram struct {
+	Column string  `json:"column"`
+	Width  float64 `json:"width"`
+	// Count is the number of non-null values counted.
+	Count int64 `json:"count"`
+	// Min and Max are unset if the column has no values in the range.
+	Min     *float64          `json:"min,omitempty"`
+	Max     *float64          `json:"max,omitempty"`
+	Buckets []histogramBucket `json:"buckets"`
+}
+
+// histogramRangeSQL computes the count and range of the column within the
+// time filter.
+func histogramRangeSQL(schema, table, timeColumn, column string) string {
+	q := quoteIdent(column)
+	return fmt.Sprintf("SELECT COUNT(%s) AS n, MIN(%s) AS lo, MAX(%s) AS hi FROM %s WHERE $__timeFilter(%s)",
+		q, q, q, qualifiedTable(schema, table), quoteIdent(timeColumn))
+}
+
+// histogramSQL counts the values of the column in n buckets of width w
+// starting at origin. Values past the last bucket, which can only be the
+// maximum, are counted in it.
+func histogramSQL(schema, table, timeColumn, column string, origin, w float64, n int) string {
+	q := quoteIdent(column)
+	bucket := fmt.Sprintf("LEAST(CAST(floor((%s - %s) / %s) AS BIGINT), %d)", q, floatLiteral(origin), floatLiteral(w), n-1)
+	return fmt.Sprintf("SELECT %s AS bucket, COUNT(*) AS count FROM %s WHERE $__timeFilter(%s) AND %s IS NOT NULL GROUP BY %s ORDER BY bucket",
+		bucket, qualifiedTable(schema, table), quoteIdent(timeColumn), q, bucket)
+}
+
+// histogram counts the values of a numeric column in buckets. The first
+// query finds the column's range, from which the buckets are laid out, and
+// the second counts the values in each. Empty buckets are included with a
+// zero count.
+func (c *influxdbClient) histogram(ctx context.Context, schema, table, column string, buckets int, width float64, opts queryOptions) (*histogram, error) {
+	if width < 0 || math.IsNaN(width) || math.IsInf(width, 0) {
+		return nil, fmt.Errorf("width must be a positive number, got %g", width)
+	}
+	if buckets < 0 || buckets > MaxInfluxHistogramBuckets {
+		return nil, fmt.Errorf("buckets must be between 1 and %d, got %d", MaxInfluxHistogramBuckets, buckets)
+	}
+	if buckets == 0 {
+		buckets = DefaultInfluxHistogramBuckets
+	}
+	if err := c.checkNumericColumn(ctx, schema, table, column); err != nil {
+		return nil, err
+	}
+
+	res, err := c.query(ctx, histogramRangeSQL(schema, table, c.timeCol(), column), opts)
+	if err != nil {
+		return nil, err
+	}
+	out := &histogram{Column: column, Width: width, Buckets: []histogramBucket{}}
+	if len(res.Rows) == 0 {
+		return out, nil
+	}
+	row := res.Rows[0]
+	if n, ok := toFloat(row["n"]); ok {
+		out.Count = int64(n)
+	}
+	lo, okLo := toFloat(row["lo"])
+	hi, okHi := toFloat(row["hi"])
+	if out.Count == 0 || !okLo || !okHi {
+		return out, nil
+	}
+	out.Min, out.Max = &lo, &hi
+
+	origin := lo
+	if width > 0 {
+		origin = math.Floor(lo/width) * width
+		n := math.Floor((hi-origin)/width) + 1
+		if n > MaxInfluxHistogramBuckets {
+			return nil, fmt.Errorf("width %g would give %.0f buckets, more than the maximum of %d; use a larger width", width, n, MaxInfluxHistogramBuckets)
+		}
+		buckets = int(n)
+	} else if hi == lo {
+		// Every value is the same, so a single bucket holds them all.
+		out.Buckets = append(out.Buckets, histogramBucket{BucketStart: lo, BucketEnd: hi, Count: out.Count})
+		return out, nil
+	} else {
+		out.Width = (hi - lo) / float64(buckets)
+	}
+
+	res, err = c.query(ctx, histogramSQL(schema, table, c.timeCol(), column, origin, out.Width, buckets), opts)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]int64, buckets)
+	for _, row := range res.Rows {
+		i, okI := toFloat(row["bucket"])
+		n, okN := toFloat(row["count"])
+		if okI && okN && i >= 0 && int(i) < buckets {
+			counts[int(i)] += int64(n)
+		}
+	}
+	for i, n := range counts {
+		start := origin + float64(i)*out.Width
+		out.Buckets = append(out.Buckets, histogramBucket{BucketStart: start, BucketEnd: start + out.Width, Count: n})
+	}
+	return out, nil
+}
+
+func influxDBHistogram(ctx context.Context, args InfluxDBHistogramParams) (*histogram, error) {
+	var opts queryOptions
+	var err error
+	if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+		return nil, err
+	}
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	return cli.histogram(ctx, args.Database, args.Table, args.Column, args.Buckets, args.Width, opts)
+}
+
+var InfluxDBHistogram = mcpgrafana.MustTool(
+	"influxdb_histogram",
+	"InfluxDB v3 datasource: Counts the values of a numeric column within a time range in equal-width buckets, for plotting or judging its distribution. Either give the number of buckets spanning the column's minimum to maximum, or a bucket width. Returns {column, width, count, min, max, buckets: [{bucketStart, bucketEnd, count}]}, including empty buckets; a column with no values in the range gives no buckets. The counting is done by the datasource.",
+	influxDBHistogram,
+)
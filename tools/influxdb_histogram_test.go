@@ -0,0 +1,85 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbHistogram(t *testing.T) {
+	columns := arrowResponse(t, data.NewFrame("",
+		data.NewField("column_name", nil, []string{"time", "host", "usage"}),
+		data.NewField("data_type", nil, []string{"Timestamp(Nanosecond, None)", "Dictionary(Int32, Utf8)", "Float64"}),
+		data.NewField("is_nullable", nil, []string{"NO", "YES", "YES"}),
+	))
+	handler := func(rangeFrame *data.Frame, sent *[]string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			sql := decodePayload(t, r).Queries[0].RawSQL
+			switch {
+			case strings.Contains(sql, "information_schema"):
+				_, _ = w.Write(columns)
+			case strings.Contains(sql, "MIN("):
+				_, _ = w.Write(arrowResponse(t, rangeFrame))
+			default:
+				*sent = append(*sent, sql)
+				_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+					data.NewField("bucket", nil, []int64{0, 3}),
+					data.NewField("count", nil, []int64{5, 2}),
+				)))
+			}
+		}
+	}
+	rangeFrame := func(n int64, lo, hi float64) *data.Frame {
+		return data.NewFrame("", data.NewField("n", nil, []int64{n}), data.NewField("lo", nil, []float64{lo}), data.NewField("hi", nil, []float64{hi}))
+	}
+
+	t.Run("by count", func(t *testing.T) {
+		var sent []string
+		cli := newTestInfluxdbClient(t, handler(rangeFrame(7, 0, 100), &sent))
+		h, err := cli.histogram(context.Background(), "", "cpu", "usage", 4, 0, queryOptions{})
+		require.NoError(t, err)
+		require.Len(t, sent, 1)
+		assert.Equal(t, `SELECT LEAST(CAST(floor(("usage" - 0) / 25) AS BIGINT), 3) AS bucket, COUNT(*) AS count FROM "cpu" WHERE $__timeFilter("time") AND "usage" IS NOT NULL GROUP BY LEAST(CAST(floor(("usage" - 0) / 25) AS BIGINT), 3) ORDER BY bucket`, sent[0])
+		assert.Equal(t, 25.0, h.Width)
+		assert.Equal(t, []histogramBucket{{0, 25, 5}, {25, 50, 0}, {50, 75, 0}, {75, 100, 2}}, h.Buckets)
+	})
+
+	t.Run("by width", func(t *testing.T) {
+		var sent []string
+		cli := newTestInfluxdbClient(t, handler(rangeFrame(7, 12, 47), &sent))
+		h, err := cli.histogram(context.Background(), "", "cpu", "usage", 0, 10, queryOptions{})
+		require.NoError(t, err)
+		assert.Contains(t, sent[0], `floor(("usage" - 10) / 10)`)
+		require.Len(t, h.Buckets, 4)
+		assert.Equal(t, histogramBucket{10, 20, 5}, h.Buckets[0])
+		assert.Equal(t, histogramBucket{40, 50, 2}, h.Buckets[3])
+
+		_, err = cli.histogram(context.Background(), "", "cpu", "usage", 0, 0.001, queryOptions{})
+		assert.ErrorContains(t, err, "use a larger width")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		var sent []string
+		empty := data.NewFrame("", data.NewField("n", nil, []int64{0}), data.NewField("lo", nil, []*float64{nil}), data.NewField("hi", nil, []*float64{nil}))
+		cli := newTestInfluxdbClient(t, handler(empty, &sent))
+		h, err := cli.histogram(context.Background(), "", "cpu", "usage", 0, 0, queryOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, sent)
+		assert.Empty(t, h.Buckets)
+		assert.Nil(t, h.Min)
+	})
+
+	t.Run("not numeric", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, respondWith(columns))
+		_, err := cli.histogram(context.Background(), "", "cpu", "host", 0, 0, queryOptions{})
+		assert.ErrorContains(t, err, "not numeric")
+	})
+}
@@ -0,0 +1,191 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// infoProbeTimeout bounds the trivial query which checks that the datasource
+// responds.
+const infoProbeTimeout = 10 * time.Second
+
+// sensitiveKeyParts are fragments of the names of settings and URL
+// parameters whose values are redacted, compared case-insensitively.
+// Grafana keeps real secrets in secureJsonData, which it never returns, but
+// misconfigured datasources sometimes have them in jsonData or the URL.
+var sensitiveKeyParts = []string{"password", "passwd", "secret", "token", "apikey", "api_key", "credential", "privatekey"}
+
+type InfluxDBDatasourceInfoParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+}
+
+type grafanaHealth struct {
+	Version  string `json:"version,omitempty"`
+	Commit   string `json:"commit,omitempty"`
+	Database string `json:"database,omitempty"`
+}
+
+type datasourceProbe struct {
+	// OK is set if a trivial query succeeded.
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+	// Category classifies Error; see QueryErrorCategory.
+	Category QueryErrorCategory `json:"category,omitempty"`
+}
+
+type influxDatasourceInfo struct {
+	UID       string `json:"uid"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+	Access    string `json:"access,omitempty"`
+	Database  string `json:"database,omitempty"`
+	IsDefault bool   `json:"isDefault"`
+	ReadOnly  bool   `json:"readOnly"`
+	// QueryLanguage is jsonData.version, the language the InfluxDB
+	// datasource is configured for: "SQL", "InfluxQL" or "Flux".
+	QueryLanguage string         `json:"queryLanguage,omitempty"`
+	JSONData      map[string]any `json:"jsonData,omitempty"`
+	// SecureJSONFields lists the secure settings which are set. Grafana
+	// never returns their values.
+	SecureJSONFields []string        `json:"secureJsonFields,omitempty"`
+	Grafana          *grafanaHealth  `json:"grafana,omitempty"`
+	Probe            datasourceProbe `json:"probe"`
+	Warnings         []string        `json:"warnings,omitempty"`
+}
+
+// isSensitiveKey reports whether the value of the setting or parameter named
+// key should be redacted.
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	return slices.ContainsFunc(sensitiveKeyParts, func(part string) bool { return strings.Contains(key, part) })
+}
+
+// redactURL removes the password and sensitive query parameters of a
+// datasource URL. URLs which do not parse are returned as they are, since
+// without a scheme there is nowhere credentials could be.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	q := u.Query()
+	changed := false
+	for key := range q {
+		if isSensitiveKey(key) {
+			q.Set(key, redactedPlaceholder)
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// redactSettings returns a copy of jsonData with the values of sensitive keys
+// replaced, at any depth. Booleans are kept, as they are flags such as
+// tlsAuth rather than secrets.
+func redactSettings(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, e := range val {
+			if _, isBool := e.(bool); isSensitiveKey(k) && !isBool && e != nil {
+				out[k] = redactedPlaceholder
+				continue
+			}
+			out[k] = redactSettings(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, e := range val {
+			out[i] = redactSettings(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// probe runs a trivial query to check that the datasource responds.
+func (c *influxdbClient) probe(ctx context.Context) datasourceProbe {
+	ctx, cancel := context.WithTimeout(ctx, infoProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	_, err := c.query(ctx, "SELECT 1", queryOptions{})
+	out := datasourceProbe{OK: err == nil, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		out.Error = err.Error()
+		out.Category = QueryErrorUnknown
+		var qe *InfluxQueryError
+		if errors.As(err, &qe) {
+			out.Category = qe.Category
+		}
+	}
+	return out
+}
+
+func influxDBDatasourceInfo(ctx context.Context, args InfluxDBDatasourceInfoParams) (*influxDatasourceInfo, error) {
+	ds, err := getDatasourceByUID(ctx, GetDatasourceByUIDParams{UID: args.DatasourceUID})
+	if err != nil {
+		return nil, err
+	}
+	out := &influxDatasourceInfo{
+		UID:       ds.UID,
+		Name:      ds.Name,
+		Type:      ds.Type,
+		URL:       redactURL(ds.URL),
+		Access:    string(ds.Access),
+		Database:  ds.Database,
+		IsDefault: ds.IsDefault,
+		ReadOnly:  ds.ReadOnly,
+	}
+	if settings, ok := redactSettings(ds.JSONData).(map[string]any); ok && len(settings) > 0 {
+		out.JSONData = settings
+		out.QueryLanguage, _ = settings["version"].(string)
+	}
+	for name, set := range ds.SecureJSONFields {
+		if set {
+			out.SecureJSONFields = append(out.SecureJSONFields, name)
+		}
+	}
+	slices.Sort(out.SecureJSONFields)
+	if ds.Type != "influxdb" {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("the datasource is of type %q, not an InfluxDB datasource", ds.Type))
+	} else if out.QueryLanguage != "" && !strings.EqualFold(out.QueryLanguage, "SQL") {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("the datasource is configured for %s; the InfluxDB v3 tools need one configured for SQL", out.QueryLanguage))
+	}
+
+	if gc := mcpgrafana.GrafanaClientFromContext(ctx); gc != nil {
+		if health, err := gc.Health.GetHealth(); err != nil {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("reading the Grafana version: %v", err))
+		} else if p := health.Payload; p != nil {
+			out.Grafana = &grafanaHealth{Version: p.Version, Commit: p.Commit, Database: p.Database}
+		}
+	}
+
+	// The datasource was just looked up, so the client need not look it up
+	// again.
+	cli, err := newInfluxdbClient(WithSkipDatasourceValidation(ctx), args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	out.Probe = cli.probe(ctx)
+	return out, nil
+}
+
+var InfluxDBDatasourceInfo = mcpgrafana.MustTool(
+	"influxdb_datasource_info",
+	"InfluxDB v3 datasource: Diagnoses a datasource in one call; call it first when queries fail. Returns {uid, name, type, url, access, database, queryLanguage, jsonData, secureJsonFields, grafana: {version, commit, database}, probe: {ok, latencyMs, error, category}, warnings}: the datasource's settings with credentials in the URL and secret-looking settings redacted, the names (never the values) of its secure settings, the Grafana version, and whether a trivial SELECT 1 succeeds and if not why, e.g. an Auth or Timeout category. Warnings flag settings which stop the InfluxDB v3 tools from working, such as a datasource configured for InfluxQL instead of SQL.",
+	influxDBDatasourceInfo,
+)
@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+const (
+	// DefaultInfluxIngestionErrorsLimit is the default number of ingestion
+	// errors returned.
+	DefaultInfluxIngestionErrorsLimit = 20
+	// MaxInfluxIngestionErrorsLimit is the maximum number of ingestion errors
+	// returned.
+	MaxInfluxIngestionErrorsLimit = 100
+)
+
+// defaultIngestionErrorTables are the tables looked for when no location is
+// configured, in order. InfluxDB v3 does not record rejected writes itself,
+// so these are the usual names of a dead-letter measurement written by the
+// ingestion pipeline.
+var defaultIngestionErrorTables = []string{"ingestion_errors", "dead_letter", "write_errors"}
+
+type InfluxDBIngestionErrorsParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table         string `json:"table,omitempty" jsonschema:"description=Table holding ingestion errors as [schema.]table. Defaults to the server's configured location\\, otherwise the first of ingestion_errors\\, dead_letter and write_errors which exists"`
+	From          string `json:"from,omitempty" jsonschema:"description=Start of the time window as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Defaults to one hour before 'to'"`
+	To            string `json:"to,omitempty" jsonschema:"description=End of the time window. Defaults to now"`
+	Limit         int    `json:"limit,omitempty" jsonschema:"description=Maximum number of errors to return\\, newest first. Defaults to 20\\, maximum 100"`
+	TimeColumn    string `json:"timeColumn,omitempty" jsonschema:"description=Name of the table's time column. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type ingestionErrors struct {
+	// Table is the table the errors were read from.
+	Table  string       `json:"table"`
+	Errors []orderedRow `json:"errors"`
+}
+
+// splitTableName splits "[schema.]table" into its schema, defaulting to
+// iox, and table.
+func splitTableName(name string) (string, string) {
+	if schema, table, ok := strings.Cut(name, "."); ok {
+		return schema, table
+	}
+	return defaultInfluxSchema, name
+}
+
+// ingestionErrorTable returns the schema and table ingestion errors are read
+// from: the one given, which must exist, or else the first default which
+// does.
+func (c *influxdbClient) ingestionErrorTable(ctx context.Context, name string) (string, string, error) {
+	candidates := defaultIngestionErrorTables
+	if name != "" {
+		candidates = []string{name}
+	}
+	for _, candidate := range candidates {
+		schema, table := splitTableName(candidate)
+		ok, err := c.hasTable(ctx, schema, table)
+		if err != nil {
+			return "", "", fmt.Errorf("checking for %s: %w", candidate, err)
+		}
+		if ok {
+			return schema, table, nil
+		}
+	}
+	return "", "", fmt.Errorf("ingestion errors are %w: no table %s exists; configure where the ingestion pipeline records rejected writes with -influxdb-ingestion-errors-table",
+		errSystemTableUnsupported, strings.Join(candidates, ", "))
+}
+
+// ingestionErrorsSQL returns the newest rows of an ingestion error table
+// within the query's time range.
+func ingestionErrorsSQL(schema, table, timeColumn string, limit int) string {
+	return fmt.Sprintf("SELECT * FROM %s WHERE $__timeFilter(%s) ORDER BY %s DESC LIMIT %d",
+		qualifiedTable(schema, table), quoteIdent(timeColumn), quoteIdent(timeColumn), limit)
+}
+
+// ingestionErrors returns the most recent rows of the ingestion error table
+// within opts' time range. The rows are returned as recorded, since their
+// columns depend on what wrote them.
+func (c *influxdbClient) ingestionErrors(ctx context.Context, name string, limit int, opts queryOptions) (*ingestionErrors, error) {
+	if limit <= 0 {
+		limit = DefaultInfluxIngestionErrorsLimit
+	}
+	limit = min(limit, MaxInfluxIngestionErrorsLimit)
+	schema, table, err := c.ingestionErrorTable(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.query(ctx, ingestionErrorsSQL(schema, table, c.timeCol(), limit), opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyTypePolicy(res.Rows, typePolicyJSONSafe); err != nil {
+		return nil, err
+	}
+	return &ingestionErrors{Table: schema + "." + table, Errors: orderedRows(res)}, nil
+}
+
+func influxDBIngestionErrors(ctx context.Context, args InfluxDBIngestionErrorsParams) (*ingestionErrors, error) {
+	var opts queryOptions
+	var err error
+	if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+		return nil, err
+	}
+	name := args.Table
+	if name == "" {
+		name = InfluxDBConfigFromContext(ctx).IngestionErrorsTable
+	}
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	return cli.ingestionErrors(ctx, name, args.Limit, opts)
+}
+
+var InfluxDBIngestionErrors = mcpgrafana.MustTool(
+	"influxdb_ingestion_errors",
+	"InfluxDB v3 datasource: Returns recent ingestion failures, newest first, read from the table where the ingestion pipeline records rejected writes (a dead-letter measurement). Useful for diagnosing why data is missing. Returns {table, errors} with the rows as recorded. Returns a 'not supported' error if the datasource has no such table.",
+	influxDBIngestionErrors,
+)
@@ -0,0 +1,54 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbIngestionErrors(t *testing.T) {
+	t.Run("default location", func(t *testing.T) {
+		var checked []string
+		var sent string
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sql := decodePayload(t, r).Queries[0].RawSQL
+			if strings.Contains(sql, "information_schema") {
+				checked = append(checked, sql)
+				found := []string{}
+				if strings.Contains(sql, "'dead_letter'") {
+					found = append(found, "dead_letter")
+				}
+				_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("table_name", nil, found))))
+				return
+			}
+			sent = sql
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+				data.NewField("time", nil, []time.Time{time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}),
+				data.NewField("error", nil, []string{"field type conflict"}),
+			)))
+		})
+		res, err := cli.ingestionErrors(context.Background(), "", 500, queryOptions{})
+		require.NoError(t, err)
+		assert.Len(t, checked, 2)
+		assert.Equal(t, "iox.dead_letter", res.Table)
+		assert.Equal(t, `SELECT * FROM "dead_letter" WHERE $__timeFilter("time") ORDER BY "time" DESC LIMIT 100`, sent)
+		require.Len(t, res.Errors, 1)
+		assert.Equal(t, "field type conflict", res.Errors[0].values["error"])
+	})
+
+	t.Run("configured location missing", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, respondWith(arrowResponse(t, data.NewFrame("", data.NewField("table_name", nil, []string{})))))
+		_, err := cli.ingestionErrors(context.Background(), "monitoring.rejected", 0, queryOptions{})
+		assert.ErrorIs(t, err, errSystemTableUnsupported)
+		assert.ErrorContains(t, err, "monitoring.rejected")
+	})
+}
@@ -0,0 +1,115 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// joinedColumnSuffix is appended to right-hand columns whose name is already
+// used by the left-hand result.
+const joinedColumnSuffix = "_right"
+
+type JoinInfluxDBQueriesParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	LeftSQL       string `json:"leftSql" jsonschema:"required,description=SQL for the left-hand side of the join"`
+	RightSQL      string `json:"rightSql" jsonschema:"required,description=SQL for the right-hand side of the join"`
+	Key           string `json:"key" jsonschema:"required,description=Column both queries return whose values the rows are joined on"`
+	JoinType      string `json:"joinType,omitempty" jsonschema:"description=Either 'inner' (default) to return only matching rows or 'left' to also return left rows without a match"`
+	From          string `json:"from,omitempty" jsonschema:"description=Start of the time range for both queries as epoch milliseconds or a relative expression like 'now-6h'. Defaults to one hour before 'to'"`
+	To            string `json:"to,omitempty" jsonschema:"description=End of the time range for both queries. Defaults to now"`
+}
+
+// joinResults joins right onto left on key. Keys are compared by their
+// display text, so e.g. an integer and a float with the same value match;
+// null keys never match. As in SQL, a key occurring several times on both
+// sides yields every combination of the matching rows. Right-hand columns
+// which clash with a left-hand column other than the key are renamed with
+// joinedColumnSuffix.
+func joinResults(left, right *queryResult, key, joinType string) (*queryResult, error) {
+	switch joinType {
+	case "", "inner", "left":
+	default:
+		return nil, fmt.Errorf("invalid joinType %q: must be 'inner' or 'left'", joinType)
+	}
+	for name, res := range map[string]*queryResult{"left": left, "right": right} {
+		if len(res.Rows) > 0 && !slices.Contains(res.Columns, key) {
+			return nil, fmt.Errorf("key column %q not found in the %s result", key, name)
+		}
+	}
+
+	columns := append([]string{}, left.Columns...)
+	rename := make(map[string]string, len(right.Columns))
+	for _, col := range right.Columns {
+		if col == key {
+			continue
+		}
+		out := col
+		if slices.Contains(left.Columns, col) {
+			out = col + joinedColumnSuffix
+		}
+		rename[col] = out
+		columns = append(columns, out)
+	}
+
+	index := make(map[string][]map[string]any, len(right.Rows))
+	for _, row := range right.Rows {
+		if k := row[key]; !isNil(k) {
+			index[formatCell(k)] = append(index[formatCell(k)], row)
+		}
+	}
+
+	rows := make([]map[string]any, 0, len(left.Rows))
+	for _, l := range left.Rows {
+		var matches []map[string]any
+		if k := l[key]; !isNil(k) {
+			matches = index[formatCell(k)]
+		}
+		if len(matches) == 0 && joinType == "left" {
+			matches = []map[string]any{nil}
+		}
+		for _, r := range matches {
+			row := make(map[string]any, len(columns))
+			for k, v := range l {
+				row[k] = v
+			}
+			for col, out := range rename {
+				row[out] = r[col]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return &queryResult{Columns: columns, Rows: rows}, nil
+}
+
+func joinInfluxDBQueries(ctx context.Context, args JoinInfluxDBQueriesParams) ([]orderedRow, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	var opts queryOptions
+	if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+		return nil, err
+	}
+	results, err := cli.queryMulti(ctx, []string{args.LeftSQL, args.RightSQL}, opts)
+	if err != nil {
+		return nil, err
+	}
+	joined, err := joinResults(results[0], results[1], args.Key, args.JoinType)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyTypePolicy(joined.Rows, typePolicyJSONSafe); err != nil {
+		return nil, err
+	}
+	return orderedRows(joined), nil
+}
+
+var JoinInfluxDBQueries = mcpgrafana.MustTool(
+	"join_influxdb_queries",
+	"InfluxDB v3 datasource: Runs two SQL queries in a single request and joins their rows on a shared key column (inner or left join). Right-hand columns whose names clash with left-hand ones get a '_right' suffix; a key which repeats on both sides yields every combination of matching rows. Useful for comparing two metrics keyed by e.g. host.",
+	joinInfluxDBQueries,
+)
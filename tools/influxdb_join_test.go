@@ -0,0 +1,55 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinResults(t *testing.T) {
+	left := &queryResult{
+		Columns: []string{"host", "cpu"},
+		Rows: []map[string]any{
+			{"host": "web1", "cpu": 0.5},
+			{"host": "web2", "cpu": 0.7},
+			{"host": nil, "cpu": 0.1},
+		},
+	}
+	right := &queryResult{
+		Columns: []string{"host", "cpu", "mem"},
+		Rows: []map[string]any{
+			{"host": "web1", "cpu": 0.6, "mem": 10},
+			{"host": "web1", "cpu": 0.8, "mem": 20},
+			{"host": "web3", "cpu": 0.9, "mem": 30},
+		},
+	}
+
+	t.Run("inner", func(t *testing.T) {
+		joined, err := joinResults(left, right, "host", "")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"host", "cpu", "cpu_right", "mem"}, joined.Columns)
+		assert.Equal(t, []map[string]any{
+			{"host": "web1", "cpu": 0.5, "cpu_right": 0.6, "mem": 10},
+			{"host": "web1", "cpu": 0.5, "cpu_right": 0.8, "mem": 20},
+		}, joined.Rows)
+	})
+
+	t.Run("left", func(t *testing.T) {
+		joined, err := joinResults(left, right, "host", "left")
+		require.NoError(t, err)
+		require.Len(t, joined.Rows, 4)
+		assert.Equal(t, map[string]any{"host": "web2", "cpu": 0.7, "cpu_right": nil, "mem": nil}, joined.Rows[2])
+		assert.Equal(t, map[string]any{"host": nil, "cpu": 0.1, "cpu_right": nil, "mem": nil}, joined.Rows[3])
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := joinResults(left, right, "host", "outer")
+		assert.ErrorContains(t, err, "invalid joinType")
+		_, err = joinResults(left, right, "missing", "inner")
+		assert.ErrorContains(t, err, "key column")
+	})
+}
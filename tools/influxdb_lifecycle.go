@@ -0,0 +1,81 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errInfluxDBShuttingDown is returned for queries started after
+// ShutdownInfluxDB.
+var errInfluxDBShuttingDown = errors.New("server is shutting down")
+
+// queryTracker keeps the cancel functions of in-flight queries so that they
+// can be cancelled together on shutdown.
+type queryTracker struct {
+	mu      sync.Mutex
+	next    int
+	cancels map[int]context.CancelFunc
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func newQueryTracker() *queryTracker {
+	return &queryTracker{cancels: make(map[int]context.CancelFunc)}
+}
+
+// activeQueries tracks the queries of every client created by
+// newInfluxdbClient.
+var activeQueries = newQueryTracker()
+
+// track derives a cancellable context for a query. The returned function must
+// be called once the query has finished.
+func (t *queryTracker) track(ctx context.Context) (context.Context, func(), error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, nil, errInfluxDBShuttingDown
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	id := t.next
+	t.next++
+	t.cancels[id] = cancel
+	t.wg.Add(1)
+	return ctx, func() {
+		t.mu.Lock()
+		delete(t.cancels, id)
+		t.mu.Unlock()
+		cancel()
+		t.wg.Done()
+	}, nil
+}
+
+// shutdown rejects new queries, cancels the in-flight ones and waits for them
+// to return or for ctx to be done.
+func (t *queryTracker) shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	t.closed = true
+	for _, cancel := range t.cancels {
+		cancel()
+	}
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ShutdownInfluxDB cancels every in-flight InfluxDB query and waits for them
+// to finish, or for ctx to be done. Queries started afterwards fail. It is
+// intended to be called once when the server stops.
+func ShutdownInfluxDB(ctx context.Context) error {
+	return activeQueries.shutdown(ctx)
+}
@@ -0,0 +1,78 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbShutdownCancelsQueries(t *testing.T) {
+	started := make(chan struct{})
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		// Consume the body so the server notices the client going away.
+		_, _ = io.Copy(io.Discard, r.Body)
+		close(started)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	cli.queries = newQueryTracker()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := cli.query(context.Background(), "SELECT slow", queryOptions{})
+		errCh <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, cli.queries.shutdown(ctx))
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("query was not cancelled")
+	}
+
+	_, err := cli.query(context.Background(), "SELECT 1", queryOptions{})
+	assert.ErrorIs(t, err, errInfluxDBShuttingDown)
+}
+
+func TestQueryInfluxSQLDoneContext(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+	ctx, err := withGrafanaURL(WithInfluxDBConfig(context.Background(), InfluxDBConfig{AllowedGrafanaURLs: []string{srv.URL}}), srv.URL)
+	require.NoError(t, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = queryInfluxSQL(cancelled, QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT 1"})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = queryInfluxSQL(expired, QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT 1"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	var qe *InfluxQueryError
+	require.ErrorAs(t, err, &qe)
+	assert.Equal(t, QueryErrorTimeout, qe.Category)
+
+	// Without the datasource lookup the query itself is not sent either.
+	_, err = queryInfluxSQL(cancelled, QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT 1", SkipValidation: true})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, requests)
+}
@@ -0,0 +1,282 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// NamedQuery is an SQL template stored under a name so that agents can run
+// it without the statement appearing in the prompt. Placeholders are written
+// {{param}} and are substituted as SQL literals, or as quoted identifiers for
+// parameters of type "identifier".
+type NamedQuery struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// DatasourceUID is the datasource the query runs against unless the
+	// caller names another one.
+	DatasourceUID string            `json:"datasourceUid,omitempty"`
+	SQL           string            `json:"sql"`
+	Params        []NamedQueryParam `json:"params,omitempty"`
+}
+
+// NamedQueryParam declares a placeholder of a NamedQuery.
+type NamedQueryParam struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Type is "string" (the default), "number", "boolean" or "identifier".
+	Type     string `json:"type,omitempty"`
+	Required bool   `json:"required,omitempty"`
+	// Default is used when an optional parameter is not given.
+	Default any `json:"default,omitempty"`
+}
+
+var namedQueryPlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// validate checks that the query is complete and that every placeholder in
+// its SQL is declared.
+func (q NamedQuery) validate() error {
+	if q.Name == "" {
+		return errors.New("named query without a name")
+	}
+	if strings.TrimSpace(q.SQL) == "" {
+		return fmt.Errorf("named query %q: sql is empty", q.Name)
+	}
+	declared := make(map[string]bool, len(q.Params))
+	for _, p := range q.Params {
+		switch p.Type {
+		case "", "string", "number", "boolean", "identifier":
+		default:
+			return fmt.Errorf("named query %q: parameter %q has invalid type %q", q.Name, p.Name, p.Type)
+		}
+		declared[p.Name] = true
+	}
+	for _, m := range namedQueryPlaceholder.FindAllStringSubmatch(q.SQL, -1) {
+		if !declared[m[1]] {
+			return fmt.Errorf("named query %q: placeholder {{%s}} is not a declared parameter", q.Name, m[1])
+		}
+	}
+	return nil
+}
+
+// render substitutes params into the query's SQL. Required parameters must
+// be given; parameters the query does not declare are rejected so that a
+// misspelt name is not silently ignored.
+func (q NamedQuery) render(params map[string]any) (string, error) {
+	declared := make(map[string]NamedQueryParam, len(q.Params))
+	for _, p := range q.Params {
+		declared[p.Name] = p
+	}
+	var unknown, missing []string
+	for name := range params {
+		if _, ok := declared[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	values := make(map[string]string, len(q.Params))
+	for _, p := range q.Params {
+		v, ok := params[p.Name]
+		if !ok || v == nil {
+			if p.Required {
+				missing = append(missing, p.Name)
+				continue
+			}
+			v = p.Default
+		}
+		s, err := namedQueryValue(p, v)
+		if err != nil {
+			return "", fmt.Errorf("parameter %q: %w", p.Name, err)
+		}
+		values[p.Name] = s
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("unknown parameters for named query %q: %s", q.Name, strings.Join(unknown, ", "))
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing required parameters for named query %q: %s", q.Name, strings.Join(missing, ", "))
+	}
+	return namedQueryPlaceholder.ReplaceAllStringFunc(q.SQL, func(m string) string {
+		return values[namedQueryPlaceholder.FindStringSubmatch(m)[1]]
+	}), nil
+}
+
+// namedQueryValue renders v as SQL according to the parameter's type.
+func namedQueryValue(p NamedQueryParam, v any) (string, error) {
+	if v == nil {
+		return "NULL", nil
+	}
+	switch p.Type {
+	case "number":
+		f, ok := toFloat(v)
+		if !ok {
+			if s, isString := v.(string); isString {
+				var err error
+				if f, err = strconv.ParseFloat(s, 64); err == nil {
+					ok = true
+				}
+			}
+		}
+		if !ok {
+			return "", fmt.Errorf("expected a number, got %v", v)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	case "boolean":
+		b, ok := v.(bool)
+		if !ok {
+			return "", fmt.Errorf("expected a boolean, got %v", v)
+		}
+		return strconv.FormatBool(b), nil
+	case "identifier":
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return "", fmt.Errorf("expected a non-empty name, got %v", v)
+		}
+		return quoteIdent(s), nil
+	default:
+		return quoteLiteral(formatCell(v)), nil
+	}
+}
+
+// NamedQueryRegistry holds named queries. It is safe for concurrent use, and
+// a registry loaded from a file can be reloaded while the server runs.
+type NamedQueryRegistry struct {
+	mu      sync.RWMutex
+	path    string
+	queries map[string]NamedQuery
+}
+
+// NewNamedQueryRegistry returns an empty registry to which queries can be
+// added with Register.
+func NewNamedQueryRegistry() *NamedQueryRegistry {
+	return &NamedQueryRegistry{queries: make(map[string]NamedQuery)}
+}
+
+// LoadNamedQueries returns a registry holding the queries in the JSON file at
+// path, which contains an array of NamedQuery objects.
+func LoadNamedQueries(path string) (*NamedQueryRegistry, error) {
+	r := NewNamedQueryRegistry()
+	r.path = path
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Register adds q, replacing any query with the same name.
+func (r *NamedQueryRegistry) Register(q NamedQuery) error {
+	if err := q.validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries[q.Name] = q
+	return nil
+}
+
+// Reload replaces the queries read from the registry's file with its current
+// contents. Queries added with Register are discarded. If the file cannot be
+// read or is invalid the registry is left unchanged. It does nothing for a
+// registry not loaded from a file.
+func (r *NamedQueryRegistry) Reload() error {
+	if r.path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("reading named queries: %w", err)
+	}
+	var list []NamedQuery
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("parsing named queries %s: %w", r.path, err)
+	}
+	queries := make(map[string]NamedQuery, len(list))
+	for _, q := range list {
+		if err := q.validate(); err != nil {
+			return fmt.Errorf("%s: %w", r.path, err)
+		}
+		if _, dup := queries[q.Name]; dup {
+			return fmt.Errorf("%s: duplicate named query %q", r.path, q.Name)
+		}
+		queries[q.Name] = q
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries = queries
+	return nil
+}
+
+// Get returns the query registered under name.
+func (r *NamedQueryRegistry) Get(name string) (NamedQuery, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	q, ok := r.queries[name]
+	return q, ok
+}
+
+// Names returns the names of the registered queries in sorted order.
+func (r *NamedQueryRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.queries))
+	for name := range r.queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type RunNamedInfluxDBQueryParams struct {
+	Name              string         `json:"name" jsonschema:"required,description=Name of the stored query to run"`
+	Params            map[string]any `json:"params,omitempty" jsonschema:"description=Values for the query's parameters keyed by parameter name"`
+	DatasourceUID     string         `json:"datasourceUid,omitempty" jsonschema:"description=InfluxDB v3 datasource UID. Defaults to the datasource the query is stored with"`
+	From              string         `json:"from,omitempty" jsonschema:"description=Start of the time range as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Defaults to one hour before 'to'"`
+	To                string         `json:"to,omitempty" jsonschema:"description=End of the time range. Defaults to now"`
+	ConfirmationToken string         `json:"confirmationToken,omitempty" jsonschema:"description=Token from a preview returned instead of a result when the server requires confirmation. Repeat the call with the same name\\, params\\, datasourceUid\\, from and to and this token to run the query. Tokens expire after 5 minutes"`
+}
+
+// renderNamedQuery looks up a named query and renders it with the caller's
+// parameters, returning the SQL and the datasource to run it against.
+func renderNamedQuery(r *NamedQueryRegistry, args RunNamedInfluxDBQueryParams) (sql, uid string, err error) {
+	if r == nil {
+		return "", "", errors.New("no named queries are configured")
+	}
+	q, ok := r.Get(args.Name)
+	if !ok {
+		return "", "", fmt.Errorf("unknown named query %q (available: %s)", args.Name, strings.Join(r.Names(), ", "))
+	}
+	if sql, err = q.render(args.Params); err != nil {
+		return "", "", err
+	}
+	uid = args.DatasourceUID
+	if uid == "" {
+		uid = q.DatasourceUID
+	}
+	if uid == "" {
+		return "", "", fmt.Errorf("named query %q has no datasource; set datasourceUid", q.Name)
+	}
+	return sql, uid, nil
+}
+
+func runNamedInfluxDBQuery(ctx context.Context, args RunNamedInfluxDBQueryParams) (any, error) {
+	sql, uid, err := renderNamedQuery(InfluxDBConfigFromContext(ctx).NamedQueries, args)
+	if err != nil {
+		return nil, err
+	}
+	return queryInfluxSQL(ctx, QueryInfluxSQLParams{DatasourceUID: uid, SQL: sql, From: args.From, To: args.To, ConfirmationToken: args.ConfirmationToken})
+}
+
+var RunNamedInfluxDBQuery = mcpgrafana.MustTool(
+	"run_named_influxdb_query",
+	"InfluxDB v3 datasource: Runs a stored SQL query by name, substituting the given parameters. The server operator defines the available queries and their parameters; an unknown name returns the list of available queries. Returns rows as query_influxdb_sql does, including its preview with a confirmationToken if the server requires confirmation: check the preview, then repeat the call with the token.",
+	runNamedInfluxDBQuery,
+)
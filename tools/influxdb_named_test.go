@@ -0,0 +1,71 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamedQueryRender(t *testing.T) {
+	q := NamedQuery{
+		Name: "cpu_by_host",
+		SQL:  "SELECT {{column}} FROM cpu WHERE host = {{ host }} AND usage > {{min}} LIMIT {{limit}}",
+		Params: []NamedQueryParam{
+			{Name: "host", Required: true},
+			{Name: "column", Type: "identifier", Default: "usage"},
+			{Name: "min", Type: "number", Required: true},
+			{Name: "limit", Type: "number", Default: 10},
+		},
+	}
+	require.NoError(t, q.validate())
+
+	sql, err := q.render(map[string]any{"host": "web'1", "min": 0.5})
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT "usage" FROM cpu WHERE host = 'web''1' AND usage > 0.5 LIMIT 10`, sql)
+
+	_, err = q.render(map[string]any{"host": "web1"})
+	assert.ErrorContains(t, err, "missing required parameters for named query \"cpu_by_host\": min")
+	_, err = q.render(map[string]any{"host": "web1", "min": 1, "hots": "x"})
+	assert.ErrorContains(t, err, "unknown parameters")
+	_, err = q.render(map[string]any{"host": "web1", "min": "lots"})
+	assert.ErrorContains(t, err, `parameter "min": expected a number`)
+
+	undeclared := NamedQuery{Name: "bad", SQL: "SELECT * FROM {{table}}"}
+	assert.ErrorContains(t, undeclared.validate(), "placeholder {{table}} is not a declared parameter")
+}
+
+func TestNamedQueryRegistryReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.json")
+	write := func(s string) { require.NoError(t, os.WriteFile(path, []byte(s), 0o600)) }
+	write(`[{"name": "count", "datasourceUid": "influx", "sql": "SELECT COUNT(*) FROM cpu"}]`)
+
+	r, err := LoadNamedQueries(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"count"}, r.Names())
+
+	write(`[{"name": "count", "sql": "SELECT COUNT(*) FROM cpu"}, {"name": "hosts", "sql": "SELECT DISTINCT host FROM cpu"}]`)
+	require.NoError(t, r.Reload())
+	assert.Equal(t, []string{"count", "hosts"}, r.Names())
+
+	// An invalid file leaves the loaded queries in place.
+	write(`[{"name": "count", "sql": ""}]`)
+	assert.ErrorContains(t, r.Reload(), "sql is empty")
+	assert.Equal(t, []string{"count", "hosts"}, r.Names())
+
+	sql, uid, err := renderNamedQuery(r, RunNamedInfluxDBQueryParams{Name: "hosts", DatasourceUID: "other"})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT DISTINCT host FROM cpu", sql)
+	assert.Equal(t, "other", uid)
+	_, _, err = renderNamedQuery(r, RunNamedInfluxDBQueryParams{Name: "hosts"})
+	assert.ErrorContains(t, err, "has no datasource")
+	_, _, err = renderNamedQuery(r, RunNamedInfluxDBQueryParams{Name: "nope"})
+	assert.ErrorContains(t, err, "available: count, hosts")
+	_, _, err = renderNamedQuery(nil, RunNamedInfluxDBQueryParams{Name: "count"})
+	assert.ErrorContains(t, err, "no named queries are configured")
+}
@@ -0,0 +1,287 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// unsupportedArrowTypeErrors are how the SDK reports Arrow types it cannot
+// convert into frame fields, such as lists and structs.
+var unsupportedArrowTypeErrors = []string{"unsupported conversion from arrow", "unsupported arrow type"}
+
+// isUnsupportedArrowType reports whether err is an SDK conversion error for
+// a column type frames cannot hold.
+func isUnsupportedArrowType(err error) bool {
+	for _, msg := range unsupportedArrowTypeErrors {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeNestedArrow decodes Arrow IPC bytes by walking the Arrow arrays
+// directly instead of converting them into SDK frame fields, which cannot
+// hold nested types. Lists become []any, structs and maps map[string]any,
+// recursively, so that they marshal as plain JSON.
+func decodeNestedArrow(arrowBytes []byte, dopts decodeOptions) (*queryResult, error) {
+	schema, records, err := readArrowRecords(arrowBytes)
+	if err != nil {
+		return nil, decodeError(fmt.Errorf("read arrow frame: %w: %w", errCorruptFrame, err))
+	}
+	keep, omitted, err := dopts.limitColumns(schema.NumFields())
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, keep)
+	fieldLabels := make([]data.Labels, keep)
+	for i := range names {
+		f := schema.Field(i)
+		names[i] = f.Name
+		if raw, ok := f.Metadata.GetValue("labels"); ok {
+			// Labels which do not parse are left off rather than failing
+			// the whole result.
+			_ = json.Unmarshal([]byte(raw), &fieldLabels[i])
+		}
+	}
+	columns := columnKeys(names, fieldLabels)
+	res := &queryResult{Columns: columns, ArrowSchema: schema, ColumnsOmitted: omitted}
+	for i, l := range fieldLabels {
+		if len(l) > 0 {
+			if res.Labels == nil {
+				res.Labels = make(map[string]map[string]string)
+			}
+			res.Labels[columns[i]] = l
+		}
+	}
+
+	if dopts.Columnar {
+		res.Data = make(map[string][]any, keep)
+		for _, col := range columns {
+			res.Data[col] = []any{}
+		}
+	} else {
+		res.Rows = []map[string]any{}
+	}
+	for _, rec := range records {
+		n := int(rec.NumRows())
+		if dopts.Columnar {
+			for j, col := range columns {
+				arr := rec.Column(j)
+				for i := 0; i < n; i++ {
+					res.Data[col] = append(res.Data[col], arrowValue(arr, i))
+				}
+			}
+			continue
+		}
+		for i := 0; i < n; i++ {
+			row := make(map[string]any, keep)
+			for j, col := range columns {
+				row[col] = arrowValue(rec.Column(j), i)
+			}
+			res.Rows = append(res.Rows, row)
+		}
+	}
+	for _, rec := range records {
+		rec.Release()
+	}
+	res.CellsTruncated = limitCells(res, dopts.MaxCellBytes)
+	return res, nil
+}
+
+// readArrowRecords reads every record batch of an Arrow IPC file or stream.
+// The caller must release the records.
+func readArrowRecords(b []byte) (*arrow.Schema, []arrow.Record, error) {
+	if bytes.HasPrefix(b, arrowFileMagic) {
+		r, err := ipc.NewFileReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, nil, err
+		}
+		defer r.Close()
+		records := make([]arrow.Record, 0, r.NumRecords())
+		for i := 0; i < r.NumRecords(); i++ {
+			rec, err := r.RecordAt(i)
+			if err != nil {
+				for _, rec := range records {
+					rec.Release()
+				}
+				return nil, nil, err
+			}
+			records = append(records, rec)
+		}
+		return r.Schema(), records, nil
+	}
+	r, err := ipc.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Release()
+	var records []arrow.Record
+	for r.Next() {
+		rec := r.Record()
+		rec.Retain()
+		records = append(records, rec)
+	}
+	if err := r.Err(); err != nil {
+		for _, rec := range records {
+			rec.Release()
+		}
+		return nil, nil, err
+	}
+	return r.Schema(), records, nil
+}
+
+// arrowValue returns the value of arr at row i as a plain Go value. Nested
+// values are converted recursively; types without a natural Go equivalent are
+// returned as Arrow formats them.
+func arrowValue(arr arrow.Array, i int) any {
+	if arr.IsNull(i) {
+		return nil
+	}
+	switch a := arr.(type) {
+	case *array.Boolean:
+		return a.Value(i)
+	case *array.Int8:
+		return a.Value(i)
+	case *array.Int16:
+		return a.Value(i)
+	case *array.Int32:
+		return a.Value(i)
+	case *array.Int64:
+		return a.Value(i)
+	case *array.Uint8:
+		return a.Value(i)
+	case *array.Uint16:
+		return a.Value(i)
+	case *array.Uint32:
+		return a.Value(i)
+	case *array.Uint64:
+		return a.Value(i)
+	case *array.Float16:
+		return a.Value(i).Float32()
+	case *array.Float32:
+		return a.Value(i)
+	case *array.Float64:
+		return a.Value(i)
+	case *array.String:
+		return a.Value(i)
+	case *array.LargeString:
+		return a.Value(i)
+	case *array.StringView:
+		return a.Value(i)
+	case *array.Binary:
+		return binaryValue(a.Value(i))
+	case *array.LargeBinary:
+		return binaryValue(a.Value(i))
+	case *array.Timestamp:
+		return a.Value(i).ToTime(a.DataType().(*arrow.TimestampType).Unit)
+	case *array.Date32:
+		return a.Value(i).ToTime()
+	case *array.Date64:
+		return a.Value(i).ToTime()
+	case *array.Dictionary:
+		return arrowValue(a.Dictionary(), a.GetValueIndex(i))
+	case *array.Struct:
+		st := a.DataType().(*arrow.StructType)
+		out := make(map[string]any, a.NumField())
+		for j := 0; j < a.NumField(); j++ {
+			out[st.Field(j).Name] = arrowValue(a.Field(j), i)
+		}
+		return out
+	case *array.Map:
+		// Checked before lists, as maps are lists of key/value structs.
+		start, end := a.ValueOffsets(i)
+		out := make(map[string]any, end-start)
+		for k := start; k < end; k++ {
+			out[formatCell(arrowValue(a.Keys(), int(k)))] = arrowValue(a.Items(), int(k))
+		}
+		return out
+	case array.ListLike:
+		start, end := a.ValueOffsets(i)
+		values := a.ListValues()
+		out := make([]any, 0, end-start)
+		for k := start; k < end; k++ {
+			out = append(out, arrowValue(values, int(k)))
+		}
+		return out
+	}
+	return arr.ValueStr(i)
+}
+
+// formatArrowSchema renders schema as an indented tree with one line per
+// field, nested fields below their parent.
+func formatArrowSchema(schema *arrow.Schema) string {
+	var b strings.Builder
+	var write func(f arrow.Field, depth int)
+	write = func(f arrow.Field, depth int) {
+		b.WriteString(strings.Repeat("  ", depth))
+		nested, isNested := f.Type.(arrow.NestedType)
+		typ := f.Type.String()
+		if isNested {
+			typ = f.Type.Name()
+		}
+		fmt.Fprintf(&b, "%s: %s", f.Name, typ)
+		if !f.Nullable {
+			b.WriteString(" not null")
+		}
+		b.WriteString("\n")
+		if isNested {
+			for _, child := range nested.Fields() {
+				write(child, depth+1)
+			}
+		}
+	}
+	for _, f := range schema.Fields() {
+		write(f, 0)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+type InfluxDBResultSchemaParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	SQL           string `json:"sql" jsonschema:"required,description=SQL statement whose result schema is described. Add a LIMIT to avoid fetching rows which are not needed"`
+	From          string `json:"from,omitempty" jsonschema:"description=Start of the time range as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Defaults to one hour before 'to'"`
+	To            string `json:"to,omitempty" jsonschema:"description=End of the time range. Defaults to now"`
+}
+
+// resultSchema runs sql and returns the schema tree of its result.
+func (c *influxdbClient) resultSchema(ctx context.Context, sql string, opts queryOptions) (string, error) {
+	opts.Nested = true
+	res, err := c.query(ctx, sql, opts)
+	if err != nil {
+		return "", err
+	}
+	if res.ArrowSchema == nil {
+		return "", fmt.Errorf("the datasource returned the result as JSON rather than Arrow, which carries no schema to describe")
+	}
+	return formatArrowSchema(res.ArrowSchema), nil
+}
+
+func influxDBResultSchema(ctx context.Context, args InfluxDBResultSchemaParams) (string, error) {
+	var opts queryOptions
+	var err error
+	if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+		return "", err
+	}
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return "", err
+	}
+	return cli.resultSchema(ctx, args.SQL, opts)
+}
+
+var InfluxDBResultSchema = mcpgrafana.MustTool(
+	"influxdb_result_schema",
+	"InfluxDB v3 datasource: Runs an SQL query and returns the Arrow schema of its result as an indented tree, one line per column with its type and nullability and the fields of list, struct and map columns indented below it. Use it to understand nested columns before querying them; query_influxdb_sql returns their values with nested=true.",
+	influxDBResultSchema,
+)
@@ -0,0 +1,41 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbResultSchema(t *testing.T) {
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(readFixture(t, "nested_struct.json"))
+	})
+	tree, err := cli.resultSchema(context.Background(), "SELECT * FROM cpu LIMIT 1", queryOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, `time: timestamp[ns, tz=UTC] not null
+meta: struct
+  host: utf8
+  cores: int64
+  disk: struct
+    used: float64
+  tags: list
+    item: utf8
+counts: map
+  entries: struct not null
+    key: utf8 not null
+    value: int64`, tree)
+
+	t.Run("values frames", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(readFixture(t, "values.json"))
+		})
+		_, err := cli.resultSchema(context.Background(), "SELECT 1", queryOptions{})
+		require.ErrorContains(t, err, "no schema")
+	})
+}
@@ -0,0 +1,204 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+const (
+	// DefaultInfluxOutliersLimit is the default number of outliers returned.
+	DefaultInfluxOutliersLimit = 100
+	// MaxInfluxOutliersLimit is the maximum number of outliers returned.
+	MaxInfluxOutliersLimit = 1000
+
+	// defaultZScoreThreshold flags values more than three standard
+	// deviations from the mean, the usual rule for roughly normal data.
+	defaultZScoreThreshold = 3
+	// defaultIQRThreshold flags values more than 1.5 interquartile ranges
+	// outside the quartiles, Tukey's fences.
+	defaultIQRThreshold = 1.5
+)
+
+type InfluxDBFindOutliersParams struct {
+	DatasourceUID string  `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table         string  `json:"table" jsonschema:"required,description=Table (measurement) to search"`
+	Column        string  `json:"column" jsonschema:"required,description=Numeric column to find outliers in"`
+	Database      string  `json:"database,omitempty" jsonschema:"description=Schema the table is in. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+	Method        string  `json:"method,omitempty" jsonschema:"description=How outliers are found. 'zscore' (default) flags values whose distance from the mean is more than threshold standard deviations (default 3) and suits roughly normal data. 'iqr' flags values more than threshold interquartile ranges (default 1.5) below the first or above the third quartile and is robust to skewed data and to the outliers themselves"`
+	Threshold     float64 `json:"threshold,omitempty" jsonschema:"description=Score above which a value is an outlier: standard deviations for zscore\\, interquartile ranges for iqr. Lower values flag more rows"`
+	From          string  `json:"from,omitempty" jsonschema:"description=Start of the time range as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Defaults to one hour before 'to'"`
+	To            string  `json:"to,omitempty" jsonschema:"description=End of the time range. Defaults to now"`
+	Limit         int     `json:"limit,omitempty" jsonschema:"description=Maximum number of outliers to return\\, most extreme first. Defaults to 100\\, maximum 1000"`
+	TimeColumn    string  `json:"timeColumn,omitempty" jsonschema:"description=Name of the table's time column. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type outlierRow struct {
+	// Score is the signed distance of the value from the center in units of
+	// the spread: standard deviations from the mean for zscore, interquartile
+	// ranges below the first or above the third quartile for iqr.
+	Score float64    `json:"score"`
+	Row   orderedRow `json:"row"`
+}
+
+type outlierResult struct {
+	Method    string  `json:"method"`
+	Column    string  `json:"column"`
+	Threshold float64 `json:"threshold"`
+	// Count is the number of non-null values of the column in the range.
+	Count int64 `json:"count"`
+	// Mean and Stddev are set for zscore, Q1 and Q3 for iqr.
+	Mean   *float64 `json:"mean,omitempty"`
+	Stddev *float64 `json:"stddev,omitempty"`
+	Q1     *float64 `json:"q1,omitempty"`
+	Q3     *float64 `json:"q3,omitempty"`
+	// Lower and Upper are the bounds outside which values are outliers.
+	Lower    *float64     `json:"lower,omitempty"`
+	Upper    *float64     `json:"upper,omitempty"`
+	Outliers []outlierRow `json:"outliers"`
+	// Truncated is set if more outliers may exist than were returned.
+	Truncated bool     `json:"truncated"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// outlierStatsSQL computes the statistics method needs over the column
+// within the time filter.
+func outlierStatsSQL(schema, table, timeColumn, column, method string) string {
+	q := quoteIdent(column)
+	exprs := fmt.Sprintf("COUNT(%s) AS n, AVG(%s) AS mean, STDDEV(%s) AS stddev", q, q, q)
+	if method == "iqr" {
+		exprs = fmt.Sprintf("COUNT(%s) AS n, approx_percentile_cont(%s, 0.25) AS q1, approx_percentile_cont(%s, 0.75) AS q3", q, q, q)
+	}
+	return fmt.Sprintf("SELECT %s FROM %s WHERE $__timeFilter(%s)", exprs, qualifiedTable(schema, table), quoteIdent(timeColumn))
+}
+
+// outliersSQL returns the rows whose column lies outside [lower, upper],
+// furthest from center first.
+func outliersSQL(schema, table, timeColumn, column string, lower, upper, center float64, limit int) string {
+	q := quoteIdent(column)
+	return fmt.Sprintf("SELECT * FROM %s WHERE $__timeFilter(%s) AND (%s < %s OR %s > %s) ORDER BY ABS(%s - %s) DESC LIMIT %d",
+		qualifiedTable(schema, table), quoteIdent(timeColumn), q, floatLiteral(lower), q, floatLiteral(upper), q, floatLiteral(center), limit)
+}
+
+// findOutliers flags the outliers of a numeric column in two queries: one
+// computing the statistics of the column, and one returning the rows outside
+// the bounds they give, so that only the outliers are fetched.
+func (c *influxdbClient) findOutliers(ctx context.Context, schema, table, column, method string, threshold float64, limit int, opts queryOptions) (*outlierResult, error) {
+	switch method {
+	case "", "zscore":
+		method = "zscore"
+		if threshold == 0 {
+			threshold = defaultZScoreThreshold
+		}
+	case "iqr":
+		if threshold == 0 {
+			threshold = defaultIQRThreshold
+		}
+	default:
+		return nil, fmt.Errorf("invalid method %q: must be 'zscore' or 'iqr'", method)
+	}
+	if threshold < 0 {
+		return nil, fmt.Errorf("threshold must be positive, got %g", threshold)
+	}
+	if limit <= 0 {
+		limit = DefaultInfluxOutliersLimit
+	}
+	limit = min(limit, MaxInfluxOutliersLimit)
+
+	if err := c.checkNumericColumn(ctx, schema, table, column); err != nil {
+		return nil, err
+	}
+
+	res, err := c.query(ctx, outlierStatsSQL(schema, table, c.timeCol(), column, method), opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Rows) == 0 {
+		return nil, fmt.Errorf("statistics query returned no rows")
+	}
+	row := res.Rows[0]
+	stat := func(name string) *float64 {
+		if f, ok := toFloat(row[name]); ok && !math.IsNaN(f) {
+			return &f
+		}
+		return nil
+	}
+	out := &outlierResult{Method: method, Column: column, Threshold: threshold, Outliers: []outlierRow{}}
+	if n := stat("n"); n != nil {
+		out.Count = int64(*n)
+	}
+
+	var center, spread, lower, upper float64
+	if method == "zscore" {
+		out.Mean, out.Stddev = stat("mean"), stat("stddev")
+		if out.Mean == nil || out.Stddev == nil {
+			out.Warnings = append(out.Warnings, "too few values to compute a standard deviation")
+			return out, nil
+		}
+		center, spread = *out.Mean, *out.Stddev
+		lower, upper = center-threshold*spread, center+threshold*spread
+	} else {
+		out.Q1, out.Q3 = stat("q1"), stat("q3")
+		if out.Q1 == nil || out.Q3 == nil {
+			out.Warnings = append(out.Warnings, "too few values to compute quartiles")
+			return out, nil
+		}
+		center, spread = (*out.Q1+*out.Q3)/2, *out.Q3-*out.Q1
+		lower, upper = *out.Q1-threshold*spread, *out.Q3+threshold*spread
+	}
+	if spread == 0 {
+		out.Warnings = append(out.Warnings, "the values have no spread, so no value can be scored")
+		return out, nil
+	}
+	out.Lower, out.Upper = &lower, &upper
+
+	res, err = c.query(ctx, outliersSQL(schema, table, c.timeCol(), column, lower, upper, center, limit), opts)
+	if err != nil {
+		return nil, err
+	}
+	scores := make([]float64, len(res.Rows))
+	for i, r := range res.Rows {
+		v, _ := toFloat(r[column])
+		switch {
+		case method == "zscore":
+			scores[i] = (v - center) / spread
+		case v < *out.Q1:
+			scores[i] = (v - *out.Q1) / spread
+		default:
+			scores[i] = (v - *out.Q3) / spread
+		}
+	}
+	if err := applyTypePolicy(res.Rows, typePolicyJSONSafe); err != nil {
+		return nil, err
+	}
+	for i, r := range orderedRows(res) {
+		out.Outliers = append(out.Outliers, outlierRow{Score: scores[i], Row: r})
+	}
+	out.Truncated = len(out.Outliers) == limit
+	return out, nil
+}
+
+func influxDBFindOutliers(ctx context.Context, args InfluxDBFindOutliersParams) (*outlierResult, error) {
+	var opts queryOptions
+	var err error
+	if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+		return nil, err
+	}
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	return cli.findOutliers(ctx, args.Database, args.Table, args.Column, args.Method, args.Threshold, args.Limit, opts)
+}
+
+var InfluxDBFindOutliers = mcpgrafana.MustTool(
+	"influxdb_find_outliers",
+	"InfluxDB v3 datasource: Finds the rows of a table whose value in a numeric column is an outlier within a time range, most extreme first, each with its score. Method 'zscore' (default) scores values by standard deviations from the mean and flags those beyond 3; 'iqr' scores them by interquartile ranges outside the quartiles and flags those beyond 1.5 (Tukey's fences), which is more robust for skewed data. The statistics and the bounds used are returned with the outliers. Both are computed by the datasource, so only the outlier rows are fetched.",
+	influxDBFindOutliers,
+)
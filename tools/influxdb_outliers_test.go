@@ -0,0 +1,80 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbFindOutliers(t *testing.T) {
+	columns := arrowResponse(t, data.NewFrame("",
+		data.NewField("column_name", nil, []string{"time", "host", "usage"}),
+		data.NewField("data_type", nil, []string{"Timestamp(Nanosecond, None)", "Dictionary(Int32, Utf8)", "Float64"}),
+		data.NewField("is_nullable", nil, []string{"NO", "YES", "YES"}),
+	))
+	handler := func(stats *data.Frame, sent *[]string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			sql := decodePayload(t, r).Queries[0].RawSQL
+			switch {
+			case strings.Contains(sql, "information_schema"):
+				_, _ = w.Write(columns)
+			case strings.Contains(sql, "COUNT("):
+				*sent = append(*sent, sql)
+				_, _ = w.Write(arrowResponse(t, stats))
+			default:
+				*sent = append(*sent, sql)
+				_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+					data.NewField("host", nil, []string{"web1", "web2"}),
+					data.NewField("usage", nil, []float64{1, 19}),
+				)))
+			}
+		}
+	}
+
+	t.Run("zscore", func(t *testing.T) {
+		var sent []string
+		stats := data.NewFrame("", data.NewField("n", nil, []int64{100}), data.NewField("mean", nil, []float64{10}), data.NewField("stddev", nil, []float64{2}))
+		cli := newTestInfluxdbClient(t, handler(stats, &sent))
+		res, err := cli.findOutliers(context.Background(), "", "cpu", "usage", "", 0, 0, queryOptions{})
+		require.NoError(t, err)
+		require.Len(t, sent, 2)
+		assert.Contains(t, sent[0], `AVG("usage") AS mean, STDDEV("usage") AS stddev`)
+		assert.Equal(t, `SELECT * FROM "cpu" WHERE $__timeFilter("time") AND ("usage" < 4 OR "usage" > 16) ORDER BY ABS("usage" - 10) DESC LIMIT 100`, sent[1])
+		assert.Equal(t, "zscore", res.Method)
+		assert.Equal(t, 3.0, res.Threshold)
+		assert.Equal(t, int64(100), res.Count)
+		require.Len(t, res.Outliers, 2)
+		assert.InDelta(t, -4.5, res.Outliers[0].Score, 1e-9)
+		assert.InDelta(t, 4.5, res.Outliers[1].Score, 1e-9)
+		assert.Equal(t, "web2", res.Outliers[1].Row.values["host"])
+	})
+
+	t.Run("iqr", func(t *testing.T) {
+		var sent []string
+		stats := data.NewFrame("", data.NewField("n", nil, []int64{100}), data.NewField("q1", nil, []float64{8}), data.NewField("q3", nil, []float64{12}))
+		cli := newTestInfluxdbClient(t, handler(stats, &sent))
+		res, err := cli.findOutliers(context.Background(), "", "cpu", "usage", "iqr", 0, 0, queryOptions{})
+		require.NoError(t, err)
+		assert.Contains(t, sent[0], `approx_percentile_cont("usage", 0.25) AS q1`)
+		assert.Contains(t, sent[1], `("usage" < 2 OR "usage" > 18)`)
+		require.Len(t, res.Outliers, 2)
+		assert.InDelta(t, -1.75, res.Outliers[0].Score, 1e-9)
+		assert.InDelta(t, 1.75, res.Outliers[1].Score, 1e-9)
+	})
+
+	t.Run("not numeric", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, respondWith(columns))
+		_, err := cli.findOutliers(context.Background(), "", "cpu", "host", "", 0, 0, queryOptions{})
+		assert.ErrorContains(t, err, "not numeric")
+		_, err = cli.findOutliers(context.Background(), "", "cpu", "usage", "mad", 0, 0, queryOptions{})
+		assert.ErrorContains(t, err, "invalid method")
+	})
+}
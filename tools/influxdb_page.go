@@ -0,0 +1,93 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errInvalidPageToken is returned for a page token which was not produced
+// for the query it is used with.
+var errInvalidPageToken = errors.New("invalid pageToken; restart paging without one")
+
+// pageToken is the decoded form of a keyset page token. Before is the time of
+// the last row of the previous page in Unix nanoseconds, and Sum binds it to
+// the query it was issued for.
+type pageToken struct {
+	Before int64  `json:"b"`
+	Sum    string `json:"s"`
+}
+
+// pageTokenSum checksums a page boundary together with the query. It makes
+// tokens tamper-evident rather than secret: anyone able to call the tool can
+// run arbitrary SQL anyway, the point is to reject edited tokens and tokens
+// reused with a different query, either of which would silently skip rows.
+func pageTokenSum(sql string, before int64) string {
+	h := sha256.Sum256([]byte(strconv.FormatInt(before, 10) + "\x00" + sql))
+	return hex.EncodeToString(h[:8])
+}
+
+// encodePageToken returns an opaque token resuming sql after boundary.
+func encodePageToken(sql string, boundary time.Time) string {
+	before := boundary.UnixNano()
+	b, _ := json.Marshal(pageToken{Before: before, Sum: pageTokenSum(sql, before)})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodePageToken returns the boundary encoded in token, which must have been
+// issued for sql.
+func decodePageToken(sql, token string) (time.Time, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, errInvalidPageToken
+	}
+	var pt pageToken
+	if err := json.Unmarshal(b, &pt); err != nil || pt.Sum != pageTokenSum(sql, pt.Before) {
+		return time.Time{}, errInvalidPageToken
+	}
+	return time.Unix(0, pt.Before).UTC(), nil
+}
+
+// keysetPageSQL wraps sql so that it returns the n newest rows older than
+// before, or the n newest rows if before is zero. The original statement runs
+// as a subquery so that its own WHERE clause is left intact.
+func keysetPageSQL(sql, timeColumn string, before time.Time, n int) string {
+	var b strings.Builder
+	b.WriteString("SELECT * FROM (\n")
+	b.WriteString(strings.TrimRight(strings.TrimSpace(sql), ";"))
+	b.WriteString("\n) AS page")
+	col := quoteIdent(timeColumn)
+	if !before.IsZero() {
+		fmt.Fprintf(&b, " WHERE %s < %s", col, quoteLiteral(before.UTC().Format(time.RFC3339Nano)))
+	}
+	fmt.Fprintf(&b, " ORDER BY %s DESC LIMIT %d", col, n)
+	return b.String()
+}
+
+// lastRowTime returns the value of column in the last row of res.
+func lastRowTime(res *queryResult, column string) (time.Time, error) {
+	var v any
+	if res.Data != nil {
+		values := res.Data[column]
+		if len(values) > 0 {
+			v = values[len(values)-1]
+		}
+	} else if len(res.Rows) > 0 {
+		v = res.Rows[len(res.Rows)-1][column]
+	}
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t != nil {
+			return *t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("paging requires a non-null time column %q in the result; set timeColumn", column)
+}
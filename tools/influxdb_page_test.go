@@ -0,0 +1,73 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeysetPaging(t *testing.T) {
+	const sql = "SELECT time, usage FROM cpu WHERE host = 'web1';"
+	first, err := pagedSQL(QueryInfluxSQLParams{SQL: sql, PageSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM (\nSELECT time, usage FROM cpu WHERE host = 'web1'\n) AS page ORDER BY \"time\" DESC LIMIT 2", first)
+
+	boundary := time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC)
+	res := &queryResult{
+		Columns: []string{"time", "usage"},
+		Rows: []map[string]any{
+			{"time": boundary.Add(time.Second), "usage": 0.5},
+			{"time": boundary, "usage": 0.75},
+		},
+	}
+	last, err := lastRowTime(res, "time")
+	require.NoError(t, err)
+	token := encodePageToken(sql, last)
+
+	next, err := pagedSQL(QueryInfluxSQLParams{SQL: sql, PageSize: 2, PageToken: token})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM (\nSELECT time, usage FROM cpu WHERE host = 'web1'\n) AS page WHERE \"time\" < '2024-05-01T10:00:00.123456789Z' ORDER BY \"time\" DESC LIMIT 2", next)
+
+	t.Run("tampered token", func(t *testing.T) {
+		b, err := base64.RawURLEncoding.DecodeString(token)
+		require.NoError(t, err)
+		var pt pageToken
+		require.NoError(t, json.Unmarshal(b, &pt))
+		pt.Before -= int64(time.Hour)
+		b, err = json.Marshal(pt)
+		require.NoError(t, err)
+		_, err = pagedSQL(QueryInfluxSQLParams{SQL: sql, PageSize: 2, PageToken: base64.RawURLEncoding.EncodeToString(b)})
+		assert.ErrorIs(t, err, errInvalidPageToken)
+		_, err = pagedSQL(QueryInfluxSQLParams{SQL: sql, PageSize: 2, PageToken: "not a token"})
+		assert.ErrorIs(t, err, errInvalidPageToken)
+	})
+
+	t.Run("token for another query", func(t *testing.T) {
+		_, err := pagedSQL(QueryInfluxSQLParams{SQL: "SELECT time FROM mem", PageSize: 2, PageToken: token})
+		assert.ErrorIs(t, err, errInvalidPageToken)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := pagedSQL(QueryInfluxSQLParams{SQL: sql, PageToken: token})
+		assert.EqualError(t, err, "pageToken requires pageSize")
+		_, err = pagedSQL(QueryInfluxSQLParams{SQL: sql, PageSize: 2, ChunkInterval: "1h"})
+		assert.EqualError(t, err, "pageSize cannot be combined with chunkInterval")
+		_, err = pagedSQL(QueryInfluxSQLParams{SQL: sql, PageSize: 2, MaxRows: 1})
+		assert.EqualError(t, err, "pageSize cannot be combined with maxRows; use a smaller pageSize")
+	})
+
+	t.Run("columnar result", func(t *testing.T) {
+		last, err := lastRowTime(&queryResult{Columns: []string{"time"}, Data: map[string][]any{"time": {boundary}}}, "time")
+		require.NoError(t, err)
+		assert.Equal(t, boundary, last)
+		_, err = lastRowTime(res, "ts")
+		assert.ErrorContains(t, err, `non-null time column "ts"`)
+	})
+}
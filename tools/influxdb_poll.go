@@ -0,0 +1,220 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+const (
+	// DefaultInfluxPollIntervalSeconds is the default delay between polls.
+	DefaultInfluxPollIntervalSeconds = 5
+	// DefaultInfluxPollTimeoutSeconds is the default time to poll before giving up.
+	DefaultInfluxPollTimeoutSeconds = 60
+	// MaxInfluxPollTimeoutSeconds is the maximum time a single call may poll for.
+	MaxInfluxPollTimeoutSeconds = 600
+)
+
+// scalarValue returns the first column of the first row of a result as a
+// number.
+func scalarValue(res *queryResult) (float64, error) {
+	if len(res.Rows) == 0 || len(res.Columns) == 0 {
+		return 0, fmt.Errorf("query returned no rows")
+	}
+	v, ok := toFloat(res.Rows[0][res.Columns[0]])
+	if !ok {
+		return 0, fmt.Errorf("column %q of the first row is not numeric", res.Columns[0])
+	}
+	return v, nil
+}
+
+// compareValues evaluates `value op target`.
+func compareValues(value float64, op string, target float64) (bool, error) {
+	switch op {
+	case ">":
+		return value > target, nil
+	case ">=":
+		return value >= target, nil
+	case "<":
+		return value < target, nil
+	case "<=":
+		return value <= target, nil
+	case "==", "=":
+		return value == target, nil
+	case "!=":
+		return value != target, nil
+	}
+	return false, fmt.Errorf("invalid comparison %q: must be one of >, >=, <, <=, ==, !=", op)
+}
+
+// pollIntervals resolves and bounds the poll interval and timeout given in
+// seconds.
+func pollIntervals(intervalSeconds, timeoutSeconds int) (time.Duration, time.Duration) {
+	if intervalSeconds <= 0 {
+		intervalSeconds = DefaultInfluxPollIntervalSeconds
+	}
+	if timeoutSeconds <= 0 {
+		timeoutSeconds = DefaultInfluxPollTimeoutSeconds
+	}
+	if timeoutSeconds > MaxInfluxPollTimeoutSeconds {
+		timeoutSeconds = MaxInfluxPollTimeoutSeconds
+	}
+	return time.Duration(intervalSeconds) * time.Second, time.Duration(timeoutSeconds) * time.Second
+}
+
+type PollInfluxDBUntilParams struct {
+	DatasourceUID       string  `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	SQL                 string  `json:"sql" jsonschema:"required,description=SQL returning a single numeric value in the first column of the first row"`
+	Comparison          string  `json:"comparison" jsonschema:"required,description=Comparison between the query value and target: one of >\\, >=\\, <\\, <=\\, == or !="`
+	Target              float64 `json:"target" jsonschema:"required,description=Value to compare the query result against"`
+	PollIntervalSeconds int     `json:"pollIntervalSeconds,omitempty" jsonschema:"description=Seconds between polls. Defaults to 5"`
+	TimeoutSeconds      int     `json:"timeoutSeconds,omitempty" jsonschema:"description=Seconds to poll before giving up. Defaults to 60\\, maximum 600"`
+}
+
+type pollResult struct {
+	Value        float64 `json:"value"`
+	ConditionMet bool    `json:"conditionMet"`
+	Attempts     int     `json:"attempts"`
+	ElapsedMs    int64   `json:"elapsedMs"`
+}
+
+// pollUntil runs a scalar query every interval until `value op target` holds
+// or timeout elapses. Reaching the timeout is not an error; the last value is
+// returned with ConditionMet false.
+func (c *influxdbClient) pollUntil(ctx context.Context, sql, op string, target float64, interval, timeout time.Duration) (*pollResult, error) {
+	if _, err := compareValues(0, op, target); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	// next is reset before each wait, so that the interval runs from the end
+	// of the previous attempt.
+	next := time.NewTimer(interval)
+	defer next.Stop()
+
+	result := &pollResult{}
+	for {
+		res, err := c.query(ctx, sql, queryOptions{})
+		if err != nil {
+			return nil, err
+		}
+		value, err := scalarValue(res)
+		if err != nil {
+			return nil, err
+		}
+		result.Attempts++
+		result.Value = value
+		result.ConditionMet, _ = compareValues(value, op, target)
+		result.ElapsedMs = time.Since(start).Milliseconds()
+		if result.ConditionMet {
+			return result, nil
+		}
+
+		next.Reset(interval)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return result, nil
+		case <-next.C:
+		}
+	}
+}
+
+func pollInfluxDBUntil(ctx context.Context, args PollInfluxDBUntilParams) (*pollResult, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	interval, timeout := pollIntervals(args.PollIntervalSeconds, args.TimeoutSeconds)
+	return cli.pollUntil(ctx, args.SQL, args.Comparison, args.Target, interval, timeout)
+}
+
+var PollInfluxDBUntil = mcpgrafana.MustTool(
+	"poll_influxdb_until",
+	"InfluxDB v3 datasource: Repeatedly runs a scalar SQL query until its value satisfies a comparison against a target (e.g. count >= 100) or the timeout elapses. Returns the last value, whether the condition was met, and the number of attempts.",
+	pollInfluxDBUntil,
+)
+
+// errNoDataBeforeTimeout is returned by waitForData when the query still
+// returns no rows once the timeout has elapsed.
+var errNoDataBeforeTimeout = errors.New("query returned no rows before the timeout")
+
+// waitForData runs sql every interval until it returns at least one row or
+// timeout elapses. The time range is resolved again on every attempt, so
+// that relative bounds such as 'now' move forward while waiting. It returns
+// the first non-empty result and the number of attempts made.
+func (c *influxdbClient) waitForData(ctx context.Context, sql string, timeRange func() (queryOptions, error), interval, timeout time.Duration) (*queryResult, int, error) {
+	start := time.Now()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	next := time.NewTimer(interval)
+	defer next.Stop()
+
+	for attempts := 1; ; attempts++ {
+		opts, err := timeRange()
+		if err != nil {
+			return nil, attempts, err
+		}
+		res, err := c.query(ctx, sql, opts)
+		if err != nil {
+			return nil, attempts, err
+		}
+		if res.numRows() > 0 {
+			return res, attempts, nil
+		}
+
+		next.Reset(interval)
+		select {
+		case <-ctx.Done():
+			return nil, attempts, ctx.Err()
+		case <-deadline.C:
+			return nil, attempts, fmt.Errorf("%w: %d attempts over %s", errNoDataBeforeTimeout, attempts, time.Since(start).Round(time.Millisecond))
+		case <-next.C:
+		}
+	}
+}
+
+type QueryInfluxDBWaitForDataParams struct {
+	DatasourceUID       string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	SQL                 string `json:"sql" jsonschema:"required,description=SQL statement to run until it returns rows"`
+	From                string `json:"from,omitempty" jsonschema:"description=Start of the time range as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-5m'. Relative bounds are re-evaluated on each attempt. Defaults to one hour before 'to'"`
+	To                  string `json:"to,omitempty" jsonschema:"description=End of the time range. Defaults to now"`
+	PollIntervalSeconds int    `json:"pollIntervalSeconds,omitempty" jsonschema:"description=Seconds between attempts. Defaults to 5"`
+	TimeoutSeconds      int    `json:"timeoutSeconds,omitempty" jsonschema:"description=Seconds to wait for data before failing. Defaults to 60\\, maximum 600"`
+}
+
+type waitForDataResult struct {
+	Rows     []orderedRow `json:"rows"`
+	Attempts int          `json:"attempts"`
+}
+
+func queryInfluxDBWaitForData(ctx context.Context, args QueryInfluxDBWaitForDataParams) (*waitForDataResult, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	timeRange := func() (opts queryOptions, err error) {
+		opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now())
+		return opts, err
+	}
+	interval, timeout := pollIntervals(args.PollIntervalSeconds, args.TimeoutSeconds)
+	res, attempts, err := cli.waitForData(ctx, args.SQL, timeRange, interval, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyTypePolicy(res.Rows, typePolicyJSONSafe); err != nil {
+		return nil, err
+	}
+	return &waitForDataResult{Rows: orderedRows(res), Attempts: attempts}, nil
+}
+
+var QueryInfluxDBWaitForData = mcpgrafana.MustTool(
+	"query_influxdb_sql_wait_for_data",
+	"InfluxDB v3 datasource: Runs an SQL query repeatedly until it returns at least one row, then returns the rows and the number of attempts. Fails if no rows appear before the timeout. Unlike poll_influxdb_until, which waits for a scalar condition, this waits for rows to be present, e.g. to verify that freshly written data has become queryable.",
+	queryInfluxDBWaitForData,
+)
@@ -0,0 +1,126 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbPollUntil(t *testing.T) {
+	// countingServer returns an increasing count on each request.
+	countingServer := func(t *testing.T) *influxdbClient {
+		var mu sync.Mutex
+		n := int64(0)
+		return newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			n++
+			v := n
+			mu.Unlock()
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("count", nil, []int64{v}))))
+		})
+	}
+
+	t.Run("condition met", func(t *testing.T) {
+		cli := countingServer(t)
+		res, err := cli.pollUntil(context.Background(), "SELECT COUNT(*) FROM t", ">=", 3, time.Millisecond, time.Second)
+		require.NoError(t, err)
+		assert.True(t, res.ConditionMet)
+		assert.Equal(t, 3.0, res.Value)
+		assert.Equal(t, 3, res.Attempts)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		cli := countingServer(t)
+		res, err := cli.pollUntil(context.Background(), "SELECT COUNT(*) FROM t", "==", -1, 5*time.Millisecond, 20*time.Millisecond)
+		require.NoError(t, err)
+		assert.False(t, res.ConditionMet)
+		assert.Greater(t, res.Attempts, 1)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		cli := countingServer(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		_, err := cli.pollUntil(ctx, "SELECT COUNT(*) FROM t", "<", 0, 5*time.Millisecond, time.Minute)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("invalid comparison", func(t *testing.T) {
+		cli := countingServer(t)
+		_, err := cli.pollUntil(context.Background(), "SELECT 1", "~", 0, time.Millisecond, time.Second)
+		assert.ErrorContains(t, err, "invalid comparison")
+	})
+
+	t.Run("non-numeric value", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, respondWith(arrowResponse(t, cpuFrame())))
+		_, err := cli.pollUntil(context.Background(), "SELECT host FROM cpu", ">", 0, time.Millisecond, time.Second)
+		assert.ErrorContains(t, err, "not numeric")
+	})
+}
+
+func TestInfluxdbWaitForData(t *testing.T) {
+	// emptyUntil returns rows from the nth request on, recording the time
+	// range of each request.
+	emptyUntil := func(t *testing.T, n int, froms *[]string) *influxdbClient {
+		var mu sync.Mutex
+		requests := 0
+		return newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			p := decodePayload(t, r)
+			mu.Lock()
+			requests++
+			ready := requests >= n
+			if froms != nil {
+				*froms = append(*froms, p.From)
+			}
+			mu.Unlock()
+			hosts := []string{}
+			if ready {
+				hosts = []string{"web1"}
+			}
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("host", nil, hosts))))
+		})
+	}
+	base := time.UnixMilli(1714564800000)
+	calls := 0
+	timeRange := func() (queryOptions, error) {
+		calls++
+		from := base.Add(time.Duration(calls) * time.Second)
+		return queryOptions{From: from, To: from.Add(time.Minute)}, nil
+	}
+
+	t.Run("data appears", func(t *testing.T) {
+		var froms []string
+		cli := emptyUntil(t, 3, &froms)
+		res, attempts, err := cli.waitForData(context.Background(), "SELECT host FROM cpu", timeRange, time.Millisecond, time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+		assert.Equal(t, []map[string]any{{"host": "web1"}}, res.Rows)
+		// The time range is resolved again for every attempt.
+		assert.Equal(t, []string{"1714564801000", "1714564802000", "1714564803000"}, froms)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		cli := emptyUntil(t, math.MaxInt, nil)
+		_, attempts, err := cli.waitForData(context.Background(), "SELECT host FROM cpu", timeRange, 5*time.Millisecond, 20*time.Millisecond)
+		require.ErrorIs(t, err, errNoDataBeforeTimeout)
+		assert.Greater(t, attempts, 1)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		cli := emptyUntil(t, math.MaxInt, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		_, _, err := cli.waitForData(ctx, "SELECT host FROM cpu", timeRange, 5*time.Millisecond, time.Minute)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,193 @@
+package tools
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+type InfluxDBSQLReferenceParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Refresh       bool   `json:"refresh,omitempty" jsonschema:"description=Read the function catalog again instead of returning the cached reference"`
+}
+
+type sqlFunction struct {
+	Name string `json:"name"`
+	// Type is "scalar", "aggregate", "window" or "table".
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Syntax      string `json:"syntax,omitempty"`
+}
+
+type sqlReference struct {
+	// Source is "information_schema.routines" if the functions were read
+	// from the datasource, or "builtin" for the curated list.
+	Source string `json:"source"`
+	// Version is what version() reported, if it is supported.
+	Version   string        `json:"version,omitempty"`
+	Functions []sqlFunction `json:"functions"`
+	Quirks    []string      `json:"quirks"`
+}
+
+// sqlRoutinesSQL reads the function catalog DataFusion exposes in
+// information_schema in recent versions.
+const sqlRoutinesSQL = "SELECT routine_name, function_type, description, syntax_example FROM information_schema.routines ORDER BY routine_name"
+
+// builtinSQLFunctions is the curated fallback for datasources without a
+// function catalog: the DataFusion and InfluxDB v3 functions most useful for
+// time series, all available since the first InfluxDB v3 releases.
+var builtinSQLFunctions = []sqlFunction{
+	{Name: "abs", Type: "scalar"}, {Name: "ceil", Type: "scalar"}, {Name: "floor", Type: "scalar"},
+	{Name: "round", Type: "scalar"}, {Name: "sqrt", Type: "scalar"}, {Name: "power", Type: "scalar"},
+	{Name: "ln", Type: "scalar"}, {Name: "log10", Type: "scalar"}, {Name: "random", Type: "scalar"},
+	{Name: "coalesce", Type: "scalar"}, {Name: "nullif", Type: "scalar"}, {Name: "greatest", Type: "scalar"},
+	{Name: "least", Type: "scalar"},
+	{Name: "concat", Type: "scalar"}, {Name: "lower", Type: "scalar"}, {Name: "upper", Type: "scalar"},
+	{Name: "length", Type: "scalar"}, {Name: "substr", Type: "scalar"}, {Name: "replace", Type: "scalar"},
+	{Name: "trim", Type: "scalar"}, {Name: "split_part", Type: "scalar"}, {Name: "starts_with", Type: "scalar"},
+	{Name: "regexp_like", Type: "scalar"}, {Name: "regexp_replace", Type: "scalar"},
+	{Name: "now", Type: "scalar"}, {Name: "date_bin", Type: "scalar", Syntax: "date_bin(INTERVAL '5 minutes', time)"},
+	{Name: "date_trunc", Type: "scalar", Syntax: "date_trunc('hour', time)"}, {Name: "date_part", Type: "scalar"},
+	{Name: "extract", Type: "scalar"}, {Name: "to_timestamp", Type: "scalar"}, {Name: "to_unixtime", Type: "scalar"},
+	{Name: "from_unixtime", Type: "scalar"}, {Name: "arrow_typeof", Type: "scalar"}, {Name: "arrow_cast", Type: "scalar"},
+	{Name: "date_bin_gapfill", Type: "scalar", Description: "InfluxDB: like date_bin, but with GROUP BY fills in missing buckets", Syntax: "date_bin_gapfill(INTERVAL '5 minutes', time)"},
+	{Name: "locf", Type: "scalar", Description: "InfluxDB: with date_bin_gapfill, fills gaps with the last observed value"},
+	{Name: "interpolate", Type: "scalar", Description: "InfluxDB: with date_bin_gapfill, fills gaps by linear interpolation"},
+	{Name: "count", Type: "aggregate"}, {Name: "sum", Type: "aggregate"}, {Name: "avg", Type: "aggregate"},
+	{Name: "min", Type: "aggregate"}, {Name: "max", Type: "aggregate"}, {Name: "median", Type: "aggregate"},
+	{Name: "stddev", Type: "aggregate"}, {Name: "var", Type: "aggregate"}, {Name: "array_agg", Type: "aggregate"},
+	{Name: "approx_distinct", Type: "aggregate"}, {Name: "approx_median", Type: "aggregate"},
+	{Name: "approx_percentile_cont", Type: "aggregate", Syntax: "approx_percentile_cont(usage, 0.99)"},
+	{Name: "first_value", Type: "aggregate"}, {Name: "last_value", Type: "aggregate"},
+	{Name: "selector_first", Type: "aggregate", Description: "InfluxDB: the first value and its time, as a struct with value and time fields", Syntax: "selector_first(usage, time)['value']"},
+	{Name: "selector_last", Type: "aggregate", Description: "InfluxDB: the last value and its time, as a struct with value and time fields", Syntax: "selector_last(usage, time)['value']"},
+	{Name: "selector_min", Type: "aggregate", Description: "InfluxDB: the minimum value and its time, as a struct with value and time fields"},
+	{Name: "selector_max", Type: "aggregate", Description: "InfluxDB: the maximum value and its time, as a struct with value and time fields"},
+	{Name: "row_number", Type: "window"}, {Name: "rank", Type: "window"}, {Name: "dense_rank", Type: "window"},
+	{Name: "lag", Type: "window"}, {Name: "lead", Type: "window"}, {Name: "ntile", Type: "window"},
+}
+
+// influxSQLQuirks are the ways InfluxDB v3 SQL differs from standard SQL
+// and from InfluxQL which agents most often trip over. They cannot be
+// probed, so they are always the built-in list.
+var influxSQLQuirks = []string{
+	"Measurements are tables in the 'iox' schema, which is the default; tags and fields are columns and the timestamp is the 'time' column.",
+	"Unquoted identifiers are lowercased: double-quote names with upper-case letters or special characters, e.g. \"Host\". Single quotes are string literals.",
+	"There is no GROUP BY time() as in InfluxQL: group by date_bin(INTERVAL '1 minute', time) instead, or date_bin_gapfill to fill empty buckets.",
+	"Tag columns are dictionary-encoded strings and may be null; compare them with string literals.",
+	"Queries without a time filter scan every partition and can be slow: filter on time, e.g. WHERE time >= now() - INTERVAL '1 hour'.",
+	"The selector_* functions return a struct; take its ['value'] or ['time'] field.",
+	"TABLESAMPLE is not supported; sample with WHERE random() < 0.01.",
+	"Only queries are supported through SQL: INSERT, UPDATE, DELETE and DDL are not, and data is written with line protocol.",
+	"information_schema.tables and information_schema.columns list the tables and columns; SHOW TABLES and SHOW COLUMNS FROM <table> are shorthands.",
+}
+
+// sqlReferenceCache holds the reference of each datasource, keyed by
+// influxdbClient.cacheKey, along with the version() it was read at, so that
+// an upgraded datasource is probed again. Only the latest version of each
+// datasource is kept.
+var sqlReferenceCache = struct {
+	sync.Mutex
+	byKey map[string]cachedSQLReference
+}{byKey: map[string]cachedSQLReference{}}
+
+type cachedSQLReference struct {
+	version string
+	ref     *sqlReference
+}
+
+// datasourceVersion returns what the datasource's version() reports, or ""
+// if it does not support it. It returns an error if the query failed for
+// another reason, so that whether it is supported is not known.
+func (c *influxdbClient) datasourceVersion(ctx context.Context) (string, error) {
+	res, err := c.query(ctx, "SELECT version() AS version", queryOptions{})
+	if err != nil && !probeRejected(err) {
+		return "", err
+	}
+	if err != nil || len(res.Rows) == 0 || isNil(res.Rows[0]["version"]) {
+		return "", nil
+	}
+	return formatCell(res.Rows[0]["version"]), nil
+}
+
+// sqlReference returns the functions the datasource supports, read from its
+// function catalog where it has one and otherwise the built-in list, along
+// with the known dialect quirks. The result is definitive, and may be
+// cached, unless reading the catalog failed for a reason other than the
+// datasource rejecting the query.
+func (c *influxdbClient) sqlReference(ctx context.Context, version string) (ref *sqlReference, definitive bool) {
+	ref = &sqlReference{Source: "builtin", Version: version, Functions: builtinSQLFunctions, Quirks: influxSQLQuirks}
+	res, err := c.query(ctx, sqlRoutinesSQL, queryOptions{})
+	if err != nil {
+		return ref, probeRejected(err)
+	}
+	if len(res.Rows) == 0 {
+		return ref, true
+	}
+	// The catalog lists a function once per signature.
+	byName := make(map[string]sqlFunction, len(res.Rows))
+	for _, row := range res.Rows {
+		name := formatCell(row["routine_name"])
+		if name == "" {
+			continue
+		}
+		f := byName[name]
+		f.Name = name
+		if f.Type == "" && !isNil(row["function_type"]) {
+			f.Type = strings.ToLower(formatCell(row["function_type"]))
+		}
+		if f.Description == "" && !isNil(row["description"]) {
+			f.Description = formatCell(row["description"])
+		}
+		if f.Syntax == "" && !isNil(row["syntax_example"]) {
+			f.Syntax = formatCell(row["syntax_example"])
+		}
+		byName[name] = f
+	}
+	ref.Source = "information_schema.routines"
+	ref.Functions = make([]sqlFunction, 0, len(byName))
+	for _, f := range byName {
+		ref.Functions = append(ref.Functions, f)
+	}
+	sort.Slice(ref.Functions, func(i, j int) bool { return ref.Functions[i].Name < ref.Functions[j].Name })
+	return ref, true
+}
+
+func influxDBSQLReference(ctx context.Context, args InfluxDBSQLReferenceParams) (*sqlReference, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	version, err := cli.datasourceVersion(ctx)
+	if err != nil {
+		// Without the version a cached reference may be stale, and a new
+		// one cannot be told apart from the next version's.
+		ref, _ := cli.sqlReference(ctx, "")
+		return ref, nil
+	}
+	key := cli.cacheKey()
+	if !args.Refresh {
+		sqlReferenceCache.Lock()
+		cached, ok := sqlReferenceCache.byKey[key]
+		sqlReferenceCache.Unlock()
+		if ok && cached.version == version {
+			return cached.ref, nil
+		}
+	}
+	ref, definitive := cli.sqlReference(ctx, version)
+	if definitive {
+		sqlReferenceCache.Lock()
+		sqlReferenceCache.byKey[key] = cachedSQLReference{version: version, ref: ref}
+		sqlReferenceCache.Unlock()
+	}
+	return ref, nil
+}
+
+var InfluxDBSQLReference = mcpgrafana.MustTool(
+	"influxdb_sql_reference",
+	"InfluxDB v3 datasource: Returns the SQL functions the datasource supports as {source, version, functions: [{name, type, description, syntax}], quirks}, where quirks lists how InfluxDB v3 SQL differs from standard SQL and InfluxQL. Functions are read from the datasource's information_schema.routines catalog where available (source 'information_schema.routines') and otherwise from a curated built-in list (source 'builtin'). Results are cached per datasource and version, except the built-in list when the catalog could not be read. Check it before using functions you are not sure the datasource has.",
+	influxDBSQLReference,
+)
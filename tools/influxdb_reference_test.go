@@ -0,0 +1,93 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxDBSQLReference(t *testing.T) {
+	var version string
+	var catalogQueries int
+	catalog, unavailable := true, ""
+	ctx := newTestGrafanaContext(t, func(w http.ResponseWriter, r *http.Request) {
+		sql := decodePayload(t, r).Queries[0].RawSQL
+		switch {
+		case unavailable != "" && strings.Contains(sql, unavailable):
+			w.WriteHeader(http.StatusBadGateway)
+		case strings.Contains(sql, "version()"):
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("version", nil, []string{version}))))
+		case strings.Contains(sql, "information_schema.routines") && catalog:
+			catalogQueries++
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+				data.NewField("routine_name", nil, []string{"abs", "abs", "avg"}),
+				data.NewField("function_type", nil, []string{"SCALAR", "SCALAR", "AGGREGATE"}),
+				data.NewField("description", nil, []*string{nil, ptrTo("Absolute value"), ptrTo("Average")}),
+				data.NewField("syntax_example", nil, []*string{ptrTo("abs(x)"), nil, nil}),
+			)))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write(readFixture(t, "query_error.json"))
+		}
+	})
+
+	version = "Apache DataFusion 43.0.0"
+	ref, err := influxDBSQLReference(ctx, InfluxDBSQLReferenceParams{DatasourceUID: "ref"})
+	require.NoError(t, err)
+	assert.Equal(t, "information_schema.routines", ref.Source)
+	assert.Equal(t, version, ref.Version)
+	assert.Equal(t, []sqlFunction{
+		{Name: "abs", Type: "scalar", Description: "Absolute value", Syntax: "abs(x)"},
+		{Name: "avg", Type: "aggregate", Description: "Average"},
+	}, ref.Functions)
+	assert.NotEmpty(t, ref.Quirks)
+
+	_, err = influxDBSQLReference(ctx, InfluxDBSQLReferenceParams{DatasourceUID: "ref"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, catalogQueries, "the reference is cached")
+
+	version = "Apache DataFusion 44.0.0"
+	_, err = influxDBSQLReference(ctx, InfluxDBSQLReferenceParams{DatasourceUID: "ref"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, catalogQueries, "a new version is probed again")
+
+	catalog = false
+	ref, err = influxDBSQLReference(ctx, InfluxDBSQLReferenceParams{DatasourceUID: "ref", Refresh: true})
+	require.NoError(t, err)
+	assert.Equal(t, "builtin", ref.Source)
+	assert.Contains(t, ref.Functions, sqlFunction{Name: "date_bin", Type: "scalar", Syntax: "date_bin(INTERVAL '5 minutes', time)"})
+	catalog = true
+	ref, err = influxDBSQLReference(ctx, InfluxDBSQLReferenceParams{DatasourceUID: "ref"})
+	require.NoError(t, err)
+	assert.Equal(t, "builtin", ref.Source, "a datasource rejecting the catalog query is cached")
+
+	t.Run("inconclusive failures are not cached", func(t *testing.T) {
+		unavailable, catalogQueries = "information_schema.routines", 0
+		ref, err := influxDBSQLReference(ctx, InfluxDBSQLReferenceParams{DatasourceUID: "uncached"})
+		require.NoError(t, err)
+		assert.Equal(t, "builtin", ref.Source)
+		unavailable = ""
+		ref, err = influxDBSQLReference(ctx, InfluxDBSQLReferenceParams{DatasourceUID: "uncached"})
+		require.NoError(t, err)
+		assert.Equal(t, "information_schema.routines", ref.Source)
+		assert.Equal(t, 1, catalogQueries)
+
+		unavailable = "version()"
+		ref, err = influxDBSQLReference(ctx, InfluxDBSQLReferenceParams{DatasourceUID: "uncached"})
+		require.NoError(t, err)
+		assert.Empty(t, ref.Version)
+		assert.Equal(t, 2, catalogQueries, "without the version the cache is not used")
+		unavailable = ""
+		ref, err = influxDBSQLReference(ctx, InfluxDBSQLReferenceParams{DatasourceUID: "uncached"})
+		require.NoError(t, err)
+		assert.Equal(t, version, ref.Version)
+		assert.Equal(t, 2, catalogQueries, "nor overwritten")
+	})
+}
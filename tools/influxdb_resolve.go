@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// influxdbDatasourceType is the plugin ID of Grafana's InfluxDB datasource.
+const influxdbDatasourceType = "influxdb"
+
+type ResolveInfluxDBDatasourceParams struct {
+	Name string `json:"name" jsonschema:"required,description=Display name of the InfluxDB datasource. Matched exactly\\, or ignoring case if no name matches exactly"`
+}
+
+// datasourceMatchError is returned when a name matches no InfluxDB
+// datasource or more than one. Candidates are the datasources the caller
+// can choose from: every match if the name is ambiguous, otherwise every
+// InfluxDB datasource.
+type datasourceMatchError struct {
+	Name       string
+	Ambiguous  bool
+	Candidates []dataSourceSummary
+}
+
+func (e *datasourceMatchError) Error() string {
+	names := make([]string, len(e.Candidates))
+	for i, ds := range e.Candidates {
+		names[i] = fmt.Sprintf("%q (uid %s)", ds.Name, ds.UID)
+	}
+	if e.Ambiguous {
+		return fmt.Sprintf("datasource name %q is ambiguous: it matches %s", e.Name, strings.Join(names, ", "))
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("no InfluxDB datasource is named %q, and there are no InfluxDB datasources", e.Name)
+	}
+	return fmt.Sprintf("no InfluxDB datasource is named %q; the InfluxDB datasources are %s", e.Name, strings.Join(names, ", "))
+}
+
+// matchDatasourceName returns the datasource named name: the exact match if
+// there is one, otherwise the only case-insensitive match.
+func matchDatasourceName(datasources []dataSourceSummary, name string) (dataSourceSummary, error) {
+	var folded []dataSourceSummary
+	for _, ds := range datasources {
+		if ds.Name == name {
+			return ds, nil
+		}
+		if strings.EqualFold(ds.Name, name) {
+			folded = append(folded, ds)
+		}
+	}
+	if len(folded) == 1 {
+		return folded[0], nil
+	}
+	if len(folded) > 1 {
+		return dataSourceSummary{}, &datasourceMatchError{Name: name, Ambiguous: true, Candidates: folded}
+	}
+	return dataSourceSummary{}, &datasourceMatchError{Name: name, Candidates: datasources}
+}
+
+func resolveInfluxDBDatasource(ctx context.Context, args ResolveInfluxDBDatasourceParams) (*dataSourceSummary, error) {
+	if args.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	c := mcpgrafana.GrafanaClientFromContext(ctx)
+	if c == nil {
+		return nil, errGrafanaURLNotConfigured
+	}
+	resp, err := c.Datasources.GetDataSources()
+	if err != nil {
+		return nil, fmt.Errorf("list datasources: %w", err)
+	}
+	var influx []dataSourceSummary
+	for _, ds := range summarizeDatasources(resp.Payload) {
+		if ds.Type == influxdbDatasourceType {
+			influx = append(influx, ds)
+		}
+	}
+	ds, err := matchDatasourceName(influx, args.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &ds, nil
+}
+
+var ResolveInfluxDBDatasource = mcpgrafana.MustTool(
+	"resolve_influxdb_datasource",
+	"Finds the UID of an InfluxDB datasource from its display name, for use as datasourceUid with the InfluxDB tools. Returns the datasource's id, uid, name, type and default status. If no datasource or more than one matches, the error lists the candidates to choose from.",
+	resolveInfluxDBDatasource,
+)
@@ -0,0 +1,46 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveInfluxDBDatasource(t *testing.T) {
+	ctx := newTestGrafanaContext(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/datasources", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]map[string]any{
+			{"id": 1, "uid": "prod", "name": "InfluxDB Prod", "type": "influxdb"},
+			{"id": 2, "uid": "staging-a", "name": "Staging", "type": "influxdb"},
+			{"id": 3, "uid": "staging-b", "name": "staging", "type": "influxdb"},
+			{"id": 4, "uid": "prom", "name": "Metrics", "type": "prometheus"},
+		})
+	})
+
+	ds, err := resolveInfluxDBDatasource(ctx, ResolveInfluxDBDatasourceParams{Name: "influxdb prod"})
+	require.NoError(t, err)
+	assert.Equal(t, "prod", ds.UID)
+
+	ds, err = resolveInfluxDBDatasource(ctx, ResolveInfluxDBDatasourceParams{Name: "staging"})
+	require.NoError(t, err)
+	assert.Equal(t, "staging-b", ds.UID, "an exact match wins over case-insensitive ones")
+
+	_, err = resolveInfluxDBDatasource(ctx, ResolveInfluxDBDatasourceParams{Name: "STAGING"})
+	var me *datasourceMatchError
+	require.ErrorAs(t, err, &me)
+	assert.True(t, me.Ambiguous)
+	assert.Len(t, me.Candidates, 2)
+	assert.ErrorContains(t, err, "staging-a")
+
+	_, err = resolveInfluxDBDatasource(ctx, ResolveInfluxDBDatasourceParams{Name: "Metrics"})
+	require.ErrorAs(t, err, &me)
+	assert.False(t, me.Ambiguous)
+	assert.Len(t, me.Candidates, 3, "only InfluxDB datasources are candidates")
+}
@@ -0,0 +1,84 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+// Sampling methods reported in samplingInfo.
+const (
+	samplingServer = "server"
+	samplingClient = "client"
+)
+
+// samplingInfo describes how a sampled result was produced.
+type samplingInfo struct {
+	Rate float64 `json:"rate"`
+	// Method is "server" if the datasource filtered rows with random(), or
+	// "client" if every row was fetched and sampled after decoding.
+	Method string `json:"method"`
+	// Reason explains why client-side sampling was used.
+	Reason string `json:"reason,omitempty"`
+}
+
+// sampledSQL wraps sql so that the datasource keeps each row with probability
+// rate. DataFusion, which InfluxDB v3 uses, does not implement TABLESAMPLE,
+// so random() is used instead.
+func sampledSQL(sql string, rate float64) string {
+	return fmt.Sprintf("SELECT * FROM (\n%s\n) AS sample WHERE random() < %s",
+		strings.TrimRight(strings.TrimSpace(sql), ";"), floatLiteral(rate))
+}
+
+// sampleRows keeps each row with probability rate, preserving order. random
+// returns values in [0, 1).
+func sampleRows(rows []map[string]any, rate float64, random func() float64) []map[string]any {
+	out := make([]map[string]any, 0, int(float64(len(rows))*rate)+1)
+	for _, row := range rows {
+		if random() < rate {
+			out = append(out, row)
+		}
+	}
+	return out
+}
+
+// supportsRandom reports whether random() may be used with the client's
+// datasource. It is only false if a capability probe found it unsupported.
+func (c *influxdbClient) supportsRandom() bool {
+	supported, probed := c.cachedCapabilities()["random"]
+	return supported || !probed
+}
+
+// querySampled runs sql returning a random subset of about rate of its rows.
+// Sampling is done by the datasource where possible; if it rejects the
+// sampled statement the query is run unsampled and rows are sampled here,
+// without the default limit so that the sample is drawn from every row.
+func (c *influxdbClient) querySampled(ctx context.Context, sql string, rate float64, opts queryOptions) (*queryResult, error) {
+	if rate <= 0 || rate > 1 {
+		return nil, fmt.Errorf("sampleRate must be greater than 0 and at most 1, got %g", rate)
+	}
+	reason := "the datasource does not support random()"
+	if c.supportsRandom() {
+		res, err := c.queryLimited(ctx, sampledSQL(sql, rate), opts)
+		if err == nil {
+			res.Sampling = &samplingInfo{Rate: rate, Method: samplingServer}
+			return res, nil
+		}
+		var qe *InfluxQueryError
+		if !errors.As(err, &qe) || qe.Category != QueryErrorBadRequest {
+			return nil, err
+		}
+		reason = fmt.Sprintf("the datasource rejected the sampled query: %v", err)
+	}
+
+	opts.Columnar = false
+	res, err := c.query(ctx, sql, opts)
+	if err != nil {
+		return nil, err
+	}
+	res.Rows = sampleRows(res.Rows, rate, rand.Float64)
+	res.Sampling = &samplingInfo{Rate: rate, Method: samplingClient, Reason: reason}
+	return res, nil
+}
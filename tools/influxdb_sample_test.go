@@ -0,0 +1,98 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbQuerySampled(t *testing.T) {
+	t.Run("server side", func(t *testing.T) {
+		var sqls []string
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sqls = append(sqls, decodePayload(t, r).Queries[0].RawSQL)
+			_, _ = w.Write(arrowResponse(t, cpuFrame()))
+		})
+		res, err := cli.querySampled(context.Background(), "SELECT * FROM cpu;", 0.25, queryOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"SELECT * FROM (\nSELECT * FROM cpu\n) AS sample WHERE random() < 0.25"}, sqls)
+		assert.Equal(t, &samplingInfo{Rate: 0.25, Method: samplingServer}, res.Sampling)
+	})
+
+	t.Run("small rates are written without an exponent", func(t *testing.T) {
+		assert.True(t, strings.HasSuffix(sampledSQL("SELECT * FROM cpu", 0.00001), "random() < 0.00001"))
+	})
+
+	t.Run("client side fallback", func(t *testing.T) {
+		var sqls []string
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sql := decodePayload(t, r).Queries[0].RawSQL
+			sqls = append(sqls, sql)
+			if strings.Contains(sql, "random()") {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"results":{"A":{"error":"Invalid function 'random'","status":400}}}`))
+				return
+			}
+			_, _ = w.Write(arrowResponse(t, cpuFrame()))
+		})
+		res, err := cli.querySampled(context.Background(), "SELECT * FROM cpu", 1, queryOptions{})
+		require.NoError(t, err)
+		require.Len(t, sqls, 2)
+		assert.Equal(t, "SELECT * FROM cpu", sqls[1])
+		assert.Len(t, res.Rows, 2)
+		require.NotNil(t, res.Sampling)
+		assert.Equal(t, samplingClient, res.Sampling.Method)
+		assert.Contains(t, res.Sampling.Reason, "Invalid function 'random'")
+	})
+
+	t.Run("client side fallback ignores the default limit", func(t *testing.T) {
+		var sqls []string
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sql := decodePayload(t, r).Queries[0].RawSQL
+			sqls = append(sqls, sql)
+			if strings.Contains(sql, "random()") {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"results":{"A":{"error":"Invalid function 'random'","status":400}}}`))
+				return
+			}
+			_, _ = w.Write(arrowResponse(t, cpuFrame()))
+		})
+		cli.defaultLimit = 1
+		res, err := cli.querySampled(context.Background(), "SELECT * FROM cpu", 1, queryOptions{})
+		require.NoError(t, err)
+		require.Len(t, sqls, 2)
+		assert.Equal(t, "SELECT * FROM cpu", sqls[1], "every row is fetched to sample from")
+		assert.Len(t, res.Rows, 2)
+		assert.Zero(t, res.AppliedLimit)
+	})
+
+	t.Run("other errors are returned", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+		_, err := cli.querySampled(context.Background(), "SELECT * FROM cpu", 0.5, queryOptions{})
+		var qe *InfluxQueryError
+		require.ErrorAs(t, err, &qe)
+		assert.Equal(t, QueryErrorAuth, qe.Category)
+	})
+
+	t.Run("invalid rate", func(t *testing.T) {
+		_, err := (&influxdbClient{}).querySampled(context.Background(), "SELECT 1", 1.5, queryOptions{})
+		assert.EqualError(t, err, "sampleRate must be greater than 0 and at most 1, got 1.5")
+	})
+}
+
+func TestSampleRows(t *testing.T) {
+	rows := []map[string]any{{"n": 0}, {"n": 1}, {"n": 2}, {"n": 3}}
+	draws := []float64{0.1, 0.9, 0.4, 0.6}
+	i := 0
+	sampled := sampleRows(rows, 0.5, func() float64 { i++; return draws[i-1] })
+	assert.Equal(t, []map[string]any{{"n": 0}, {"n": 2}}, sampled)
+}
@@ -0,0 +1,212 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// defaultInfluxSchema is the schema InfluxDB v3 stores measurement tables in.
+const defaultInfluxSchema = "iox"
+
+// tableQueryConcurrency bounds how many per-table queries run at once.
+const tableQueryConcurrency = 4
+
+// listTables returns the names of the tables in the given schema.
+func (c *influxdbClient) listTables(ctx context.Context, schema string) ([]string, error) {
+	if schema == "" {
+		schema = defaultInfluxSchema
+	}
+	sql := fmt.Sprintf(
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = %s ORDER BY table_name",
+		quoteLiteral(schema),
+	)
+	res, err := c.query(ctx, sql, queryOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+	tables := make([]string, 0, len(res.Rows))
+	for _, row := range res.Rows {
+		if name := formatCell(row["table_name"]); name != "" {
+			tables = append(tables, name)
+		}
+	}
+	return tables, nil
+}
+
+type InfluxDBTableRowCountsParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Database      string `json:"database,omitempty" jsonschema:"description=Schema to list tables from. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+}
+
+type tableRowCount struct {
+	Table string `json:"table"`
+	// Rows is -1 if the table could not be counted.
+	Rows  int64  `json:"rows"`
+	Error string `json:"error,omitempty"`
+}
+
+// tableRowCounts counts the rows of every table in schema with bounded
+// concurrency. Tables which fail to count are reported rather than failing
+// the whole call.
+func (c *influxdbClient) tableRowCounts(ctx context.Context, schema string) ([]tableRowCount, error) {
+	tables, err := c.listTables(ctx, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, tableQueryConcurrency)
+		counts = make([]tableRowCount, len(tables))
+	)
+	for i, table := range tables {
+		wg.Add(1)
+		go func(i int, table string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			counts[i] = tableRowCount{Table: table, Rows: -1}
+			n, err := c.countRows(ctx, schema, table)
+			if err != nil {
+				counts[i].Error = err.Error()
+				return
+			}
+			counts[i].Rows = n
+		}(i, table)
+	}
+	wg.Wait()
+	return counts, nil
+}
+
+// countRows counts the rows of a single table.
+func (c *influxdbClient) countRows(ctx context.Context, schema, table string) (int64, error) {
+	sql := fmt.Sprintf("SELECT COUNT(*) AS row_count FROM %s", qualifiedTable(schema, table))
+	res, err := c.query(ctx, sql, queryOptions{})
+	if err != nil {
+		return 0, err
+	}
+	if len(res.Rows) == 0 {
+		return 0, fmt.Errorf("count query returned no rows")
+	}
+	n, ok := toFloat(res.Rows[0]["row_count"])
+	if !ok {
+		return 0, fmt.Errorf("count query returned a non-numeric value")
+	}
+	return int64(n), nil
+}
+
+func influxDBTableRowCounts(ctx context.Context, args InfluxDBTableRowCountsParams) ([]tableRowCount, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	return cli.tableRowCounts(ctx, args.Database)
+}
+
+var InfluxDBTableRowCounts = mcpgrafana.MustTool(
+	"influxdb_table_row_counts",
+	"InfluxDB v3 datasource: Lists the tables in a database and counts the rows in each one. Returns an array of {table, rows}; tables which cannot be counted (e.g. due to permissions) report rows as -1 with an error note. Note: counting scans every table and can be slow for large databases.",
+	influxDBTableRowCounts,
+)
+
+// defaultTableSearchLimit is the number of tables returned by
+// search_influxdb_tables unless the caller asks for another limit.
+const defaultTableSearchLimit = 100
+
+// likePattern converts a table search pattern to an SQL LIKE pattern using
+// '\' as the escape character. A pattern containing '*' or '?' is a glob
+// which must match the whole name; anything else matches as a substring.
+func likePattern(pattern string) string {
+	glob := strings.ContainsAny(pattern, "*?")
+	var b strings.Builder
+	if !glob {
+		b.WriteByte('%')
+	}
+	for _, r := range pattern {
+		switch {
+		case r == '%' || r == '_' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case glob && r == '*':
+			b.WriteByte('%')
+		case glob && r == '?':
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if !glob {
+		b.WriteByte('%')
+	}
+	return b.String()
+}
+
+type SearchInfluxDBTablesParams struct {
+	DatasourceUID   string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Pattern         string `json:"pattern" jsonschema:"required,description=Text the table name contains\\, or a glob matching the whole name using * for any characters and ? for one character (e.g. 'cpu_*')"`
+	Database        string `json:"database,omitempty" jsonschema:"description=Schema to search. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+	CaseInsensitive bool   `json:"caseInsensitive,omitempty" jsonschema:"description=Match the pattern regardless of case"`
+	Limit           int    `json:"limit,omitempty" jsonschema:"description=Maximum number of tables to return. Defaults to 100"`
+}
+
+type tableSearchResult struct {
+	Tables []string `json:"tables"`
+	// Truncated is set if more tables match than were returned.
+	Truncated bool `json:"truncated"`
+}
+
+// searchTables returns the tables in schema whose name matches pattern, in
+// name order. The pattern is passed as a quoted literal, since ds/query has
+// no bind parameters.
+func (c *influxdbClient) searchTables(ctx context.Context, schema, pattern string, caseInsensitive bool, limit int) (*tableSearchResult, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern is required; use influxdb_table_row_counts to list every table")
+	}
+	if schema == "" {
+		schema = defaultInfluxSchema
+	}
+	if limit <= 0 {
+		limit = defaultTableSearchLimit
+	}
+	op := "LIKE"
+	if caseInsensitive {
+		op = "ILIKE"
+	}
+	sql := fmt.Sprintf(
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = %s AND table_name %s %s ESCAPE '\\' ORDER BY table_name LIMIT %d",
+		quoteLiteral(schema), op, quoteLiteral(likePattern(pattern)), limit+1,
+	)
+	res, err := c.query(ctx, sql, queryOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("searching tables: %w", err)
+	}
+	out := &tableSearchResult{Tables: make([]string, 0, len(res.Rows))}
+	for _, row := range res.Rows {
+		if name := formatCell(row["table_name"]); name != "" {
+			out.Tables = append(out.Tables, name)
+		}
+	}
+	if len(out.Tables) > limit {
+		out.Tables, out.Truncated = out.Tables[:limit], true
+	}
+	return out, nil
+}
+
+func searchInfluxDBTables(ctx context.Context, args SearchInfluxDBTablesParams) (*tableSearchResult, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	return cli.searchTables(ctx, args.Database, args.Pattern, args.CaseInsensitive, args.Limit)
+}
+
+var SearchInfluxDBTables = mcpgrafana.MustTool(
+	"search_influxdb_tables",
+	"InfluxDB v3 datasource: Finds tables (measurements) whose name contains a substring or matches a glob such as 'cpu_*', optionally ignoring case. Returns the matching names in order, with truncated set if there are more than the limit. Use this instead of listing every table in large databases.",
+	searchInfluxDBTables,
+)
@@ -0,0 +1,73 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbTableRowCounts(t *testing.T) {
+	cli := newTestInfluxdbClient(t, sqlRouter(t, map[string][]byte{
+		"information_schema.tables": arrowResponse(t, data.NewFrame("",
+			data.NewField("table_name", nil, []string{"cpu", "mem", "secret"}),
+		)),
+		`FROM "cpu"`: arrowResponse(t, data.NewFrame("", data.NewField("row_count", nil, []int64{42}))),
+		`FROM "mem"`: arrowResponse(t, data.NewFrame("", data.NewField("row_count", nil, []int64{7}))),
+	}))
+
+	tables, err := cli.listTables(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cpu", "mem", "secret"}, tables)
+
+	counts, err := cli.tableRowCounts(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, counts, 3)
+	assert.Equal(t, int64(42), counts[0].Rows)
+	assert.Equal(t, int64(7), counts[1].Rows)
+	assert.Equal(t, int64(-1), counts[2].Rows)
+	assert.Contains(t, counts[2].Error, "unexpected query")
+}
+
+func TestLikePattern(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"cpu":      "%cpu%",
+		"cpu_*":    `cpu\_%`,
+		"disk?":    "disk_",
+		"100%":     `%100\%%`,
+		`back\ref`: `%back\\ref%`,
+	} {
+		assert.Equal(t, want, likePattern(pattern), pattern)
+	}
+}
+
+func TestInfluxdbSearchTables(t *testing.T) {
+	var sqls []string
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sqls = append(sqls, decodePayload(t, r).Queries[0].RawSQL)
+		_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+			data.NewField("table_name", nil, []string{"cpu_total", "cpu_user", "cpu_system"}),
+		)))
+	})
+
+	res, err := cli.searchTables(context.Background(), "", "cpu_*", false, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cpu_total", "cpu_user", "cpu_system"}, res.Tables)
+	assert.False(t, res.Truncated)
+	assert.Equal(t, `SELECT table_name FROM information_schema.tables WHERE table_schema = 'iox' AND table_name LIKE 'cpu\_%' ESCAPE '\' ORDER BY table_name LIMIT 101`, sqls[0])
+
+	res, err = cli.searchTables(context.Background(), "metrics", "it's", true, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cpu_total", "cpu_user"}, res.Tables)
+	assert.True(t, res.Truncated)
+	assert.Equal(t, `SELECT table_name FROM information_schema.tables WHERE table_schema = 'metrics' AND table_name ILIKE '%it''s%' ESCAPE '\' ORDER BY table_name LIMIT 3`, sqls[1])
+
+	_, err = cli.searchTables(context.Background(), "", "", false, 0)
+	assert.Error(t, err)
+}
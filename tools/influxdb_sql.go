@@ -0,0 +1,69 @@
+package tools
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// quoteIdent quotes an identifier such as a table or column name for use in
+// InfluxDB v3 SQL, escaping embedded double quotes.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes a string literal for use in InfluxDB v3 SQL, escaping
+// embedded single quotes.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// floatLiteral formats f as an SQL numeric literal, without an exponent.
+func floatLiteral(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// qualifiedTable returns the quoted name of table in schema. Tables in the
+// default schema are left unqualified, as InfluxDB v3 resolves them there.
+func qualifiedTable(schema, table string) string {
+	if schema == "" || schema == defaultInfluxSchema {
+		return quoteIdent(table)
+	}
+	return quoteIdent(schema) + "." + quoteIdent(table)
+}
+
+// withDefaultLimit appends LIMIT limit to a single SELECT statement which
+// does not already limit its rows at the top level, reporting whether it did.
+// Statements it cannot be sure about, such as several statements or ones
+// with an OFFSET, are left as they are.
+func withDefaultLimit(sql string, limit int) (string, bool) {
+	if limit <= 0 {
+		return sql, false
+	}
+	trimmed := strings.TrimRight(strings.TrimSpace(sql), ";")
+	tokens := tokenizeSQL(trimmed)
+	if len(tokens) == 0 || tokens[0].quoted {
+		return sql, false
+	}
+	if first := strings.ToLower(tokens[0].text); first != "select" && first != "with" {
+		return sql, false
+	}
+	depth := 0
+	for _, tok := range tokens {
+		switch {
+		case tok.text == "(":
+			depth++
+		case tok.text == ")":
+			depth--
+		case tok.text == ";":
+			return sql, false
+		case depth == 0 && tok.ident && !tok.quoted:
+			switch strings.ToLower(tok.text) {
+			case "limit", "offset", "fetch":
+				return sql, false
+			}
+		}
+	}
+	// On its own line so that a trailing line comment cannot swallow it.
+	return fmt.Sprintf("%s\nLIMIT %d", trimmed, limit), true
+}
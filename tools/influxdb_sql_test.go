@@ -0,0 +1,77 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteIdent(t *testing.T) {
+	for name, want := range map[string]string{
+		"cpu":             `"cpu"`,
+		"CPU":             `"CPU"`,
+		`say "hi"`:        `"say ""hi"""`,
+		`"`:               `""""`,
+		"disk used":       `"disk used"`,
+		"select":          `"select"`,
+		"time":            `"time"`,
+		"a.b":             `"a.b"`,
+		"x; DROP TABLE y": `"x; DROP TABLE y"`,
+		"":                `""`,
+	} {
+		assert.Equal(t, want, quoteIdent(name), name)
+	}
+	assert.Equal(t, `'it''s'`, quoteLiteral("it's"))
+	assert.Equal(t, `"my ""db"""."order"`, qualifiedTable(`my "db"`, "order"))
+	assert.Equal(t, `"order"`, qualifiedTable("iox", "order"))
+
+	// Builders interpolating identifiers quote them.
+	hostile := `x" FROM t; --`
+	escaped := `"x"" FROM t; --"`
+	for name, sql := range map[string]string{
+		"columnStatsSQL":      columnStatsSQL("iox", hostile, hostile, []string{hostile}, false),
+		"populatedColumnsSQL": populatedColumnsSQL("iox", hostile, hostile, []string{hostile}),
+		"tailSQL":             tailSQL("iox", hostile, hostile, "", time.Time{}),
+		"keysetPageSQL":       keysetPageSQL("SELECT 1", hostile, time.Time{}, 1),
+	} {
+		assert.Contains(t, sql, escaped, name)
+		assert.NotContains(t, strings.ReplaceAll(sql, escaped, ""), `x"`, name)
+	}
+}
+
+func TestWithDefaultLimit(t *testing.T) {
+	for _, tc := range []struct {
+		sql, want string
+	}{
+		{"SELECT * FROM cpu", "SELECT * FROM cpu\nLIMIT 100"},
+		{"select host from cpu;", "select host from cpu\nLIMIT 100"},
+		{"SELECT * FROM cpu -- newest", "SELECT * FROM cpu -- newest\nLIMIT 100"},
+		{"WITH t AS (SELECT * FROM cpu LIMIT 5) SELECT * FROM t", "WITH t AS (SELECT * FROM cpu LIMIT 5) SELECT * FROM t\nLIMIT 100"},
+		{"SELECT * FROM (SELECT * FROM cpu LIMIT 5) AS s", "SELECT * FROM (SELECT * FROM cpu LIMIT 5) AS s\nLIMIT 100"},
+		{"SELECT 'limit' AS \"limit\" FROM cpu", "SELECT 'limit' AS \"limit\" FROM cpu\nLIMIT 100"},
+		{"SELECT * FROM cpu LIMIT 10", ""},
+		{"SELECT * FROM cpu limit 10 OFFSET 5", ""},
+		{"SELECT * FROM cpu OFFSET 5", ""},
+		{"SHOW TABLES", ""},
+		{"EXPLAIN SELECT * FROM cpu", ""},
+		{"SELECT 1; SELECT 2", ""},
+		{"", ""},
+	} {
+		got, ok := withDefaultLimit(tc.sql, 100)
+		if tc.want == "" {
+			assert.False(t, ok, tc.sql)
+			assert.Equal(t, tc.sql, got)
+			continue
+		}
+		assert.True(t, ok, tc.sql)
+		assert.Equal(t, tc.want, got)
+	}
+
+	_, ok := withDefaultLimit("SELECT * FROM cpu", 0)
+	assert.False(t, ok, "a zero limit disables it")
+}
@@ -0,0 +1,435 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// isNumericType reports whether an information_schema data type, as reported
+// by InfluxDB v3 using Arrow type names, is numeric.
+func isNumericType(dataType string) bool {
+	t := strings.ToLower(dataType)
+	for _, prefix := range []string{"int", "uint", "float", "decimal"} {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNumericColumn returns an error unless column exists in the table and
+// is numeric.
+func (c *influxdbClient) checkNumericColumn(ctx context.Context, schema, table, column string) error {
+	columns, err := c.describeTable(ctx, schema, table)
+	if err != nil {
+		return err
+	}
+	for _, col := range columns {
+		if col.Name != column {
+			continue
+		}
+		if !isNumericType(col.DataType) {
+			return fmt.Errorf("column %q is not numeric (%s)", column, col.DataType)
+		}
+		return nil
+	}
+	return fmt.Errorf("column %q not found in table %q", column, table)
+}
+
+// maxSummaryDistinct is the number of distinct values up to which a result
+// summary counts the values of a string or boolean column. Columns with more
+// are only reported as high-cardinality, which bounds the memory used.
+const maxSummaryDistinct = 20
+
+// resultSummary describes a query result so that an agent need not scan its
+// rows; see summarizeResult.
+type resultSummary struct {
+	RowCount int             `json:"rowCount"`
+	Columns  []columnSummary `json:"columns"`
+}
+
+type columnSummary struct {
+	Name string `json:"name"`
+	// Type is "number", "string", "bool", "time" or "other", from the
+	// column's first non-null value, or "null" if it has none.
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+	Nulls int    `json:"nulls"`
+	// Min, Max and Mean are set for numeric columns.
+	Min  *float64 `json:"min,omitempty"`
+	Max  *float64 `json:"max,omitempty"`
+	Mean *float64 `json:"mean,omitempty"`
+	// Earliest and Latest are set for time columns.
+	Earliest string `json:"earliest,omitempty"`
+	Latest   string `json:"latest,omitempty"`
+	// Distinct and Values, the number of rows with each value, are set for
+	// string and boolean columns with at most maxSummaryDistinct distinct
+	// values; HighCardinality is set for those with more.
+	Distinct        int            `json:"distinct,omitempty"`
+	Values          map[string]int `json:"values,omitempty"`
+	HighCardinality bool           `json:"highCardinality,omitempty"`
+	// Redacted is set for redactColumns and pseudonymize columns, whose
+	// values are not summarized.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// summaryType returns the summary type of a non-null value.
+func summaryType(v any) string {
+	if _, ok := v.(bool); ok {
+		return "bool"
+	}
+	if b, ok := v.(*bool); ok && b != nil {
+		return "bool"
+	}
+	if _, ok := v.(time.Time); ok {
+		return "time"
+	}
+	if t, ok := v.(*time.Time); ok && t != nil {
+		return "time"
+	}
+	if _, ok := toFloat(v); ok {
+		return "number"
+	}
+	switch v.(type) {
+	case string, *string:
+		return "string"
+	}
+	return "other"
+}
+
+// summarizeColumn summarizes the value of column col in each of n rows.
+func summarizeColumn(col string, n int, value func(i int) any) columnSummary {
+	cs := columnSummary{Name: col, Type: "null"}
+	var (
+		sum, lo, hi      = 0.0, math.Inf(1), math.Inf(-1)
+		numbers          int
+		earliest, latest time.Time
+		counts           = map[string]int{}
+	)
+	for i := 0; i < n; i++ {
+		v := value(i)
+		if isNil(v) {
+			cs.Nulls++
+			continue
+		}
+		cs.Count++
+		if cs.Type == "null" {
+			cs.Type = summaryType(v)
+		}
+		switch cs.Type {
+		case "number":
+			if f, ok := toFloat(v); ok && !math.IsNaN(f) {
+				numbers++
+				sum += f
+				lo, hi = math.Min(lo, f), math.Max(hi, f)
+			}
+		case "time":
+			if t, ok := toTime(v); ok {
+				if earliest.IsZero() || t.Before(earliest) {
+					earliest = t
+				}
+				if t.After(latest) {
+					latest = t
+				}
+			}
+		case "string", "bool":
+			if cs.HighCardinality {
+				continue
+			}
+			k := formatCell(v)
+			if _, seen := counts[k]; !seen && len(counts) == maxSummaryDistinct {
+				cs.HighCardinality = true
+				counts = nil
+				continue
+			}
+			counts[k]++
+		}
+	}
+	switch {
+	case numbers > 0:
+		mean := sum / float64(numbers)
+		cs.Min, cs.Max, cs.Mean = &lo, &hi, &mean
+	case !earliest.IsZero():
+		cs.Earliest = earliest.UTC().Format(time.RFC3339Nano)
+		cs.Latest = latest.UTC().Format(time.RFC3339Nano)
+	case len(counts) > 0:
+		cs.Distinct, cs.Values = len(counts), counts
+	}
+	return cs
+}
+
+// nullCounts are the number of null or empty values of each column of a
+// decoded result with Rows rows; see countNulls.
+type nullCounts struct {
+	Rows    int
+	Columns map[string]int
+}
+
+// isNullOrEmpty reports whether v is null, including a nil pointer from a
+// nullable field, or an empty string.
+func isNullOrEmpty(v any) bool {
+	switch s := v.(type) {
+	case string:
+		return s == ""
+	case *string:
+		return s == nil || *s == ""
+	}
+	return isNil(v)
+}
+
+// countNulls counts the null or empty values of each column of a freshly
+// decoded result, however it was decoded.
+func countNulls(res *queryResult) *nullCounts {
+	n := res.numRows()
+	out := &nullCounts{Rows: n, Columns: make(map[string]int, len(res.Columns))}
+	for _, col := range res.Columns {
+		nulls := 0
+		if res.Data != nil {
+			for _, v := range res.Data[col] {
+				if isNullOrEmpty(v) {
+					nulls++
+				}
+			}
+		} else {
+			for _, row := range res.Rows {
+				if isNullOrEmpty(row[col]) {
+					nulls++
+				}
+			}
+		}
+		out.Columns[col] = nulls
+	}
+	return out
+}
+
+// add returns the counts of nc and other combined, for results whose rows
+// are concatenated. Either may be nil.
+func (nc *nullCounts) add(other *nullCounts) *nullCounts {
+	if nc == nil {
+		return other
+	}
+	if other == nil {
+		return nc
+	}
+	out := &nullCounts{Rows: nc.Rows + other.Rows, Columns: maps.Clone(nc.Columns)}
+	for col, n := range other.Columns {
+		if _, ok := out.Columns[col]; !ok {
+			// The column is missing from the earlier rows: they are all null.
+			out.Columns[col] = nc.Rows
+		}
+		out.Columns[col] += n
+	}
+	for col := range nc.Columns {
+		if _, ok := other.Columns[col]; !ok {
+			out.Columns[col] += other.Rows
+		}
+	}
+	return out
+}
+
+// nullRates returns the fraction of null values of each column the options
+// of args return, under the name args gives it. A result without rows has a
+// rate of 0 for every column.
+func nullRates(nc *nullCounts, args QueryInfluxSQLParams) (map[string]float64, error) {
+	fold, err := keyCaseFold(args.KeyCase)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]float64, len(nc.Columns))
+	for col, n := range nc.Columns {
+		if len(args.SelectColumns) > 0 && !slices.Contains(args.SelectColumns, col) {
+			continue
+		}
+		var rate float64
+		if nc.Rows > 0 {
+			rate = float64(n) / float64(nc.Rows)
+		}
+		if fold != nil {
+			col = fold(col)
+		}
+		out[col] = rate
+	}
+	return out, nil
+}
+
+// summarizeResult computes a summary of every column of res which the
+// options of args return, under the name args gives it. It runs before rows
+// are truncated, so it describes the whole result. Redacted and pseudonymized
+// columns only have their counts reported.
+func summarizeResult(res *queryResult, args QueryInfluxSQLParams) (*resultSummary, error) {
+	fold, err := keyCaseFold(args.KeyCase)
+	if err != nil {
+		return nil, err
+	}
+	n := res.numRows()
+	out := &resultSummary{RowCount: n, Columns: []columnSummary{}}
+	for _, col := range res.Columns {
+		if len(args.SelectColumns) > 0 && !slices.Contains(args.SelectColumns, col) {
+			continue
+		}
+		value := func(i int) any { return res.Rows[i][col] }
+		if res.Data != nil {
+			value = func(i int) any { return res.Data[col][i] }
+		}
+		redacted := slices.Contains(args.RedactColumns, col) || slices.Contains(args.Pseudonymize, col)
+		var cs columnSummary
+		if redacted {
+			cs = columnSummary{Name: col, Type: "other", Redacted: true}
+			for i := 0; i < n; i++ {
+				if isNil(value(i)) {
+					cs.Nulls++
+				} else {
+					cs.Count++
+				}
+			}
+		} else {
+			cs = summarizeColumn(col, n, value)
+		}
+		if fold != nil {
+			cs.Name = fold(cs.Name)
+		}
+		out.Columns = append(out.Columns, cs)
+	}
+	return out, nil
+}
+
+type InfluxDBColumnStatsParams struct {
+	DatasourceUID string   `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table         string   `json:"table" jsonschema:"required,description=Table (measurement) to profile"`
+	Columns       []string `json:"columns" jsonschema:"required,description=Numeric columns to compute statistics for"`
+	Database      string   `json:"database,omitempty" jsonschema:"description=Schema the table is in. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+	From          string   `json:"from,omitempty" jsonschema:"description=If set\\, only rows at or after this time are included. Epoch milliseconds or a relative expression like 'now-6h'"`
+	To            string   `json:"to,omitempty" jsonschema:"description=If set\\, only rows before this time are included. Defaults to now when from is set"`
+	TimeColumn    string   `json:"timeColumn,omitempty" jsonschema:"description=Name of the table's time column. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type columnStats struct {
+	Count  int64    `json:"count"`
+	Min    *float64 `json:"min"`
+	Max    *float64 `json:"max"`
+	Avg    *float64 `json:"avg"`
+	Stddev *float64 `json:"stddev"`
+}
+
+type columnStatsResult struct {
+	Stats map[string]columnStats `json:"stats"`
+	// Skipped holds the reason each requested column was not profiled.
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// columnStatsSQL builds a single statement computing count, min, max, avg and
+// stddev of every column. Results are aliased by position so that column
+// names never need to appear in aliases.
+func columnStatsSQL(schema, table, timeColumn string, columns []string, timeFilter bool) string {
+	exprs := make([]string, 0, 5*len(columns))
+	for i, col := range columns {
+		q := quoteIdent(col)
+		exprs = append(exprs,
+			fmt.Sprintf("COUNT(%s) AS count_%d", q, i),
+			fmt.Sprintf("MIN(%s) AS min_%d", q, i),
+			fmt.Sprintf("MAX(%s) AS max_%d", q, i),
+			fmt.Sprintf("AVG(%s) AS avg_%d", q, i),
+			fmt.Sprintf("STDDEV(%s) AS stddev_%d", q, i),
+		)
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), qualifiedTable(schema, table))
+	if timeFilter {
+		sql += fmt.Sprintf(" WHERE $__timeFilter(%s)", quoteIdent(timeColumn))
+	}
+	return sql
+}
+
+// columnStats computes statistics for the numeric columns of a table in one
+// query. Columns which do not exist or are not numeric are skipped and
+// reported. If opts has a time range, only rows within it are included.
+func (c *influxdbClient) columnStats(ctx context.Context, schema, table string, columns []string, opts queryOptions) (*columnStatsResult, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("at least one column is required")
+	}
+	described, err := c.describeTable(ctx, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(described))
+	for _, col := range described {
+		types[col.Name] = col.DataType
+	}
+
+	out := &columnStatsResult{Stats: make(map[string]columnStats)}
+	var numeric []string
+	for _, col := range columns {
+		dataType, ok := types[col]
+		switch {
+		case !ok:
+			out.skip(col, "column not found")
+		case !isNumericType(dataType):
+			out.skip(col, fmt.Sprintf("not numeric (%s)", dataType))
+		default:
+			numeric = append(numeric, col)
+		}
+	}
+	if len(numeric) == 0 {
+		return out, nil
+	}
+
+	res, err := c.query(ctx, columnStatsSQL(schema, table, c.timeCol(), numeric, !opts.From.IsZero()), opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Rows) == 0 {
+		return nil, fmt.Errorf("statistics query returned no rows")
+	}
+	row := res.Rows[0]
+	value := func(name string, i int) *float64 {
+		if f, ok := toFloat(row[fmt.Sprintf("%s_%d", name, i)]); ok {
+			return &f
+		}
+		return nil
+	}
+	for i, col := range numeric {
+		var s columnStats
+		if n := value("count", i); n != nil {
+			s.Count = int64(*n)
+		}
+		s.Min, s.Max, s.Avg, s.Stddev = value("min", i), value("max", i), value("avg", i), value("stddev", i)
+		out.Stats[col] = s
+	}
+	return out, nil
+}
+
+func (r *columnStatsResult) skip(col, reason string) {
+	if r.Skipped == nil {
+		r.Skipped = make(map[string]string)
+	}
+	r.Skipped[col] = reason
+}
+
+func influxDBColumnStats(ctx context.Context, args InfluxDBColumnStatsParams) (*columnStatsResult, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	var opts queryOptions
+	if args.From != "" {
+		if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return cli.columnStats(ctx, args.Database, args.Table, args.Columns, opts)
+}
+
+var InfluxDBColumnStats = mcpgrafana.MustTool(
+	"influxdb_column_stats",
+	"InfluxDB v3 datasource: Computes count, min, max, average and standard deviation of numeric columns of a table in a single query. Returns the statistics per column; requested columns which do not exist or are not numeric are listed under 'skipped' with the reason.",
+	influxDBColumnStats,
+)
@@ -0,0 +1,175 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbColumnStats(t *testing.T) {
+	var statsSQL string
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sql := decodePayload(t, r).Queries[0].RawSQL
+		if strings.Contains(sql, "information_schema.columns") {
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+				data.NewField("column_name", nil, []string{"time", "host", "usage", `we"ird`}),
+				data.NewField("data_type", nil, []string{"Timestamp(Nanosecond, None)", "Dictionary(Int32, Utf8)", "Float64", "Int64"}),
+				data.NewField("is_nullable", nil, []string{"NO", "YES", "YES", "YES"}),
+			)))
+			return
+		}
+		statsSQL = sql
+		_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+			data.NewField("count_0", nil, []int64{10}),
+			data.NewField("min_0", nil, []float64{0.1}),
+			data.NewField("max_0", nil, []float64{0.9}),
+			data.NewField("avg_0", nil, []float64{0.5}),
+			data.NewField("stddev_0", nil, []*float64{nil}),
+			data.NewField("count_1", nil, []int64{0}),
+			data.NewField("min_1", nil, []*int64{nil}),
+			data.NewField("max_1", nil, []*int64{nil}),
+			data.NewField("avg_1", nil, []*float64{nil}),
+			data.NewField("stddev_1", nil, []*float64{nil}),
+		)))
+	})
+
+	out, err := cli.columnStats(context.Background(), "", "cpu", []string{"usage", `we"ird`, "host", "nope"}, queryOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, statsSQL, `MIN("usage") AS min_0`)
+	assert.Contains(t, statsSQL, `STDDEV("we""ird") AS stddev_1`)
+	assert.NotContains(t, statsSQL, "$__timeFilter")
+
+	f := func(v float64) *float64 { return &v }
+	assert.Equal(t, columnStats{Count: 10, Min: f(0.1), Max: f(0.9), Avg: f(0.5)}, out.Stats["usage"])
+	assert.Equal(t, columnStats{}, out.Stats[`we"ird`])
+	assert.Equal(t, map[string]string{
+		"host": "not numeric (Dictionary(Int32, Utf8))",
+		"nope": "column not found",
+	}, out.Skipped)
+
+	_, err = cli.columnStats(context.Background(), "", "cpu", []string{"usage"}, queryOptions{From: time.UnixMilli(0), To: time.UnixMilli(1)})
+	require.NoError(t, err)
+	assert.Contains(t, statsSQL, `WHERE $__timeFilter("time")`)
+}
+
+func TestSummarizeResult(t *testing.T) {
+	newResult := func() *queryResult {
+		res := &queryResult{Columns: []string{"time", "host", "usage", "id", "secret", "up"}}
+		for i := 0; i < 30; i++ {
+			row := map[string]any{
+				"time":   time.UnixMilli(1714564800000 + int64(i)*60000),
+				"host":   []string{"web1", "web2", "web1"}[i%3],
+				"usage":  ptrTo(float64(i)),
+				"id":     fmt.Sprintf("id-%d", i),
+				"secret": "s3cret",
+				"up":     i%2 == 0,
+			}
+			if i == 0 {
+				row["usage"] = (*float64)(nil)
+			}
+			res.Rows = append(res.Rows, row)
+		}
+		return res
+	}
+
+	res := newResult()
+	require.NoError(t, transformResult(res, QueryInfluxSQLParams{Summarize: true, MaxRows: 5, RedactColumns: []string{"secret"}}))
+	assert.Len(t, res.Rows, 5)
+	s := res.Summary
+	require.NotNil(t, s)
+	assert.Equal(t, 30, s.RowCount, "the summary covers rows cut by maxRows")
+	require.Len(t, s.Columns, 6)
+
+	byName := map[string]columnSummary{}
+	for _, c := range s.Columns {
+		byName[c.Name] = c
+	}
+	assert.Equal(t, columnSummary{Name: "time", Type: "time", Count: 30, Earliest: "2024-05-01T12:00:00Z", Latest: "2024-05-01T12:29:00Z"}, byName["time"])
+	assert.Equal(t, columnSummary{Name: "host", Type: "string", Count: 30, Distinct: 2, Values: map[string]int{"web1": 20, "web2": 10}}, byName["host"])
+	assert.Equal(t, columnSummary{Name: "usage", Type: "number", Count: 29, Nulls: 1, Min: ptrTo(1.0), Max: ptrTo(29.0), Mean: ptrTo(15.0)}, byName["usage"])
+	assert.Equal(t, columnSummary{Name: "id", Type: "string", Count: 30, HighCardinality: true}, byName["id"])
+	assert.Equal(t, columnSummary{Name: "secret", Type: "other", Count: 30, Redacted: true}, byName["secret"], "redacted values are not summarized")
+	assert.Equal(t, map[string]int{"true": 15, "false": 15}, byName["up"].Values)
+
+	res = newResult()
+	require.NoError(t, transformResult(res, QueryInfluxSQLParams{Summarize: true, SelectColumns: []string{"host"}, KeyCase: "upper"}))
+	require.Len(t, res.Summary.Columns, 1)
+	assert.Equal(t, "HOST", res.Summary.Columns[0].Name)
+
+	out, err := formatResult(res, QueryInfluxSQLParams{Summarize: true})
+	require.NoError(t, err)
+	assert.Equal(t, res.Summary, out.(queryResponse).Summary)
+
+	md, err := formatResult(res, QueryInfluxSQLParams{Summarize: true, Format: "markdown"})
+	require.NoError(t, err)
+	assert.Contains(t, md, "- HOST (string): 30 values, 0 nulls; 2 distinct: web1 (20), web2 (10)")
+}
+
+func TestQueryInfluxSQLNullRates(t *testing.T) {
+	one, two := 1.0, 2.0
+	ctx := newTestGrafanaContext(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+			data.NewField("host", nil, []string{"web1", "", "web3", "web4"}),
+			data.NewField("usage", nil, []*float64{&one, nil, nil, &two}),
+			data.NewField("region", nil, []*string{nil, nil, nil, nil}),
+			data.NewField("count", nil, []int64{1, 2, 3, 4}),
+		)))
+	})
+	args := QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT * FROM cpu", NullRates: true}
+
+	out, err := queryInfluxSQL(ctx, args)
+	require.NoError(t, err)
+	resp, ok := out.(queryResponse)
+	require.True(t, ok, "nullRates returns the envelope")
+	assert.Equal(t, map[string]float64{"host": 0.25, "usage": 0.5, "region": 1, "count": 0}, resp.NullRates)
+
+	t.Run("covers rows dropped by maxRows", func(t *testing.T) {
+		args := args
+		args.MaxRows = 1
+		out, err := queryInfluxSQL(ctx, args)
+		require.NoError(t, err)
+		assert.Equal(t, 0.5, out.(queryResponse).NullRates["usage"])
+	})
+
+	t.Run("follows selectColumns and keyCase", func(t *testing.T) {
+		args := args
+		args.SelectColumns = []string{"usage"}
+		args.KeyCase = "upper"
+		out, err := queryInfluxSQL(ctx, args)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]float64{"USAGE": 0.5}, out.(queryResponse).NullRates)
+	})
+
+	t.Run("columnar", func(t *testing.T) {
+		args := args
+		args.Columnar = true
+		out, err := queryInfluxSQL(ctx, args)
+		require.NoError(t, err)
+		assert.Equal(t, 0.25, out.(columnarResponse).NullRates["host"])
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		args := args
+		args.Format = "markdown"
+		out, err := queryInfluxSQL(ctx, args)
+		require.NoError(t, err)
+		assert.Contains(t, out, "_Null rates: host 25.0%, usage 50.0%, region 100.0%, count 0.0%._")
+	})
+}
+
+func TestNullCountsAdd(t *testing.T) {
+	a := &nullCounts{Rows: 2, Columns: map[string]int{"host": 1}}
+	b := &nullCounts{Rows: 3, Columns: map[string]int{"host": 0, "usage": 1}}
+	assert.Equal(t, &nullCounts{Rows: 5, Columns: map[string]int{"host": 1, "usage": 3}}, a.add(b))
+	assert.Equal(t, b, (*nullCounts)(nil).add(b))
+}
@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+const (
+	// DefaultInfluxRecentQueriesLimit is the default number of recent queries returned.
+	DefaultInfluxRecentQueriesLimit = 20
+	// MaxInfluxRecentQueriesLimit is the maximum number of recent queries returned.
+	MaxInfluxRecentQueriesLimit = 100
+)
+
+// errSystemTableUnsupported is returned when the datasource does not expose a
+// system table a tool relies on.
+var errSystemTableUnsupported = errors.New("not supported by this datasource")
+
+// hasTable reports whether schema.table is listed in information_schema.
+func (c *influxdbClient) hasTable(ctx context.Context, schema, table string) (bool, error) {
+	sql := fmt.Sprintf(
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = %s AND table_name = %s",
+		quoteLiteral(schema), quoteLiteral(table),
+	)
+	res, err := c.query(ctx, sql, queryOptions{})
+	if err != nil {
+		return false, err
+	}
+	return len(res.Rows) > 0, nil
+}
+
+type InfluxDBRecentQueriesParams struct {
+	DatasourceUID string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	OrderBy       string `json:"orderBy,omitempty" jsonschema:"description=Either 'duration' (default) to return the most expensive queries first or 'recent' to return the newest first"`
+	Limit         int    `json:"limit,omitempty" jsonschema:"description=Maximum number of queries to return. Defaults to 20\\, maximum 100"`
+}
+
+// recentQueries returns recent query statistics from the system.queries
+// table, which InfluxDB v3 uses to log queries it has executed.
+func (c *influxdbClient) recentQueries(ctx context.Context, orderBy string, limit int) ([]map[string]any, error) {
+	if limit <= 0 {
+		limit = DefaultInfluxRecentQueriesLimit
+	}
+	if limit > MaxInfluxRecentQueriesLimit {
+		limit = MaxInfluxRecentQueriesLimit
+	}
+	var order string
+	switch orderBy {
+	case "", "duration":
+		order = "end2end_duration DESC"
+	case "recent":
+		order = "issue_time DESC"
+	default:
+		return nil, fmt.Errorf("invalid orderBy %q: must be 'duration' or 'recent'", orderBy)
+	}
+
+	ok, err := c.hasTable(ctx, "system", "queries")
+	if err != nil {
+		return nil, fmt.Errorf("checking for system.queries: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("recent query statistics are %w: system.queries is not available", errSystemTableUnsupported)
+	}
+
+	sql := fmt.Sprintf(
+		"SELECT issue_time, query_type, query_text, end2end_duration, success, running FROM %s ORDER BY %s LIMIT %d",
+		qualifiedTable("system", "queries"), order, limit,
+	)
+	res, err := c.query(ctx, sql, queryOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Rows, nil
+}
+
+func influxDBRecentQueries(ctx context.Context, args InfluxDBRecentQueriesParams) ([]map[string]any, error) {
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	return cli.recentQueries(ctx, args.OrderBy, args.Limit)
+}
+
+var InfluxDBRecentQueries = mcpgrafana.MustTool(
+	"influxdb_recent_queries",
+	"InfluxDB v3 datasource: Lists recently executed queries with their text, type, end-to-end duration and outcome, read from the system.queries table. Useful for finding expensive queries. Returns an error if the datasource does not expose query statistics.",
+	influxDBRecentQueries,
+)
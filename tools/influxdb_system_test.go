@@ -0,0 +1,49 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbRecentQueries(t *testing.T) {
+	t.Run("supported", func(t *testing.T) {
+		var sent string
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sql := decodePayload(t, r).Queries[0].RawSQL
+			if strings.Contains(sql, "information_schema") {
+				_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("table_name", nil, []string{"queries"}))))
+				return
+			}
+			sent = sql
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+				data.NewField("query_text", nil, []string{"SELECT * FROM cpu"}),
+				data.NewField("success", nil, []bool{true}),
+			)))
+		})
+		rows, err := cli.recentQueries(context.Background(), "recent", 500)
+		require.NoError(t, err)
+		assert.Equal(t, "SELECT * FROM cpu", rows[0]["query_text"])
+		assert.Contains(t, sent, "ORDER BY issue_time DESC LIMIT 100")
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, respondWith(arrowResponse(t, data.NewFrame("", data.NewField("table_name", nil, []string{})))))
+		_, err := cli.recentQueries(context.Background(), "", 0)
+		assert.ErrorIs(t, err, errSystemTableUnsupported)
+	})
+
+	t.Run("invalid order", func(t *testing.T) {
+		cli := newTestInfluxdbClient(t, respondWith(nil))
+		_, err := cli.recentQueries(context.Background(), "rows", 0)
+		assert.ErrorContains(t, err, "invalid orderBy")
+	})
+}
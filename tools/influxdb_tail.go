@@ -0,0 +1,222 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// minTailPollInterval bounds how often a tail queries the datasource.
+	minTailPollInterval = time.Second
+	// defaultTailOverlap is how far behind the newest row seen each poll
+	// looks again, to pick up rows which arrive late or are timestamped by a
+	// writer whose clock is behind.
+	defaultTailOverlap = 5 * time.Second
+	// defaultTailMaxRows bounds the rows returned by a single tail.
+	defaultTailMaxRows = 1000
+)
+
+type TailInfluxDBTableParams struct {
+	DatasourceUID       string `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table               string `json:"table" jsonschema:"required,description=Table (measurement) to follow"`
+	Database            string `json:"database,omitempty" jsonschema:"description=Schema the table is in. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+	Where               string `json:"where,omitempty" jsonschema:"description=Optional SQL condition rows must match\\, e.g. \"host = 'web1'\""`
+	PollIntervalSeconds int    `json:"pollIntervalSeconds,omitempty" jsonschema:"description=Seconds between polls. Defaults to 5\\, minimum 1"`
+	DurationSeconds     int    `json:"durationSeconds,omitempty" jsonschema:"description=Seconds to follow the table for. Defaults to 60\\, maximum 600"`
+	OverlapSeconds      int    `json:"overlapSeconds,omitempty" jsonschema:"description=Seconds before the newest row seen which each poll reads again so that late rows are not missed. Rows already returned are not repeated. Defaults to 5"`
+	MaxRows             int    `json:"maxRows,omitempty" jsonschema:"description=Stop once this many new rows have been returned. Defaults to 1000"`
+	TimeColumn          string `json:"timeColumn,omitempty" jsonschema:"description=Name of the table's time column. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type tailResult struct {
+	Rows []orderedRow `json:"rows"`
+	// HighWater is the time of the newest row seen, or of the newest row in
+	// the table when the tail started if no rows arrived.
+	HighWater string `json:"highWater,omitempty"`
+	Polls     int    `json:"polls"`
+	// Truncated is set if the tail stopped because maxRows was reached.
+	Truncated bool `json:"truncated"`
+}
+
+// tailOptions bounds a tail; see TailInfluxDBTableParams.
+type tailOptions struct {
+	Interval, Duration, Overlap time.Duration
+	MaxRows                     int
+}
+
+// tailCondition combines the high-water filter with the caller's condition.
+func tailCondition(timeColumn, where string, after time.Time) string {
+	var conds []string
+	if !after.IsZero() {
+		conds = append(conds, fmt.Sprintf("%s > %s", quoteIdent(timeColumn), quoteLiteral(after.UTC().Format(time.RFC3339Nano))))
+	}
+	if where = strings.TrimSpace(where); where != "" {
+		conds = append(conds, "("+where+")")
+	}
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// tailHighWaterSQL returns the time of the newest matching row.
+func tailHighWaterSQL(schema, table, timeColumn, where string) string {
+	return fmt.Sprintf("SELECT MAX(%s) AS high_water FROM %s%s",
+		quoteIdent(timeColumn), qualifiedTable(schema, table), tailCondition(timeColumn, where, time.Time{}))
+}
+
+// tailSQL returns the matching rows newer than after, oldest first.
+func tailSQL(schema, table, timeColumn, where string, after time.Time) string {
+	return fmt.Sprintf("SELECT * FROM %s%s ORDER BY %s",
+		qualifiedTable(schema, table), tailCondition(timeColumn, where, after), quoteIdent(timeColumn))
+}
+
+// tail follows a table, returning the rows written after it started. The
+// starting point is the newest row according to the datasource rather than
+// the local clock, so clock skew between this server and InfluxDB cannot
+// hide rows. Each poll reads again from opts.Overlap before the newest row
+// seen and drops rows already returned, so rows arriving slightly out of
+// order are still picked up; exact duplicate rows within the overlap are
+// returned once. emit, if set, is called with each batch of new rows.
+func (c *influxdbClient) tail(ctx context.Context, schema, table, where string, opts tailOptions, emit func([]map[string]any)) (*queryResult, time.Time, int, error) {
+	res, err := c.query(ctx, tailHighWaterSQL(schema, table, c.timeCol(), where), queryOptions{})
+	if err != nil {
+		return nil, time.Time{}, 0, err
+	}
+	var start time.Time
+	if len(res.Rows) > 0 {
+		start, _ = toTime(res.Rows[0]["high_water"])
+	}
+
+	deadline := time.NewTimer(opts.Duration)
+	defer deadline.Stop()
+	next := time.NewTimer(opts.Interval)
+	defer next.Stop()
+	out := &queryResult{}
+	highWater := start
+	// seen holds the rows within the overlap which were returned, or which
+	// were already in the table at the start, by rowKey.
+	seen := map[string]time.Time{}
+	// The first poll runs immediately. Rows it finds up to the starting
+	// point were already there and are only marked as seen.
+	for polls := 0; ; polls++ {
+		if polls > 0 {
+			next.Reset(opts.Interval)
+			select {
+			case <-ctx.Done():
+				return nil, highWater, polls, ctx.Err()
+			case <-deadline.C:
+				return out, highWater, polls, nil
+			case <-next.C:
+			}
+		}
+
+		var after time.Time
+		if !highWater.IsZero() {
+			after = highWater.Add(-opts.Overlap)
+		}
+		res, err := c.query(ctx, tailSQL(schema, table, c.timeCol(), where, after), queryOptions{})
+		if err != nil {
+			return nil, highWater, polls + 1, err
+		}
+		if out.Columns == nil {
+			out.Columns = res.Columns
+		}
+		var batch []map[string]any
+		for _, row := range res.Rows {
+			key := rowKey(row)
+			if _, dup := seen[key]; dup {
+				continue
+			}
+			ts, _ := toTime(row[c.timeCol()])
+			seen[key] = ts
+			if ts.After(highWater) {
+				highWater = ts
+			}
+			if polls == 0 && !ts.After(start) {
+				continue
+			}
+			batch = append(batch, row)
+			if len(out.Rows)+len(batch) == opts.MaxRows {
+				break
+			}
+		}
+		for key, ts := range seen {
+			if !ts.After(highWater.Add(-opts.Overlap)) {
+				delete(seen, key)
+			}
+		}
+		if len(batch) > 0 {
+			if err := applyTypePolicy(batch, typePolicyJSONSafe); err != nil {
+				return nil, highWater, polls + 1, err
+			}
+			if emit != nil {
+				emit(batch)
+			}
+			out.Rows = append(out.Rows, batch...)
+		}
+		if len(out.Rows) >= opts.MaxRows {
+			out.TotalSeen = len(out.Rows)
+			return out, highWater, polls + 1, nil
+		}
+	}
+}
+
+// tailNotifier returns a function sending each batch of tailed rows to the
+// client as a log message, or nil if the context has no MCP session.
+func tailNotifier(ctx context.Context, table string) func([]map[string]any) {
+	srv := server.ServerFromContext(ctx)
+	if srv == nil || server.ClientSessionFromContext(ctx) == nil {
+		return nil
+	}
+	return func(rows []map[string]any) {
+		_ = srv.SendNotificationToClient(ctx, "notifications/message", map[string]any{
+			"level":  "info",
+			"logger": "tail_influxdb_table",
+			"data":   map[string]any{"table": table, "rows": rows},
+		})
+	}
+}
+
+func tailInfluxDBTable(ctx context.Context, args TailInfluxDBTableParams) (*tailResult, error) {
+	interval, duration := pollIntervals(args.PollIntervalSeconds, args.DurationSeconds)
+	opts := tailOptions{
+		Interval: max(interval, minTailPollInterval),
+		Duration: duration,
+		Overlap:  defaultTailOverlap,
+		MaxRows:  args.MaxRows,
+	}
+	if args.OverlapSeconds > 0 {
+		opts.Overlap = time.Duration(args.OverlapSeconds) * time.Second
+	}
+	if opts.MaxRows <= 0 {
+		opts.MaxRows = defaultTailMaxRows
+	}
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	res, highWater, polls, err := cli.tail(ctx, args.Database, args.Table, args.Where, opts, tailNotifier(ctx, args.Table))
+	if err != nil {
+		return nil, err
+	}
+	out := &tailResult{Rows: orderedRows(res), Polls: polls, Truncated: res.TotalSeen > 0}
+	if !highWater.IsZero() {
+		out.HighWater = highWater.UTC().Format(time.RFC3339Nano)
+	}
+	return out, nil
+}
+
+var TailInfluxDBTable = mcpgrafana.MustTool(
+	"tail_influxdb_table",
+	"InfluxDB v3 datasource: Follows a table like 'tail -f', polling for rows newer than the newest one seen and returning the rows written while it ran, oldest first. Each batch of new rows is also sent to the client as a log notification as it arrives. Stops after durationSeconds or once maxRows rows have arrived. Rows already in the table when it starts are not returned; use query_influxdb_sql for those.",
+	tailInfluxDBTable,
+)
@@ -0,0 +1,97 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbTail(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+	rows := func(times []time.Time, hosts []string) []byte {
+		return arrowResponse(t, data.NewFrame("",
+			data.NewField("time", nil, times),
+			data.NewField("host", nil, hosts),
+		))
+	}
+	// Each poll returns what the table holds after the filter; the row at
+	// t0-1s was already there, the one at t0+1s arrives late, after t0+2s.
+	polls := [][]byte{
+		rows([]time.Time{at(-1), at(0)}, []string{"a", "a"}),
+		rows([]time.Time{at(-1), at(0), at(2)}, []string{"a", "a", "b"}),
+		rows([]time.Time{at(0), at(1), at(2), at(3)}, []string{"a", "late", "b", "c"}),
+		rows([]time.Time{at(1), at(2), at(3)}, []string{"late", "b", "c"}),
+	}
+	var sqls []string
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sql := decodePayload(t, r).Queries[0].RawSQL
+		sqls = append(sqls, sql)
+		if strings.HasPrefix(sql, "SELECT MAX") {
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("high_water", nil, []time.Time{t0}))))
+			return
+		}
+		i := min(len(sqls)-2, len(polls)-1)
+		_, _ = w.Write(polls[i])
+	})
+
+	var batches [][]map[string]any
+	opts := tailOptions{Interval: 5 * time.Millisecond, Duration: time.Second, Overlap: 5 * time.Second, MaxRows: 3}
+	res, highWater, n, err := cli.tail(context.Background(), "", "cpu", "host <> 'x'", opts, func(b []map[string]any) {
+		batches = append(batches, b)
+	})
+	require.NoError(t, err)
+
+	var hosts []string
+	for _, row := range res.Rows {
+		hosts = append(hosts, row["host"].(string))
+	}
+	assert.Equal(t, []string{"b", "late", "c"}, hosts, "existing rows are skipped and no row is repeated")
+	assert.Len(t, batches, 2)
+	assert.True(t, at(3).Equal(highWater), highWater)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, res.TotalSeen)
+
+	assert.Equal(t, `SELECT MAX("time") AS high_water FROM "cpu" WHERE (host <> 'x')`, sqls[0])
+	assert.Equal(t, `SELECT * FROM "cpu" WHERE "time" > '2024-05-01T11:59:55Z' AND (host <> 'x') ORDER BY "time"`, sqls[1])
+	assert.Equal(t, `SELECT * FROM "cpu" WHERE "time" > '2024-05-01T11:59:57Z' AND (host <> 'x') ORDER BY "time"`, sqls[3])
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _, _, err := cli.tail(ctx, "", "cpu", "", opts, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("custom time column", func(t *testing.T) {
+		var sqls []string
+		cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sql := decodePayload(t, r).Queries[0].RawSQL
+			sqls = append(sqls, sql)
+			if strings.HasPrefix(sql, "SELECT MAX") {
+				_, _ = w.Write(arrowResponse(t, data.NewFrame("", data.NewField("high_water", nil, []time.Time{t0}))))
+				return
+			}
+			_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+				data.NewField("ts", nil, []time.Time{at(1)}),
+				data.NewField("host", nil, []string{"a"}),
+			)))
+		})
+		cli.timeColumn = "ts"
+		opts := tailOptions{Interval: 5 * time.Millisecond, Duration: time.Second, MaxRows: 1}
+		_, highWater, _, err := cli.tail(context.Background(), "", "cpu", "", opts, nil)
+		require.NoError(t, err)
+		assert.True(t, at(1).Equal(highWater), "the high-water mark is read from the ts column: %s", highWater)
+		assert.Equal(t, `SELECT MAX("ts") AS high_water FROM "cpu"`, sqls[0])
+		assert.Equal(t, `SELECT * FROM "cpu" WHERE "ts" > '2024-05-01T12:00:00Z' ORDER BY "ts"`, sqls[1])
+	})
+}
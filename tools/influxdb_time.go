@@ -0,0 +1,197 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+// isoTimeLayouts are the ISO 8601 forms parseInfluxTime accepts. Timestamps
+// without a zone are taken to be UTC.
+var isoTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseInfluxTime parses a time range bound given as epoch milliseconds, an
+// ISO 8601 timestamp or a Grafana-style relative expression such as 'now',
+// 'now-6h' or 'now-1d/d'. A trailing '/unit' rounds the time down to the
+// start of that unit, or, if roundUp is set, to the last millisecond of it, so
+// that 'now/d' covers today when used for both bounds. Whatever the form, the
+// result is sent to Grafana as epoch milliseconds, which every version
+// accepts. An empty string yields the zero time so callers can apply their
+// own default.
+func parseInfluxTime(s string, now time.Time, roundUp bool) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	if !strings.HasPrefix(s, "now") {
+		for _, layout := range isoTimeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time %q: expected epoch milliseconds, an ISO 8601 timestamp or an expression like 'now-1h'", s)
+	}
+
+	rest := strings.TrimPrefix(s, "now")
+	rest, round, hasRound := strings.Cut(rest, "/")
+	t, err := applyInfluxOffset(now, rest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %w", s, err)
+	}
+	if !hasRound {
+		return t, nil
+	}
+	if t, err = roundInfluxTime(t, round, roundUp); err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// applyInfluxOffset applies an offset such as '-6h' or '+1w' to t.
+func applyInfluxOffset(t time.Time, offset string) (time.Time, error) {
+	if offset == "" {
+		return t, nil
+	}
+	sign := offset[0]
+	if sign != '-' && sign != '+' {
+		return time.Time{}, fmt.Errorf("expected '-' or '+' after 'now'")
+	}
+	d, err := parseInfluxDuration(offset[1:])
+	if err != nil {
+		return time.Time{}, err
+	}
+	if sign == '-' {
+		d = -d
+	}
+	return t.Add(d), nil
+}
+
+// roundInfluxTime rounds t down to the start of unit (s, m, h, d, w, M or y),
+// or to the last millisecond of it if roundUp is set. Rounding is done in UTC
+// and weeks start on Monday.
+func roundInfluxTime(t time.Time, unit string, roundUp bool) (time.Time, error) {
+	t = t.UTC()
+	var start, next time.Time
+	switch unit {
+	case "s", "m", "h":
+		d := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+		start = t.Truncate(d)
+		next = start.Add(d)
+	case "d":
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(0, 0, 1)
+	case "w":
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		start = time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(0, 0, 7)
+	case "M":
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(0, 1, 0)
+	case "y":
+		start = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(1, 0, 0)
+	default:
+		return time.Time{}, fmt.Errorf("unknown rounding unit %q: expected one of s, m, h, d, w, M or y", unit)
+	}
+	if roundUp {
+		return next.Add(-time.Millisecond), nil
+	}
+	return start, nil
+}
+
+// resolveInfluxTimeRange parses a from/to pair. to defaults to now and from
+// to one hour before to.
+func resolveInfluxTimeRange(from, to string, now time.Time) (time.Time, time.Time, error) {
+	toTime, err := parseInfluxTime(to, now, true)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("parsing to: %w", err)
+	}
+	if toTime.IsZero() {
+		toTime = now
+	}
+	fromTime, err := parseInfluxTime(from, now, false)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("parsing from: %w", err)
+	}
+	if fromTime.IsZero() {
+		fromTime = toTime.Add(-time.Hour)
+	}
+	if !fromTime.Before(toTime) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	return fromTime, toTime, nil
+}
+
+// parseInfluxDuration parses a duration such as '30s', '5m', '6h', '7d' or
+// '2w'. Days and weeks are not supported by time.ParseDuration, so they are
+// handled here.
+func parseInfluxDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var unit time.Duration
+	switch s[len(s)-1] {
+	case 'd':
+		unit = 24 * time.Hour
+	case 'w':
+		unit = 7 * 24 * time.Hour
+	default:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		if d <= 0 {
+			return 0, fmt.Errorf("duration %q must be positive", s)
+		}
+		return d, nil
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+type ResolveInfluxDBTimeRangeParams struct {
+	From string `json:"from,omitempty" jsonschema:"description=Start of the range as epoch milliseconds or a Grafana-style expression such as 'now-7d' or 'now/d'. Defaults to one hour before 'to'"`
+	To   string `json:"to,omitempty" jsonschema:"description=End of the range in the same forms as 'from'. Defaults to 'now'"`
+}
+
+type resolvedTimeRange struct {
+	From    int64  `json:"from"`
+	To      int64  `json:"to"`
+	FromISO string `json:"fromIso"`
+	ToISO   string `json:"toIso"`
+}
+
+func resolveInfluxDBTimeRange(ctx context.Context, args ResolveInfluxDBTimeRangeParams) (*resolvedTimeRange, error) {
+	from, to, err := resolveInfluxTimeRange(args.From, args.To, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &resolvedTimeRange{
+		From:    from.UnixMilli(),
+		To:      to.UnixMilli(),
+		FromISO: from.UTC().Format(time.RFC3339Nano),
+		ToISO:   to.UTC().Format(time.RFC3339Nano),
+	}, nil
+}
+
+var ResolveInfluxDBTimeRange = mcpgrafana.MustTool(
+	"resolve_influxdb_time_range",
+	"InfluxDB v3 datasource: Resolves a from/to pair given as Grafana-style expressions (e.g. 'now-7d', 'now/d', 'now-1d/d') to epoch milliseconds and RFC 3339 timestamps, exactly as query_influxdb_sql would interpret them. Rounding with '/unit' is done in UTC. Useful for checking a time window before querying.",
+	resolveInfluxDBTimeRange,
+)
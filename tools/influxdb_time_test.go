@@ -0,0 +1,96 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInfluxTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		in       string
+		expected time.Time
+	}{
+		{"", time.Time{}},
+		{"now", now},
+		{"now-6h", now.Add(-6 * time.Hour)},
+		{"now-7d", now.Add(-7 * 24 * time.Hour)},
+		{"now+1w", now.Add(7 * 24 * time.Hour)},
+		{"1714564800000", time.UnixMilli(1714564800000)},
+		{"2024-05-01T10:00:00Z", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{"2024-05-01T12:00:00.5+02:00", time.Date(2024, 5, 1, 10, 0, 0, 5e8, time.UTC)},
+		{"2024-05-01T10:00:00", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{"2024-05-01 10:00:00", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := parseInfluxTime(tc.in, now, false)
+			require.NoError(t, err)
+			assert.True(t, tc.expected.Equal(got), "expected %s, got %s", tc.expected, got)
+		})
+	}
+
+	for _, in := range []string{"yesterday", "now-", "now*1h", "now-1x", "now/q", "now/", "2024-13-01", "05/01/2024"} {
+		_, err := parseInfluxTime(in, now, false)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestResolveInfluxTimeRange(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2024, 5, 1, 12, 30, 15, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+	for _, tc := range []struct {
+		from, to       string
+		expFrom, expTo time.Time
+	}{
+		{"", "", now.Add(-time.Hour), now},
+		{"now-7d", "now", now.Add(-7 * 24 * time.Hour), now},
+		{"now/d", "now/d", day(1), day(2).Add(-time.Millisecond)},
+		{"now-1d/d", "now-1d/d", day(1).AddDate(0, 0, -1), day(1).Add(-time.Millisecond)},
+		{"now/w", "now", time.Date(2024, 4, 29, 0, 0, 0, 0, time.UTC), now},
+		{"now/M", "now/h", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 12, 59, 59, 999e6, time.UTC)},
+		{"now/y", "now", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), now},
+	} {
+		t.Run(tc.from+".."+tc.to, func(t *testing.T) {
+			from, to, err := resolveInfluxTimeRange(tc.from, tc.to, now)
+			require.NoError(t, err)
+			assert.True(t, tc.expFrom.Equal(from), "from: expected %s, got %s", tc.expFrom, from)
+			assert.True(t, tc.expTo.Equal(to), "to: expected %s, got %s", tc.expTo, to)
+		})
+	}
+
+	_, _, err := resolveInfluxTimeRange("now", "now-1h", now)
+	assert.EqualError(t, err, "from must be before to")
+	_, _, err = resolveInfluxTimeRange("now/x", "", now)
+	assert.ErrorContains(t, err, "parsing from")
+}
+
+func TestInfluxdbQueryTimeRangeForms(t *testing.T) {
+	var payload dsQueryPayload
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		payload = decodePayload(t, r)
+		_, _ = w.Write(arrowResponse(t, cpuFrame()))
+	})
+	// ISO and epoch inputs are both sent as epoch milliseconds.
+	for _, tc := range []struct{ from, to string }{
+		{"2024-05-01T12:00:00Z", "2024-05-01T13:00:00Z"},
+		{"1714564800000", "1714568400000"},
+		{"2024-05-01T14:00:00+02:00", "1714568400000"},
+	} {
+		from, to, err := resolveInfluxTimeRange(tc.from, tc.to, time.Now())
+		require.NoError(t, err)
+		_, err = cli.query(context.Background(), "SELECT 1", queryOptions{From: from, To: to})
+		require.NoError(t, err)
+		assert.Equal(t, "1714564800000", payload.From, tc.from)
+		assert.Equal(t, "1714568400000", payload.To, tc.to)
+	}
+}
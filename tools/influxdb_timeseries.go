@@ -0,0 +1,213 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	mcpgrafana "github.com/grafana/mcp-grafana"
+)
+
+const (
+	// DefaultInfluxTimeseriesBuckets is the number of buckets the time range
+	// is divided into when no bucket size is given.
+	DefaultInfluxTimeseriesBuckets = 100
+	// MaxInfluxTimeseriesBuckets is the maximum number of buckets the time
+	// range may be divided into.
+	MaxInfluxTimeseriesBuckets = 10000
+	// MaxInfluxTimeseriesPoints is the maximum number of points returned
+	// across all series.
+	MaxInfluxTimeseriesPoints = 100000
+)
+
+type InfluxDBTimeseriesParams struct {
+	DatasourceUID string   `json:"datasourceUid" jsonschema:"required,description=InfluxDB v3 datasource UID"`
+	Table         string   `json:"table" jsonschema:"required,description=Table (measurement) to read"`
+	Column        string   `json:"column" jsonschema:"required,description=Numeric column whose values are aggregated"`
+	Aggregate     string   `json:"aggregate,omitempty" jsonschema:"description=Aggregate of the values in each bucket: 'mean' (default)\\, 'sum'\\, 'min'\\, 'max'\\, 'count'\\, 'count_distinct'\\, 'median'\\, 'stddev'\\, 'p90'\\, 'p95'\\, 'p99'\\, 'first' or 'last'"`
+	Bucket        string   `json:"bucket,omitempty" jsonschema:"description=Bucket size as a duration such as '30s'\\, '5m'\\, '1h' or '1d'. Defaults to a round size giving about 100 buckets over the time range"`
+	GroupBy       []string `json:"groupBy,omitempty" jsonschema:"description=Tag columns splitting the values into one series per combination\\, e.g. [host]. Defaults to a single series"`
+	Database      string   `json:"database,omitempty" jsonschema:"description=Schema the table is in. Defaults to 'iox'\\, where InfluxDB v3 stores measurement tables"`
+	From          string   `json:"from,omitempty" jsonschema:"description=Start of the time range as epoch milliseconds\\, an ISO 8601 timestamp or a relative expression like 'now-6h'. Defaults to one hour before 'to'"`
+	To            string   `json:"to,omitempty" jsonschema:"description=End of the time range. Defaults to now"`
+	TimeColumn    string   `json:"timeColumn,omitempty" jsonschema:"description=Name of the table's time column. Defaults to the server's configured time column\\, normally 'time'"`
+}
+
+type timeseriesPoint struct {
+	// Time is the start of the bucket.
+	Time  any `json:"time"`
+	Value any `json:"value"`
+}
+
+type timeseriesSeries struct {
+	// Labels are the values of the groupBy columns of the series.
+	Labels map[string]string `json:"labels,omitempty"`
+	Points []timeseriesPoint `json:"points"`
+}
+
+type timeseriesResult struct {
+	Table     string `json:"table"`
+	Column    string `json:"column"`
+	Aggregate string `json:"aggregate"`
+	// Bucket is the bucket size used, e.g. "5m", and BucketMs the same in
+	// milliseconds.
+	Bucket   string             `json:"bucket"`
+	BucketMs float64            `json:"bucketMs"`
+	From     string             `json:"from"`
+	To       string             `json:"to"`
+	SQL      string             `json:"sql"`
+	Series   []timeseriesSeries `json:"series"`
+	// Truncated is set if the points were cut off at the maximum.
+	Truncated bool `json:"truncated"`
+}
+
+// autoBucket returns the smallest niceInterval dividing span into at most
+// DefaultInfluxTimeseriesBuckets buckets, or a whole number of days for
+// spans too long for any.
+func autoBucket(span time.Duration) time.Duration {
+	target := span / DefaultInfluxTimeseriesBuckets
+	for _, d := range niceIntervals {
+		if d >= target {
+			return d
+		}
+	}
+	day := 24 * time.Hour
+	return (target + day - 1) / day * day
+}
+
+// timeseriesSQL aggregates the column into buckets of the table's time
+// column, one row per bucket and combination of the groupBy columns, ordered
+// by series and time. Results are aliased as _time and _value so that they
+// cannot collide with the groupBy columns.
+func timeseriesSQL(schema, table, timeColumn, column, aggregate, interval string, groupBy []string) string {
+	timeCol := quoteIdent(timeColumn)
+	exprs := []string{fmt.Sprintf("date_bin(%s, %s) AS _time", interval, timeCol)}
+	groups := []string{"1"}
+	for i, c := range groupBy {
+		exprs = append(exprs, quoteIdent(c))
+		groups = append(groups, fmt.Sprint(i+2))
+	}
+	exprs = append(exprs, fmt.Sprintf(aggregateFunctions[aggregate].expr+" AS _value", quoteIdent(column), timeCol))
+	order := append(slices.Clone(groups[1:]), "1")
+	return fmt.Sprintf("SELECT %s FROM %s WHERE $__timeFilter(%s) GROUP BY %s ORDER BY %s LIMIT %d",
+		strings.Join(exprs, ", "), qualifiedTable(schema, table), timeCol,
+		strings.Join(groups, ", "), strings.Join(order, ", "), MaxInfluxTimeseriesPoints)
+}
+
+// timeseries aggregates a numeric column of a table into time buckets over
+// opts' time range, one series per combination of the groupBy columns. The
+// column and groupBy columns are checked against the table first, so that
+// mistakes are reported by name rather than as SQL errors.
+func (c *influxdbClient) timeseries(ctx context.Context, schema, table, column, aggregate, bucket string, groupBy []string, opts queryOptions) (*timeseriesResult, error) {
+	if aggregate == "" {
+		aggregate = "mean"
+	}
+	if _, err := lookupAggregate(aggregate); err != nil {
+		return nil, err
+	}
+	span := opts.To.Sub(opts.From)
+	var size time.Duration
+	if bucket == "" {
+		size = autoBucket(span)
+	} else {
+		var err error
+		if size, err = parseInfluxDuration(bucket); err != nil {
+			return nil, fmt.Errorf("bucket: %w", err)
+		}
+	}
+	short, interval := formatGranularity(size)
+	if n := (span + size - 1) / size; n > MaxInfluxTimeseriesBuckets {
+		return nil, fmt.Errorf("bucket %s divides the time range into %d buckets, more than the maximum of %d; use a larger bucket", short, n, MaxInfluxTimeseriesBuckets)
+	}
+
+	columns, err := c.describeTable(ctx, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(columns))
+	for _, col := range columns {
+		types[col.Name] = col.DataType
+	}
+	for _, col := range append([]string{c.timeCol(), column}, groupBy...) {
+		if _, ok := types[col]; !ok {
+			return nil, fmt.Errorf("column %q not found in table %q", col, table)
+		}
+	}
+	if !strings.HasPrefix(aggregate, "count") && !isNumericType(types[column]) {
+		return nil, fmt.Errorf("column %q is not numeric (%s); only aggregates 'count' and 'count_distinct' apply to it", column, types[column])
+	}
+	if slices.Contains(groupBy, column) {
+		return nil, fmt.Errorf("column %q cannot be both the value column and in groupBy", column)
+	}
+
+	sql := timeseriesSQL(schema, table, c.timeCol(), column, aggregate, interval, groupBy)
+	res, err := c.query(ctx, sql, opts)
+	if err != nil {
+		return nil, err
+	}
+	if schema == "" {
+		schema = defaultInfluxSchema
+	}
+	out := &timeseriesResult{
+		Table:     schema + "." + table,
+		Column:    column,
+		Aggregate: aggregate,
+		Bucket:    short,
+		BucketMs:  float64(size) / float64(time.Millisecond),
+		From:      opts.From.UTC().Format(time.RFC3339Nano),
+		To:        opts.To.UTC().Format(time.RFC3339Nano),
+		SQL:       sql,
+		Series:    []timeseriesSeries{},
+		Truncated: len(res.Rows) == MaxInfluxTimeseriesPoints,
+	}
+	index := map[string]int{}
+	for _, row := range res.Rows {
+		labels := make(map[string]string, len(groupBy))
+		key := make([]string, len(groupBy))
+		for i, g := range groupBy {
+			labels[g] = formatCell(row[g])
+			key[i] = labels[g]
+		}
+		k := strings.Join(key, "\x00")
+		i, ok := index[k]
+		if !ok {
+			i = len(out.Series)
+			index[k] = i
+			s := timeseriesSeries{Points: []timeseriesPoint{}}
+			if len(groupBy) > 0 {
+				s.Labels = labels
+			}
+			out.Series = append(out.Series, s)
+		}
+		var value any
+		if f, ok := toFloat(row["_value"]); ok {
+			value = f
+		}
+		out.Series[i].Points = append(out.Series[i].Points, timeseriesPoint{Time: jsonSafeValue(row["_time"]), Value: value})
+	}
+	return out, nil
+}
+
+func influxDBTimeseries(ctx context.Context, args InfluxDBTimeseriesParams) (*timeseriesResult, error) {
+	var opts queryOptions
+	var err error
+	if opts.From, opts.To, err = resolveInfluxTimeRange(args.From, args.To, time.Now()); err != nil {
+		return nil, err
+	}
+	cli, err := newInfluxdbClient(ctx, args.DatasourceUID)
+	if err != nil {
+		return nil, err
+	}
+	if args.TimeColumn != "" {
+		cli.timeColumn = args.TimeColumn
+	}
+	return cli.timeseries(ctx, args.Database, args.Table, args.Column, args.Aggregate, args.Bucket, args.GroupBy, opts)
+}
+
+var InfluxDBTimeseries = mcpgrafana.MustTool(
+	"influxdb_timeseries",
+	"InfluxDB v3 datasource: Aggregates a numeric column of a table into time buckets over a time range, writing the date_bin query itself, for charting or checking a metric over time without hand-writing SQL. Returns {table, column, aggregate, bucket, bucketMs, from, to, sql, series: [{labels, points: [{time, value}]}], truncated}, with one series per combination of the groupBy tag columns and time the start of each bucket. Buckets without data are omitted. Without a bucket size one giving about 100 buckets is chosen; bucket reports the size used.",
+	influxDBTimeseries,
+)
@@ -0,0 +1,94 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbTimeseries(t *testing.T) {
+	columns := arrowResponse(t, data.NewFrame("",
+		data.NewField("column_name", nil, []string{"time", "host", "usage"}),
+		data.NewField("data_type", nil, []string{"Timestamp(Nanosecond, None)", "Dictionary(Int32, Utf8)", "Float64"}),
+		data.NewField("is_nullable", nil, []string{"NO", "YES", "YES"}),
+	))
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	opts := queryOptions{From: t0, To: t0.Add(time.Hour)}
+	var sent []string
+	cli := newTestInfluxdbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sql := decodePayload(t, r).Queries[0].RawSQL
+		if strings.Contains(sql, "information_schema") {
+			_, _ = w.Write(columns)
+			return
+		}
+		sent = append(sent, sql)
+		_, _ = w.Write(arrowResponse(t, data.NewFrame("",
+			data.NewField("_time", nil, []time.Time{t0, t0.Add(5 * time.Minute), t0}),
+			data.NewField("host", nil, []string{"web1", "web1", "web2"}),
+			data.NewField("_value", nil, []*float64{ptrTo(0.5), nil, ptrTo(0.75)}),
+		)))
+	})
+
+	t.Run("grouped", func(t *testing.T) {
+		sent = nil
+		res, err := cli.timeseries(context.Background(), "", "cpu", "usage", "max", "5m", []string{"host"}, opts)
+		require.NoError(t, err)
+		require.Len(t, sent, 1)
+		assert.Equal(t, `SELECT date_bin(INTERVAL '5 minutes', "time") AS _time, "host", MAX("usage") AS _value FROM "cpu" WHERE $__timeFilter("time") GROUP BY 1, 2 ORDER BY 2, 1 LIMIT 100000`, sent[0])
+		assert.Equal(t, "iox.cpu", res.Table)
+		assert.Equal(t, "5m", res.Bucket)
+		assert.Equal(t, 300000.0, res.BucketMs)
+		require.Len(t, res.Series, 2)
+		assert.Equal(t, map[string]string{"host": "web1"}, res.Series[0].Labels)
+		assert.Equal(t, []timeseriesPoint{
+			{Time: "2024-05-01T12:00:00Z", Value: 0.5},
+			{Time: "2024-05-01T12:05:00Z", Value: nil},
+		}, res.Series[0].Points)
+		assert.Equal(t, map[string]string{"host": "web2"}, res.Series[1].Labels)
+	})
+
+	t.Run("auto bucket", func(t *testing.T) {
+		sent = nil
+		res, err := cli.timeseries(context.Background(), "", "cpu", "usage", "", "", nil, opts)
+		require.NoError(t, err)
+		assert.Equal(t, "1m", res.Bucket, "an hour in about 100 buckets")
+		assert.Equal(t, "mean", res.Aggregate)
+		assert.Contains(t, sent[0], `date_bin(INTERVAL '1 minutes', "time") AS _time, AVG("usage") AS _value`)
+		assert.Contains(t, sent[0], "GROUP BY 1 ORDER BY 1")
+		require.Len(t, res.Series, 1)
+		assert.Nil(t, res.Series[0].Labels)
+		assert.Len(t, res.Series[0].Points, 3)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		sent = nil
+		for _, tc := range []struct {
+			column, aggregate, bucket string
+			groupBy                   []string
+			want                      string
+		}{
+			{"usage", "p42", "", nil, "invalid aggregate"},
+			{"usage", "", "5x", nil, "invalid duration"},
+			{"usage", "", "100ms", nil, "more than the maximum"},
+			{"idle", "", "", nil, `column "idle" not found`},
+			{"usage", "", "", []string{"region"}, `column "region" not found`},
+			{"host", "mean", "", nil, "not numeric"},
+			{"usage", "", "", []string{"usage"}, "both the value column and in groupBy"},
+		} {
+			_, err := cli.timeseries(context.Background(), "", "cpu", tc.column, tc.aggregate, tc.bucket, tc.groupBy, opts)
+			assert.ErrorContains(t, err, tc.want)
+		}
+		assert.Empty(t, sent, "invalid input is rejected before querying")
+		_, err := cli.timeseries(context.Background(), "", "cpu", "host", "count", "", nil, opts)
+		assert.NoError(t, err, "count applies to any column")
+	})
+}
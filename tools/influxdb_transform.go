@@ -0,0 +1,606 @@
+package tools
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultTimeColumn is the name InfluxDB v3 gives the timestamp column.
+const defaultTimeColumn = "time"
+
+// toFloat converts a decoded numeric value to float64, dereferencing the
+// pointers used for nullable Arrow fields. It reports false for nil and
+// non-numeric values.
+func toFloat(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return 0, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	}
+	return 0, false
+}
+
+// toTime converts a decoded timestamp to a time.Time. Numeric values are
+// interpreted as epoch milliseconds, which is how the JSON values encoding
+// represents times.
+func toTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, false
+		}
+		return *t, true
+	}
+	if ms, ok := toFloat(v); ok {
+		return time.UnixMilli(int64(ms)), true
+	}
+	return time.Time{}, false
+}
+
+// firstNumericColumn returns the first column other than skip whose first
+// non-nil value is numeric.
+func firstNumericColumn(res *queryResult, skip string) string {
+	for _, col := range res.Columns {
+		if col == skip {
+			continue
+		}
+		for _, row := range res.Rows {
+			if v, ok := row[col]; ok && !isNil(v) {
+				if _, ok := toFloat(v); ok {
+					return col
+				}
+				break
+			}
+		}
+	}
+	return ""
+}
+
+// isNil reports whether v is nil or a nil pointer.
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// downsampleLTTB reduces rows to at most maxPoints using the
+// largest-triangle-three-buckets algorithm over (timeCol, valueCol). The
+// first and last points are always kept. Rows whose time or value is null or
+// non-numeric are dropped before sampling.
+func downsampleLTTB(rows []map[string]any, timeCol, valueCol string, maxPoints int) ([]map[string]any, error) {
+	if maxPoints < 3 {
+		return nil, fmt.Errorf("maxPoints must be at least 3")
+	}
+
+	type point struct {
+		x, y float64
+		row  map[string]any
+	}
+	points := make([]point, 0, len(rows))
+	for _, row := range rows {
+		t, ok := toTime(row[timeCol])
+		if !ok {
+			continue
+		}
+		y, ok := toFloat(row[valueCol])
+		if !ok {
+			continue
+		}
+		points = append(points, point{x: float64(t.UnixNano()), y: y, row: row})
+	}
+	if len(points) == 0 && len(rows) > 0 {
+		return nil, fmt.Errorf("no rows have both a time in column %q and a numeric value in column %q", timeCol, valueCol)
+	}
+	if len(points) <= maxPoints {
+		out := make([]map[string]any, len(points))
+		for i, p := range points {
+			out[i] = p.row
+		}
+		return out, nil
+	}
+
+	out := make([]map[string]any, 0, maxPoints)
+	out = append(out, points[0].row)
+	every := float64(len(points)-2) / float64(maxPoints-2)
+	a := 0
+	for i := 0; i < maxPoints-2; i++ {
+		// Average of the next bucket, used as the third triangle vertex.
+		avgStart := int(math.Floor(float64(i+1)*every)) + 1
+		avgEnd := int(math.Floor(float64(i+2)*every)) + 1
+		if avgEnd > len(points) {
+			avgEnd = len(points)
+		}
+		var avgX, avgY float64
+		for _, p := range points[avgStart:avgEnd] {
+			avgX += p.x
+			avgY += p.y
+		}
+		n := float64(avgEnd - avgStart)
+		avgX /= n
+		avgY /= n
+
+		// Pick the point in the current bucket with the largest triangle.
+		start := int(math.Floor(float64(i)*every)) + 1
+		end := int(math.Floor(float64(i+1)*every)) + 1
+		maxArea, next := -1.0, start
+		for j := start; j < end; j++ {
+			area := math.Abs((points[a].x-avgX)*(points[j].y-points[a].y) -
+				(points[a].x-points[j].x)*(avgY-points[a].y))
+			if area > maxArea {
+				maxArea, next = area, j
+			}
+		}
+		out = append(out, points[next].row)
+		a = next
+	}
+	out = append(out, points[len(points)-1].row)
+	return out, nil
+}
+
+// redactedPlaceholder replaces the values of redacted columns.
+const redactedPlaceholder = "***"
+
+// redactColumns replaces the values of the named columns with a placeholder.
+// The columns are kept, so the shape of the result is unchanged; null values
+// stay null so that they remain distinguishable from redacted data.
+func redactColumns(rows []map[string]any, columns []string) {
+	for _, row := range rows {
+		for _, col := range columns {
+			if v, ok := row[col]; ok && !isNil(v) {
+				row[col] = redactedPlaceholder
+			}
+		}
+	}
+}
+
+// pseudonymPrefix marks values replaced by pseudonymizeColumns.
+const pseudonymPrefix = "anon_"
+
+// pseudonym returns the token pseudonymizeColumns replaces v with: a keyed
+// hash of its formatted value, so that equal values map to equal tokens and
+// the values cannot be recovered without the salt.
+func pseudonym(v any, salt string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(formatCell(v)))
+	return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// pseudonymizeColumns replaces the values of the named columns with their
+// pseudonym. Like redactColumns it keeps the columns and null values.
+func pseudonymizeColumns(rows []map[string]any, columns []string, salt string) {
+	for _, row := range rows {
+		for _, col := range columns {
+			if v, ok := row[col]; ok && !isNil(v) {
+				row[col] = pseudonym(v, salt)
+			}
+		}
+	}
+}
+
+// selectColumns keeps only the named columns of res, in the order they have
+// in the result. Names the result does not have are ignored.
+func selectColumns(res *queryResult, names []string) {
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+	columns := res.Columns[:0:0]
+	for _, col := range res.Columns {
+		if keep[col] {
+			columns = append(columns, col)
+		}
+	}
+	res.Columns = columns
+	for _, row := range res.Rows {
+		for col := range row {
+			if !keep[col] {
+				delete(row, col)
+			}
+		}
+	}
+	for col := range res.Data {
+		if !keep[col] {
+			delete(res.Data, col)
+		}
+	}
+	for col := range res.Labels {
+		if !keep[col] {
+			delete(res.Labels, col)
+		}
+	}
+}
+
+// timeSpan is the range of timestamps observed in a result, which may be
+// narrower than the time range queried.
+type timeSpan struct {
+	Start      string `json:"start"`
+	End        string `json:"end"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// observedTimeSpan returns the earliest and latest value of column in res, or
+// nil if the column has no time values.
+func observedTimeSpan(res *queryResult, column string) *timeSpan {
+	var lo, hi time.Time
+	observe := func(v any) {
+		t, ok := toTime(v)
+		if !ok {
+			return
+		}
+		if lo.IsZero() || t.Before(lo) {
+			lo = t
+		}
+		if hi.IsZero() || t.After(hi) {
+			hi = t
+		}
+	}
+	if res.Data != nil {
+		for _, v := range res.Data[column] {
+			observe(v)
+		}
+	} else {
+		for _, row := range res.Rows {
+			observe(row[column])
+		}
+	}
+	if lo.IsZero() {
+		return nil
+	}
+	return &timeSpan{
+		Start:      lo.UTC().Format(time.RFC3339Nano),
+		End:        hi.UTC().Format(time.RFC3339Nano),
+		DurationMs: hi.Sub(lo).Milliseconds(),
+	}
+}
+
+// Key cases control the case of column names in the result.
+const (
+	keyCaseAsIs  = "asis"
+	keyCaseLower = "lower"
+	keyCaseUpper = "upper"
+)
+
+// keyCaseFold returns the function converting column names to keyCase, or
+// nil if they are kept as they are.
+func keyCaseFold(keyCase string) (func(string) string, error) {
+	switch keyCase {
+	case "", keyCaseAsIs:
+		return nil, nil
+	case keyCaseLower:
+		return strings.ToLower, nil
+	case keyCaseUpper:
+		return strings.ToUpper, nil
+	}
+	return nil, fmt.Errorf("invalid keyCase %q: must be 'asis', 'lower' or 'upper'", keyCase)
+}
+
+// normalizeKeyCase renames every column of res to keyCase, in place. It fails
+// without modifying res if two columns would end up with the same name.
+func normalizeKeyCase(res *queryResult, keyCase string) error {
+	fold, err := keyCaseFold(keyCase)
+	if err != nil || fold == nil {
+		return err
+	}
+
+	names := slices.Clone(res.Columns)
+	for _, row := range res.Rows {
+		names = append(names, sortedKeys(row)...)
+	}
+	for col := range res.Data {
+		names = append(names, col)
+	}
+	renamed := make(map[string]string, len(names))
+	from := make(map[string]string, len(names))
+	for _, name := range names {
+		if _, ok := renamed[name]; ok {
+			continue
+		}
+		folded := fold(name)
+		if other, ok := from[folded]; ok {
+			return fmt.Errorf("columns %q and %q would both be named %q with keyCase %q; alias one of them in the SQL", other, name, folded, keyCase)
+		}
+		renamed[name], from[folded] = folded, name
+	}
+
+	for i, col := range res.Columns {
+		res.Columns[i] = renamed[col]
+	}
+	for i, row := range res.Rows {
+		out := make(map[string]any, len(row))
+		for col, v := range row {
+			out[renamed[col]] = v
+		}
+		res.Rows[i] = out
+	}
+	if res.Data != nil {
+		data := make(map[string][]any, len(res.Data))
+		for col, values := range res.Data {
+			data[renamed[col]] = values
+		}
+		res.Data = data
+	}
+	if res.Labels != nil {
+		labels := make(map[string]map[string]string, len(res.Labels))
+		for col, l := range res.Labels {
+			labels[fold(col)] = l
+		}
+		res.Labels = labels
+	}
+	return nil
+}
+
+// dedupeRows removes rows which are exact duplicates of an earlier row,
+// comparing every column. It runs in O(n) time by keeping a set of row keys,
+// and preserves the order of first occurrences.
+func dedupeRows(rows []map[string]any) []map[string]any {
+	seen := make(map[string]struct{}, len(rows))
+	out := rows[:0]
+	for _, row := range rows {
+		key := rowKey(row)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, row)
+	}
+	return out
+}
+
+// rowKey encodes every column name, value type and value of row into a string
+// which is equal for two rows exactly when their contents are.
+func rowKey(row map[string]any) string {
+	var b strings.Builder
+	for _, col := range sortedKeys(row) {
+		v := row[col]
+		b.WriteString(col)
+		b.WriteByte(0)
+		if isNil(v) {
+			b.WriteString("null")
+		} else {
+			fmt.Fprintf(&b, "%T", v)
+			b.WriteByte(0)
+			b.WriteString(formatCell(v))
+		}
+		b.WriteByte(1)
+	}
+	return b.String()
+}
+
+// resultHash returns the hex SHA-256 of res in a normalized form: the column
+// names followed by each row as a JSON array of its JSON-safe values in
+// column order. Rows are hashed in sorted order, so results holding the same
+// rows hash equally even if a query without ORDER BY returns them in a
+// different order, and regardless of how they were decoded or typed.
+func resultHash(res *queryResult) string {
+	n := res.numRows()
+	rows := make([]string, n)
+	values := make([]any, len(res.Columns))
+	for i := 0; i < n; i++ {
+		for j, col := range res.Columns {
+			if res.Data != nil {
+				values[j] = jsonSafeValue(res.Data[col][i])
+			} else {
+				values[j] = jsonSafeValue(res.Rows[i][col])
+			}
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			// Values which cannot be marshalled, like NaN, still have to
+			// count.
+			b = []byte(fmt.Sprint(values...))
+		}
+		rows[i] = string(b)
+	}
+	slices.Sort(rows)
+	h := sha256.New()
+	columns, _ := json.Marshal(res.Columns)
+	h.Write(columns)
+	for _, row := range rows {
+		h.Write([]byte{'\n'})
+		h.Write([]byte(row))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Type policies control how decoded values are converted before they are
+// returned.
+const (
+	// typePolicyNative keeps the Go types the frame was decoded into,
+	// including pointers for nullable fields.
+	typePolicyNative = "native"
+	// typePolicyString renders every non-null value as display text.
+	typePolicyString = "string"
+	// typePolicyJSONSafe dereferences pointers and renders times as RFC 3339
+	// strings in UTC, so rows serialize the same regardless of how their
+	// frame was encoded.
+	typePolicyJSONSafe = "jsonSafe"
+)
+
+// applyTypePolicy converts every value of rows in place according to policy,
+// which defaults to typePolicyJSONSafe.
+func applyTypePolicy(rows []map[string]any, policy string) error {
+	convert, err := typePolicyConverter(policy)
+	if err != nil || convert == nil {
+		return err
+	}
+	for _, row := range rows {
+		for k, v := range row {
+			row[k] = convert(v)
+		}
+	}
+	return nil
+}
+
+// applyTypePolicyValues is applyTypePolicy for the values of a single column.
+func applyTypePolicyValues(values []any, policy string) error {
+	convert, err := typePolicyConverter(policy)
+	if err != nil || convert == nil {
+		return err
+	}
+	for i, v := range values {
+		values[i] = convert(v)
+	}
+	return nil
+}
+
+// typePolicyConverter returns the conversion for policy, or nil if values
+// are kept as they are.
+func typePolicyConverter(policy string) (func(any) any, error) {
+	switch policy {
+	case typePolicyNative:
+		return nil, nil
+	case typePolicyString:
+		return func(v any) any {
+			if isNil(v) {
+				return nil
+			}
+			return formatCell(v)
+		}, nil
+	case "", typePolicyJSONSafe:
+		return jsonSafeValue, nil
+	default:
+		return nil, fmt.Errorf("invalid typePolicy %q: must be 'native', 'string' or 'jsonSafe'", policy)
+	}
+}
+
+// jsonSafeValue dereferences v and converts values without a single obvious
+// JSON form: times become RFC 3339 strings in UTC and byte slices strings.
+func jsonSafeValue(v any) any {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	switch val := rv.Interface().(type) {
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano)
+	case []byte:
+		return string(val)
+	case []any:
+		// Nested values, see decodeNestedArrow.
+		out := make([]any, len(val))
+		for i, e := range val {
+			out[i] = jsonSafeValue(e)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, e := range val {
+			out[k] = jsonSafeValue(e)
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+// limitResultBytes keeps the leading rows of res whose JSON encoding fits in
+// maxBytes and records their size in res.ResultBytes. The size is estimated
+// row by row, stopping at the first row which does not fit, by encoding each
+// value as typePolicy would return it and adding the keys, brackets and
+// separators of the row-oriented or columnar layout. Envelope fields such as
+// warnings are not counted, so the response is slightly larger than the
+// estimate.
+func limitResultBytes(res *queryResult, maxBytes int, typePolicy string) error {
+	convert, err := typePolicyConverter(typePolicy)
+	if err != nil {
+		return err
+	}
+	valueSize := func(v any) int {
+		if convert != nil {
+			v = convert(v)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return len(fmt.Sprint(v))
+		}
+		return len(b)
+	}
+	keySizes := make([]int, len(res.Columns))
+	for j, col := range res.Columns {
+		keySizes[j] = valueSize(col)
+	}
+
+	// "[]" around the rows, or for columnar results "{}" around the data
+	// with `"col":[]` per column and commas between them.
+	size := 2
+	if res.Data != nil {
+		for _, k := range keySizes {
+			size += k + 3
+		}
+		size += max(len(keySizes)-1, 0)
+	}
+	n := res.numRows()
+	keep := 0
+	for ; keep < n; keep++ {
+		var rowSize int
+		if res.Data != nil {
+			for _, col := range res.Columns {
+				rowSize += valueSize(res.Data[col][keep])
+			}
+			if keep > 0 {
+				rowSize += len(res.Columns)
+			}
+		} else {
+			row := res.Rows[keep]
+			rowSize = 2
+			fields := 0
+			for j, col := range res.Columns {
+				if v, ok := row[col]; ok {
+					rowSize += keySizes[j] + 1 + valueSize(v)
+					fields++
+				}
+			}
+			rowSize += max(fields-1, 0)
+			if keep > 0 {
+				rowSize++
+			}
+		}
+		if size+rowSize > maxBytes {
+			break
+		}
+		size += rowSize
+	}
+	res.ResultBytes = size
+	if keep == n {
+		return nil
+	}
+	if res.TotalSeen == 0 {
+		res.TotalSeen = n
+	}
+	if res.Data != nil {
+		for col, values := range res.Data {
+			res.Data[col] = values[:keep]
+		}
+	} else {
+		res.Rows = res.Rows[:keep]
+	}
+	return nil
+}
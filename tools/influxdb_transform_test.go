@@ -0,0 +1,355 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownsampleLTTB(t *testing.T) {
+	start := time.UnixMilli(0)
+	rows := make([]map[string]any, 0, 100)
+	for i := 0; i < 100; i++ {
+		v := float64(i % 10)
+		if i == 42 {
+			v = 1000 // a spike that LTTB should keep
+		}
+		rows = append(rows, map[string]any{"time": start.Add(time.Duration(i) * time.Second), "value": &v})
+	}
+
+	t.Run("keeps first, last and extreme points", func(t *testing.T) {
+		out, err := downsampleLTTB(rows, "time", "value", 10)
+		require.NoError(t, err)
+		require.Len(t, out, 10)
+		assert.Equal(t, rows[0], out[0])
+		assert.Equal(t, rows[99], out[9])
+		assert.Contains(t, out, rows[42])
+	})
+
+	t.Run("returns rows unchanged when under the limit", func(t *testing.T) {
+		out, err := downsampleLTTB(rows[:5], "time", "value", 10)
+		require.NoError(t, err)
+		assert.Equal(t, rows[:5], out)
+	})
+
+	t.Run("requires numeric values", func(t *testing.T) {
+		_, err := downsampleLTTB([]map[string]any{{"time": start, "value": "x"}}, "time", "value", 10)
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects tiny limits", func(t *testing.T) {
+		_, err := downsampleLTTB(rows, "time", "value", 2)
+		assert.Error(t, err)
+	})
+}
+
+func TestFirstNumericColumn(t *testing.T) {
+	v := 1.5
+	res := &queryResult{
+		Columns: []string{"time", "host", "usage"},
+		Rows:    []map[string]any{{"time": time.Now(), "host": "a", "usage": &v}},
+	}
+	assert.Equal(t, "usage", firstNumericColumn(res, "time"))
+}
+
+func TestTransformResultRedactColumns(t *testing.T) {
+	var nilString *string
+	res := &queryResult{
+		Columns: []string{"user", "email", "count"},
+		Rows: []map[string]any{
+			{"user": "alice", "email": "alice@example.com", "count": 1},
+			{"user": "bob", "email": nilString, "count": 2},
+		},
+	}
+	require.NoError(t, transformResult(res, QueryInfluxSQLParams{RedactColumns: []string{"email", "user", "missing"}}))
+	assert.Equal(t, []map[string]any{
+		{"user": "***", "email": "***", "count": 1},
+		{"user": "***", "email": nilString, "count": 2},
+	}, res.Rows)
+}
+
+func TestTransformResultDedupe(t *testing.T) {
+	a, b := 0.5, 0.5
+	res := &queryResult{
+		Columns: []string{"host", "usage"},
+		Rows: []map[string]any{
+			{"host": "web1", "usage": &a},
+			{"host": "web2", "usage": 1.0},
+			{"host": "web1", "usage": &b},
+			{"host": "web2", "usage": "1"},
+			{"host": "web2", "usage": 1.0},
+			{"host": "web3", "usage": nil},
+			{"host": "web3", "usage": (*float64)(nil)},
+		},
+	}
+	require.NoError(t, transformResult(res, QueryInfluxSQLParams{Dedupe: true}))
+	assert.Equal(t, []map[string]any{
+		{"host": "web1", "usage": &a},
+		{"host": "web2", "usage": 1.0},
+		{"host": "web2", "usage": "1"},
+		{"host": "web3", "usage": nil},
+	}, res.Rows)
+}
+
+func TestApplyTypePolicy(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	usage := 0.5
+	newRows := func() []map[string]any {
+		return []map[string]any{{"time": ts, "usage": &usage, "count": int64(3), "missing": (*float64)(nil), "raw": []byte("x")}}
+	}
+
+	for _, tc := range []struct {
+		policy   string
+		expected map[string]any
+	}{
+		{"", map[string]any{"time": "2024-05-01T10:00:00Z", "usage": 0.5, "count": int64(3), "missing": nil, "raw": "x"}},
+		{"jsonSafe", map[string]any{"time": "2024-05-01T10:00:00Z", "usage": 0.5, "count": int64(3), "missing": nil, "raw": "x"}},
+		{"string", map[string]any{"time": "2024-05-01T10:00:00Z", "usage": "0.5", "count": "3", "missing": nil, "raw": "x"}},
+		{"native", newRows()[0]},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			rows := newRows()
+			require.NoError(t, applyTypePolicy(rows, tc.policy))
+			assert.Equal(t, tc.expected, rows[0])
+		})
+	}
+
+	assert.Error(t, applyTypePolicy(newRows(), "bogus"))
+}
+
+func TestTransformResultSelectColumns(t *testing.T) {
+	newResult := func() *queryResult {
+		return &queryResult{
+			Columns: []string{"time", "host", "usage", "region"},
+			Rows: []map[string]any{
+				{"time": 1, "host": "web1", "usage": 0.5, "region": "eu"},
+				{"time": 2, "host": "web2", "usage": 0.75, "region": "us"},
+			},
+			Labels: map[string]map[string]string{"usage": {"unit": "percent"}, "region": {"tier": "1"}},
+		}
+	}
+	args := QueryInfluxSQLParams{SelectColumns: []string{"usage", "nope", "time"}, OrderedKeys: true}
+	res := newResult()
+	require.NoError(t, transformResult(res, args))
+	assert.Equal(t, []string{"time", "usage"}, res.Columns)
+	assert.Equal(t, map[string]map[string]string{"usage": {"unit": "percent"}}, res.Labels)
+
+	out, err := formatResult(res, args)
+	require.NoError(t, err)
+	b, err := json.Marshal(out)
+	require.NoError(t, err)
+	assert.Equal(t, `[{"time":1,"usage":0.5},{"time":2,"usage":0.75}]`, string(b))
+
+	t.Run("columnar", func(t *testing.T) {
+		res := newResult()
+		res.Data, res.Rows = columnsFromRows(res), nil
+		require.NoError(t, transformResult(res, args))
+		assert.Equal(t, map[string][]any{"time": {1, 2}, "usage": {0.5, 0.75}}, res.Data)
+	})
+}
+
+func TestTransformResultKeyCase(t *testing.T) {
+	newResult := func(columns ...string) *queryResult {
+		res := &queryResult{Columns: columns, Labels: map[string]map[string]string{}}
+		row := map[string]any{}
+		for i, col := range columns {
+			row[col] = i
+			res.Labels[col] = map[string]string{"n": strconv.Itoa(i)}
+		}
+		res.Rows = []map[string]any{row}
+		return res
+	}
+
+	res := newResult("Time", "Host", "usage")
+	require.NoError(t, transformResult(res, QueryInfluxSQLParams{KeyCase: "lower"}))
+	assert.Equal(t, []string{"time", "host", "usage"}, res.Columns)
+	assert.Equal(t, []map[string]any{{"time": 0, "host": 1, "usage": 2}}, res.Rows)
+	assert.Equal(t, "1", res.Labels["host"]["n"])
+
+	res = newResult("Time", "Host")
+	res.Data, res.Rows = columnsFromRows(res), nil
+	require.NoError(t, transformResult(res, QueryInfluxSQLParams{KeyCase: "upper"}))
+	assert.Equal(t, map[string][]any{"TIME": {0}, "HOST": {1}}, res.Data)
+
+	res = newResult("Time", "Host")
+	require.NoError(t, transformResult(res, QueryInfluxSQLParams{}))
+	assert.Equal(t, []string{"Time", "Host"}, res.Columns)
+
+	t.Run("collision", func(t *testing.T) {
+		res := newResult("host", "Host", "usage")
+		err := transformResult(res, QueryInfluxSQLParams{KeyCase: "lower"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `columns "host" and "Host" would both be named "host"`)
+		assert.Equal(t, []string{"host", "Host", "usage"}, res.Columns, "the result is left unchanged")
+		assert.Contains(t, res.Rows[0], "Host")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.Error(t, transformResult(newResult("a"), QueryInfluxSQLParams{KeyCase: "title"}))
+	})
+}
+
+func TestTransformResultTimeSpan(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	newResult := func() *queryResult {
+		return frameToResult(data.NewFrame("",
+			data.NewField("time", nil, []*time.Time{ptrTo(t0.Add(time.Minute)), nil, ptrTo(t0), ptrTo(t0.Add(90 * time.Second))}),
+			data.NewField("usage", nil, []float64{1, 2, 3, 4}),
+		))
+	}
+
+	res := newResult()
+	args := QueryInfluxSQLParams{TimeSpan: true, SelectColumns: []string{"usage"}}
+	require.NoError(t, transformResult(res, args))
+	assert.Equal(t, &timeSpan{Start: "2024-05-01T12:00:00Z", End: "2024-05-01T12:01:30Z", DurationMs: 90000}, res.TimeSpan)
+	out, err := formatResult(res, args)
+	require.NoError(t, err)
+	b, err := json.Marshal(out)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"timeSpan":{"start":"2024-05-01T12:00:00Z","end":"2024-05-01T12:01:30Z","durationMs":90000}`)
+
+	t.Run("columnar", func(t *testing.T) {
+		res := newResult()
+		res.Data, res.Rows = columnsFromRows(res), nil
+		require.NoError(t, transformResult(res, QueryInfluxSQLParams{TimeSpan: true}))
+		assert.Equal(t, "2024-05-01T12:01:30Z", res.TimeSpan.End)
+	})
+
+	t.Run("not requested", func(t *testing.T) {
+		res := newResult()
+		require.NoError(t, transformResult(res, QueryInfluxSQLParams{}))
+		assert.Nil(t, res.TimeSpan)
+	})
+
+	t.Run("no time column", func(t *testing.T) {
+		res := &queryResult{Columns: []string{"usage"}, Rows: []map[string]any{{"usage": 1.0}}}
+		require.NoError(t, transformResult(res, QueryInfluxSQLParams{TimeSpan: true}))
+		assert.Nil(t, res.TimeSpan)
+		assert.Len(t, res.Warnings, 1)
+	})
+}
+
+func TestResultHash(t *testing.T) {
+	usage := 0.5
+	res := &queryResult{Columns: []string{"host", "usage"}, Rows: []map[string]any{
+		{"host": "web1", "usage": &usage},
+		{"host": "web2", "usage": 0.75},
+	}}
+	hash := resultHash(res)
+	assert.Len(t, hash, 64)
+
+	reordered := &queryResult{Columns: []string{"host", "usage"}, Rows: []map[string]any{res.Rows[1], {"host": "web1", "usage": 0.5}}}
+	assert.Equal(t, hash, resultHash(reordered), "row order and pointer types do not matter")
+
+	columnar := &queryResult{Columns: res.Columns, Data: columnsFromRows(res)}
+	assert.Equal(t, hash, resultHash(columnar))
+
+	changed := &queryResult{Columns: res.Columns, Rows: []map[string]any{{"host": "web1", "usage": 0.5}, {"host": "web2", "usage": 0.8}}}
+	assert.NotEqual(t, hash, resultHash(changed))
+	renamed := &queryResult{Columns: []string{"host", "cpu"}, Rows: []map[string]any{{"host": "web1", "cpu": 0.5}, {"host": "web2", "cpu": 0.75}}}
+	assert.NotEqual(t, hash, resultHash(renamed))
+	assert.NotEqual(t, resultHash(&queryResult{}), resultHash(&queryResult{Columns: []string{"host"}, Rows: []map[string]any{{"host": nil}}}))
+
+	out, err := formatResult(res, QueryInfluxSQLParams{HashOnly: true})
+	require.NoError(t, err)
+	assert.Equal(t, hashResponse{Hash: hash, RowCount: 2}, out)
+
+	out, err = formatResult(res, QueryInfluxSQLParams{Hash: true})
+	require.NoError(t, err)
+	resp := out.(queryResponse)
+	assert.Equal(t, hash, resp.Hash)
+	assert.NotNil(t, resp.Rows)
+}
+
+func TestLimitResultBytes(t *testing.T) {
+	rows := func() *queryResult {
+		return &queryResult{Columns: []string{"host", "usage", "time"}, Rows: []map[string]any{
+			{"host": "web1", "usage": 0.5, "time": time.UnixMilli(1714564800000)},
+			{"host": "web2", "usage": ptrTo(0.75), "time": time.UnixMilli(1714564860000)},
+			{"host": "web3", "usage": nil, "time": time.UnixMilli(1714564920000)},
+		}}
+	}
+	encoded := func(res *queryResult) int {
+		require.NoError(t, applyTypePolicy(res.Rows, typePolicyJSONSafe))
+		b, err := json.Marshal(res.Rows)
+		require.NoError(t, err)
+		return len(b)
+	}
+
+	full := rows()
+	require.NoError(t, limitResultBytes(full, 1<<20, ""))
+	assert.Len(t, full.Rows, 3)
+	assert.Zero(t, full.TotalSeen)
+	assert.Equal(t, encoded(rows()), full.ResultBytes, "the estimate matches the JSON encoding")
+
+	res := rows()
+	require.NoError(t, limitResultBytes(res, full.ResultBytes-1, ""))
+	assert.Len(t, res.Rows, 2)
+	assert.Equal(t, 3, res.TotalSeen)
+	assert.Equal(t, encoded(res), res.ResultBytes)
+
+	res = rows()
+	require.NoError(t, limitResultBytes(res, 10, ""))
+	assert.Empty(t, res.Rows)
+	assert.Equal(t, 2, res.ResultBytes)
+
+	t.Run("columnar", func(t *testing.T) {
+		res := rows()
+		require.NoError(t, applyTypePolicy(res.Rows, typePolicyJSONSafe))
+		res = &queryResult{Columns: res.Columns, Data: columnsFromRows(res)}
+		b, err := json.Marshal(res.Data)
+		require.NoError(t, err)
+		require.NoError(t, limitResultBytes(res, 1<<20, ""))
+		assert.Equal(t, len(b), res.ResultBytes)
+	})
+
+	t.Run("envelope", func(t *testing.T) {
+		res := rows()
+		require.NoError(t, transformResult(res, QueryInfluxSQLParams{MaxResultBytes: 100}))
+		out, err := formatResult(res, QueryInfluxSQLParams{MaxResultBytes: 100})
+		require.NoError(t, err)
+		resp := out.(queryResponse)
+		assert.True(t, resp.Truncated)
+		assert.Equal(t, 3, resp.TotalSeen)
+		assert.Equal(t, res.ResultBytes, resp.ResultBytes)
+		assert.LessOrEqual(t, resp.ResultBytes, 100)
+	})
+}
+
+func TestPseudonymizeColumns(t *testing.T) {
+	rows := func() []map[string]any {
+		return []map[string]any{
+			{"user": "alice", "host": "web1"},
+			{"user": "bob", "host": "web2"},
+			{"user": "alice", "host": nil},
+		}
+	}
+	res := &queryResult{Columns: []string{"user", "host"}, Rows: rows()}
+	require.NoError(t, transformResult(res, QueryInfluxSQLParams{Pseudonymize: []string{"user", "host"}, PseudonymSalt: "s1"}))
+
+	alice := res.Rows[0]["user"]
+	assert.Regexp(t, `^anon_[0-9a-f]{16}$`, alice)
+	assert.Equal(t, alice, res.Rows[2]["user"], "equal values get equal tokens")
+	assert.NotEqual(t, alice, res.Rows[1]["user"])
+	assert.NotEqual(t, "web1", res.Rows[0]["host"])
+	assert.Nil(t, res.Rows[2]["host"])
+
+	again := rows()
+	pseudonymizeColumns(again, []string{"user"}, "s1")
+	assert.Equal(t, alice, again[0]["user"], "the same salt gives the same tokens")
+	other := rows()
+	pseudonymizeColumns(other, []string{"user"}, "s2")
+	assert.NotEqual(t, alice, other[0]["user"])
+
+	ctx := newTestGrafanaContext(t, respondWith(arrowResponse(t, cpuFrame())))
+	_, err := queryInfluxSQL(ctx, QueryInfluxSQLParams{DatasourceUID: "influx", SQL: "SELECT 1", Pseudonymize: []string{"user"}})
+	assert.ErrorContains(t, err, "pseudonymSalt")
+}
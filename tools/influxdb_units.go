@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// unit is a unit values can be converted from or to: the quantity it measures
+// and how many of the quantity's base unit one of it is.
+type unit struct {
+	quantity string
+	factor   float64
+}
+
+// units are the units convertUnits knows, keyed by lower-cased name. They
+// include the unit ids Grafana writes to field configs, such as "decbytes";
+// note that Grafana's "kbytes", "mbytes" and so on are binary, 1024 to the
+// next.
+var units = map[string]unit{
+	"b": {"data", 1}, "bytes": {"data", 1}, "decbytes": {"data", 1},
+	"kb": {"data", 1e3}, "mb": {"data", 1e6}, "gb": {"data", 1e9}, "tb": {"data", 1e12},
+	"deckbytes": {"data", 1e3}, "decmbytes": {"data", 1e6}, "decgbytes": {"data", 1e9}, "dectbytes": {"data", 1e12},
+	"kib": {"data", 1 << 10}, "mib": {"data", 1 << 20}, "gib": {"data", 1 << 30}, "tib": {"data", 1 << 40},
+	"kbytes": {"data", 1 << 10}, "mbytes": {"data", 1 << 20}, "gbytes": {"data", 1 << 30}, "tbytes": {"data", 1 << 40},
+
+	"ns": {"time", 1}, "us": {"time", 1e3}, "µs": {"time", 1e3}, "ms": {"time", 1e6}, "s": {"time", 1e9},
+	"m": {"time", 60e9}, "h": {"time", 3600e9}, "d": {"time", 86400e9},
+
+	"percent": {"ratio", 0.01}, "percentunit": {"ratio", 1},
+}
+
+// unitConversion records a conversion convertUnits applied.
+type unitConversion struct {
+	Column string `json:"column"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	// Factor is what the values were multiplied by.
+	Factor float64 `json:"factor"`
+}
+
+// lookupUnit returns the unit named name, ignoring case.
+func lookupUnit(name string) (unit, bool) {
+	u, ok := units[strings.ToLower(strings.TrimSpace(name))]
+	return u, ok
+}
+
+// knownUnits lists the names of units, sorted, for error messages.
+func knownUnits() string {
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// fieldUnits returns the unit of each field of the frame res was decoded from
+// which has one in its config, keyed by column.
+func fieldUnits(res *queryResult) map[string]string {
+	out := map[string]string{}
+	if res.Frame == nil {
+		return out
+	}
+	for i, col := range fieldColumns(res.Frame) {
+		if cfg := res.Frame.Fields[i].Config; cfg != nil && cfg.Unit != "" {
+			out[col] = cfg.Unit
+		}
+	}
+	return out
+}
+
+// convertUnits converts the numeric values of each column of targets from the
+// unit in its field config to the target unit, in place, and returns the
+// conversions applied in column order. Null and non-numeric values are kept
+// as they are.
+func convertUnits(res *queryResult, targets map[string]string) ([]unitConversion, error) {
+	for col := range targets {
+		if !slices.Contains(res.Columns, col) {
+			return nil, fmt.Errorf("convertUnits: the result has no column %q", col)
+		}
+	}
+	sources := fieldUnits(res)
+	var out []unitConversion
+	for _, col := range res.Columns {
+		target, ok := targets[col]
+		if !ok {
+			continue
+		}
+		from, ok := sources[col]
+		if !ok {
+			return nil, fmt.Errorf("convertUnits: column %q has no unit in its field config, so its values cannot be converted", col)
+		}
+		src, ok := lookupUnit(from)
+		if !ok {
+			return nil, fmt.Errorf("convertUnits: column %q is in unit %q, which cannot be converted", col, from)
+		}
+		dst, ok := lookupUnit(target)
+		if !ok {
+			return nil, fmt.Errorf("convertUnits: unknown unit %q for column %q; known units are %s", target, col, knownUnits())
+		}
+		if src.quantity != dst.quantity {
+			return nil, fmt.Errorf("convertUnits: cannot convert column %q from %s to %s: one is %s and the other %s", col, from, target, src.quantity, dst.quantity)
+		}
+		factor := src.factor / dst.factor
+		convert := func(v any) any {
+			if f, ok := toFloat(v); ok {
+				return f * factor
+			}
+			return v
+		}
+		if res.Data != nil {
+			for i, v := range res.Data[col] {
+				res.Data[col][i] = convert(v)
+			}
+		} else {
+			for _, row := range res.Rows {
+				if v, ok := row[col]; ok {
+					row[col] = convert(v)
+				}
+			}
+		}
+		out = append(out, unitConversion{Column: col, From: from, To: target, Factor: factor})
+	}
+	return out, nil
+}
@@ -0,0 +1,69 @@
+//go:build unit
+// +build unit
+
+package tools
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryInfluxSQLConvertUnits(t *testing.T) {
+	mem := 2.5e6
+	frame := data.NewFrame("",
+		data.NewField("host", nil, []string{"web1", "web2"}),
+		data.NewField("mem", nil, []*float64{&mem, nil}).SetConfig(&data.FieldConfig{Unit: "decbytes"}),
+		data.NewField("latency", nil, []int64{1500000, 250000}).SetConfig(&data.FieldConfig{Unit: "ns"}),
+	)
+	ctx := newTestGrafanaContext(t, respondWith(arrowResponse(t, frame)))
+	query := func(args QueryInfluxSQLParams) (any, error) {
+		args.DatasourceUID, args.SQL = "influx", "SELECT * FROM cpu LIMIT 10"
+		return queryInfluxSQL(ctx, args)
+	}
+
+	t.Run("rows", func(t *testing.T) {
+		out, err := query(QueryInfluxSQLParams{ConvertUnits: map[string]string{"mem": "MB", "latency": "ms"}})
+		require.NoError(t, err)
+		resp, ok := out.(queryResponse)
+		require.True(t, ok, "conversions force the envelope: %T", out)
+		assert.Equal(t, []map[string]any{
+			{"host": "web1", "mem": 2.5, "latency": 1.5},
+			{"host": "web2", "mem": nil, "latency": 0.25},
+		}, resp.Rows)
+		assert.Equal(t, []unitConversion{
+			{Column: "mem", From: "decbytes", To: "MB", Factor: 1e-6},
+			{Column: "latency", From: "ns", To: "ms", Factor: 1e-6},
+		}, resp.UnitConversions)
+	})
+
+	t.Run("columnar", func(t *testing.T) {
+		out, err := query(QueryInfluxSQLParams{Columnar: true, ConvertUnits: map[string]string{"latency": "us"}})
+		require.NoError(t, err)
+		assert.Equal(t, []any{1500.0, 250.0}, out.(columnarResponse).Data["latency"])
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		out, err := query(QueryInfluxSQLParams{Format: "markdown", ConvertUnits: map[string]string{"mem": "KB"}})
+		require.NoError(t, err)
+		assert.Contains(t, out, "_Units converted: mem from decbytes to KB._")
+	})
+
+	for name, tc := range map[string]struct {
+		targets map[string]string
+		want    string
+	}{
+		"unknown target": {map[string]string{"mem": "furlongs"}, `unknown unit "furlongs"`},
+		"incompatible":   {map[string]string{"mem": "ms"}, "from decbytes to ms: one is data and the other time"},
+		"no unit":        {map[string]string{"host": "MB"}, `column "host" has no unit`},
+		"missing column": {map[string]string{"disk": "MB"}, `no column "disk"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := query(QueryInfluxSQLParams{ConvertUnits: tc.targets})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}